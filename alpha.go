@@ -0,0 +1,132 @@
+package atlas
+
+import "image"
+
+// Represents how alpha is split from colour into a second image, for
+// texture formats such as ETC1 that have no alpha
+type AlphaSplit string
+
+const (
+	ALPHA_SPLIT_NONE AlphaSplit = ""
+	// Alpha is written as a grayscale image, or in the RGB channels of
+	// compressed pixel formats
+	ALPHA_SPLIT_GRAY AlphaSplit = "gray"
+	// Alpha is copied into the RGB channels of an image in the same pixel
+	// format as the colour image
+	ALPHA_SPLIT_RGB AlphaSplit = "rgb"
+)
+
+// Appended to the name of an atlas to name its alpha image
+const ALPHA_SUFFIX = "_alpha"
+
+// Fills the colour of fully transparent pixels with the average colour of
+// their nearest non-transparent neighbours, spreading outwards until every
+// pixel has a colour. Alpha is left untouched so the image looks the same
+// but filtering at the edges of sprites no longer pulls in black
+func alphaBleed(im *image.NRGBA) {
+	b := im.Bounds()
+	w, h := b.Dx(), b.Dy()
+	filled := make([]bool, w*h)
+	frontier := make([]int, 0)
+	for i := range filled {
+		if im.Pix[pixOffset(im, i, w)+3] != 0 {
+			filled[i] = true
+		}
+	}
+	// Start with the transparent pixels that touch a coloured one
+	queued := make([]bool, w*h)
+	for i := range filled {
+		if !filled[i] && hasFilledNeighbour(filled, i, w, h) {
+			frontier = append(frontier, i)
+			queued[i] = true
+		}
+	}
+
+	for len(frontier) > 0 {
+		// Work out the colours of the whole frontier before marking any of
+		// it filled so that the order pixels are visited in does not matter
+		colours := make([][3]uint8, len(frontier))
+		for n, i := range frontier {
+			var sum [3]int
+			count := 0
+			forNeighbours(i, w, h, func(j int) {
+				if filled[j] {
+					p := im.Pix[pixOffset(im, j, w):]
+					sum[0] += int(p[0])
+					sum[1] += int(p[1])
+					sum[2] += int(p[2])
+					count++
+				}
+			})
+			colours[n] = [3]uint8{
+				uint8((sum[0] + count/2) / count),
+				uint8((sum[1] + count/2) / count),
+				uint8((sum[2] + count/2) / count),
+			}
+		}
+		next := make([]int, 0)
+		for n, i := range frontier {
+			p := im.Pix[pixOffset(im, i, w):]
+			p[0], p[1], p[2] = colours[n][0], colours[n][1], colours[n][2]
+			filled[i] = true
+		}
+		for _, i := range frontier {
+			forNeighbours(i, w, h, func(j int) {
+				if !filled[j] && !queued[j] {
+					queued[j] = true
+					next = append(next, j)
+				}
+			})
+		}
+		frontier = next
+	}
+}
+
+// Returns the offset into the pixel data of the i'th pixel of the image
+func pixOffset(im *image.NRGBA, i, w int) int {
+	return (i/w)*im.Stride + (i%w)*4
+}
+
+// Calls fn with the index of each of the 8 pixels surrounding pixel i
+func forNeighbours(i, w, h int, fn func(j int)) {
+	x, y := i%w, i/w
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			nx, ny := x+dx, y+dy
+			if (dx != 0 || dy != 0) && nx >= 0 && ny >= 0 && nx < w && ny < h {
+				fn(ny*w + nx)
+			}
+		}
+	}
+}
+
+func hasFilledNeighbour(filled []bool, i, w, h int) bool {
+	found := false
+	forNeighbours(i, w, h, func(j int) {
+		found = found || filled[j]
+	})
+	return found
+}
+
+// Multiplies the colour of every pixel by its alpha. The result is still
+// stored in an NRGBA image so that it is written out unchanged
+func premultiply(im *image.NRGBA) {
+	for i := 0; i+3 < len(im.Pix); i += 4 {
+		a := uint32(im.Pix[i+3])
+		im.Pix[i] = uint8((uint32(im.Pix[i])*a + 127) / 255)
+		im.Pix[i+1] = uint8((uint32(im.Pix[i+1])*a + 127) / 255)
+		im.Pix[i+2] = uint8((uint32(im.Pix[i+2])*a + 127) / 255)
+	}
+}
+
+// Moves the alpha of the image into the RGB channels of a new opaque
+// image, leaving the original image opaque
+func splitAlpha(im *image.NRGBA) *image.NRGBA {
+	out := image.NewNRGBA(im.Bounds())
+	for i := 0; i+3 < len(im.Pix); i += 4 {
+		a := im.Pix[i+3]
+		out.Pix[i], out.Pix[i+1], out.Pix[i+2], out.Pix[i+3] = a, a, a, 255
+		im.Pix[i+3] = 255
+	}
+	return out
+}
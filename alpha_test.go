@@ -0,0 +1,157 @@
+package atlas
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestAlphaBleed(t *testing.T) {
+	im := image.NewNRGBA(image.Rect(0, 0, 8, 4))
+	red := color.NRGBA{255, 0, 0, 255}
+	blue := color.NRGBA{0, 0, 255, 128}
+	im.SetNRGBA(0, 0, red)
+	im.SetNRGBA(6, 0, blue)
+
+	alphaBleed(im)
+
+	cases := []struct {
+		x, y int
+		want color.NRGBA
+	}{
+		// Coloured pixels are left alone
+		{0, 0, red},
+		{6, 0, blue},
+		// Pixels take the colour of their nearest neighbours
+		{0, 3, color.NRGBA{255, 0, 0, 0}},
+		{7, 3, color.NRGBA{0, 0, 255, 0}},
+		// And average them when they are the same distance away
+		{3, 0, color.NRGBA{128, 0, 128, 0}},
+	}
+	for _, c := range cases {
+		if got := im.NRGBAAt(c.x, c.y); got != c.want {
+			t.Errorf("Unexpected colour at %d,%d: want %v, got %v", c.x, c.y, c.want, got)
+		}
+	}
+}
+
+func TestPremultiply(t *testing.T) {
+	im := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	im.SetNRGBA(0, 0, color.NRGBA{255, 128, 0, 255})
+	im.SetNRGBA(1, 0, color.NRGBA{255, 128, 0, 128})
+	im.SetNRGBA(2, 0, color.NRGBA{255, 128, 0, 0})
+
+	premultiply(im)
+
+	want := []color.NRGBA{{255, 128, 0, 255}, {128, 64, 0, 128}, {0, 0, 0, 0}}
+	for x, w := range want {
+		if got := im.NRGBAAt(x, 0); got != w {
+			t.Errorf("Unexpected premultiplied colour at %d: want %v, got %v", x, w, got)
+		}
+	}
+
+	// Descriptors should tell loaders the image is premultiplied
+	for _, format := range []DescriptorFormat{DESC_KIWI, DESC_JSON_HASH} {
+		tmpl, err := GetTemplateForFormat(format)
+		if err != nil {
+			t.Fatalf("Unable to load template %s: %s", format, err.Error())
+		}
+		out := &bytes.Buffer{}
+		if err := tmpl.Execute(out, &Atlas{Name: "test", Premultiplied: true}); err != nil {
+			t.Fatalf("Unable to write descriptor %s: %s", format, err.Error())
+		}
+		var desc struct {
+			PremultipliedAlpha bool
+			Meta               struct {
+				PremultipliedAlpha bool
+			}
+		}
+		if err := json.Unmarshal(out.Bytes(), &desc); err != nil {
+			t.Fatalf("Descriptor %s is not valid JSON: %s", format, err.Error())
+		}
+		if !desc.PremultipliedAlpha && !desc.Meta.PremultipliedAlpha {
+			t.Errorf("Descriptor %s does not record premultiplied alpha", format)
+		}
+	}
+}
+
+func TestGenerateAlphaSplit(t *testing.T) {
+	outputDir := t.TempDir()
+	files := []string{"./fixtures/button.png", "./fixtures/button_hover.png"}
+	res, err := Generate(files, outputDir, &GenerateParams{
+		Name:       "test-alpha-split",
+		Descriptor: DESC_JSON_HASH,
+		AlphaSplit: ALPHA_SPLIT_GRAY,
+	})
+	if err != nil {
+		t.Fatalf("Generate threw an error: %s", err.Error())
+	}
+	atlas := res.Atlases[0]
+	colour, err := readPNG(path.Join(outputDir, atlas.ImageName()))
+	if err != nil {
+		t.Fatalf("Unable to read colour image: %s", err.Error())
+	}
+	alpha, err := readPNG(path.Join(outputDir, atlas.AlphaImageName()))
+	if err != nil {
+		t.Fatalf("Unable to read alpha image: %s", err.Error())
+	}
+	if _, ok := alpha.(*image.Gray); !ok {
+		t.Errorf("Expected a grayscale alpha image, got %T", alpha)
+	}
+	if colour.Bounds() != alpha.Bounds() {
+		t.Errorf("Alpha image size %v does not match colour image %v", alpha.Bounds(), colour.Bounds())
+	}
+	// The padding around the sprites is transparent and the sprites opaque
+	for _, p := range []image.Point{{0, 0}, atlas.Files[0].Frame().Min.Add(image.Pt(10, 10))} {
+		_, _, _, a := colour.At(p.X, p.Y).RGBA()
+		if a != 0xffff {
+			t.Errorf("Expected the colour image to be opaque at %v", p)
+		}
+	}
+	descriptor, err := ioutil.ReadFile(path.Join(outputDir, atlas.Name+".json"))
+	if err != nil {
+		t.Fatalf("Unable to read descriptor: %s", err.Error())
+	}
+	var desc struct {
+		Meta struct {
+			AlphaImage string
+		}
+	}
+	if err := json.Unmarshal(descriptor, &desc); err != nil {
+		t.Fatalf("Descriptor is not valid JSON: %s", err.Error())
+	}
+	if desc.Meta.AlphaImage != atlas.AlphaImageName() {
+		t.Errorf("Unexpected alpha image in descriptor: want %s, got %s", atlas.AlphaImageName(), desc.Meta.AlphaImage)
+	}
+
+	// ETC1 textures hold the alpha in the RGB channels of a second texture
+	res, err = Generate(files, outputDir, &GenerateParams{
+		Name:        "test-alpha-split-etc1",
+		PixelFormat: PIXEL_ETC1,
+		Container:   CONTAINER_KTX,
+		AlphaSplit:  ALPHA_SPLIT_RGB,
+	})
+	if err != nil {
+		t.Fatalf("Generate threw an error: %s", err.Error())
+	}
+	for _, name := range []string{res.Atlases[0].ImageName(), res.Atlases[0].AlphaImageName()} {
+		if _, err := os.Stat(path.Join(outputDir, name)); err != nil {
+			t.Errorf("Texture %s was not written", name)
+		}
+	}
+}
+
+func readPNG(filename string) (image.Image, error) {
+	r, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return png.Decode(r)
+}
@@ -0,0 +1,108 @@
+package atlas
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The default pattern used to detect numbered sequences of files, matching
+// names such as "walk_01" and "walk-01". The separator is required so that
+// names that just end in a number, such as "tile2", are left alone
+const SEQUENCE_DEFAULT = `^(.+?)[_-](\d+)$`
+
+// Represents a named animation made up of an ordered sequence of files
+type Animation struct {
+	Name   string
+	Frames []*File
+}
+
+// Groups files into animations by matching their names against the given
+// pattern. The pattern is matched against the file name without its
+// directory or extension, the first submatch must capture the name of the
+// sequence and the second the frame number. Frames are ordered by their
+// number and only sequences with more than one frame are returned. Each
+// animation is named by the directory of its files and the captured name,
+// such as "fx/boom"
+func detectAnimations(files []*File, pattern string) ([]*Animation, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if re.NumSubexp() < 2 {
+		return nil, errors.New(fmt.Sprintf("Sequence pattern %s must capture a name and a frame number", pattern))
+	}
+
+	type frame struct {
+		file   *File
+		number int
+	}
+
+	// Sequences are named with their directory as well as the captured
+	// name so that files that just happen to share a name are neither
+	// grouped together nor given the same animation name
+	keys := make([]string, 0)
+	sequences := make(map[string][]frame)
+	for _, file := range files {
+		dir, base := path.Split(file.Name)
+		base = strings.TrimSuffix(base, path.Ext(base))
+		match := re.FindStringSubmatch(base)
+		if match == nil {
+			continue
+		}
+		number, err := strconv.Atoi(match[2])
+		if err != nil {
+			continue
+		}
+		key := dir + match[1]
+		if _, ok := sequences[key]; !ok {
+			keys = append(keys, key)
+		}
+		sequences[key] = append(sequences[key], frame{file, number})
+	}
+
+	animations := make([]*Animation, 0)
+	for _, key := range keys {
+		frames := sequences[key]
+		if len(frames) < 2 {
+			continue
+		}
+		sort.SliceStable(frames, func(i, j int) bool {
+			return frames[i].number < frames[j].number
+		})
+		animation := &Animation{
+			Name:   key,
+			Frames: make([]*File, len(frames)),
+		}
+		for i, f := range frames {
+			animation.Frames[i] = f.file
+		}
+		animations = append(animations, animation)
+	}
+	return animations, nil
+}
+
+// Returns the animations that have frames in the given atlas, containing
+// only the frames that were packed into it
+func atlasAnimations(animations []*Animation, atlas *Atlas) []*Animation {
+	res := make([]*Animation, 0)
+	for _, animation := range animations {
+		frames := make([]*File, 0)
+		for _, file := range animation.Frames {
+			if file.Atlas == atlas {
+				frames = append(frames, file)
+			}
+		}
+		if len(frames) > 0 {
+			res = append(res, &Animation{
+				Name:   animation.Name,
+				Frames: frames,
+			})
+		}
+	}
+	return res
+}
@@ -0,0 +1,79 @@
+package atlas
+
+import "testing"
+
+func TestDetectAnimations(t *testing.T) {
+	FILES := []*File{
+		&File{Name: "fx/boom_02.png"},
+		&File{Name: "fx/boom_10.png"},
+		&File{Name: "fx/boom_01.png"},
+		&File{Name: "fx/bomb.png"},
+		&File{Name: "fx/shell_01.png"},
+		&File{Name: "other/boom_01.png"},
+		&File{Name: "other/boom_02.png"},
+		&File{Name: "walk-1.gif"},
+		&File{Name: "walk-2.gif"},
+		&File{Name: "ui/tile1.png"},
+		&File{Name: "ui/tile2.png"},
+	}
+
+	type Want struct {
+		name   string
+		frames []string
+	}
+
+	cases := []struct {
+		pattern string
+		want    []Want
+	}{
+		{
+			// The default pattern should group numbered files in the same
+			// directory, naming them with that directory and ignoring files
+			// without a sibling frame or a separator before the number
+			pattern: SEQUENCE_DEFAULT,
+			want: []Want{
+				{"fx/boom", []string{"fx/boom_01.png", "fx/boom_02.png", "fx/boom_10.png"}},
+				{"other/boom", []string{"other/boom_01.png", "other/boom_02.png"}},
+				{"walk", []string{"walk-1.gif", "walk-2.gif"}},
+			},
+		},
+		{
+			// A custom pattern should only group the files it matches
+			pattern: `^(.+)-(\d+)$`,
+			want: []Want{
+				{"walk", []string{"walk-1.gif", "walk-2.gif"}},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		got, err := detectAnimations(FILES, c.pattern)
+		if err != nil {
+			t.Fatalf("detectAnimations threw an error: %s", err.Error())
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("Unexpected number of animations: want %d, got %d", len(c.want), len(got))
+			continue
+		}
+		for i, animation := range got {
+			expect := c.want[i]
+			if animation.Name != expect.name {
+				t.Errorf("Unexpected animation name: want %s, got %s", expect.name, animation.Name)
+			}
+			if len(animation.Frames) != len(expect.frames) {
+				t.Errorf("Unexpected number of frames in %s: want %d, got %d",
+					expect.name, len(expect.frames), len(animation.Frames))
+				continue
+			}
+			for j, frame := range animation.Frames {
+				if frame.Name != expect.frames[j] {
+					t.Errorf("Unexpected frame order in %s: want %s, got %s", expect.name, expect.frames[j], frame.Name)
+				}
+			}
+		}
+	}
+
+	if _, err := detectAnimations(FILES, `^(.+)$`); err == nil {
+		t.Errorf("Expected an error for a pattern without a frame number")
+	}
+}
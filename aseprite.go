@@ -0,0 +1,464 @@
+package atlas
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Aseprite magic numbers and chunk types, see
+// https://github.com/aseprite/aseprite/blob/main/docs/ase-file-specs.md
+const (
+	aseMagic      = 0xA5E0
+	aseFrameMagic = 0xF1FA
+
+	aseChunkOldPalette = 0x0004
+	aseChunkLayer      = 0x2004
+	aseChunkCel        = 0x2005
+	aseChunkTags       = 0x2018
+	aseChunkPalette    = 0x2019
+	aseChunkSlice      = 0x2022
+
+	aseLayerVisible    = 1
+	aseLayerBackground = 8
+	aseLayerGroup      = 1
+
+	aseCelRaw        = 0
+	aseCelLinked     = 1
+	aseCelCompressed = 2
+
+	aseTagForward         = 0
+	aseTagReverse         = 1
+	aseTagPingPong        = 2
+	aseTagPingPongReverse = 3
+)
+
+// Represents a decoded Aseprite file flattened into a series of frames
+type aseprite struct {
+	Frames    []image.Image
+	Durations []int
+	Tags      []aseTag
+	Slices    []aseSlice
+}
+
+// A named range of frames played in the given direction
+type aseTag struct {
+	Name      string
+	From, To  int
+	Direction byte
+}
+
+// The state of a slice from the given frame onwards
+type aseSliceKey struct {
+	Frame  int
+	Bounds image.Rectangle
+	// Center of a nine-slice relative to the bounds, empty if not set
+	Center image.Rectangle
+	// Pivot relative to the bounds, nil if not set
+	Pivot *image.Point
+}
+
+type aseSlice struct {
+	Name string
+	Keys []aseSliceKey
+}
+
+type aseLayer struct {
+	visible    bool
+	background bool
+	group      bool
+	opacity    uint8
+}
+
+type aseCel struct {
+	layer   int
+	z       int
+	opacity uint8
+	image   image.Image
+}
+
+// Reads a string stored as its length followed by UTF-8 bytes
+func aseString(r *byteReader) string {
+	return string(r.bytes(int(r.u16())))
+}
+
+// Decodes an Aseprite file, flattening the visible layers of every frame
+// into a single image. Only the normal blend mode is supported, layers
+// using any other mode are composited as if they were normal
+func decodeAseprite(data []byte) (*aseprite, error) {
+	r := &byteReader{data: data, order: binary.LittleEndian}
+	r.skip(4) // File size
+	if r.u16() != aseMagic {
+		return nil, errors.New("Not an Aseprite file")
+	}
+	numFrames := int(r.u16())
+	width, height := int(r.u16()), int(r.u16())
+	depth := int(r.u16())
+	flags := r.u32()
+	r.skip(2 + 4 + 4) // Speed and reserved
+	transparent := r.u8()
+	r.skip(3 + 2 + 1 + 1 + 2 + 2 + 2 + 2 + 84) // Colour count, pixel ratio, grid and reserved
+	if r.err != nil {
+		return nil, r.err
+	}
+	if depth != 32 && depth != 16 && depth != 8 {
+		return nil, errors.New(fmt.Sprintf("Unsupported Aseprite colour depth %d", depth))
+	}
+	layerOpacity := flags&1 != 0
+
+	ase := &aseprite{
+		Frames:    make([]image.Image, numFrames),
+		Durations: make([]int, numFrames),
+	}
+	layers := make([]aseLayer, 0)
+	// The visibility of the parent groups at each child level
+	parents := make([]bool, 0)
+	palette := make(color.Palette, 256)
+	for i := range palette {
+		palette[i] = color.NRGBA{}
+	}
+	hasPalette := false
+	cels := make([][]*aseCel, numFrames)
+
+	for frame := 0; frame < numFrames; frame++ {
+		start := r.pos
+		size := int(r.u32())
+		if r.u16() != aseFrameMagic {
+			return nil, errors.New(fmt.Sprintf("Invalid Aseprite frame %d", frame))
+		}
+		numChunks := int(r.u16())
+		ase.Durations[frame] = int(r.u16())
+		r.skip(2)
+		if n := int(r.u32()); n != 0 {
+			numChunks = n
+		}
+
+		for chunk := 0; chunk < numChunks && r.err == nil; chunk++ {
+			chunkStart := r.pos
+			chunkSize := int(r.u32())
+			chunkType := r.u16()
+			cr := r.sub(chunkSize - 6)
+
+			switch chunkType {
+			case aseChunkLayer:
+				layerFlags := cr.u16()
+				layerType := cr.u16()
+				level := int(cr.u16())
+				cr.skip(2 + 2 + 2) // Default size and blend mode
+				opacity := cr.u8()
+				if !layerOpacity {
+					opacity = 255
+				}
+				// A layer is only visible if all the groups it is in are
+				visible := layerFlags&aseLayerVisible != 0
+				if level > len(parents) {
+					level = len(parents)
+				}
+				parents = parents[:level]
+				for _, v := range parents {
+					visible = visible && v
+				}
+				parents = append(parents, visible)
+				layers = append(layers, aseLayer{
+					visible:    visible,
+					background: layerFlags&aseLayerBackground != 0,
+					group:      layerType == aseLayerGroup,
+					opacity:    opacity,
+				})
+
+			case aseChunkCel:
+				layer := int(cr.u16())
+				x, y := cr.i16(), cr.i16()
+				opacity := cr.u8()
+				celType := cr.u16()
+				z := cr.i16()
+				cr.skip(5)
+				cel := &aseCel{layer: layer, z: z, opacity: opacity}
+				switch celType {
+				case aseCelRaw, aseCelCompressed:
+					w, h := int(cr.u16()), int(cr.u16())
+					pixels := cr.data[cr.pos:]
+					if celType == aseCelCompressed {
+						zr, err := zlib.NewReader(bytes.NewReader(pixels))
+						if err != nil {
+							return nil, err
+						}
+						if pixels, err = ioutil.ReadAll(zr); err != nil {
+							return nil, err
+						}
+					}
+					background := layer < len(layers) && layers[layer].background
+					im, err := aseCelImage(pixels, image.Rect(x, y, x+w, y+h), depth, palette, transparent, background)
+					if err != nil {
+						return nil, err
+					}
+					cel.image = im
+				case aseCelLinked:
+					linked := int(cr.u16())
+					if linked < frame {
+						for _, c := range cels[linked] {
+							if c.layer == layer {
+								cel.image = c.image
+								cel.opacity = c.opacity
+								cel.z = c.z
+							}
+						}
+					}
+				}
+				// Tilemap cels are not supported and are skipped
+				if cel.image != nil {
+					cels[frame] = append(cels[frame], cel)
+				}
+
+			case aseChunkPalette:
+				n := int(cr.u32())
+				first, last := int(cr.u32()), int(cr.u32())
+				cr.skip(8)
+				if n > len(palette) {
+					palette = append(palette, make(color.Palette, n-len(palette))...)
+				}
+				for i := first; i <= last && i < len(palette) && cr.err == nil; i++ {
+					entryFlags := cr.u16()
+					c := cr.bytes(4)
+					palette[i] = color.NRGBA{c[0], c[1], c[2], c[3]}
+					if entryFlags&1 != 0 {
+						aseString(cr)
+					}
+				}
+				hasPalette = true
+
+			case aseChunkOldPalette:
+				if hasPalette {
+					break
+				}
+				index := 0
+				for packets := int(cr.u16()); packets > 0 && cr.err == nil; packets-- {
+					index += int(cr.u8())
+					n := int(cr.u8())
+					if n == 0 {
+						n = 256
+					}
+					for ; n > 0 && index < len(palette); n-- {
+						c := cr.bytes(3)
+						palette[index] = color.NRGBA{c[0], c[1], c[2], 255}
+						index++
+					}
+				}
+
+			case aseChunkTags:
+				n := int(cr.u16())
+				cr.skip(8)
+				for i := 0; i < n && cr.err == nil; i++ {
+					tag := aseTag{From: int(cr.u16()), To: int(cr.u16())}
+					tag.Direction = cr.u8()
+					cr.skip(2 + 6 + 3 + 1) // Repeat, reserved and colour
+					tag.Name = aseString(cr)
+					ase.Tags = append(ase.Tags, tag)
+				}
+
+			case aseChunkSlice:
+				n := int(cr.u32())
+				sliceFlags := cr.u32()
+				cr.skip(4)
+				slice := aseSlice{Name: aseString(cr)}
+				for i := 0; i < n && cr.err == nil; i++ {
+					key := aseSliceKey{Frame: int(cr.u32())}
+					x, y := cr.i32(), cr.i32()
+					w, h := int(cr.u32()), int(cr.u32())
+					key.Bounds = image.Rect(x, y, x+w, y+h)
+					if sliceFlags&1 != 0 {
+						cx, cy := cr.i32(), cr.i32()
+						cw, ch := int(cr.u32()), int(cr.u32())
+						key.Center = image.Rect(cx, cy, cx+cw, cy+ch)
+					}
+					if sliceFlags&2 != 0 {
+						key.Pivot = &image.Point{cr.i32(), cr.i32()}
+					}
+					slice.Keys = append(slice.Keys, key)
+				}
+				ase.Slices = append(ase.Slices, slice)
+			}
+
+			if cr.err != nil {
+				return nil, cr.err
+			}
+			r.pos = chunkStart + chunkSize
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		r.pos = start + size
+	}
+
+	for frame := range ase.Frames {
+		ase.Frames[frame] = flattenAseFrame(cels[frame], layers, width, height)
+	}
+	return ase, nil
+}
+
+// Converts the pixel data of a cel into an image in the given colour depth
+func aseCelImage(pixels []byte, rect image.Rectangle, depth int, palette color.Palette, transparent uint8, background bool) (image.Image, error) {
+	n := rect.Dx() * rect.Dy()
+	if len(pixels) < n*depth/8 {
+		return nil, errors.New("Aseprite cel is missing pixel data")
+	}
+	im := image.NewNRGBA(rect)
+	switch depth {
+	case 32:
+		copy(im.Pix, pixels[:n*4])
+	case 16:
+		for i := 0; i < n; i++ {
+			v, a := pixels[i*2], pixels[i*2+1]
+			im.Pix[i*4], im.Pix[i*4+1], im.Pix[i*4+2], im.Pix[i*4+3] = v, v, v, a
+		}
+	case 8:
+		for i := 0; i < n; i++ {
+			index := pixels[i]
+			// The transparent index is only transparent on normal layers
+			if index == transparent && !background {
+				continue
+			}
+			c := color.NRGBAModel.Convert(palette[index]).(color.NRGBA)
+			im.Pix[i*4], im.Pix[i*4+1], im.Pix[i*4+2], im.Pix[i*4+3] = c.R, c.G, c.B, c.A
+		}
+	}
+	return im, nil
+}
+
+// Composites the cels of the visible layers of a frame from bottom to top
+func flattenAseFrame(cels []*aseCel, layers []aseLayer, width, height int) image.Image {
+	sort.SliceStable(cels, func(i, j int) bool {
+		// The z index moves a cel relative to the other layers
+		oi, oj := cels[i].layer+cels[i].z, cels[j].layer+cels[j].z
+		if oi == oj {
+			return cels[i].z < cels[j].z
+		}
+		return oi < oj
+	})
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+	for _, cel := range cels {
+		if cel.layer >= len(layers) {
+			continue
+		}
+		layer := layers[cel.layer]
+		if !layer.visible || layer.group {
+			continue
+		}
+		opacity := uint8(int(cel.opacity) * int(layer.opacity) / 255)
+		mask := image.NewUniform(color.Alpha{opacity})
+		draw.DrawMask(canvas, cel.image.Bounds(), cel.image, cel.image.Bounds().Min, mask, image.Point{}, draw.Over)
+	}
+	return canvas
+}
+
+// Returns the frames of a tag in the order they are played
+func (t aseTag) frames() []int {
+	frames := make([]int, 0)
+	for i := t.From; i <= t.To; i++ {
+		frames = append(frames, i)
+	}
+	reverse := func(s []int) []int {
+		r := make([]int, len(s))
+		for i, v := range s {
+			r[len(s)-1-i] = v
+		}
+		return r
+	}
+	switch t.Direction {
+	case aseTagReverse:
+		frames = reverse(frames)
+	case aseTagPingPong, aseTagPingPongReverse:
+		if t.Direction == aseTagPingPongReverse {
+			frames = reverse(frames)
+		}
+		// Play back through the frames without repeating either end
+		if len(frames) > 2 {
+			frames = append(frames, reverse(frames[1:len(frames)-1])...)
+		}
+	}
+	return frames
+}
+
+// Returns the slice key in effect at the given frame, keys last until
+// the next key for the slice
+func (s aseSlice) key(frame int) *aseSliceKey {
+	var res *aseSliceKey
+	for i := range s.Keys {
+		if s.Keys[i].Frame <= frame {
+			res = &s.Keys[i]
+		}
+	}
+	return res
+}
+
+// Reads an Aseprite file into a file per frame. Frames are named after
+// the file with the frame index appended and each tag becomes an
+// animation named after the file, including its directory, and tag, eg.
+// "chars/hero_walk". The first slice with nine-slice or pivot data is
+// applied to each frame
+func asepriteFiles(filename string, data []byte) ([]*File, []*Animation, error) {
+	ase, err := decodeAseprite(data)
+	if err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("Unable to read %s: %s", filename, err.Error()))
+	}
+
+	ext := path.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+	files := make([]*File, len(ase.Frames))
+	for i, frame := range ase.Frames {
+		size := frame.Bounds().Size()
+		file := &File{
+			FileName: filename,
+			Name:     fmt.Sprintf("%s_%d%s", base, i, ext),
+			Image:    frame,
+			Width:    size.X,
+			Height:   size.Y,
+			Duration: ase.Durations[i],
+		}
+		for _, slice := range ase.Slices {
+			key := slice.key(i)
+			if key == nil || (key.Center.Empty() && key.Pivot == nil) {
+				continue
+			}
+			if !key.Center.Empty() {
+				center := key.Center.Add(key.Bounds.Min)
+				file.NineSlice = &NineSlice{
+					Left:   center.Min.X,
+					Top:    center.Min.Y,
+					Right:  size.X - center.Max.X,
+					Bottom: size.Y - center.Max.Y,
+				}
+			}
+			if key.Pivot != nil {
+				pivot := key.Pivot.Add(key.Bounds.Min)
+				file.Pivot = &Pivot{
+					X: float64(pivot.X) / float64(size.X),
+					Y: float64(pivot.Y) / float64(size.Y),
+				}
+			}
+			break
+		}
+		files[i] = file
+	}
+
+	animations := make([]*Animation, 0, len(ase.Tags))
+	for _, tag := range ase.Tags {
+		animation := &Animation{Name: fmt.Sprintf("%s_%s", base, tag.Name)}
+		for _, i := range tag.frames() {
+			if i >= 0 && i < len(files) {
+				animation.Frames = append(animation.Frames, files[i])
+			}
+		}
+		animations = append(animations, animation)
+	}
+	return files, animations, nil
+}
@@ -0,0 +1,198 @@
+package atlas
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+)
+
+// Builds Aseprite files for testing, see
+// https://github.com/aseprite/aseprite/blob/main/docs/ase-file-specs.md
+type aseBuilder struct {
+	bytes.Buffer
+}
+
+func (b *aseBuilder) write(values ...interface{}) *aseBuilder {
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			binary.Write(b, binary.LittleEndian, uint16(len(s)))
+			b.WriteString(s)
+		} else {
+			binary.Write(b, binary.LittleEndian, v)
+		}
+	}
+	return b
+}
+
+// Wraps the data written by fn as a chunk of the given type
+func aseChunk(chunkType uint16, fn func(b *aseBuilder)) []byte {
+	data := &aseBuilder{}
+	fn(data)
+	chunk := &aseBuilder{}
+	chunk.write(uint32(data.Len()+6), chunkType)
+	chunk.Write(data.Bytes())
+	return chunk.Bytes()
+}
+
+func aseFrame(duration uint16, chunks ...[]byte) []byte {
+	data := bytes.Join(chunks, nil)
+	frame := &aseBuilder{}
+	frame.write(uint32(len(data)+16), uint16(aseFrameMagic), uint16(len(chunks)), duration, uint16(0), uint32(len(chunks)))
+	frame.Write(data)
+	return frame.Bytes()
+}
+
+func aseFile(width, height uint16, frames ...[]byte) []byte {
+	data := bytes.Join(frames, nil)
+	file := &aseBuilder{}
+	file.write(uint32(len(data)+128), uint16(aseMagic), uint16(len(frames)), width, height, uint16(32), uint32(1))
+	file.write(uint16(0), uint32(0), uint32(0), uint8(0), [3]byte{}, uint16(0), uint8(1), uint8(1))
+	file.write(int16(0), int16(0), uint16(16), uint16(16), [84]byte{})
+	file.Write(data)
+	return file.Bytes()
+}
+
+func aseLayerChunk(flags, layerType, level uint16, name string) []byte {
+	return aseChunk(aseChunkLayer, func(b *aseBuilder) {
+		b.write(flags, layerType, level, uint16(0), uint16(0), uint16(0), uint8(255), [3]byte{}, name)
+	})
+}
+
+// A cel filled with a single colour, compressed or raw
+func aseColourCel(layer uint16, rect image.Rectangle, c color.NRGBA, compressed bool) []byte {
+	pixels := make([]byte, 0)
+	for i := 0; i < rect.Dx()*rect.Dy(); i++ {
+		pixels = append(pixels, c.R, c.G, c.B, c.A)
+	}
+	celType := uint16(aseCelRaw)
+	if compressed {
+		celType = aseCelCompressed
+		buf := &bytes.Buffer{}
+		w := zlib.NewWriter(buf)
+		w.Write(pixels)
+		w.Close()
+		pixels = buf.Bytes()
+	}
+	return aseChunk(aseChunkCel, func(b *aseBuilder) {
+		b.write(layer, int16(rect.Min.X), int16(rect.Min.Y), uint8(255), celType, int16(0), [5]byte{})
+		b.write(uint16(rect.Dx()), uint16(rect.Dy()))
+		b.Write(pixels)
+	})
+}
+
+func TestAsepriteFiles(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	blue := color.NRGBA{0, 0, 255, 255}
+
+	data := aseFile(4, 4,
+		aseFrame(100,
+			aseLayerChunk(aseLayerVisible, 0, 0, "base"),
+			aseLayerChunk(0, 0, 0, "hidden"),
+			aseLayerChunk(aseLayerVisible, aseLayerGroup, 0, "group"),
+			aseLayerChunk(aseLayerVisible, 0, 1, "top"),
+			aseColourCel(0, image.Rect(0, 0, 4, 4), red, true),
+			aseColourCel(1, image.Rect(0, 0, 4, 4), blue, false),
+			aseColourCel(3, image.Rect(1, 1, 2, 2), blue, false),
+			aseChunk(aseChunkTags, func(b *aseBuilder) {
+				b.write(uint16(1), [8]byte{})
+				b.write(uint16(0), uint16(2), uint8(aseTagPingPong), uint16(0), [6]byte{}, [3]byte{}, uint8(0), "walk")
+			}),
+			aseChunk(aseChunkSlice, func(b *aseBuilder) {
+				b.write(uint32(1), uint32(3), uint32(0), "slice")
+				b.write(uint32(0), int32(0), int32(0), uint32(4), uint32(4))
+				b.write(int32(1), int32(1), uint32(2), uint32(1))
+				b.write(int32(2), int32(1))
+			}),
+		),
+		aseFrame(200,
+			aseChunk(aseChunkCel, func(b *aseBuilder) {
+				b.write(uint16(0), int16(0), int16(0), uint8(255), uint16(aseCelLinked), int16(0), [5]byte{}, uint16(0))
+			}),
+		),
+		aseFrame(300),
+	)
+
+	files, animations, err := asepriteFiles("chars/hero.ase", data)
+	if err != nil {
+		t.Fatalf("asepriteFiles threw an error: %s", err.Error())
+	}
+
+	type Want struct {
+		name     string
+		duration int
+		pixels   map[image.Point]color.NRGBA
+	}
+	cases := []Want{
+		{"chars/hero_0.ase", 100, map[image.Point]color.NRGBA{{0, 0}: red, {1, 1}: blue}},
+		{"chars/hero_1.ase", 200, map[image.Point]color.NRGBA{{0, 0}: red, {1, 1}: red}},
+		{"chars/hero_2.ase", 300, map[image.Point]color.NRGBA{{0, 0}: color.NRGBA{}}},
+	}
+	if len(files) != len(cases) {
+		t.Fatalf("Unexpected number of frames: want %d, got %d", len(cases), len(files))
+	}
+	for i, c := range cases {
+		file := files[i]
+		if file.Name != c.name {
+			t.Errorf("Unexpected frame name: want %s, got %s", c.name, file.Name)
+		}
+		if file.Duration != c.duration {
+			t.Errorf("Unexpected duration for %s: want %d, got %d", c.name, c.duration, file.Duration)
+		}
+		if file.Width != 4 || file.Height != 4 {
+			t.Errorf("Unexpected size for %s: want 4x4, got %dx%d", c.name, file.Width, file.Height)
+		}
+		for p, want := range c.pixels {
+			got := color.NRGBAModel.Convert(file.Image.At(p.X, p.Y)).(color.NRGBA)
+			if got != want {
+				t.Errorf("Unexpected pixel %v in %s: want %v, got %v", p, c.name, want, got)
+			}
+		}
+		if file.NineSlice == nil || *file.NineSlice != (NineSlice{1, 1, 1, 2}) {
+			t.Errorf("Unexpected nine-slice for %s: want %v, got %v", c.name, NineSlice{1, 1, 1, 2}, file.NineSlice)
+		}
+		if file.Pivot == nil || *file.Pivot != (Pivot{0.5, 0.25}) {
+			t.Errorf("Unexpected pivot for %s: want %v, got %v", c.name, Pivot{0.5, 0.25}, file.Pivot)
+		}
+	}
+
+	if len(animations) != 1 {
+		t.Fatalf("Unexpected number of animations: want 1, got %d", len(animations))
+	}
+	if animations[0].Name != "chars/hero_walk" {
+		t.Errorf("Unexpected animation name: want chars/hero_walk, got %s", animations[0].Name)
+	}
+	order := []*File{files[0], files[1], files[2], files[1]}
+	if len(animations[0].Frames) != len(order) {
+		t.Fatalf("Unexpected number of animation frames: want %d, got %d", len(order), len(animations[0].Frames))
+	}
+	for i, frame := range animations[0].Frames {
+		if frame != order[i] {
+			t.Errorf("Unexpected animation frame %d: want %s, got %s", i, order[i].Name, frame.Name)
+		}
+	}
+
+	if _, _, err := asepriteFiles("broken.ase", data[:200]); err == nil {
+		t.Errorf("Expected an error reading a truncated file")
+	}
+
+	// The numbered frames are not detected again as a sequence
+	filename := path.Join(t.TempDir(), "hero.ase")
+	if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, _, desc := generateJSON(t, []string{filename}, &GenerateParams{Descriptor: DESC_JSON_HASH})
+	var parsed struct {
+		Animations map[string][]string
+	}
+	parseDescriptor(t, desc, &parsed)
+	want := strings.TrimSuffix(filename, ".ase") + "_walk"
+	if len(parsed.Animations) != 1 || parsed.Animations[want] == nil {
+		t.Errorf("Unexpected animations: want only %s, got %v", want, parsed.Animations)
+	}
+}
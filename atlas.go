@@ -4,9 +4,8 @@ import (
 	"fmt"
 	"image"
 	"image/draw"
-	_ "image/gif"
 	_ "image/jpeg"
-	"image/png"
+	"io/ioutil"
 	"os"
 	"path"
 )
@@ -19,6 +18,50 @@ type Atlas struct {
 	MaxWidth, MaxHeight int
 	Padding, Gutter     int
 	Descriptor          DescriptorFormat
+	Animations          []*Animation
+	// The scale of the atlas relative to the source images, 0 is treated
+	// as 1
+	Scale float64
+	// Whether to fill the colour of transparent pixels from their
+	// neighbours, see alphaBleed
+	AlphaBleed bool
+	// Whether the colour of the image is written multiplied by its alpha
+	Premultiplied bool
+	// The number of colours to reduce the image to, writing an indexed
+	// image, or 0 to keep full colour
+	PaletteSize int
+	// The dithering used when reducing colours
+	Dither Dither
+	// The pixel format of the texture, defaults to PIXEL_RGBA8888
+	PixelFormat PixelFormat
+	// How the texture is written, defaults to CONTAINER_PNG
+	Container Container
+	// The number of mipmap levels to generate below the full size image,
+	// or MIP_FULL for every level down to 1x1
+	MipLevels int
+	// The filter used to shrink each mipmap level, defaults to MIP_BOX
+	MipFilter MipFilter
+	// The mipmap level down to which files are shrunk separately, files
+	// must be aligned to 2 to the power of this level
+	MipSeparation int
+	// Whether alpha is written to a separate image, see AlphaImageName
+	AlphaSplit AlphaSplit
+	// Encodes images written to CONTAINER_PNG, defaults to a PNGEncoder
+	Encoder ImageEncoder
+	// Extra images written with the same layout as the atlas, see
+	// CompanionImageName
+	Companions []Companion
+	// Whether files are grayscale masks drawn into the channel given by
+	// File.Channel
+	ChannelPack bool
+	// The kind of distance field files were converted to and the distance
+	// in pixels it covers either side of an edge
+	DistanceField DistanceField
+	Spread        int
+	// Whether the hit masks of the files are written, see HitMaskName,
+	// and the alpha at or above which their pixels are set
+	HitMask      bool
+	HitThreshold uint8
 }
 
 // Adds a file into the atlas at the given position
@@ -29,21 +72,143 @@ func (a *Atlas) AddFile(file *File, x, y int) {
 	a.Files = append(a.Files, file)
 }
 
+// Returns the index of the given file within the atlas or -1 if the
+// file is not in this atlas
+func (a *Atlas) Index(file *File) int {
+	for i, f := range a.Files {
+		if f == file {
+			return i
+		}
+	}
+	return -1
+}
+
+// Returns the file name of the image written for this atlas
+func (a *Atlas) ImageName() string {
+	return fmt.Sprintf("%s.%s", a.Name, a.imageExt())
+}
+
+// Returns the file name of the image holding the alpha of this atlas when
+// alpha is split from colour, otherwise an empty string
+func (a *Atlas) AlphaImageName() string {
+	if a.AlphaSplit == ALPHA_SPLIT_NONE {
+		return ""
+	}
+	return fmt.Sprintf("%s%s.%s", a.Name, ALPHA_SUFFIX, a.imageExt())
+}
+
+// Returns the file names of the mipmap levels of this atlas when they are
+// written as separate images, DDS and KTX containers hold every level
+// in a single file so return nil
+func (a *Atlas) MipNames() []string {
+	return a.mipNames(a.Name)
+}
+
+func (a *Atlas) mipNames(name string) []string {
+	if a.Container == CONTAINER_DDS || a.Container == CONTAINER_KTX {
+		return nil
+	}
+	count := mipCount(a.MipLevels, a.Width, a.Height)
+	names := make([]string, count)
+	for i := range names {
+		names[i] = fmt.Sprintf("%s-mip%d.%s", name, i+1, a.imageExt())
+	}
+	return names
+}
+
+func (a *Atlas) imageExt() string {
+	if a.Container == "" || a.Container == CONTAINER_PNG {
+		return a.encoder().Ext()
+	}
+	return string(a.Container)
+}
+
+func (a *Atlas) encoder() ImageEncoder {
+	if a.Encoder == nil {
+		return &PNGEncoder{}
+	}
+	return a.Encoder
+}
+
 // Writes the atlas to the given output directory, this is shorthand
-// for calling both WriteImage and WriteDescriptor
+// for calling WriteImage, WriteDescriptor and WriteHitMasks
 func (a *Atlas) Write(outputDir string) error {
-	if err := a.WriteImage(outputDir); err == nil {
-		return a.WriteDescriptor(outputDir)
-	} else {
+	if err := a.WriteImage(outputDir); err != nil {
 		return err
 	}
+	if err := a.WriteDescriptor(outputDir); err != nil {
+		return err
+	}
+	return a.WriteHitMasks(outputDir)
 }
 
 // Writes the image for this atlas to the given output directory
 // Returns an error if any IO operation fails
 func (a *Atlas) WriteImage(outputDir string) (err error) {
-	// Generate the image data
-	im := image.NewRGBA(image.Rect(0, 0, a.Width, a.Height))
+	var im *image.NRGBA
+	space := mipSRGB
+	if a.ChannelPack {
+		im, err = a.compositeChannels()
+		space = mipChannels
+	} else {
+		im, err = a.composite((*File).decode)
+	}
+	if a.DistanceField != DF_NONE {
+		// Distances are data so mipmaps average them without gamma
+		space = mipChannels
+	}
+	if err != nil {
+		return err
+	}
+
+	levels := []*image.NRGBA{im}
+	if a.MipLevels != 0 {
+		levels = append(levels, a.mipmaps(im, space)...)
+	}
+
+	format := a.PixelFormat
+	if format == "" {
+		format = PIXEL_RGBA8888
+	}
+	for _, level := range levels {
+		if a.AlphaBleed {
+			alphaBleed(level)
+		}
+		if a.Premultiplied {
+			premultiply(level)
+		}
+	}
+
+	if a.AlphaSplit != ALPHA_SPLIT_NONE {
+		alphaLevels := make([]*image.NRGBA, len(levels))
+		for i, level := range levels {
+			alphaLevels[i] = splitAlpha(level)
+		}
+		alphaFormat := format
+		if a.AlphaSplit == ALPHA_SPLIT_GRAY && !format.Compressed() {
+			alphaFormat = PIXEL_L8
+		}
+		if err := a.writeLevels(outputDir, a.Name+ALPHA_SUFFIX, alphaLevels, alphaFormat); err != nil {
+			return err
+		}
+	}
+	if err := a.writeLevels(outputDir, a.Name, levels, format); err != nil {
+		return err
+	}
+	for _, companion := range a.Companions {
+		if err := a.writeCompanion(outputDir, companion, format); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Draws the image of every file at its position in a new image the size
+// of the atlas, using decode to get the image of each file
+func (a *Atlas) composite(decode func(file *File) (image.Image, error)) (*image.NRGBA, error) {
+	// Generate the image data, the colour is kept separate from alpha so
+	// that transparent pixels can still have a colour
+	im := image.NewNRGBA(image.Rect(0, 0, a.Width, a.Height))
 	// Set the background colour of the image
 	for i, n := 0, len(im.Pix); i < n; i += 4 {
 		im.Pix[i] = 0   // Red
@@ -52,17 +217,18 @@ func (a *Atlas) WriteImage(outputDir string) (err error) {
 		im.Pix[i+3] = 0 // Alpha
 	}
 
+	var err error
 	if a.Gutter == 0 {
-		err = compositeImage(a.Files, func(file *File, cim image.Image) {
+		err = compositeImage(a.Files, decode, func(file *File, cim image.Image) {
 			dp := image.Pt(file.X+a.Padding, file.Y+a.Padding)
 			draw.Draw(im, image.Rectangle{dp, dp.Add(cim.Bounds().Size())}, cim, cim.Bounds().Min, draw.Src)
 		})
 	} else {
-		err = compositeImage(a.Files, func(file *File, cim image.Image) {
+		err = compositeImage(a.Files, decode, func(file *File, cim image.Image) {
 			// Create a temp image with padding for the gutter
 			cimSize := cim.Bounds().Size()
 			tempRect := image.Rect(0, 0, cimSize.X+a.Gutter*2, cimSize.Y+a.Gutter*2)
-			temp := image.NewRGBA(tempRect)
+			temp := image.NewNRGBA(tempRect)
 			dp := image.Pt(a.Gutter, a.Gutter)
 			draw.Draw(temp, image.Rectangle{dp, dp.Add(cimSize)}, cim, cim.Bounds().Min, draw.Src)
 			// Bleed the image into the gutter space
@@ -72,38 +238,68 @@ func (a *Atlas) WriteImage(outputDir string) (err error) {
 			draw.Draw(im, image.Rectangle{dp, dp.Add(tempRect.Size())}, temp, temp.Bounds().Min, draw.Src)
 		})
 	}
+	return im, err
+}
 
-	if err != nil {
-		return err
+// Writes the levels of an image in the pixel format and container of the
+// atlas, using the given name in place of the name of the atlas
+func (a *Atlas) writeLevels(outputDir, name string, levels []*image.NRGBA, format PixelFormat) error {
+	if format != PIXEL_RGBA8888 && !format.Compressed() {
+		for _, level := range levels {
+			reduceDepth(level, format, a.Dither)
+		}
 	}
 
-	out, err := os.Create(path.Join(outputDir, fmt.Sprintf("%s.png", a.Name)))
-	if err != nil {
-		return err
+	imageName := fmt.Sprintf("%s.%s", name, a.imageExt())
+	if a.Container == CONTAINER_DDS || a.Container == CONTAINER_KTX {
+		encode := encodeDDS
+		if a.Container == CONTAINER_KTX {
+			encode = encodeKTX
+		}
+		data := make([][]byte, len(levels))
+		for i, level := range levels {
+			data[i] = textureData(level, format)
+		}
+		encoded, err := encode(format, a.Width, a.Height, data)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(path.Join(outputDir, imageName), encoded, 0644)
 	}
 
-	err = png.Encode(out, im)
-	if err != nil {
-		return err
+	// Other containers hold a single image so each level is written to its
+	// own file
+	names := append([]string{imageName}, a.mipNames(name)...)
+	for i, level := range levels {
+		var err error
+		filename := path.Join(outputDir, names[i])
+		switch {
+		case a.Container == CONTAINER_RAW:
+			err = ioutil.WriteFile(filename, textureData(level, format), 0644)
+		case format == PIXEL_L8:
+			gray := image.NewGray(level.Bounds())
+			draw.Draw(gray, gray.Bounds(), level, level.Bounds().Min, draw.Src)
+			err = writeImage(filename, gray, a.encoder())
+		case a.PaletteSize > 0 && format == PIXEL_RGBA8888:
+			err = writeImage(filename, quantize(level, a.PaletteSize, a.Dither), a.encoder())
+		default:
+			err = writeImage(filename, level, a.encoder())
+		}
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-// Runs through all the given files, reading their image and then performs the op function on them
-func compositeImage(files []*File, op func(file *File, cim image.Image)) error {
+// Runs through all the given files, reading their image with decode and then performs the op function on them
+func compositeImage(files []*File, decode func(file *File) (image.Image, error), op func(file *File, cim image.Image)) error {
 	for _, file := range files {
-		// Open the given file
-		r, err := os.Open(file.FileName)
-		if err != nil {
-			return err
-		}
-		// Decode the image
-		cim, _, err := image.Decode(r)
+		cim, err := decode(file)
 		if err != nil {
 			return err
 		}
 		op(file, cim)
-		r.Close()
 	}
 	return nil
 }
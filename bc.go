@@ -0,0 +1,238 @@
+package atlas
+
+import (
+	"encoding/binary"
+	"image"
+)
+
+// The size in pixels of the blocks used by compressed pixel formats
+const BLOCK_SIZE = 4
+
+// Calls fn with the pixels of each 4x4 block of the image in row order,
+// blocks that hang over the edge of the image repeat its last row/column
+func forBlocks(im *image.NRGBA, fn func(block *[16][4]uint8)) {
+	b := im.Bounds()
+	var block [16][4]uint8
+	for by := 0; by < b.Dy(); by += BLOCK_SIZE {
+		for bx := 0; bx < b.Dx(); bx += BLOCK_SIZE {
+			for i := range block {
+				x := clampInt(bx+i%4, 0, b.Dx()-1)
+				y := clampInt(by+i/4, 0, b.Dy()-1)
+				copy(block[i][:], im.Pix[im.PixOffset(b.Min.X+x, b.Min.Y+y):])
+			}
+			fn(&block)
+		}
+	}
+}
+
+// Encodes the image as BC1 (DXT1) blocks. Pixels with alpha below half
+// are made transparent using the 3 colour mode of the format
+func encodeBC1(im *image.NRGBA) []byte {
+	out := make([]byte, 0)
+	forBlocks(im, func(block *[16][4]uint8) {
+		out = appendBC1Colour(out, block, true)
+	})
+	return out
+}
+
+// Encodes the image as BC3 (DXT5) blocks, an alpha block followed by a
+// colour block
+func encodeBC3(im *image.NRGBA) []byte {
+	out := make([]byte, 0)
+	forBlocks(im, func(block *[16][4]uint8) {
+		out = appendBC3Alpha(out, block)
+		out = appendBC1Colour(out, block, false)
+	})
+	return out
+}
+
+// Packs a colour into 5:6:5 bits
+func to565(c [3]int) uint16 {
+	r := uint16((clampInt(c[0], 0, 255)*31 + 127) / 255)
+	g := uint16((clampInt(c[1], 0, 255)*63 + 127) / 255)
+	b := uint16((clampInt(c[2], 0, 255)*31 + 127) / 255)
+	return r<<11 | g<<5 | b
+}
+
+// Expands a 5:6:5 colour back to 8 bits per channel
+func from565(v uint16) [3]int {
+	r, g, b := int(v>>11&31), int(v>>5&63), int(v&31)
+	return [3]int{r<<3 | r>>2, g<<2 | g>>4, b<<3 | b>>2}
+}
+
+// Appends a BC1 colour block. The end points are the two pixels furthest
+// apart along the main axis of the colours of the block. When alpha is
+// allowed, transparent pixels switch the block to its 3 colour mode
+func appendBC1Colour(out []byte, block *[16][4]uint8, alpha bool) []byte {
+	transparent := false
+	var mean [3]int
+	count := 0
+	for _, p := range block {
+		if alpha && p[3] < 128 {
+			transparent = true
+			continue
+		}
+		for k := range mean {
+			mean[k] += int(p[k])
+		}
+		count++
+	}
+	if count == 0 {
+		// Every pixel is transparent
+		return append(out, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff)
+	}
+	for k := range mean {
+		mean[k] /= count
+	}
+
+	// Use the channel with the widest range as the axis and flip the
+	// others to follow how they change with it
+	var lo, hi [3]int
+	for k := range lo {
+		lo[k], hi[k] = 255, 0
+	}
+	var cov [3]int
+	for _, p := range block {
+		if alpha && p[3] < 128 {
+			continue
+		}
+		for k := range lo {
+			lo[k] = minInt(lo[k], int(p[k]))
+			hi[k] = maxInt(hi[k], int(p[k]))
+		}
+		d0 := int(p[0]) - mean[0]
+		cov[1] += d0 * (int(p[1]) - mean[1])
+		cov[2] += d0 * (int(p[2]) - mean[2])
+	}
+	axis := [3]int{hi[0] - lo[0], hi[1] - lo[1], hi[2] - lo[2]}
+	if cov[1] < 0 {
+		axis[1] = -axis[1]
+	}
+	if cov[2] < 0 {
+		axis[2] = -axis[2]
+	}
+	minDot, maxDot := 0, 0
+	var c0, c1 [3]int
+	first := true
+	for _, p := range block {
+		if alpha && p[3] < 128 {
+			continue
+		}
+		dot := 0
+		for k := range axis {
+			dot += int(p[k]) * axis[k]
+		}
+		if first || dot < minDot {
+			minDot, c1 = dot, [3]int{int(p[0]), int(p[1]), int(p[2])}
+		}
+		if first || dot > maxDot {
+			maxDot, c0 = dot, [3]int{int(p[0]), int(p[1]), int(p[2])}
+		}
+		first = false
+	}
+
+	e0, e1 := to565(c0), to565(c1)
+	// The order of the end points selects the mode, 4 colours when the
+	// first is larger and 3 colours plus transparent otherwise
+	if transparent {
+		if e0 > e1 {
+			e0, e1 = e1, e0
+		}
+	} else if e0 < e1 {
+		e0, e1 = e1, e0
+	}
+	p0, p1 := from565(e0), from565(e1)
+	var palette [4][3]int
+	palette[0], palette[1] = p0, p1
+	for k := 0; k < 3; k++ {
+		if e0 > e1 {
+			palette[2][k] = (2*p0[k] + p1[k]) / 3
+			palette[3][k] = (p0[k] + 2*p1[k]) / 3
+		} else {
+			palette[2][k] = (p0[k] + p1[k]) / 2
+		}
+	}
+	colours := 4
+	if e0 <= e1 {
+		colours = 3
+	}
+
+	indices := uint32(0)
+	for i, p := range block {
+		index := 3
+		if !(alpha && p[3] < 128) {
+			index = nearestColour(p, palette[:colours])
+		}
+		indices |= uint32(index) << (uint(i) * 2)
+	}
+	out = binary.LittleEndian.AppendUint16(out, e0)
+	out = binary.LittleEndian.AppendUint16(out, e1)
+	return binary.LittleEndian.AppendUint32(out, indices)
+}
+
+// Appends a BC3 alpha block using the 8 value mode between the lowest and
+// highest alpha of the block
+func appendBC3Alpha(out []byte, block *[16][4]uint8) []byte {
+	a0, a1 := 0, 255
+	for _, p := range block {
+		a0 = maxInt(a0, int(p[3]))
+		a1 = minInt(a1, int(p[3]))
+	}
+	var palette [8]int
+	palette[0], palette[1] = a0, a1
+	for i := 1; i < 7; i++ {
+		palette[i+1] = ((7-i)*a0 + i*a1) / 7
+	}
+	indices := uint64(0)
+	for i, p := range block {
+		best, bestDist := 0, -1
+		for j, a := range palette {
+			if d := absInt(int(p[3]) - a); bestDist == -1 || d < bestDist {
+				best, bestDist = j, d
+			}
+		}
+		indices |= uint64(best) << (uint(i) * 3)
+	}
+	out = append(out, uint8(a0), uint8(a1))
+	for i := 0; i < 6; i++ {
+		out = append(out, uint8(indices>>(uint(i)*8)))
+	}
+	return out
+}
+
+// Returns the index of the palette colour nearest to the pixel
+func nearestColour(p [4]uint8, palette [][3]int) int {
+	best, bestDist := 0, -1
+	for i, c := range palette {
+		dist := 0
+		for k := range c {
+			d := int(p[k]) - c[k]
+			dist += d * d
+		}
+		if bestDist == -1 || dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+	return best
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,298 @@
+package atlas
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Available bitmap font descriptor formats, both are AngelCode BMFont
+// files written with the .fnt extension
+type FontFormat string
+
+const (
+	FONT_TEXT FontFormat = "bmfont"
+	FONT_XML  FontFormat = "bmfont_xml"
+)
+
+// The printable ASCII characters, used when no characters are given
+const CHARSET_ASCII = " !\"#$%&'()*+,-./0123456789:;<=>?@ABCDEFGHIJKLMNOPQRSTUVWXYZ[\\]^_`abcdefghijklmnopqrstuvwxyz{|}~"
+
+// The size fonts are rasterised at when none is given
+const DEFAULT_FONT_SIZE = 32
+
+// Includes parameters that can be passed to the GenerateFont function
+type FontParams struct {
+	Name   string
+	Format FontFormat
+	// The size of the em square in pixels, defaults to DEFAULT_FONT_SIZE
+	Size int
+	// The characters to include, defaults to CHARSET_ASCII. Characters
+	// the font has no glyph for are skipped
+	Characters          string
+	Packer              Packer
+	Sorter              Sorter
+	MaxWidth, MaxHeight int
+	Padding             int
+	// The image format pages are written in, defaults to a PNGEncoder
+	Encoder ImageEncoder
+}
+
+// Represents a bitmap font made from a TrueType or OpenType font, with
+// the glyphs packed into one or more atlases (pages)
+type Font struct {
+	Name string
+	// The family name of the font
+	Face string
+	Size int
+	// The distance between lines and from the top of a line to the
+	// baseline, in pixels
+	LineHeight, Base int
+	// The size of every page, pages are all the size of the largest
+	Width, Height int
+	Padding       int
+	Atlases       []*Atlas
+	Glyphs        []*Glyph
+	Kernings      []*Kerning
+}
+
+// Represents a single character of a bitmap font
+type Glyph struct {
+	ID rune
+	// The packed image of the glyph, nil if the glyph has no pixels
+	// such as a space
+	File *File
+	// The page holding the glyph
+	Page int
+	// The offset from the pen position to the top left of the image and
+	// the distance the pen moves after drawing the glyph
+	XOffset, YOffset, XAdvance int
+}
+
+// Represents a change to the advance between two characters
+type Kerning struct {
+	First, Second rune
+	Amount        int
+}
+
+// Returns the area of the page covered by the glyph's image
+func (g *Glyph) Frame() image.Rectangle {
+	if g.File == nil {
+		return image.Rectangle{}
+	}
+	return g.File.Frame()
+}
+
+// Returns the gap left between glyphs on each page
+func (f *Font) Spacing() int {
+	return f.Padding * 2
+}
+
+// Generates a bitmap font from a TrueType or OpenType font file, packing
+// the glyphs of the given characters into pages written to the output
+// directory along with a BMFont descriptor.
+// Will generate an error if any IO operations fail, if the font can not
+// be read or if the FontParams represent an invalid configuration
+func GenerateFont(fontFile, outputDir string, params *FontParams) (*Font, error) {
+	// Apply any default parameters
+	if params == nil {
+		params = &FontParams{}
+	}
+	if params.Name == "" {
+		params.Name = "font"
+	}
+	if params.Format == "" {
+		params.Format = FONT_TEXT
+	}
+	if params.Format != FONT_TEXT && params.Format != FONT_XML {
+		return nil, errors.New(fmt.Sprintf("Unsupported font format %s", params.Format))
+	}
+	if params.Size == 0 {
+		params.Size = DEFAULT_FONT_SIZE
+	}
+	if params.Size < 0 {
+		return nil, errors.New("Size can not be negative")
+	}
+	if params.Characters == "" {
+		params.Characters = CHARSET_ASCII
+	}
+	if params.Packer == nil {
+		params.Packer = PackGrowing
+	}
+	if params.Sorter == nil {
+		params.Sorter = GetSorterFromString(SORT_DEFAULT)
+	}
+	if params.MaxWidth == 0 {
+		params.MaxWidth = math.MaxInt32
+	}
+	if params.MaxHeight == 0 {
+		params.MaxHeight = math.MaxInt32
+	}
+
+	data, err := ioutil.ReadFile(fontFile)
+	if err != nil {
+		return nil, err
+	}
+	f, err := parseFont(data)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to read %s: %s", fontFile, err.Error()))
+	}
+
+	scale := float64(params.Size) / float64(f.unitsPerEm)
+	res := &Font{
+		Name:       params.Name,
+		Face:       f.family,
+		Size:       params.Size,
+		LineHeight: int(math.Round(float64(f.ascent-f.descent+f.lineGap) * scale)),
+		Base:       int(math.Round(float64(f.ascent) * scale)),
+		Padding:    params.Padding,
+		Glyphs:     make([]*Glyph, 0),
+		Kernings:   make([]*Kerning, 0),
+	}
+	if res.Face == "" {
+		res.Face = strings.TrimSuffix(path.Base(fontFile), path.Ext(fontFile))
+	}
+
+	chars := []rune(params.Characters)
+	sort.Slice(chars, func(i, j int) bool { return chars[i] < chars[j] })
+	indices := make(map[rune]int)
+	files := make([]*File, 0, len(chars))
+	for _, c := range chars {
+		if _, ok := indices[c]; ok {
+			continue
+		}
+		index := f.glyphIndex(c)
+		if index == 0 {
+			fmt.Printf("Font has no glyph for %q\n", c)
+			continue
+		}
+		indices[c] = index
+		glyph, err := fontGlyph(f, c, index, scale, fontFile)
+		if err != nil {
+			return nil, err
+		}
+		glyph.YOffset += res.Base
+		res.Glyphs = append(res.Glyphs, glyph)
+		if file := glyph.File; file != nil {
+			file.Width += params.Padding * 2
+			file.Height += params.Padding * 2
+			if file.Width > params.MaxWidth || file.Height > params.MaxHeight {
+				return nil, errors.New(fmt.Sprintf("Glyph %q exceeds maximum size of atlas (%dx%d)",
+					c, file.Width, file.Height))
+			}
+			files = append(files, file)
+		}
+	}
+
+	for _, first := range res.Glyphs {
+		for _, second := range res.Glyphs {
+			amount := int(math.Round(float64(f.kerning(indices[first.ID], indices[second.ID])) * scale))
+			if amount != 0 {
+				res.Kernings = append(res.Kernings, &Kerning{first.ID, second.ID, amount})
+			}
+		}
+	}
+
+	pending := params.Sorter(files)
+	for i := 0; len(pending) > 0; i++ {
+		atlas := &Atlas{
+			Name:      fmt.Sprintf("%s-%d", params.Name, i+1),
+			MaxWidth:  params.MaxWidth,
+			MaxHeight: params.MaxHeight,
+			Padding:   params.Padding,
+			Encoder:   params.Encoder,
+		}
+		res.Atlases = append(res.Atlases, atlas)
+		params.Packer(atlas, pending)
+		pending = getRemainingFiles(pending)
+		res.Width = maxInt(res.Width, atlas.Width)
+		res.Height = maxInt(res.Height, atlas.Height)
+	}
+	for _, glyph := range res.Glyphs {
+		if glyph.File != nil {
+			for page, atlas := range res.Atlases {
+				if glyph.File.Atlas == atlas {
+					glyph.Page = page
+				}
+			}
+		}
+	}
+	// Loaders work out texture coordinates from a single page size
+	for _, atlas := range res.Atlases {
+		atlas.Width, atlas.Height = res.Width, res.Height
+		fmt.Printf("Writing font page named %s to %s\n", atlas.Name, outputDir)
+		if err := atlas.WriteImage(outputDir); err != nil {
+			return nil, err
+		}
+	}
+	if err := res.WriteDescriptor(outputDir, params.Format); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Rasterises the glyph for a character, the offsets are relative to the
+// baseline
+func fontGlyph(f *font, c rune, index int, scale float64, fontFile string) (*Glyph, error) {
+	glyph := &Glyph{ID: c, XAdvance: int(math.Round(float64(f.advances[index]) * scale))}
+	contours, err := f.glyphContours(index, 0.1/scale)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to read glyph %q: %s", c, err.Error()))
+	}
+	if len(contours) == 0 {
+		return glyph, nil
+	}
+
+	// Font units have y pointing up from the baseline
+	contours = transformContours(contours, affine{scale, 0, 0, -scale, 0, 0})
+	min, max := contourBounds(contours)
+	x0, y0 := int(math.Floor(min.X)), int(math.Floor(min.Y))
+	width, height := int(math.Ceil(max.X))-x0, int(math.Ceil(max.Y))-y0
+	if width <= 0 || height <= 0 {
+		return glyph, nil
+	}
+	contours = transformContours(contours, affine{1, 0, 0, 1, float64(-x0), float64(-y0)})
+	coverage := rasterize(contours, width, height, fillNonZero)
+
+	im := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i, v := range coverage {
+		copy(im.Pix[i*4:], []uint8{255, 255, 255, clampByte(float64(v) * 255)})
+	}
+	glyph.XOffset, glyph.YOffset = x0, y0
+	glyph.File = &File{
+		FileName: fontFile,
+		Name:     string(c),
+		Image:    im,
+		Width:    width,
+		Height:   height,
+	}
+	return glyph, nil
+}
+
+// Returns the file name of the descriptor written for this font
+func (f *Font) DescriptorName() string {
+	return f.Name + ".fnt"
+}
+
+// Writes the BMFont descriptor for this font to the given output directory
+// Returns an error if any IO operation fails
+func (f *Font) WriteDescriptor(outputDir string, format FontFormat) error {
+	t, err := template.New(fmt.Sprintf("%s.template", format)).
+		ParseFiles(fmt.Sprintf("templates/%s.template", format))
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(path.Join(outputDir, f.DescriptorName()))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	return t.Execute(out, f)
+}
@@ -0,0 +1,586 @@
+package atlas
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Dict operators and Type 2 charstring operators of CFF fonts, escaped
+// operators are 1200 plus the second byte, see
+// https://adobe-type-tools.github.io/font-tech-notes/pdfs/5176.CFF.pdf and
+// https://adobe-type-tools.github.io/font-tech-notes/pdfs/5177.Type2.pdf
+const (
+	cffCharStrings = 17
+	cffPrivate     = 18
+	cffSubrs       = 19
+	cffCharType    = 1206
+	cffFDArray     = 1236
+	cffFDSelect    = 1237
+
+	csHStem      = 1
+	csVStem      = 3
+	csVMoveTo    = 4
+	csRLineTo    = 5
+	csHLineTo    = 6
+	csVLineTo    = 7
+	csRRCurveTo  = 8
+	csCallSubr   = 10
+	csReturn     = 11
+	csEscape     = 12
+	csEndChar    = 14
+	csHStemHM    = 18
+	csHintMask   = 19
+	csCntrMask   = 20
+	csRMoveTo    = 21
+	csHMoveTo    = 22
+	csVStemHM    = 23
+	csRCurveLine = 24
+	csRLineCurve = 25
+	csVVCurveTo  = 26
+	csHHCurveTo  = 27
+	csCallGSubr  = 29
+	csVHCurveTo  = 30
+	csHVCurveTo  = 31
+	csHFlex      = 34
+	csFlex       = 35
+	csHFlex1     = 36
+	csFlex1      = 37
+
+	// Limits from the Type 2 charstring format
+	csMaxStack = 48
+	csMaxCalls = 10
+)
+
+// Represents the glyph outlines of a CFF font
+type cff struct {
+	charStrings [][]byte
+	globalSubrs [][]byte
+	// The local subroutines of each font dict, and the font dict of each
+	// glyph of CID keyed fonts (nil when there is a single font dict)
+	localSubrs [][][]byte
+	fdSelect   []int
+}
+
+// Parses the outlines of the first font of a CFF table
+func parseCFF(data []byte) (*cff, error) {
+	r := &byteReader{data: data, order: binary.BigEndian}
+	r.skip(2)
+	r.pos = int(r.u8())
+	cffIndex(r) // Names
+	tops := cffIndex(r)
+	cffIndex(r) // Strings
+	c := &cff{globalSubrs: cffIndex(r)}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(tops) == 0 {
+		return nil, errors.New("No fonts in CFF table")
+	}
+	top, err := cffDict(tops[0])
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := top[cffCharType]; ok && len(t) == 1 && t[0] != 2 {
+		return nil, errors.New(fmt.Sprintf("Unsupported charstring type %v", t[0]))
+	}
+
+	at := func(offset float64) *byteReader {
+		r := &byteReader{data: data, order: binary.BigEndian}
+		if offset < 0 || int(offset) > len(data) {
+			r.err = errors.New("Offset out of range")
+			return r
+		}
+		r.pos = int(offset)
+		return r
+	}
+	if len(top[cffCharStrings]) != 1 {
+		return nil, errors.New("Missing charstrings")
+	}
+	chars := at(top[cffCharStrings][0])
+	c.charStrings = cffIndex(chars)
+	if chars.err != nil {
+		return nil, chars.err
+	}
+
+	// Returns the local subroutines of a private dict
+	privateSubrs := func(private []float64) ([][]byte, error) {
+		if len(private) != 2 {
+			return nil, nil
+		}
+		size, offset := int(private[0]), int(private[1])
+		if offset < 0 || size < 0 || offset+size > len(data) {
+			return nil, errors.New("Private dict out of range")
+		}
+		dict, err := cffDict(data[offset : offset+size])
+		if err != nil || len(dict[cffSubrs]) != 1 {
+			return nil, err
+		}
+		sr := at(float64(offset) + dict[cffSubrs][0])
+		subrs := cffIndex(sr)
+		return subrs, sr.err
+	}
+
+	if fdArray, ok := top[cffFDArray]; ok && len(fdArray) == 1 {
+		fr := at(fdArray[0])
+		fonts := cffIndex(fr)
+		if fr.err != nil {
+			return nil, fr.err
+		}
+		for _, font := range fonts {
+			dict, err := cffDict(font)
+			if err != nil {
+				return nil, err
+			}
+			subrs, err := privateSubrs(dict[cffPrivate])
+			if err != nil {
+				return nil, err
+			}
+			c.localSubrs = append(c.localSubrs, subrs)
+		}
+		if len(top[cffFDSelect]) != 1 {
+			return nil, errors.New("Missing FDSelect")
+		}
+		if c.fdSelect, err = cffFDSelectTable(at(top[cffFDSelect][0]), len(c.charStrings)); err != nil {
+			return nil, err
+		}
+	} else {
+		subrs, err := privateSubrs(top[cffPrivate])
+		if err != nil {
+			return nil, err
+		}
+		c.localSubrs = [][][]byte{subrs}
+	}
+	return c, nil
+}
+
+// Reads an INDEX of variable length items
+func cffIndex(r *byteReader) [][]byte {
+	count := int(r.u16())
+	if count == 0 {
+		return nil
+	}
+	size := int(r.u8())
+	offset := func() int {
+		v := 0
+		for _, b := range r.bytes(size) {
+			v = v<<8 | int(b)
+		}
+		return v
+	}
+	offsets := make([]int, count+1)
+	for i := range offsets {
+		offsets[i] = offset()
+	}
+	// Offsets start from 1 at the byte before the data
+	base := r.pos - 1
+	items := make([][]byte, count)
+	for i := range items {
+		start, end := base+offsets[i], base+offsets[i+1]
+		if r.err != nil || start < r.pos || end < start || end > len(r.data) {
+			r.err = errors.New("Invalid INDEX offsets")
+			return nil
+		}
+		items[i] = r.data[start:end]
+	}
+	r.pos = base + offsets[count]
+	return items
+}
+
+// Reads a DICT into the operands of each operator
+func cffDict(data []byte) (map[int][]float64, error) {
+	dict := make(map[int][]float64)
+	operands := make([]float64, 0)
+	for i := 0; i < len(data); {
+		b := int(data[i])
+		switch {
+		case b <= 21:
+			op := b
+			i++
+			if b == csEscape {
+				if i >= len(data) {
+					return nil, errors.New("Truncated DICT operator")
+				}
+				op = 1200 + int(data[i])
+				i++
+			}
+			dict[op] = operands
+			operands = make([]float64, 0)
+		case b == 30:
+			v, n, err := cffReal(data[i+1:])
+			if err != nil {
+				return nil, err
+			}
+			operands = append(operands, v)
+			i += n + 1
+		case b == 29:
+			if i+5 > len(data) {
+				return nil, errors.New("Truncated DICT operand")
+			}
+			operands = append(operands, float64(int32(binary.BigEndian.Uint32(data[i+1:]))))
+			i += 5
+		default:
+			v, n, err := cffNumber(data[i:], false)
+			if err != nil {
+				return nil, err
+			}
+			operands = append(operands, v)
+			i += n
+		}
+	}
+	return dict, nil
+}
+
+// Reads an integer operand shared by DICTs and charstrings, returning its
+// value and length. Fixed point values are only valid in charstrings
+func cffNumber(data []byte, charstring bool) (float64, int, error) {
+	b := int(data[0])
+	need := 1
+	switch {
+	case b >= 32 && b <= 246:
+		return float64(b - 139), 1, nil
+	case b >= 247 && b <= 254:
+		need = 2
+	case b == 28:
+		need = 3
+	case b == 255 && charstring:
+		need = 5
+	default:
+		return 0, 0, errors.New(fmt.Sprintf("Invalid operand %d", b))
+	}
+	if len(data) < need {
+		return 0, 0, errors.New("Truncated operand")
+	}
+	switch {
+	case b == 28:
+		return float64(int16(binary.BigEndian.Uint16(data[1:]))), 3, nil
+	case b <= 250:
+		return float64((b-247)*256 + int(data[1]) + 108), 2, nil
+	case b <= 254:
+		return float64(-(b-251)*256 - int(data[1]) - 108), 2, nil
+	}
+	return float64(int32(binary.BigEndian.Uint32(data[1:]))) / 65536, 5, nil
+}
+
+// Reads a real number operand stored as packed decimal nibbles
+func cffReal(data []byte) (float64, int, error) {
+	s := make([]byte, 0, 16)
+	for i, b := range data {
+		for _, nibble := range []byte{b >> 4, b & 0xf} {
+			switch {
+			case nibble <= 9:
+				s = append(s, '0'+nibble)
+			case nibble == 0xa:
+				s = append(s, '.')
+			case nibble == 0xb:
+				s = append(s, 'e')
+			case nibble == 0xc:
+				s = append(s, 'e', '-')
+			case nibble == 0xe:
+				s = append(s, '-')
+			case nibble == 0xf:
+				v, err := strconv.ParseFloat(string(s), 64)
+				if err != nil {
+					return 0, 0, errors.New("Invalid real number")
+				}
+				return v, i + 1, nil
+			}
+		}
+	}
+	return 0, 0, errors.New("Truncated real number")
+}
+
+// Reads the font dict of each glyph of a CID keyed font
+func cffFDSelectTable(r *byteReader, glyphs int) ([]int, error) {
+	fds := make([]int, glyphs)
+	switch format := r.u8(); format {
+	case 0:
+		for i := range fds {
+			fds[i] = int(r.u8())
+		}
+	case 3:
+		ranges := int(r.u16())
+		first := int(r.u16())
+		for i := 0; i < ranges && r.err == nil; i++ {
+			fd := int(r.u8())
+			next := int(r.u16())
+			for g := first; g < next && g < glyphs; g++ {
+				fds[g] = fd
+			}
+			first = next
+		}
+	default:
+		return nil, errors.New(fmt.Sprintf("Unsupported FDSelect format %d", format))
+	}
+	return fds, r.err
+}
+
+// Returns the number added to subroutine numbers to index them
+func cffBias(subrs [][]byte) int {
+	switch n := len(subrs); {
+	case n < 1240:
+		return 107
+	case n < 33900:
+		return 1131
+	}
+	return 32768
+}
+
+// The state of a Type 2 charstring as it is run
+type charString struct {
+	b     *pathBuilder
+	c     *cff
+	local [][]byte
+	stack []float64
+	pos   vec
+	stems int
+	width bool
+	open  bool
+	ended bool
+	depth int
+}
+
+// Adds the outline of the glyph to the path
+func (c *cff) glyph(b *pathBuilder, glyph int) error {
+	if glyph >= len(c.charStrings) {
+		return errors.New(fmt.Sprintf("Glyph %d is out of range", glyph))
+	}
+	fd := 0
+	if c.fdSelect != nil {
+		fd = c.fdSelect[glyph]
+	}
+	if fd >= len(c.localSubrs) {
+		return errors.New(fmt.Sprintf("Invalid font dict %d", fd))
+	}
+	cs := &charString{b: b, c: c, local: c.localSubrs[fd], stack: make([]float64, 0, csMaxStack)}
+	if err := cs.run(c.charStrings[glyph]); err != nil {
+		return err
+	}
+	if cs.open {
+		b.close()
+	}
+	return nil
+}
+
+// Drops the advance width that may come before the first stack clearing
+// operator, which is given when the operator has an extra argument
+func (cs *charString) takeWidth(odd bool) {
+	if !cs.width && odd && len(cs.stack) > 0 {
+		cs.stack = cs.stack[1:]
+	}
+	cs.width = true
+}
+
+func (cs *charString) moveTo(d vec) {
+	if cs.open {
+		cs.b.close()
+	}
+	cs.pos = cs.pos.add(d)
+	cs.b.moveTo(cs.pos)
+	cs.open = true
+}
+
+func (cs *charString) lineTo(d vec) {
+	cs.pos = cs.pos.add(d)
+	cs.b.lineTo(cs.pos)
+}
+
+func (cs *charString) curveTo(d1, d2, d3 vec) {
+	c1 := cs.pos.add(d1)
+	c2 := c1.add(d2)
+	cs.pos = c2.add(d3)
+	cs.b.cubicTo(c1, c2, cs.pos)
+}
+
+func (cs *charString) run(code []byte) error {
+	if cs.depth > csMaxCalls {
+		return errors.New("Subroutines are nested too deeply")
+	}
+	for i := 0; i < len(code) && !cs.ended; {
+		op := int(code[i])
+		if op >= 32 || op == 28 || op == 255 {
+			v, n, err := cffNumber(code[i:], true)
+			if err != nil {
+				return err
+			}
+			if len(cs.stack) >= csMaxStack {
+				return errors.New("Charstring stack overflow")
+			}
+			cs.stack = append(cs.stack, v)
+			i += n
+			continue
+		}
+		i++
+		if op == csEscape {
+			if i >= len(code) {
+				return errors.New("Truncated charstring operator")
+			}
+			op = int(code[i])
+			i++
+			if err := cs.flex(op); err != nil {
+				return err
+			}
+			cs.stack = cs.stack[:0]
+			continue
+		}
+
+		s := cs.stack
+		switch op {
+		case csHStem, csVStem, csHStemHM, csVStemHM:
+			cs.takeWidth(len(s)%2 == 1)
+			cs.stems += len(cs.stack) / 2
+		case csHintMask, csCntrMask:
+			// Stems may be given before the mask in place of vstem
+			cs.takeWidth(len(s)%2 == 1)
+			cs.stems += len(cs.stack) / 2
+			i += (cs.stems + 7) / 8
+		case csRMoveTo:
+			cs.takeWidth(len(s) > 2)
+			if s = cs.stack; len(s) < 2 {
+				return errors.New("Too few arguments for rmoveto")
+			}
+			cs.moveTo(vec{s[0], s[1]})
+		case csHMoveTo, csVMoveTo:
+			cs.takeWidth(len(s) > 1)
+			if s = cs.stack; len(s) < 1 {
+				return errors.New("Too few arguments for moveto")
+			}
+			if op == csHMoveTo {
+				cs.moveTo(vec{s[0], 0})
+			} else {
+				cs.moveTo(vec{0, s[0]})
+			}
+		case csRLineTo:
+			for j := 0; j+1 < len(s); j += 2 {
+				cs.lineTo(vec{s[j], s[j+1]})
+			}
+		case csHLineTo, csVLineTo:
+			for j := range s {
+				if (j%2 == 0) == (op == csHLineTo) {
+					cs.lineTo(vec{s[j], 0})
+				} else {
+					cs.lineTo(vec{0, s[j]})
+				}
+			}
+		case csRRCurveTo, csRCurveLine:
+			j := 0
+			for ; j+5 < len(s); j += 6 {
+				cs.curveTo(vec{s[j], s[j+1]}, vec{s[j+2], s[j+3]}, vec{s[j+4], s[j+5]})
+			}
+			if op == csRCurveLine && j+1 < len(s) {
+				cs.lineTo(vec{s[j], s[j+1]})
+			}
+		case csRLineCurve:
+			j := 0
+			for ; j < len(s)-6; j += 2 {
+				cs.lineTo(vec{s[j], s[j+1]})
+			}
+			if j+5 < len(s) {
+				cs.curveTo(vec{s[j], s[j+1]}, vec{s[j+2], s[j+3]}, vec{s[j+4], s[j+5]})
+			}
+		case csHHCurveTo, csVVCurveTo:
+			// An odd argument count starts with the first control point's
+			// offset across the direction of travel
+			first := 0.0
+			if len(s)%2 == 1 {
+				first, s = s[0], s[1:]
+			}
+			for j := 0; j+3 < len(s); j += 4 {
+				if op == csHHCurveTo {
+					cs.curveTo(vec{s[j], first}, vec{s[j+1], s[j+2]}, vec{s[j+3], 0})
+				} else {
+					cs.curveTo(vec{first, s[j]}, vec{s[j+1], s[j+2]}, vec{0, s[j+3]})
+				}
+				first = 0
+			}
+		case csHVCurveTo, csVHCurveTo:
+			horizontal := op == csHVCurveTo
+			for j := 0; j+3 < len(s); j += 4 {
+				last := 0.0
+				if len(s)-j == 5 {
+					last = s[j+4]
+				}
+				if horizontal {
+					cs.curveTo(vec{s[j], 0}, vec{s[j+1], s[j+2]}, vec{last, s[j+3]})
+				} else {
+					cs.curveTo(vec{0, s[j]}, vec{s[j+1], s[j+2]}, vec{s[j+3], last})
+				}
+				horizontal = !horizontal
+			}
+		case csCallSubr, csCallGSubr:
+			if len(s) == 0 {
+				return errors.New("Missing subroutine number")
+			}
+			subrs := cs.local
+			if op == csCallGSubr {
+				subrs = cs.c.globalSubrs
+			}
+			n := int(s[len(s)-1]) + cffBias(subrs)
+			if n < 0 || n >= len(subrs) {
+				return errors.New(fmt.Sprintf("Subroutine %d is out of range", n))
+			}
+			// Subroutines continue with the arguments left on the stack
+			cs.stack = s[:len(s)-1]
+			cs.depth++
+			if err := cs.run(subrs[n]); err != nil {
+				return err
+			}
+			cs.depth--
+			continue
+		case csReturn:
+			return nil
+		case csEndChar:
+			cs.takeWidth(len(s) == 1 || len(s) == 5)
+			if len(cs.stack) >= 4 {
+				return errors.New("Accented characters made with endchar are not supported")
+			}
+			cs.ended = true
+		default:
+			return errors.New(fmt.Sprintf("Unsupported charstring operator %d", op))
+		}
+		cs.stack = cs.stack[:0]
+	}
+	return nil
+}
+
+// Runs the escaped flex operators, which draw two curves that may be
+// drawn as a straight line when they are small enough
+func (cs *charString) flex(op int) error {
+	s := cs.stack
+	need := map[int]int{csFlex: 13, csHFlex: 7, csHFlex1: 9, csFlex1: 11}
+	n, ok := need[op]
+	if !ok {
+		return errors.New(fmt.Sprintf("Unsupported charstring operator 12 %d", op))
+	}
+	if len(s) < n {
+		return errors.New(fmt.Sprintf("Too few arguments for flex operator %d", op))
+	}
+	start := cs.pos
+	switch op {
+	case csFlex:
+		cs.curveTo(vec{s[0], s[1]}, vec{s[2], s[3]}, vec{s[4], s[5]})
+		cs.curveTo(vec{s[6], s[7]}, vec{s[8], s[9]}, vec{s[10], s[11]})
+	case csHFlex:
+		cs.curveTo(vec{s[0], 0}, vec{s[1], s[2]}, vec{s[3], 0})
+		cs.curveTo(vec{s[4], 0}, vec{s[5], start.Y - cs.pos.Y}, vec{s[6], 0})
+	case csHFlex1:
+		cs.curveTo(vec{s[0], s[1]}, vec{s[2], s[3]}, vec{s[4], 0})
+		c3 := vec{s[5], 0}
+		c4 := vec{s[6], s[7]}
+		dy := start.Y - (cs.pos.Y + c3.Y + c4.Y)
+		cs.curveTo(c3, c4, vec{s[8], dy})
+	case csFlex1:
+		cs.curveTo(vec{s[0], s[1]}, vec{s[2], s[3]}, vec{s[4], s[5]})
+		c3, c4 := vec{s[6], s[7]}, vec{s[8], s[9]}
+		d := cs.pos.add(c3).add(c4).sub(start)
+		// The last argument moves along whichever axis moved the most
+		if math.Abs(d.X) > math.Abs(d.Y) {
+			cs.curveTo(c3, c4, vec{s[10], -d.Y})
+		} else {
+			cs.curveTo(c3, c4, vec{-d.X, s[10]})
+		}
+	}
+	return nil
+}
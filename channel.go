@@ -0,0 +1,92 @@
+package atlas
+
+import (
+	"image"
+	"image/color"
+)
+
+// The number of masks that can share a channel packed atlas, one for each
+// of red, green, blue and alpha
+const CHANNEL_COUNT = 4
+
+// Packs the files as masks spread between the channels of the atlas. Each
+// channel has its own layout so masks in different channels overlap,
+// files are given to the channel with the least area so far to keep the
+// layouts a similar size. Files that do not fit are left unpacked
+func packChannels(atlas *Atlas, files []*File, packer Packer) {
+	var groups [CHANNEL_COUNT][]*File
+	var areas [CHANNEL_COUNT]int
+	for _, file := range files {
+		channel := 0
+		for c := range areas {
+			if areas[c] < areas[channel] {
+				channel = c
+			}
+		}
+		groups[channel] = append(groups[channel], file)
+		areas[channel] += file.Width * file.Height
+	}
+
+	for channel, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+		layer := &Atlas{
+			MaxWidth:  atlas.MaxWidth,
+			MaxHeight: atlas.MaxHeight,
+			Padding:   atlas.Padding,
+			Gutter:    atlas.Gutter,
+		}
+		packer(layer, group)
+		for _, file := range layer.Files {
+			file.Channel = channel
+			atlas.AddFile(file, file.X, file.Y)
+		}
+		atlas.Width = maxInt(atlas.Width, layer.Width)
+		atlas.Height = maxInt(atlas.Height, layer.Height)
+	}
+}
+
+// Draws the mask of each file into its channel of the atlas
+func (a *Atlas) compositeChannels() (*image.NRGBA, error) {
+	im := image.NewNRGBA(image.Rect(0, 0, a.Width, a.Height))
+	for channel := 0; channel < CHANNEL_COUNT; channel++ {
+		layer := *a
+		layer.Files = make([]*File, 0, len(a.Files))
+		for _, file := range a.Files {
+			if file.Channel == channel {
+				layer.Files = append(layer.Files, file)
+			}
+		}
+		masks, err := layer.composite(func(file *File) (image.Image, error) {
+			im, err := file.decode()
+			if err != nil {
+				return nil, err
+			}
+			return maskImage(im), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(im.Pix); i += 4 {
+			im.Pix[i+channel] = masks.Pix[i]
+		}
+	}
+	return im, nil
+}
+
+// Returns the image as a single channel mask, the luminance of each pixel
+// multiplied by its alpha. This reads both grayscale masks and masks drawn
+// in the alpha of white images
+func maskImage(im image.Image) *image.Gray {
+	b := im.Bounds()
+	mask := image.NewGray(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			// The gray model works on premultiplied colour so already
+			// includes alpha
+			mask.Set(x, y, color.GrayModel.Convert(im.At(x, y)))
+		}
+	}
+	return mask
+}
@@ -0,0 +1,83 @@
+package atlas
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"path"
+	"testing"
+)
+
+func TestGenerateChannelPack(t *testing.T) {
+	dir := t.TempDir()
+	// Grayscale masks and a mask drawn in the alpha of a white image
+	values := []uint8{40, 90, 160, 220}
+	files := make([]string, len(values))
+	for i, v := range values {
+		var im image.Image
+		if i == 3 {
+			nrgba := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+			for i := 0; i < len(nrgba.Pix); i += 4 {
+				copy(nrgba.Pix[i:], []uint8{255, 255, 255, v})
+			}
+			im = nrgba
+		} else {
+			gray := image.NewGray(image.Rect(0, 0, 8, 8))
+			for i := range gray.Pix {
+				gray.Pix[i] = v
+			}
+			im = gray
+		}
+		files[i] = writeTestPNG(t, dir, string('a'+rune(i))+".png", im)
+	}
+
+	res, outputDir, desc := generateJSON(t, files, &GenerateParams{
+		Name:        "test-channels",
+		Descriptor:  DESC_JSON_HASH,
+		ChannelPack: true,
+		Gutter:      1,
+	})
+	if len(res.Atlases) != 1 {
+		t.Fatalf("Unexpected number of atlases: want 1, got %d", len(res.Atlases))
+	}
+	atlas := res.Atlases[0]
+	if atlas.Width != 10 || atlas.Height != 10 {
+		t.Errorf("Expected the masks to overlap, got a %dx%d atlas", atlas.Width, atlas.Height)
+	}
+	im, err := readPNG(path.Join(outputDir, atlas.ImageName()))
+	if err != nil {
+		t.Fatalf("Atlas image was not written: %s", err.Error())
+	}
+	used := make(map[int]bool)
+	for _, file := range atlas.Files {
+		used[file.Channel] = true
+		want := values[file.Name[len(file.Name)-5]-'a']
+		p := color.NRGBAModel.Convert(im.At(file.Frame().Min.X, file.Frame().Min.Y)).(color.NRGBA)
+		if got := []uint8{p.R, p.G, p.B, p.A}[file.Channel]; got != want {
+			t.Errorf("Unexpected mask value for %s in channel %d: want %d, got %d", file.Name, file.Channel, want, got)
+		}
+	}
+	if len(used) != CHANNEL_COUNT {
+		t.Errorf("Expected each mask in its own channel, got %v", used)
+	}
+	if !bytes.Contains(desc, []byte(`"channel": 3`)) {
+		t.Errorf("Expected the descriptor to give the channel of each file")
+	}
+
+	if _, err := Generate(files, outputDir, &GenerateParams{ChannelPack: true, Premultiply: true}); err == nil {
+		t.Errorf("Expected an error channel packing premultiplied atlases")
+	}
+}
+
+func TestDownsampleChannels(t *testing.T) {
+	// Channels hold separate masks so a transparent alpha must not clear
+	// the other channels
+	im := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	for i := 0; i < len(im.Pix); i += 4 {
+		copy(im.Pix[i:], []uint8{200, 100, 0, 0})
+	}
+	got := downsample(toLinearImage(im, mipChannels), MIP_BOX).toNRGBA(mipChannels).NRGBAAt(0, 0)
+	if want := (color.NRGBA{200, 100, 0, 0}); got != want {
+		t.Errorf("Unexpected channel downsample: want %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,136 @@
+package atlas
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"path"
+	"strings"
+)
+
+// Represents an extra image drawn at the same position as every sprite in
+// an atlas of its own, such as a normal or emissive map. Companions are
+// found next to each source image with the suffix added to its name, eg.
+// "hero_n.png" for "hero.png"
+type Companion struct {
+	// Added to the names of source images and atlases, eg. "_n"
+	Suffix string
+	// The colour drawn for sprites without a companion image, eg. a flat
+	// normal of 128,128,255
+	Default color.NRGBA
+	// Whether the image holds data rather than colour, such as a normal
+	// map, so that mipmaps are shrunk without gamma correction
+	Linear bool
+}
+
+// Returns the file name of the companion of an image, whether or not it
+// exists
+func companionFileName(filename string, companion Companion) string {
+	ext := path.Ext(filename)
+	return strings.TrimSuffix(filename, ext) + companion.Suffix + ext
+}
+
+// Returns the given file names without companion images, a file is a
+// companion if removing a companion suffix gives the name of another of
+// the given files
+func primaryFiles(files []string, companions []Companion) []string {
+	given := make(map[string]bool, len(files))
+	for _, filename := range files {
+		given[filename] = true
+	}
+	primary := make([]string, 0, len(files))
+	for _, filename := range files {
+		ext := path.Ext(filename)
+		base := strings.TrimSuffix(filename, ext)
+		companion := false
+		for _, c := range companions {
+			if !strings.HasSuffix(base, c.Suffix) {
+				continue
+			}
+			source := strings.TrimSuffix(base, c.Suffix) + ext
+			if given[source] {
+				companion = true
+				break
+			}
+		}
+		if !companion {
+			primary = append(primary, filename)
+		}
+	}
+	return primary
+}
+
+// Finds the companion images of files read directly from an image on disk,
+// files taken from within another file such as PSD layers and animation
+// frames have no companions. Companions must be the same size as their
+// source image
+func loadCompanions(files []*File, companions []Companion) error {
+	for _, file := range files {
+		if file.Image != nil {
+			continue
+		}
+		for _, c := range companions {
+			filename := companionFileName(file.FileName, c)
+			r, err := os.Open(filename)
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			config, _, err := image.DecodeConfig(r)
+			r.Close()
+			if err != nil {
+				return errors.New(fmt.Sprintf("Unable to read %s: %s", filename, err.Error()))
+			}
+			if config.Width != file.Width || config.Height != file.Height {
+				return errors.New(fmt.Sprintf("Companion %s (%dx%d) is not the same size as %s (%dx%d)",
+					filename, config.Width, config.Height, file.FileName, file.Width, file.Height))
+			}
+			if file.companions == nil {
+				file.companions = make(map[string]*File)
+			}
+			file.companions[c.Suffix] = &File{
+				FileName: filename,
+				Name:     filename,
+				Width:    config.Width,
+				Height:   config.Height,
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the file name of the image written for a companion of this atlas
+func (a *Atlas) CompanionImageName(companion Companion) string {
+	return fmt.Sprintf("%s%s.%s", a.Name, companion.Suffix, a.imageExt())
+}
+
+// Writes the image of a companion, drawing the companion of each file at
+// the position of the file or filling the file with the default colour
+// of the companion if it has none
+func (a *Atlas) writeCompanion(outputDir string, companion Companion, format PixelFormat) error {
+	im, err := a.composite(func(file *File) (image.Image, error) {
+		if c, ok := file.companions[companion.Suffix]; ok {
+			return c.decode()
+		}
+		size := file.Frame().Size()
+		fill := image.NewNRGBA(image.Rect(0, 0, size.X, size.Y))
+		draw.Draw(fill, fill.Bounds(), image.NewUniform(companion.Default), image.ZP, draw.Src)
+		return fill, nil
+	})
+	if err != nil {
+		return err
+	}
+	levels := []*image.NRGBA{im}
+	if a.MipLevels != 0 {
+		space := mipSRGB
+		if companion.Linear {
+			space = mipLinear
+		}
+		levels = append(levels, a.mipmaps(im, space)...)
+	}
+	return a.writeLevels(outputDir, a.Name+companion.Suffix, levels, format)
+}
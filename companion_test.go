@@ -0,0 +1,78 @@
+package atlas
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+func TestGenerateCompanions(t *testing.T) {
+	dir := t.TempDir()
+	solid := func(name string, w, h int, c color.NRGBA) string {
+		im := image.NewNRGBA(image.Rect(0, 0, w, h))
+		for i := 0; i < len(im.Pix); i += 4 {
+			copy(im.Pix[i:], []uint8{c.R, c.G, c.B, c.A})
+		}
+		return writeTestPNG(t, dir, name, im)
+	}
+	red, blue := color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 255}
+	normal, flat := color.NRGBA{200, 100, 255, 255}, color.NRGBA{128, 128, 255, 255}
+	files := []string{
+		solid("a.png", 8, 8, red),
+		solid("a_n.png", 8, 8, normal),
+		solid("b.png", 12, 6, blue),
+	}
+
+	res, outputDir, _ := generateJSON(t, files, &GenerateParams{
+		Name:       "test-companion",
+		Descriptor: DESC_JSON_HASH,
+		Padding:    1,
+		Companions: []Companion{{Suffix: "_n", Default: flat, Linear: true}},
+		Variants:   []Variant{{Scale: 1, Suffix: "@1x"}, {Scale: 0.5, Suffix: "@0.5x"}},
+	})
+	if len(res.Files) != 4 {
+		t.Fatalf("Expected the companion image not to be packed, got %d files", len(res.Files))
+	}
+
+	for _, atlas := range res.Atlases {
+		companion := atlas.CompanionImageName(atlas.Companions[0])
+		im, err := readPNG(path.Join(outputDir, companion))
+		if err != nil {
+			t.Fatalf("Companion image was not written: %s", err.Error())
+		}
+		for _, file := range atlas.Files {
+			want := flat
+			if path.Base(file.Name) == "a.png" {
+				want = normal
+			}
+			frame := file.Frame()
+			for _, p := range []image.Point{frame.Min, frame.Max.Sub(image.Pt(1, 1))} {
+				if got := color.NRGBAModel.Convert(im.At(p.X, p.Y)); got != want {
+					t.Errorf("Unexpected companion colour for %s in %s: want %v, got %v", file.Name, atlas.Name, want, got)
+				}
+			}
+		}
+		desc, err := ioutil.ReadFile(path.Join(outputDir, atlas.Name+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(desc, []byte(`"companions": {"_n": "`+companion+`"}`)) {
+			t.Errorf("Expected the descriptor of %s to list its companion", atlas.Name)
+		}
+	}
+
+	files = append(files, solid("c.png", 4, 4, red), solid("c_n.png", 2, 2, normal))
+	if _, err := Generate(files, outputDir, &GenerateParams{Companions: []Companion{{Suffix: "_n"}}}); err == nil {
+		t.Errorf("Expected an error for a companion of a different size")
+	}
+
+	// A file is only a companion of the files being packed, not of any
+	// other image next to it on disk
+	res, _, _ = generateJSON(t, []string{files[1]}, &GenerateParams{Companions: []Companion{{Suffix: "_n"}}})
+	if len(res.Files) != 1 || res.Files[0].Name != files[1] {
+		t.Errorf("Expected %s to be packed on its own", files[1])
+	}
+}
@@ -0,0 +1,94 @@
+package atlas
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Flags of the DDS header, see
+// https://docs.microsoft.com/en-us/windows/win32/direct3ddds/dds-header
+const (
+	ddsCaps        = 0x1
+	ddsHeight      = 0x2
+	ddsWidth       = 0x4
+	ddsPitch       = 0x8
+	ddsPixelFormat = 0x1000
+	ddsMipMapCount = 0x20000
+	ddsLinearSize  = 0x80000
+
+	ddsAlphaPixels = 0x1
+	ddsAlpha       = 0x2
+	ddsFourCC      = 0x4
+	ddsRGB         = 0x40
+	ddsLuminance   = 0x20000
+
+	ddsCapsComplex = 0x8
+	ddsCapsTexture = 0x1000
+	ddsCapsMipMap  = 0x400000
+)
+
+// Describes how a pixel format is stored in a DDS file, either as a four
+// character code or as the bits of each channel
+type ddsFormat struct {
+	flags      uint32
+	fourCC     string
+	bits       uint32
+	r, g, b, a uint32
+}
+
+var ddsFormats = map[PixelFormat]ddsFormat{
+	PIXEL_BC1:      {flags: ddsFourCC, fourCC: "DXT1"},
+	PIXEL_BC3:      {flags: ddsFourCC, fourCC: "DXT5"},
+	PIXEL_RGBA8888: {ddsRGB | ddsAlphaPixels, "", 32, 0xff, 0xff00, 0xff0000, 0xff000000},
+	PIXEL_RGB888:   {ddsRGB, "", 24, 0xff, 0xff00, 0xff0000, 0},
+	PIXEL_RGBA4444: {ddsRGB | ddsAlphaPixels, "", 16, 0xf000, 0x0f00, 0x00f0, 0x000f},
+	PIXEL_RGBA5551: {ddsRGB | ddsAlphaPixels, "", 16, 0xf800, 0x07c0, 0x003e, 0x0001},
+	PIXEL_RGB565:   {ddsRGB, "", 16, 0xf800, 0x07e0, 0x001f, 0},
+	PIXEL_L8:       {ddsLuminance, "", 8, 0xff, 0, 0, 0},
+	PIXEL_A8:       {ddsAlpha, "", 8, 0, 0, 0, 0xff},
+}
+
+// Encodes a DDS file holding the given mipmap levels of a texture, the
+// first level is the full size texture
+func encodeDDS(format PixelFormat, width, height int, levels [][]byte) ([]byte, error) {
+	pf, ok := ddsFormats[format]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Pixel format %s can not be written to a DDS container", format))
+	}
+	flags := uint32(ddsCaps | ddsHeight | ddsWidth | ddsPixelFormat)
+	caps := uint32(ddsCapsTexture)
+	pitch := uint32(rowSize(format, width))
+	if format.Compressed() {
+		flags |= ddsLinearSize
+		pitch = uint32(len(levels[0]))
+	} else {
+		flags |= ddsPitch
+	}
+	if len(levels) > 1 {
+		flags |= ddsMipMapCount
+		caps |= ddsCapsComplex | ddsCapsMipMap
+	}
+
+	b := &bytes.Buffer{}
+	le := func(values ...uint32) {
+		for _, v := range values {
+			binary.Write(b, binary.LittleEndian, v)
+		}
+	}
+	b.WriteString("DDS ")
+	le(124, flags, uint32(height), uint32(width), pitch, 0, uint32(len(levels)))
+	le(make([]uint32, 11)...)
+	// Pixel format
+	fourCC := uint32(0)
+	if pf.fourCC != "" {
+		fourCC = binary.LittleEndian.Uint32([]byte(pf.fourCC))
+	}
+	le(32, pf.flags, fourCC, pf.bits, pf.r, pf.g, pf.b, pf.a)
+	le(caps, 0, 0, 0, 0)
+	for _, level := range levels {
+		b.Write(level)
+	}
+	return b.Bytes(), nil
+}
@@ -1,32 +1,46 @@
 package atlas
 
 import (
+	"encoding/json"
 	"fmt"
 	"text/template"
 )
 
 // Available descriptor templates
 const (
-	DESC_INVALID DescriptorFormat = ""
-	DESC_KIWI    DescriptorFormat = "kiwi"
+	DESC_INVALID   DescriptorFormat = ""
+	DESC_KIWI      DescriptorFormat = "kiwi"
+	DESC_JSON_HASH DescriptorFormat = "jsonhash" // Phaser & PixiJS
 )
 
 // Represents a Descriptor Format
 type DescriptorFormat string
 
+// Functions available to descriptor templates, names come from file names,
+// layers and tags so json quotes and escapes them as JSON strings
+var descriptorFuncs = template.FuncMap{
+	"json": jsonString,
+}
+
 // Get the template for the given descriptor format
 // Returns an error if the template can not be parsed
 func GetTemplateForFormat(format DescriptorFormat) (*template.Template, error) {
-	t := template.New(fmt.Sprintf("%s.template", format))
+	t := template.New(fmt.Sprintf("%s.template", format)).Funcs(descriptorFuncs)
 	return t.ParseFiles(fmt.Sprintf("templates/%s.template", format))
 }
 
+// Returns the string quoted as a JSON string
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
 // Gets the file extension for the given descriptor format
 // Extension is returned without the separator dot for eg:
 // "xml", "json", "yaml"
 func GetFileExtForFormat(format DescriptorFormat) string {
 	switch format {
-	case DESC_KIWI:
+	case DESC_KIWI, DESC_JSON_HASH:
 		return "json"
 	default:
 		return ""
@@ -0,0 +1,95 @@
+package atlas
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestDescriptorEscaping(t *testing.T) {
+	// Layer names are free text so can hold anything that needs escaping
+	// in JSON, the numbered layers also form an animation
+	psd := path.Join(t.TempDir(), "ship.psd")
+	fill := color.NRGBA{255, 0, 0, 255}
+	data := testPSD(40, 20, []testPSDLayer{
+		{name: `a"b\c`, bounds: image.Rect(0, 0, 10, 10), fill: fill},
+		{name: `go"\_1`, bounds: image.Rect(10, 0, 20, 10), fill: fill},
+		{name: `go"\_2`, bounds: image.Rect(20, 0, 30, 10), fill: fill},
+	})
+	if err := ioutil.WriteFile(psd, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	base := strings.TrimSuffix(psd, ".psd")
+	names := []string{base + `/a"b\c`, base + `/go"\_1`, base + `/go"\_2`}
+	animation := base + `/go"\`
+
+	for _, format := range []DescriptorFormat{DESC_KIWI, DESC_JSON_HASH} {
+		_, _, data := generateJSON(t, []string{psd}, &GenerateParams{Name: `test-"escape"`, Descriptor: format})
+		got := make(map[string]bool)
+		var anims []string
+		if format == DESC_KIWI {
+			var desc struct {
+				Name      string
+				Cells     []struct{ Name string }
+				Sequences []struct{ Name string }
+			}
+			parseDescriptor(t, data, &desc)
+			if desc.Name != `test-"escape"-1` {
+				t.Errorf("Unexpected atlas name: %q", desc.Name)
+			}
+			for _, cell := range desc.Cells {
+				got[cell.Name] = true
+			}
+			for _, seq := range desc.Sequences {
+				anims = append(anims, seq.Name)
+			}
+		} else {
+			var desc struct {
+				Frames     map[string]interface{}
+				Animations map[string][]string
+			}
+			parseDescriptor(t, data, &desc)
+			for name := range desc.Frames {
+				got[name] = true
+			}
+			for name := range desc.Animations {
+				anims = append(anims, name)
+			}
+		}
+		for _, name := range names {
+			if !got[name] {
+				t.Errorf("Missing %s sprite %q in %v", format, name, got)
+			}
+		}
+		if len(anims) != 1 || anims[0] != animation {
+			t.Errorf("Unexpected %s animations: want [%q], got %q", format, animation, anims)
+		}
+	}
+}
+
+func TestKiwiCells(t *testing.T) {
+	png := writeTestPNG(t, t.TempDir(), "ship.png", image.NewNRGBA(image.Rect(0, 0, 5, 3)))
+	res, _, data := generateJSON(t, []string{png}, &GenerateParams{Name: "test-kiwi", Padding: 2, Gutter: 1})
+	var desc struct {
+		Cells []struct {
+			X, Y, W, H int
+			Frame      struct{ X, Y, W, H int }
+		}
+	}
+	parseDescriptor(t, data, &desc)
+	if len(desc.Cells) != 1 {
+		t.Fatalf("Unexpected number of cells: want 1, got %d", len(desc.Cells))
+	}
+	// The cell covers the padding and gutter, the frame just the image
+	file, cell := res.Files[0], desc.Cells[0]
+	if got := image.Rect(cell.X, cell.Y, cell.X+cell.W, cell.Y+cell.H); got != image.Rect(file.X, file.Y, file.X+file.Width, file.Y+file.Height) {
+		t.Errorf("Unexpected cell: want %v at %d,%d, got %v", image.Pt(file.Width, file.Height), file.X, file.Y, got)
+	}
+	frame := cell.Frame
+	if got := image.Rect(frame.X, frame.Y, frame.X+frame.W, frame.Y+frame.H); got != file.Frame() || got.Size() != image.Pt(5, 3) {
+		t.Errorf("Unexpected frame: want %v, got %v", file.Frame(), got)
+	}
+}
@@ -0,0 +1,451 @@
+package atlas
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"math"
+	"path"
+	"strings"
+)
+
+// Represents the kind of distance field files are converted to
+type DistanceField string
+
+const (
+	DF_NONE DistanceField = ""
+	// A single distance in the alpha channel, with white colour
+	DF_SDF DistanceField = "sdf"
+	// Distances to differently coloured edges in red, green and blue that
+	// keep sharp corners, with the true distance in alpha. Only SVG files
+	// have the outlines needed, other files get the SDF in every channel
+	DF_MSDF DistanceField = "msdf"
+)
+
+// The spread used when none is given
+const DEFAULT_SPREAD = 4
+
+// Edge colours of a multi-channel distance field, each is the set of
+// channels the edge is used for
+const (
+	msdfRed     = 1
+	msdfGreen   = 2
+	msdfBlue    = 4
+	msdfYellow  = msdfRed | msdfGreen
+	msdfMagenta = msdfRed | msdfBlue
+	msdfCyan    = msdfGreen | msdfBlue
+	msdfWhite   = msdfRed | msdfGreen | msdfBlue
+)
+
+// The sine of the smallest change in direction that is treated as a
+// corner, about 8 degrees
+var msdfCornerThreshold = math.Sin(3)
+
+// Converts the image of each file into a distance field. The field reaches
+// spread pixels either side of the edge of the image's alpha, so files
+// grow by spread on every side. Positions within the source canvas,
+// nine-slice borders and pivots are moved to match
+func distanceFieldFiles(files []*File, field DistanceField, spread int) error {
+	for _, file := range files {
+		var im *image.NRGBA
+		if field == DF_MSDF && strings.ToLower(path.Ext(file.FileName)) == ".svg" {
+			data, err := ioutil.ReadFile(file.FileName)
+			if err != nil {
+				return err
+			}
+			shapes, err := svgShapes(data, file.Width, file.Height)
+			if err != nil {
+				return errors.New(fmt.Sprintf("Unable to read %s: %s", file.FileName, err.Error()))
+			}
+			im = msdfImage(shapes, file.Width, file.Height, spread)
+		} else {
+			src, err := file.decode()
+			if err != nil {
+				return err
+			}
+			im = sdfImage(src, spread, field == DF_MSDF)
+		}
+
+		if file.Pivot != nil {
+			file.Pivot = &Pivot{
+				X: (file.Pivot.X*float64(file.Width) + float64(spread)) / float64(file.Width+spread*2),
+				Y: (file.Pivot.Y*float64(file.Height) + float64(spread)) / float64(file.Height+spread*2),
+			}
+		}
+		if file.NineSlice != nil {
+			file.NineSlice = &NineSlice{
+				Left:   file.NineSlice.Left + spread,
+				Top:    file.NineSlice.Top + spread,
+				Right:  file.NineSlice.Right + spread,
+				Bottom: file.NineSlice.Bottom + spread,
+			}
+		}
+		if file.SourceWidth != 0 {
+			file.SourceWidth += spread * 2
+			file.SourceHeight += spread * 2
+		}
+		file.Image = im
+		file.Width, file.Height = im.Bounds().Dx(), im.Bounds().Dy()
+	}
+	return nil
+}
+
+// Maps a signed distance in pixels, positive inside, to a byte so that
+// the edge is at 128 and spread pixels away is 0 or 255
+func distanceByte(d float64, spread int) uint8 {
+	return clampByte((0.5 + d/float64(spread*2)) * 255)
+}
+
+// Returns the signed distance field of the image's alpha, where pixels
+// with at least half alpha are inside. Distances are exact between pixel
+// centres, taken half a pixel in so the edge lies between the pixels
+func sdfImage(im image.Image, spread int, allChannels bool) *image.NRGBA {
+	b := im.Bounds()
+	w, h := b.Dx()+spread*2, b.Dy()+spread*2
+	inside := make([]bool, w*h)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			_, _, _, a := im.At(x, y).RGBA()
+			inside[(y-b.Min.Y+spread)*w+x-b.Min.X+spread] = a >= 0x8000
+		}
+	}
+	toInside := distanceTransform(inside, w, h, true)
+	toOutside := distanceTransform(inside, w, h, false)
+
+	out := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i, in := range inside {
+		d := 0.5 - math.Sqrt(toInside[i])
+		if in {
+			d = math.Sqrt(toOutside[i]) - 0.5
+		}
+		v := distanceByte(d, spread)
+		p := out.Pix[i*4 : i*4+4]
+		p[0], p[1], p[2], p[3] = 255, 255, 255, v
+		if allChannels {
+			p[0], p[1], p[2] = v, v, v
+		}
+	}
+	return out
+}
+
+// Returns the squared distance from each pixel to the nearest pixel whose
+// inside value matches target, using the separable algorithm of
+// Felzenszwalb and Huttenlocher
+func distanceTransform(inside []bool, w, h int, target bool) []float64 {
+	// Used in place of infinity so the parabola intersections stay finite
+	const far = 1e20
+	dist := make([]float64, w*h)
+	for i, in := range inside {
+		if in != target {
+			dist[i] = far
+		}
+	}
+	n := maxInt(w, h)
+	f, d, z, v := make([]float64, n), make([]float64, n), make([]float64, n+1), make([]int, n)
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			f[y] = dist[y*w+x]
+		}
+		distanceTransform1D(f[:h], d[:h], z, v)
+		for y := 0; y < h; y++ {
+			dist[y*w+x] = d[y]
+		}
+	}
+	for y := 0; y < h; y++ {
+		copy(f, dist[y*w:(y+1)*w])
+		distanceTransform1D(f[:w], d[:w], z, v)
+		copy(dist[y*w:], d[:w])
+	}
+	return dist
+}
+
+// Finds the lower envelope of the parabolas rooted at each sample
+func distanceTransform1D(f, d, z []float64, v []int) {
+	k := 0
+	v[0], z[0], z[1] = 0, math.Inf(-1), math.Inf(1)
+	for q := 1; q < len(f); q++ {
+		var s float64
+		for {
+			r := v[k]
+			s = ((f[q] + float64(q*q)) - (f[r] + float64(r*r))) / float64(2*q-2*r)
+			if s > z[k] || k == 0 {
+				break
+			}
+			k--
+		}
+		if s <= z[k] {
+			// Only possible for the first parabola, which is replaced
+			v[k], z[k+1] = q, math.Inf(1)
+			continue
+		}
+		k++
+		v[k], z[k], z[k+1] = q, s, math.Inf(1)
+	}
+	k = 0
+	for q := range f {
+		for z[k+1] < float64(q) {
+			k++
+		}
+		r := float64(q - v[k])
+		d[q] = r*r + f[v[k]]
+	}
+}
+
+// A run of line segments between two corners of a contour, coloured with
+// the channels it is used for
+type msdfEdge struct {
+	points []vec
+	color  int
+}
+
+// The distance from a point to an edge, and how far the point is from
+// being perpendicular to the edge which breaks ties at shared corners
+type edgeDistance struct {
+	distance float64
+	dot      float64
+}
+
+func (d edgeDistance) less(o edgeDistance) bool {
+	return math.Abs(d.distance) < math.Abs(o.distance) ||
+		(math.Abs(d.distance) == math.Abs(o.distance) && d.dot < o.dot)
+}
+
+// Returns the multi-channel signed distance field of the shapes, which
+// are in the coordinates of a width x height image. Shapes overlap by
+// taking the largest distance in each channel
+func msdfImage(shapes []svgShape, width, height, spread int) *image.NRGBA {
+	w, h := width+spread*2, height+spread*2
+	out := image.NewNRGBA(image.Rect(0, 0, w, h))
+	field := make([]float64, w*h*4)
+	for i := range field {
+		field[i] = math.Inf(-1)
+	}
+	for _, shape := range shapes {
+		edges := msdfEdges(shape)
+		if len(edges) == 0 {
+			continue
+		}
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				p := vec{float64(x-spread) + 0.5, float64(y-spread) + 0.5}
+				d := msdfDistances(edges, p)
+				// The true distance takes its sign from the fill rule
+				// which copes with overlapping contours
+				if shape.inside(p) != (d[3] > 0) {
+					d[3] = -d[3]
+				}
+				f := field[(y*w+x)*4:]
+				for c := range d {
+					f[c] = math.Max(f[c], d[c])
+				}
+			}
+		}
+	}
+	for i, d := range field {
+		if math.IsInf(d, -1) {
+			d = -float64(spread)
+		}
+		out.Pix[i] = distanceByte(d, spread)
+	}
+	return out
+}
+
+// Returns the signed pseudo-distances from the point to the nearest edge
+// of each colour channel and the true distance to the nearest edge
+func msdfDistances(edges []msdfEdge, p vec) [4]float64 {
+	var best [4]edgeDistance
+	var nearest [3]*msdfEdge
+	for c := range best {
+		best[c] = edgeDistance{math.Inf(1), 1}
+	}
+	for i := range edges {
+		e := &edges[i]
+		d := e.distance(p)
+		for c := 0; c < 3; c++ {
+			if e.color&(1<<uint(c)) != 0 && d.less(best[c]) {
+				best[c], nearest[c] = d, e
+			}
+		}
+		if d.less(best[3]) {
+			best[3] = d
+		}
+	}
+	var res [4]float64
+	for c := 0; c < 3; c++ {
+		if nearest[c] != nil {
+			res[c] = nearest[c].pseudoDistance(p, best[c].distance)
+		} else {
+			res[c] = best[3].distance
+		}
+	}
+	res[3] = best[3].distance
+	return res
+}
+
+// Returns the signed distance from the point to the nearest point of the
+// edge, positive on the inside
+func (e *msdfEdge) distance(p vec) edgeDistance {
+	best := edgeDistance{math.Inf(1), 1}
+	for i := 0; i+1 < len(e.points); i++ {
+		a, b := e.points[i], e.points[i+1]
+		dir := b.sub(a)
+		t := math.Max(0, math.Min(1, p.sub(a).dot(dir)/dir.dot(dir)))
+		toPoint := p.sub(a.lerp(b, t))
+		d := edgeDistance{toPoint.length(), 0}
+		if t == 0 || t == 1 {
+			d.dot = math.Abs(dir.dot(toPoint)) / (dir.length() * math.Max(toPoint.length(), 1e-12))
+		}
+		if dir.cross(p.sub(a)) < 0 {
+			d.distance = -d.distance
+		}
+		if d.less(best) {
+			best = d
+		}
+	}
+	return best
+}
+
+// Returns the distance to the edge extended in a straight line past its
+// ends, which keeps the channels of a corner crossing at the corner
+func (e *msdfEdge) pseudoDistance(p vec, distance float64) float64 {
+	ends := []struct{ a, b, from vec }{
+		{e.points[0], e.points[1], e.points[0]},
+		{e.points[len(e.points)-2], e.points[len(e.points)-1], e.points[len(e.points)-1]},
+	}
+	for i, end := range ends {
+		dir := end.b.sub(end.a)
+		along := p.sub(end.from).dot(dir)
+		if (i == 0 && along < 0) || (i == 1 && along > 0) {
+			pseudo := dir.cross(p.sub(end.from)) / dir.length()
+			if math.Abs(pseudo) <= math.Abs(distance) {
+				return pseudo
+			}
+		}
+	}
+	return distance
+}
+
+// Returns whether the point is within the filled area of the shape
+func (s svgShape) inside(p vec) bool {
+	winding := 0
+	for _, c := range s.contours {
+		for i := range c.points {
+			a, b := c.points[i], c.points[(i+1)%len(c.points)]
+			if a.Y <= p.Y && b.Y > p.Y && b.sub(a).cross(p.sub(a)) > 0 {
+				winding++
+			} else if a.Y > p.Y && b.Y <= p.Y && b.sub(a).cross(p.sub(a)) < 0 {
+				winding--
+			}
+		}
+	}
+	if s.rule == fillEvenOdd {
+		return winding%2 != 0
+	}
+	return winding != 0
+}
+
+// Splits the contours of the shape into edges at their corners and
+// colours them so that the edges either side of a corner share only one
+// channel, following the simple colouring of msdfgen. Contours are turned
+// so that the filled side is positive and contours with the fill on both
+// or neither side are left out
+func msdfEdges(shape svgShape) []msdfEdge {
+	edges := make([]msdfEdge, 0)
+	for _, c := range shape.contours {
+		points := make([]vec, 0, len(c.points)+1)
+		for _, p := range c.points {
+			if len(points) == 0 || p != points[len(points)-1] {
+				points = append(points, p)
+			}
+		}
+		for len(points) > 1 && points[0] == points[len(points)-1] {
+			points = points[:len(points)-1]
+		}
+		if len(points) < 3 {
+			continue
+		}
+
+		// Check which side of the longest segment is filled
+		longest := 0
+		for i := range points {
+			if points[(i+1)%len(points)].sub(points[i]).length() > points[(longest+1)%len(points)].sub(points[longest]).length() {
+				longest = i
+			}
+		}
+		a, b := points[longest], points[(longest+1)%len(points)]
+		mid := a.lerp(b, 0.5)
+		side := b.sub(a).normal().mul(1e-3)
+		left, right := shape.inside(mid.add(side)), shape.inside(mid.sub(side))
+		if left == right {
+			continue
+		}
+		// The normal is on the positive side of cross products so
+		// reverse contours whose fill is on the other side
+		if right {
+			for i, j := 0, len(points)-1; i < j; i, j = i+1, j-1 {
+				points[i], points[j] = points[j], points[i]
+			}
+		}
+
+		n := len(points)
+		dir := func(i int) vec {
+			d := points[(i+1)%n].sub(points[i%n])
+			return d.mul(1 / d.length())
+		}
+		corners := make([]int, 0)
+		for i := 0; i < n; i++ {
+			in, out := dir(i+n-1), dir(i)
+			if in.dot(out) <= 0 || math.Abs(in.cross(out)) > msdfCornerThreshold {
+				corners = append(corners, i)
+			}
+		}
+		// Returns the points from index i to j going round the contour
+		run := func(i, j int) []vec {
+			res := make([]vec, 0)
+			for k := i; ; k++ {
+				res = append(res, points[k%n])
+				if k%n == j%n && k != i {
+					return res
+				}
+			}
+		}
+
+		switch len(corners) {
+		case 0:
+			edges = append(edges, msdfEdge{run(0, 0), msdfWhite})
+		case 1:
+			// A teardrop is split into three edges so the corner still
+			// has different colours either side
+			start := corners[0]
+			third := n / 3
+			colors := []int{msdfMagenta, msdfWhite, msdfYellow}
+			bounds := []int{start, start + third, start + 2*third, start + n}
+			for i, color := range colors {
+				edges = append(edges, msdfEdge{run(bounds[i], bounds[i+1]), color})
+			}
+		default:
+			color := msdfCyan
+			for i, corner := range corners {
+				end := corners[(i+1)%len(corners)]
+				if i > 0 {
+					color = msdfSwitchColor(color)
+					if i == len(corners)-1 {
+						// The last edge must also differ from the first
+						if combined := color & msdfCyan; combined == msdfRed || combined == msdfGreen || combined == msdfBlue {
+							color = combined ^ msdfWhite
+						}
+					}
+				}
+				edges = append(edges, msdfEdge{run(corner, end), color})
+			}
+		}
+	}
+	return edges
+}
+
+// Moves to the next of cyan, magenta and yellow
+func msdfSwitchColor(color int) int {
+	shifted := color << 1
+	return (shifted | shifted>>3) & msdfWhite
+}
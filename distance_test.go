@@ -0,0 +1,107 @@
+package atlas
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+)
+
+// Returns the middle of the three colour values, which is the distance a
+// multi-channel distance field is read as
+func median(c color.NRGBA) uint8 {
+	r, g, b := int(c.R), int(c.G), int(c.B)
+	return uint8(maxInt(minInt(r, g), minInt(maxInt(r, g), b)))
+}
+
+func TestSDFImage(t *testing.T) {
+	im := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for y := 2; y < 6; y++ {
+		for x := 2; x < 6; x++ {
+			im.SetNRGBA(x, y, color.NRGBA{255, 0, 0, 255})
+		}
+	}
+	field := sdfImage(im, 4, false)
+	if b := field.Bounds(); b.Dx() != 16 || b.Dy() != 16 {
+		t.Fatalf("Unexpected field size: want 16x16, got %dx%d", b.Dx(), b.Dy())
+	}
+	cases := []struct {
+		x, y int
+		want uint8
+	}{
+		{7, 7, 175}, // Two pixels from the edge inside
+		{6, 8, 143}, // On the inside of the edge
+		{5, 8, 112}, // On the outside of the edge
+		{0, 0, 0},   // Further than the spread outside
+		{2, 8, 16},  // Three and a half pixels outside
+	}
+	for _, c := range cases {
+		got := field.NRGBAAt(c.x, c.y)
+		if got.A != c.want || got.R != 255 {
+			t.Errorf("Unexpected distance at %d,%d: want %d, got %v", c.x, c.y, c.want, got)
+		}
+	}
+}
+
+func TestMSDFImage(t *testing.T) {
+	const SVG = `<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10">
+		<rect x="1" y="1" width="8" height="8"/>
+	</svg>`
+	shapes, err := svgShapes([]byte(SVG), 10, 10)
+	if err != nil {
+		t.Fatalf("Unable to read shapes: %s", err.Error())
+	}
+	field := msdfImage(shapes, 10, 10, 2)
+	if b := field.Bounds(); b.Dx() != 14 || b.Dy() != 14 {
+		t.Fatalf("Unexpected field size: want 14x14, got %dx%d", b.Dx(), b.Dy())
+	}
+
+	// The corner pixels are inside in every channel and the pixel outside
+	// the corner is outside, which a single channel field would round off
+	inside := []image.Point{{3, 3}, {10, 3}, {7, 7}}
+	outside := []image.Point{{2, 2}, {11, 11}, {7, 1}}
+	for _, p := range inside {
+		if c := field.NRGBAAt(p.X, p.Y); median(c) < 128 || c.A < 128 {
+			t.Errorf("Expected %v to be inside, got %v", p, c)
+		}
+	}
+	for _, p := range outside {
+		if c := field.NRGBAAt(p.X, p.Y); median(c) >= 128 || c.A >= 128 {
+			t.Errorf("Expected %v to be outside, got %v", p, c)
+		}
+	}
+	// Outside the corner the channels disagree
+	if c := field.NRGBAAt(1, 1); c.R == c.G && c.G == c.B {
+		t.Errorf("Expected the channels to differ at the corner, got %v", c)
+	}
+}
+
+func TestGenerateDistanceField(t *testing.T) {
+	svg := path.Join(t.TempDir(), "circle.svg")
+	err := ioutil.WriteFile(svg, []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="16" height="16">
+		<circle cx="8" cy="8" r="6"/>
+	</svg>`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, outputDir, data := generateJSON(t, []string{svg, "./fixtures/button.png"}, &GenerateParams{
+		Name:           "test-msdf",
+		Descriptor:     DESC_JSON_HASH,
+		DistanceField:  DF_MSDF,
+		DistanceSpread: 3,
+	})
+	for _, file := range res.Files {
+		if file.FileName == svg && (file.Width != 22 || file.Height != 22) {
+			t.Errorf("Unexpected size for %s: want 22x22, got %dx%d", file.Name, file.Width, file.Height)
+		}
+	}
+	if want := `"distanceField": {"type": "msdf", "spread": 3}`; !strings.Contains(string(data), want) {
+		t.Errorf("Expected the descriptor to contain %s", want)
+	}
+
+	if _, err := Generate([]string{svg}, outputDir, &GenerateParams{DistanceField: DF_SDF, Premultiply: true}); err == nil {
+		t.Errorf("Expected an error using a distance field with Premultiply")
+	}
+}
@@ -0,0 +1,94 @@
+package atlas
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+)
+
+// Encodes atlas images to an image file format
+type ImageEncoder interface {
+	// Returns the extension of files written by the encoder, without the
+	// leading dot
+	Ext() string
+	Encode(w io.Writer, im image.Image) error
+}
+
+// Encodes images as PNGs. The PNG colour type is chosen from the pixels of
+// each image rather than set, so the pixels are kept exactly but the type
+// of an atlas can change from one build to the next as sprites change:
+//   - Images where every pixel is opaque, such as the colour image of an
+//     AlphaSplit atlas, are written as RGB without an alpha channel, other
+//     images are written as RGBA
+//   - With ReducePalette, images with at most 256 distinct colours are
+//     written as indexed colour, with transparency only if they need it
+//   - Grayscale images, such as PIXEL_L8 previews, are written as grayscale
+type PNGEncoder struct {
+	// The zlib compression used, defaults to png.DefaultCompression.
+	// png.BestSpeed writes faster for debug builds and png.BestCompression
+	// writes smaller files
+	CompressionLevel png.CompressionLevel
+	// Write images that have at most 256 distinct colours as indexed
+	// colour PNGs, other images are written as RGB or RGBA. Unlike
+	// PaletteSize this never changes a pixel
+	ReducePalette bool
+}
+
+func (e *PNGEncoder) Ext() string {
+	return "png"
+}
+
+func (e *PNGEncoder) Encode(w io.Writer, im image.Image) error {
+	if e.ReducePalette {
+		if paletted := exactPalette(im); paletted != nil {
+			im = paletted
+		}
+	}
+	encoder := &png.Encoder{CompressionLevel: e.CompressionLevel}
+	return encoder.Encode(w, im)
+}
+
+// Returns the image as an indexed colour image if it has few enough
+// colours to fit in a palette, otherwise nil. Colours are added to the
+// palette in the order they are first seen
+func exactPalette(im image.Image) *image.Paletted {
+	switch im.(type) {
+	case *image.Paletted, *image.Gray:
+		// Already a single byte per pixel
+		return nil
+	}
+	b := im.Bounds()
+	paletted := image.NewPaletted(b, make(color.Palette, 0, MAX_PALETTE_SIZE))
+	indices := make(map[color.NRGBA]uint8)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(im.At(x, y)).(color.NRGBA)
+			i, ok := indices[c]
+			if !ok {
+				if len(paletted.Palette) == MAX_PALETTE_SIZE {
+					return nil
+				}
+				i = uint8(len(paletted.Palette))
+				indices[c] = i
+				paletted.Palette = append(paletted.Palette, c)
+			}
+			paletted.SetColorIndex(x, y, i)
+		}
+	}
+	return paletted
+}
+
+// Encodes the image with the given encoder to a file
+func writeImage(filename string, im image.Image, encoder ImageEncoder) error {
+	out, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := encoder.Encode(out, im); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,105 @@
+package atlas
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+// PNG colour types, read from the IHDR chunk that follows the signature
+const (
+	pngGrayscale       = 0
+	pngTrueColour      = 2
+	pngIndexed         = 3
+	pngTrueColourAlpha = 6
+)
+
+func pngColourType(data []byte) int {
+	return int(data[25])
+}
+
+func TestPNGEncoder(t *testing.T) {
+	few := image.NewNRGBA(image.Rect(0, 0, 20, 20))
+	many := image.NewNRGBA(image.Rect(0, 0, 20, 20))
+	translucent := image.NewNRGBA(image.Rect(0, 0, 20, 20))
+	gray := image.NewGray(image.Rect(0, 0, 20, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 20; x++ {
+			few.SetNRGBA(x, y, color.NRGBA{uint8(x % 3 * 100), 50, 0, uint8(255 - y%2*128)})
+			many.SetNRGBA(x, y, color.NRGBA{uint8(x * 12), uint8(y * 12), 0, 255})
+			translucent.SetNRGBA(x, y, color.NRGBA{uint8(x * 12), uint8(y * 12), 0, 200})
+			gray.SetGray(x, y, color.Gray{uint8(x % 2 * 255)})
+		}
+	}
+	cases := []struct {
+		name       string
+		im         image.Image
+		reduce     bool
+		colourType int
+	}{
+		{"few colours", few, true, pngIndexed},
+		{"few colours without a palette", few, false, pngTrueColourAlpha},
+		{"many opaque colours", many, true, pngTrueColour},
+		{"opaque colours without a palette", many, false, pngTrueColour},
+		{"many translucent colours", translucent, true, pngTrueColourAlpha},
+		{"grayscale", gray, true, pngGrayscale},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		if err := (&PNGEncoder{ReducePalette: c.reduce}).Encode(&buf, c.im); err != nil {
+			t.Fatalf("Encode threw an error for %s: %s", c.name, err.Error())
+		}
+		if ct := pngColourType(buf.Bytes()); ct != c.colourType {
+			t.Errorf("Unexpected colour type for %s: want %d, got %d", c.name, c.colourType, ct)
+		}
+		decoded, err := png.Decode(&buf)
+		if err != nil {
+			t.Fatalf("Unable to decode %s: %s", c.name, err.Error())
+		}
+		b := c.im.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				want := color.NRGBAModel.Convert(c.im.At(x, y))
+				if got := color.NRGBAModel.Convert(decoded.At(x, y)); got != want {
+					t.Fatalf("Unexpected colour for %s at %d,%d: want %v, got %v", c.name, x, y, want, got)
+				}
+			}
+		}
+	}
+
+	var fast, small bytes.Buffer
+	(&PNGEncoder{CompressionLevel: png.NoCompression}).Encode(&fast, many)
+	(&PNGEncoder{CompressionLevel: png.BestCompression}).Encode(&small, many)
+	if small.Len() >= fast.Len() {
+		t.Errorf("Expected best compression to be smaller: %d >= %d", small.Len(), fast.Len())
+	}
+}
+
+func TestGeneratePNGEncoder(t *testing.T) {
+	outputDir := t.TempDir()
+	files := []string{
+		"./fixtures/button.png",
+		"./fixtures/button_active.png",
+		"./fixtures/button_hover.png",
+	}
+	res, err := Generate(files, outputDir, &GenerateParams{
+		Name:       "test-png-encoder",
+		AlphaSplit: ALPHA_SPLIT_GRAY,
+		Encoder:    &PNGEncoder{CompressionLevel: png.BestSpeed},
+	})
+	if err != nil {
+		t.Fatalf("Generate threw an error: %s", err.Error())
+	}
+	// The colour image of a split atlas is opaque so needs no alpha
+	data, err := ioutil.ReadFile(path.Join(outputDir, res.Atlases[0].ImageName()))
+	if err != nil {
+		t.Fatalf("Image was not written: %s", err.Error())
+	}
+	if ct := pngColourType(data); ct != pngTrueColour {
+		t.Errorf("Unexpected colour type for an opaque atlas: want %d, got %d", pngTrueColour, ct)
+	}
+}
@@ -0,0 +1,226 @@
+package atlas
+
+import (
+	"encoding/binary"
+	"image"
+)
+
+// The intensity modifiers of ETC1 blocks, each table gives the small and
+// large modifier which are applied positively or negatively
+var etcModifiers = [8][2]int{
+	{2, 8}, {5, 17}, {9, 29}, {13, 42},
+	{18, 60}, {24, 80}, {33, 106}, {47, 183},
+}
+
+// The modifier tables of EAC alpha blocks
+var eacModifiers = [16][8]int{
+	{-3, -6, -9, -15, 2, 5, 8, 14},
+	{-3, -7, -10, -13, 2, 6, 9, 12},
+	{-2, -5, -8, -13, 1, 4, 7, 12},
+	{-2, -4, -6, -13, 1, 3, 5, 12},
+	{-3, -6, -8, -12, 2, 5, 7, 11},
+	{-3, -7, -9, -11, 2, 6, 8, 10},
+	{-4, -7, -8, -11, 3, 6, 7, 10},
+	{-3, -5, -8, -11, 2, 4, 7, 10},
+	{-2, -6, -8, -10, 1, 5, 7, 9},
+	{-2, -5, -8, -10, 1, 4, 7, 9},
+	{-2, -4, -8, -10, 1, 3, 7, 9},
+	{-2, -5, -7, -10, 1, 4, 6, 9},
+	{-3, -4, -7, -10, 2, 3, 6, 9},
+	{-1, -2, -3, -10, 0, 1, 2, 9},
+	{-4, -6, -8, -9, 3, 5, 7, 8},
+	{-3, -5, -7, -9, 2, 4, 6, 8},
+}
+
+// Encodes the image as ETC1 blocks ignoring alpha. Only the modes shared
+// with ETC1 are used so the result is also valid ETC2 RGB data
+func encodeETC1(im *image.NRGBA) []byte {
+	out := make([]byte, 0)
+	forBlocks(im, func(block *[16][4]uint8) {
+		out = binary.BigEndian.AppendUint64(out, etc1Block(block))
+	})
+	return out
+}
+
+// Encodes the image as ETC2 RGBA blocks, an EAC alpha block followed by an
+// ETC2 colour block
+func encodeETC2RGBA(im *image.NRGBA) []byte {
+	out := make([]byte, 0)
+	forBlocks(im, func(block *[16][4]uint8) {
+		out = binary.BigEndian.AppendUint64(out, eacBlock(block))
+		out = binary.BigEndian.AppendUint64(out, etc1Block(block))
+	})
+	return out
+}
+
+// The result of encoding half of an ETC1 block
+type etcHalf struct {
+	table   int
+	indices [16]int
+	err     int
+}
+
+// Encodes a block trying both ways of splitting it and both ways of
+// storing the base colours, keeping whichever is closest
+func etc1Block(block *[16][4]uint8) uint64 {
+	var best uint64
+	bestErr := -1
+	for flip := 0; flip < 2; flip++ {
+		inHalf := func(i, half int) bool {
+			x, y := i%4, i/4
+			if flip == 1 {
+				return (y >= 2) == (half == 1)
+			}
+			return (x >= 2) == (half == 1)
+		}
+		var avg [2][3]int
+		for half := 0; half < 2; half++ {
+			for i, p := range block {
+				if inHalf(i, half) {
+					for k := 0; k < 3; k++ {
+						avg[half][k] += int(p[k])
+					}
+				}
+			}
+			for k := 0; k < 3; k++ {
+				avg[half][k] = (avg[half][k] + 4) / 8
+			}
+		}
+
+		encode := func(diff bool, bases [2][3]int, codes [2][3]int) {
+			var halves [2]etcHalf
+			for half := 0; half < 2; half++ {
+				halves[half] = etcFitHalf(block, bases[half], func(i int) bool { return inHalf(i, half) })
+			}
+			err := halves[0].err + halves[1].err
+			if bestErr != -1 && err >= bestErr {
+				return
+			}
+			bestErr = err
+			var hi uint32
+			if diff {
+				for k := 0; k < 3; k++ {
+					d := codes[1][k] - codes[0][k]
+					hi |= uint32(codes[0][k])<<uint(27-k*8) | uint32(d&7)<<uint(24-k*8)
+				}
+				hi |= 1 << 1
+			} else {
+				for k := 0; k < 3; k++ {
+					hi |= uint32(codes[0][k])<<uint(28-k*8) | uint32(codes[1][k])<<uint(24-k*8)
+				}
+			}
+			hi |= uint32(halves[0].table)<<5 | uint32(halves[1].table)<<2 | uint32(flip)
+			var lo uint32
+			for i := range block {
+				h := halves[0]
+				if inHalf(i, 1) {
+					h = halves[1]
+				}
+				index := h.indices[i]
+				bit := uint((i%4)*4 + i/4)
+				lo |= uint32(index>>1)<<(16+bit) | uint32(index&1)<<bit
+			}
+			best = uint64(hi)<<32 | uint64(lo)
+		}
+
+		// Individual mode stores each base colour with 4 bits per channel
+		var codes, bases [2][3]int
+		for half := 0; half < 2; half++ {
+			for k := 0; k < 3; k++ {
+				codes[half][k] = (avg[half][k]*15 + 127) / 255
+				bases[half][k] = codes[half][k] * 17
+			}
+		}
+		encode(false, bases, codes)
+
+		// Differential mode stores the first with 5 bits per channel and
+		// the second as a 3 bit offset from it
+		fits := true
+		for half := 0; half < 2; half++ {
+			for k := 0; k < 3; k++ {
+				codes[half][k] = (avg[half][k]*31 + 127) / 255
+				bases[half][k] = codes[half][k]<<3 | codes[half][k]>>2
+			}
+		}
+		for k := 0; k < 3; k++ {
+			if d := codes[1][k] - codes[0][k]; d < -4 || d > 3 {
+				fits = false
+			}
+		}
+		if fits {
+			encode(true, bases, codes)
+		}
+	}
+	return best
+}
+
+// Picks the modifier table and the modifier of each pixel for half of a
+// block with the given base colour
+func etcFitHalf(block *[16][4]uint8, base [3]int, in func(i int) bool) etcHalf {
+	best := etcHalf{err: -1}
+	for table, mods := range etcModifiers {
+		candidates := [4]int{mods[0], mods[1], -mods[0], -mods[1]}
+		h := etcHalf{table: table}
+		for i, p := range block {
+			if !in(i) {
+				continue
+			}
+			bestDist := -1
+			for index, m := range candidates {
+				dist := 0
+				for k := 0; k < 3; k++ {
+					d := clampInt(base[k]+m, 0, 255) - int(p[k])
+					dist += d * d
+				}
+				if bestDist == -1 || dist < bestDist {
+					h.indices[i], bestDist = index, dist
+				}
+			}
+			h.err += bestDist
+		}
+		if best.err == -1 || h.err < best.err {
+			best = h
+		}
+	}
+	return best
+}
+
+// Encodes the alpha of a block as an EAC block, trying every modifier
+// table with multipliers that fit the range of the alpha values
+func eacBlock(block *[16][4]uint8) uint64 {
+	lo, hi := 255, 0
+	for _, p := range block {
+		lo = minInt(lo, int(p[3]))
+		hi = maxInt(hi, int(p[3]))
+	}
+	var best uint64
+	bestErr := -1
+	for table, mods := range eacModifiers {
+		spread := mods[7] - mods[3]
+		guess := (hi - lo + spread/2) / spread
+		for mult := guess - 1; mult <= guess+1; mult++ {
+			if mult < 1 || mult > 15 {
+				continue
+			}
+			base := clampInt((lo+hi)/2-(mods[7]+mods[3])*mult/2, 0, 255)
+			err := 0
+			var indices uint64
+			for i, p := range block {
+				bestIndex, bestDist := 0, -1
+				for index, m := range mods {
+					if d := absInt(clampInt(base+m*mult, 0, 255) - int(p[3])); bestDist == -1 || d < bestDist {
+						bestIndex, bestDist = index, d
+					}
+				}
+				err += bestDist * bestDist
+				j := uint((i%4)*4 + i/4)
+				indices |= uint64(bestIndex) << (45 - 3*j)
+			}
+			if bestErr == -1 || err < bestErr {
+				bestErr = err
+				best = uint64(base)<<56 | uint64(mult)<<52 | uint64(table)<<48 | indices
+			}
+		}
+	}
+	return best
+}
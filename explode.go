@@ -0,0 +1,232 @@
+package atlas
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Represents an area of an atlas image that belongs to a single sprite
+type region struct {
+	name string
+	// The area of the atlas image holding the sprite
+	frame image.Rectangle
+	// Whether the sprite was rotated 90 degrees clockwise when packed
+	rotated bool
+	// The size of the original image and the position the sprite was
+	// trimmed from within it
+	source image.Rectangle
+}
+
+// The parts of the descriptor formats needed to find the regions
+type rect struct {
+	X, Y, W, H int
+}
+
+type kiwiDescriptor struct {
+	Cells []struct {
+		rect
+		// The image without padding, gutter or alignment, cells written
+		// by other tools only have the outer rect
+		Frame *rect
+		Name  string
+	}
+}
+
+type jsonHashFrame struct {
+	Filename         string
+	Frame            rect
+	Rotated          bool
+	Trimmed          bool
+	SpriteSourceSize rect
+	SourceSize       rect
+}
+
+type jsonHashDescriptor struct {
+	Frames json.RawMessage
+}
+
+// Splits an existing atlas back into individual images. Each region listed
+// in the descriptor is cut from the atlas image, restoring any rotation and
+// trimming, and written to the output directory as a PNG named after the
+// region, keeping the directories in its name. If format is DESC_INVALID
+// it is guessed from the descriptor. Returns the paths of the written
+// images
+func Explode(imageFile, descriptorFile, outputDir string, format DescriptorFormat) ([]string, error) {
+	data, err := ioutil.ReadFile(descriptorFile)
+	if err != nil {
+		return nil, err
+	}
+	regions, err := readRegions(data, format)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := os.Open(imageFile)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	im, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	written := make([]string, 0, len(regions))
+	names := make(map[string]string)
+	for _, reg := range regions {
+		if !reg.frame.In(im.Bounds()) {
+			return nil, errors.New(fmt.Sprintf("Region %s %v is outside of %s", reg.name, reg.frame, imageFile))
+		}
+		name := regionFileName(reg.name)
+		if other, ok := names[name]; ok {
+			return nil, errors.New(fmt.Sprintf("Regions %s and %s would both be written to %s", other, reg.name, name))
+		}
+		names[name] = reg.name
+		filename := path.Join(outputDir, name)
+		if err := os.MkdirAll(path.Dir(filename), 0755); err != nil {
+			return nil, err
+		}
+		if err := writePNG(filename, reg.extract(im)); err != nil {
+			return nil, err
+		}
+		written = append(written, filename)
+	}
+	return written, nil
+}
+
+// Parses the regions from a descriptor in the given format
+func readRegions(data []byte, format DescriptorFormat) ([]*region, error) {
+	if format == DESC_INVALID {
+		format = guessFormat(data)
+	}
+	switch format {
+	case DESC_KIWI:
+		var desc kiwiDescriptor
+		if err := json.Unmarshal(data, &desc); err != nil {
+			return nil, err
+		}
+		regions := make([]*region, len(desc.Cells))
+		for i, cell := range desc.Cells {
+			r := cell.rect
+			if cell.Frame != nil {
+				r = *cell.Frame
+			}
+			regions[i] = &region{
+				name:   cell.Name,
+				frame:  image.Rect(r.X, r.Y, r.X+r.W, r.Y+r.H),
+				source: image.Rect(0, 0, r.W, r.H),
+			}
+		}
+		return regions, nil
+	case DESC_JSON_HASH:
+		var desc jsonHashDescriptor
+		if err := json.Unmarshal(data, &desc); err != nil {
+			return nil, err
+		}
+		frames := make([]jsonHashFrame, 0)
+		// TexturePacker writes frames either as a hash keyed by name
+		// or as an array with the name in each frame
+		if strings.HasPrefix(strings.TrimSpace(string(desc.Frames)), "[") {
+			if err := json.Unmarshal(desc.Frames, &frames); err != nil {
+				return nil, err
+			}
+		} else {
+			hash := make(map[string]jsonHashFrame)
+			if err := json.Unmarshal(desc.Frames, &hash); err != nil {
+				return nil, err
+			}
+			for name, frame := range hash {
+				frame.Filename = name
+				frames = append(frames, frame)
+			}
+			sort.Slice(frames, func(i, j int) bool {
+				return frames[i].Filename < frames[j].Filename
+			})
+		}
+		regions := make([]*region, len(frames))
+		for i, f := range frames {
+			// The frame size is given before rotation so we need to swap
+			// it to find the area taken up in the atlas
+			w, h := f.Frame.W, f.Frame.H
+			if f.Rotated {
+				w, h = h, w
+			}
+			source := image.Rect(0, 0, f.Frame.W, f.Frame.H)
+			if f.Trimmed {
+				source = image.Rect(-f.SpriteSourceSize.X, -f.SpriteSourceSize.Y,
+					f.SourceSize.W-f.SpriteSourceSize.X, f.SourceSize.H-f.SpriteSourceSize.Y)
+			}
+			regions[i] = &region{
+				name:    f.Filename,
+				frame:   image.Rect(f.Frame.X, f.Frame.Y, f.Frame.X+w, f.Frame.Y+h),
+				rotated: f.Rotated,
+				source:  source,
+			}
+		}
+		return regions, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("Unable to read descriptor format %s", format))
+	}
+}
+
+// Guesses the format of a descriptor from its contents
+func guessFormat(data []byte) DescriptorFormat {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return DESC_INVALID
+	}
+	if _, ok := keys["cells"]; ok {
+		return DESC_KIWI
+	}
+	if _, ok := keys["frames"]; ok {
+		return DESC_JSON_HASH
+	}
+	return DESC_INVALID
+}
+
+// Cuts the region out of the atlas image, undoing any rotation and
+// placing it back within its untrimmed bounds
+func (r *region) extract(atlas image.Image) image.Image {
+	// Source bounds are relative to the sprite so the sprite sits at 0,0
+	out := image.NewNRGBA(image.Rect(0, 0, r.source.Dx(), r.source.Dy()))
+	offset := r.source.Min.Mul(-1)
+	if !r.rotated {
+		dp := offset
+		draw.Draw(out, image.Rectangle{dp, dp.Add(r.frame.Size())}, atlas, r.frame.Min, draw.Src)
+		return out
+	}
+	// Rotated sprites were turned clockwise so turn them back
+	w, h := r.frame.Dy(), r.frame.Dx()
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			out.Set(offset.X+x, offset.Y+y, atlas.At(r.frame.Min.X+h-1-y, r.frame.Min.Y+x))
+		}
+	}
+	return out
+}
+
+// Returns the file name to write a region to relative to the output
+// directory. Regions are often named after the path of their original
+// image so the directories are kept, with any drive, leading slash or ".."
+// removed so the file stays within the output directory
+func regionFileName(name string) string {
+	name = strings.Replace(name, "\\", "/", -1)
+	if len(name) >= 2 && name[1] == ':' {
+		name = name[2:]
+	}
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	return strings.TrimSuffix(name, path.Ext(name)) + ".png"
+}
+
+// Encodes the image as a PNG to the given file
+func writePNG(filename string, im image.Image) error {
+	return writeImage(filename, im, &PNGEncoder{})
+}
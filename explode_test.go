@@ -0,0 +1,178 @@
+package atlas
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestExplode(t *testing.T) {
+	BUTTONS := []string{
+		"./fixtures/button.png",
+		"./fixtures/button_active.png",
+		"./fixtures/button_hover.png",
+	}
+
+	dir := t.TempDir()
+
+	// Pack the buttons and then explode them back out again, we expect
+	// to end up with the exact same images we started with. Padding,
+	// gutters and block alignment must all be left out of the regions
+	cases := []struct {
+		written, read DescriptorFormat
+	}{
+		{DESC_JSON_HASH, DESC_JSON_HASH},
+		{DESC_JSON_HASH, DESC_INVALID},
+		{DESC_KIWI, DESC_KIWI},
+		{DESC_KIWI, DESC_INVALID},
+	}
+	for i, c := range cases {
+		res, err := Generate(BUTTONS, dir, &GenerateParams{
+			Name:       fmt.Sprintf("test-explode-%d", i),
+			Descriptor: c.written,
+			Padding:    2,
+			Gutter:     1,
+			BlockAlign: true,
+		})
+		if err != nil {
+			t.Fatalf("Generate threw an error: %s", err.Error())
+		}
+		atlas := res.Atlases[0]
+		outDir := path.Join(dir, fmt.Sprintf("exploded-%d", i))
+		written, err := Explode(path.Join(dir, atlas.ImageName()), path.Join(dir, atlas.Name+".json"), outDir, c.read)
+		if err != nil {
+			t.Fatalf("Explode threw an error: %s", err.Error())
+		}
+		if len(written) != len(BUTTONS) {
+			t.Errorf("Unexpected number of images: want %d, got %d", len(BUTTONS), len(written))
+		}
+		for _, filename := range BUTTONS {
+			want, err := (&File{FileName: filename}).decode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := (&File{FileName: path.Join(outDir, "fixtures", path.Base(filename))}).decode()
+			if err != nil {
+				t.Errorf("Unable to read exploded image: %s", err.Error())
+				continue
+			}
+			if !sameImage(want, got) {
+				t.Errorf("Exploded image %s does not match the original (%s)", filename, c.written)
+			}
+		}
+	}
+}
+
+func TestExplodeNames(t *testing.T) {
+	cases := map[string]string{
+		"fx/boom_01.png":          "fx/boom_01.png",
+		"./ui/boom_01.gif":        "ui/boom_01.png",
+		"/home/user/art/ship.png": "home/user/art/ship.png",
+		"../../etc/passwd":        "etc/passwd.png",
+		"C:\\art\\..\\sprite.jpg": "sprite.png",
+	}
+	for name, want := range cases {
+		if got := regionFileName(name); got != want {
+			t.Errorf("Unexpected file name for %s: want %s, got %s", name, want, got)
+		}
+	}
+
+	dir := t.TempDir()
+	im := writeTestPNG(t, dir, "atlas.png", image.NewNRGBA(image.Rect(0, 0, 8, 8)))
+	// Regions in different directories are kept apart, regions that
+	// would write the same file are rejected
+	desc := path.Join(dir, "atlas.json")
+	write := func(names ...string) {
+		cells := make([]string, len(names))
+		for i, name := range names {
+			cells[i] = fmt.Sprintf(`{"x": %d, "y": 0, "w": 2, "h": 2, "name": %q}`, i*2, name)
+		}
+		if err := ioutil.WriteFile(desc, []byte(`{"cells": [`+strings.Join(cells, ",")+`]}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("fx/boom_01.png", "ui/boom_01.png")
+	written, err := Explode(im, desc, path.Join(dir, "out"), DESC_INVALID)
+	if err != nil {
+		t.Fatalf("Explode threw an error: %s", err.Error())
+	}
+	if len(written) != 2 || written[0] == written[1] {
+		t.Errorf("Expected two separate images, got %v", written)
+	}
+	write("boom_01.png", "boom_01.gif")
+	if _, err := Explode(im, desc, path.Join(dir, "out"), DESC_INVALID); err == nil {
+		t.Errorf("Expected an error for regions written to the same file")
+	}
+}
+
+func TestRegionExtract(t *testing.T) {
+	// The original 3x2 sprite, sitting in a 5x4 canvas at 1,1 before
+	// it was trimmed
+	sprite := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for i := 0; i < 6; i++ {
+		sprite.Set(i%3, i/3, color.NRGBA{uint8(i * 40), 0, 0, 255})
+	}
+	// The sprite rotated clockwise into a 2x3 area of the atlas at 4,4
+	atlas := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			atlas.Set(4+1-y, 4+x, sprite.At(x, y))
+		}
+	}
+
+	regions, err := readRegions([]byte(`{"frames": [{
+		"filename": "sprite",
+		"frame": {"x": 4, "y": 4, "w": 3, "h": 2},
+		"rotated": true,
+		"trimmed": true,
+		"spriteSourceSize": {"x": 1, "y": 1, "w": 3, "h": 2},
+		"sourceSize": {"w": 5, "h": 4}
+	}]}`), DESC_INVALID)
+	if err != nil {
+		t.Fatalf("readRegions threw an error: %s", err.Error())
+	}
+	if len(regions) != 1 {
+		t.Fatalf("Unexpected number of regions: want 1, got %d", len(regions))
+	}
+
+	got := regions[0].extract(atlas)
+	if got.Bounds() != image.Rect(0, 0, 5, 4) {
+		t.Errorf("Unexpected image bounds: want %v, got %v", image.Rect(0, 0, 5, 4), got.Bounds())
+	}
+	want := image.NewNRGBA(image.Rect(0, 0, 5, 4))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			want.Set(x+1, y+1, sprite.At(x, y))
+		}
+	}
+	if !sameImage(want, got) {
+		t.Errorf("Extracted region does not match the original sprite")
+	}
+}
+
+// Returns whether the two images have the same size and pixels, allowing
+// for rounding when the atlas converts to and from premultiplied alpha
+func sameImage(a, b image.Image) bool {
+	near := func(c1, c2 uint32) bool {
+		d := int(c1>>8) - int(c2>>8)
+		return d >= -1 && d <= 1
+	}
+	if a.Bounds().Size() != b.Bounds().Size() {
+		return false
+	}
+	ab, bb := a.Bounds(), b.Bounds()
+	for y := 0; y < ab.Dy(); y++ {
+		for x := 0; x < ab.Dx(); x++ {
+			r1, g1, b1, a1 := a.At(ab.Min.X+x, ab.Min.Y+y).RGBA()
+			r2, g2, b2, a2 := b.At(bb.Min.X+x, bb.Min.Y+y).RGBA()
+			if !near(r1, r2) || !near(g1, g2) || !near(b1, b2) || !near(a1, a2) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -1,11 +1,145 @@
 package atlas
 
+import (
+	"image"
+	"os"
+)
+
 // Represents a File to be outputted
 type File struct {
 	Atlas    *Atlas
 	FileName string
-	X        int
-	Y        int
-	Width    int
-	Height   int
+	// The name used to reference the file in descriptors, usually
+	// the same as FileName
+	Name string
+	// Decoded image data for files that do not map directly to an
+	// image on disk (such as animation frames), nil if the image
+	// should be read from FileName
+	Image  image.Image
+	X      int
+	Y      int
+	Width  int
+	Height int
+	// The position of the image within the larger canvas it was taken
+	// from and the size of that canvas, such as a layer of a PSD file.
+	// The source size is 0 if the image is not part of a larger canvas
+	OffsetX, OffsetY          int
+	SourceWidth, SourceHeight int
+	// The display time of the file in milliseconds when it is a
+	// frame of an animation, 0 otherwise
+	Duration int
+	// Nine-slice borders of the image, nil if the image is not nine-sliced
+	NineSlice *NineSlice
+	// The pivot point of the image relative to the image itself, nil if no
+	// pivot has been set. Files are never rotated when packed so this also
+	// holds in the atlas
+	Pivot *Pivot
+	// The triangles covering the opaque pixels of the image, nil if no
+	// mesh has been built
+	Mesh *Mesh
+	// The channel of a channel packed atlas holding the image, 0 to 3 for
+	// red, green, blue and alpha
+	Channel int
+	// Space added to the right and bottom of the file to align it to
+	// compression blocks, included in Width and Height
+	alignWidth, alignHeight int
+	// Images drawn at the same position in companion atlases, keyed by
+	// the suffix of the companion
+	companions map[string]*File
+}
+
+// Represents the borders of a nine-slice (9-patch) image, in pixels from
+// each edge of the image. The borders keep their size when the image is
+// stretched and the area between them is stretched to fill
+type NineSlice struct {
+	Left, Top, Right, Bottom int
+}
+
+// Represents the point an image is positioned and rotated around, relative
+// to the size of the image so 0,0 is the top left and 1,1 the bottom right
+type Pivot struct {
+	X, Y float64
+}
+
+// Returns the area of the atlas image covered by the file's image, this
+// excludes any padding and gutter that surrounds it
+func (f *File) Frame() image.Rectangle {
+	border := 0
+	if f.Atlas != nil {
+		border = f.Atlas.Padding + f.Atlas.Gutter
+	}
+	return image.Rect(f.X+border, f.Y+border,
+		f.X+f.Width-border-f.alignWidth, f.Y+f.Height-border-f.alignHeight)
+}
+
+// Returns the pivot relative to the canvas the image was taken from, as
+// used by descriptors that give the position of the image within its
+// source. This is the same as Pivot if the image is not part of a larger
+// canvas, nil if no pivot has been set
+func (f *File) SourcePivot() *Pivot {
+	if f.Pivot == nil || f.SourceWidth == 0 || f.SourceHeight == 0 {
+		return f.Pivot
+	}
+	frame := f.Frame()
+	return &Pivot{
+		X: (f.Pivot.X*float64(frame.Dx()) + float64(f.OffsetX)) / float64(f.SourceWidth),
+		Y: (f.Pivot.Y*float64(frame.Dy()) + float64(f.OffsetY)) / float64(f.SourceHeight),
+	}
+}
+
+// Returns the vertices of the mesh relative to the canvas the image was
+// taken from, the same as the mesh vertices if the image is not part of a
+// larger canvas
+func (f *File) MeshSourceVertices() []image.Point {
+	if f.Mesh == nil {
+		return nil
+	}
+	offset := image.Pt(f.OffsetX, f.OffsetY)
+	res := make([]image.Point, len(f.Mesh.Vertices))
+	for i, v := range f.Mesh.Vertices {
+		res[i] = v.Add(offset)
+	}
+	return res
+}
+
+// Returns the positions of the vertices of the mesh in the atlas image, in
+// pixels
+func (f *File) MeshUVs() []image.Point {
+	if f.Mesh == nil {
+		return nil
+	}
+	min := f.Frame().Min
+	res := make([]image.Point, len(f.Mesh.Vertices))
+	for i, v := range f.Mesh.Vertices {
+		res[i] = v.Add(min)
+	}
+	return res
+}
+
+// Converts a pivot relative to the canvas the image was taken from into
+// one relative to the image, so that the pivot stays at the same point
+// of the canvas whichever part of it the image covers
+func (f *File) canvasPivot(p Pivot) *Pivot {
+	if f.SourceWidth == 0 || f.SourceHeight == 0 || f.Width == 0 || f.Height == 0 {
+		return &p
+	}
+	return &Pivot{
+		X: (p.X*float64(f.SourceWidth) - float64(f.OffsetX)) / float64(f.Width),
+		Y: (p.Y*float64(f.SourceHeight) - float64(f.OffsetY)) / float64(f.Height),
+	}
+}
+
+// Returns the image data for the file, decoding it from FileName
+// if it has not already been loaded into memory
+func (f *File) decode() (image.Image, error) {
+	if f.Image != nil {
+		return f.Image, nil
+	}
+	r, err := os.Open(f.FileName)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	im, _, err := image.Decode(r)
+	return im, err
 }
@@ -0,0 +1,680 @@
+package atlas
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+)
+
+// Flags of the points of TrueType glyph outlines and of the components of
+// composite glyphs, see https://docs.microsoft.com/typography/opentype/spec/glyf
+const (
+	glyfOnCurve      = 0x01
+	glyfXShort       = 0x02
+	glyfYShort       = 0x04
+	glyfRepeat       = 0x08
+	glyfXSameOrPos   = 0x10
+	glyfYSameOrPos   = 0x20
+	glyfArgWords     = 0x01
+	glyfArgsXY       = 0x02
+	glyfScale        = 0x08
+	glyfMore         = 0x20
+	glyfXYScale      = 0x40
+	glyfTwoByTwo     = 0x80
+	glyfMaxRecursion = 8
+)
+
+// The GPOS lookup types holding pair kerning
+const (
+	gposPairAdjustment = 2
+	gposExtension      = 9
+)
+
+// Represents the parts of a TrueType or OpenType font needed to draw its
+// glyphs. Values are in font units, unitsPerEm to the em
+type font struct {
+	family     string
+	unitsPerEm int
+	// The distance above and below the baseline of the tallest glyphs,
+	// descent is negative below the baseline, and the gap between lines
+	ascent, descent, lineGap int
+	numGlyphs                int
+	advances                 []int
+
+	cmap    *byteReader
+	cmapFmt int
+	// TrueType outlines, loca holds the offset of each glyph in glyf
+	loca []int
+	glyf []byte
+	// CFF outlines for OpenType fonts that use them
+	cff *cff
+	// Kerning between pairs of glyphs from the kern table, keyed by the
+	// left glyph in the top 16 bits and the right glyph below
+	kern map[uint32]int
+	// Pair adjustment subtables of the GPOS kern feature
+	gposPairs []*byteReader
+}
+
+// Parses a TrueType or OpenType font, only the first font of a collection
+// is read. Glyph outlines can be TrueType or CFF, CFF2 is not supported
+func parseFont(data []byte) (*font, error) {
+	r := &byteReader{data: data, order: binary.BigEndian}
+	start := 0
+	if string(data[:minInt(4, len(data))]) == "ttcf" {
+		r.skip(12)
+		start = int(r.u32())
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+	r = &byteReader{data: data, pos: start, order: binary.BigEndian}
+	version := string(r.bytes(4))
+	if version != "\x00\x01\x00\x00" && version != "OTTO" && version != "true" {
+		return nil, errors.New("Not a TrueType or OpenType font")
+	}
+	count := int(r.u16())
+	r.skip(6)
+	tables := make(map[string]*byteReader)
+	for i := 0; i < count; i++ {
+		tag := string(r.bytes(4))
+		r.skip(4) // Checksum
+		offset, length := int(r.u32()), int(r.u32())
+		if r.err != nil {
+			return nil, r.err
+		}
+		if offset < 0 || length < 0 || offset+length > len(data) {
+			return nil, errors.New(fmt.Sprintf("Table %s is out of range", tag))
+		}
+		tables[tag] = &byteReader{data: data[offset : offset+length], order: binary.BigEndian}
+	}
+	for _, tag := range []string{"head", "hhea", "hmtx", "maxp", "cmap"} {
+		if tables[tag] == nil {
+			return nil, errors.New(fmt.Sprintf("Missing %s table", tag))
+		}
+	}
+
+	f := &font{}
+	head := tables["head"]
+	head.skip(18)
+	f.unitsPerEm = int(head.u16())
+	head.skip(30)
+	longLoca := head.i16() != 0
+	if f.unitsPerEm == 0 {
+		return nil, errors.New("Invalid units per em")
+	}
+
+	maxp := tables["maxp"]
+	maxp.skip(4)
+	f.numGlyphs = int(maxp.u16())
+
+	hhea := tables["hhea"]
+	hhea.skip(4)
+	f.ascent, f.descent, f.lineGap = hhea.i16(), hhea.i16(), hhea.i16()
+	hhea.skip(24)
+	metrics := int(hhea.u16())
+
+	// Glyphs past the last metric share its advance
+	hmtx := tables["hmtx"]
+	f.advances = make([]int, f.numGlyphs)
+	for i := range f.advances {
+		if i < metrics {
+			f.advances[i] = int(hmtx.u16())
+			hmtx.skip(2)
+		} else if i > 0 {
+			f.advances[i] = f.advances[i-1]
+		}
+	}
+	for _, t := range []*byteReader{head, maxp, hhea, hmtx} {
+		if t.err != nil {
+			return nil, t.err
+		}
+	}
+
+	if err := f.parseCmap(tables["cmap"]); err != nil {
+		return nil, err
+	}
+	if name := tables["name"]; name != nil {
+		f.family = fontFamily(name)
+	}
+
+	switch {
+	case tables["glyf"] != nil && tables["loca"] != nil:
+		f.glyf = tables["glyf"].data
+		loca := tables["loca"]
+		f.loca = make([]int, f.numGlyphs+1)
+		for i := range f.loca {
+			if longLoca {
+				f.loca[i] = int(loca.u32())
+			} else {
+				f.loca[i] = int(loca.u16()) * 2
+			}
+		}
+		if loca.err != nil {
+			return nil, loca.err
+		}
+	case tables["CFF "] != nil:
+		c, err := parseCFF(tables["CFF "].data)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Unable to read CFF outlines: %s", err.Error()))
+		}
+		f.cff = c
+	default:
+		return nil, errors.New("Unsupported glyph outlines")
+	}
+
+	if kern := tables["kern"]; kern != nil {
+		f.kern = parseKern(kern)
+	}
+	if gpos := tables["GPOS"]; gpos != nil {
+		f.gposPairs = gposKernSubtables(gpos.data)
+	}
+	return f, nil
+}
+
+// Picks the Unicode subtable of the cmap table, preferring the full
+// range of format 12 over the basic plane of format 4
+func (f *font) parseCmap(cmap *byteReader) error {
+	cmap.skip(2)
+	count := int(cmap.u16())
+	best := -1
+	for i := 0; i < count; i++ {
+		platform, encoding, offset := cmap.u16(), cmap.u16(), int(cmap.u32())
+		if cmap.err != nil {
+			return cmap.err
+		}
+		unicode := platform == 0 || (platform == 3 && (encoding == 1 || encoding == 10))
+		if !unicode || offset+2 > len(cmap.data) {
+			continue
+		}
+		format := int(binary.BigEndian.Uint16(cmap.data[offset:]))
+		if (format == 12 && best != 12) || (format == 4 && best == -1) {
+			best = format
+			f.cmapFmt = format
+			f.cmap = &byteReader{data: cmap.data[offset:], order: binary.BigEndian}
+		}
+	}
+	if f.cmap == nil {
+		return errors.New("No Unicode character map")
+	}
+	return nil
+}
+
+// Returns the glyph for the character, 0 if the font does not have one
+func (f *font) glyphIndex(c rune) int {
+	r := &byteReader{data: f.cmap.data, order: binary.BigEndian}
+	if f.cmapFmt == 12 {
+		r.skip(12)
+		groups := int(r.u32())
+		for i := 0; i < groups && r.err == nil; i++ {
+			first, last, glyph := rune(r.u32()), rune(r.u32()), int(r.u32())
+			if c >= first && c <= last {
+				return glyph + int(c-first)
+			}
+		}
+		return 0
+	}
+
+	if c > 0xffff {
+		return 0
+	}
+	r.skip(6)
+	segments := int(r.u16()) / 2
+	r.skip(6)
+	ends := r.pos
+	starts := ends + segments*2 + 2
+	deltas := starts + segments*2
+	rangeOffsets := deltas + segments*2
+	if rangeOffsets+segments*2 > len(r.data) {
+		return 0
+	}
+	u16 := func(pos int) int {
+		return u16At(r.data, pos)
+	}
+	for i := 0; i < segments; i++ {
+		if int(c) > u16(ends+i*2) {
+			continue
+		}
+		start := u16(starts + i*2)
+		if int(c) < start {
+			return 0
+		}
+		delta, rangeOffset := u16(deltas+i*2), u16(rangeOffsets+i*2)
+		if rangeOffset == 0 {
+			return (int(c) + delta) & 0xffff
+		}
+		glyph := u16(rangeOffsets + i*2 + rangeOffset + (int(c)-start)*2)
+		if glyph == 0 {
+			return 0
+		}
+		return (glyph + delta) & 0xffff
+	}
+	return 0
+}
+
+// Returns the family name from the name table, preferring the Windows
+// Unicode entry
+func fontFamily(name *byteReader) string {
+	name.skip(2)
+	count, storage := int(name.u16()), int(name.u16())
+	family := ""
+	for i := 0; i < count && name.err == nil; i++ {
+		platform, encoding := name.u16(), name.u16()
+		name.skip(2) // Language
+		id, length, offset := name.u16(), int(name.u16()), int(name.u16())
+		if id != 1 || storage+offset+length > len(name.data) {
+			continue
+		}
+		raw := name.data[storage+offset : storage+offset+length]
+		switch {
+		case platform == 3 || platform == 0:
+			units := make([]uint16, len(raw)/2)
+			for j := range units {
+				units[j] = binary.BigEndian.Uint16(raw[j*2:])
+			}
+			return string(utf16.Decode(units))
+		case platform == 1 && encoding == 0 && family == "":
+			family = string(raw)
+		}
+	}
+	return family
+}
+
+// Returns the outline of the glyph in font units with y pointing up,
+// curves are flattened to within the tolerance
+func (f *font) glyphContours(glyph int, tolerance float64) ([]contour, error) {
+	if glyph < 0 || glyph >= f.numGlyphs {
+		return nil, errors.New(fmt.Sprintf("Glyph %d is out of range", glyph))
+	}
+	b := newPathBuilder(tolerance)
+	var err error
+	if f.cff != nil {
+		err = f.cff.glyph(b, glyph)
+	} else {
+		err = f.trueTypeGlyph(b, glyph, affine{1, 0, 0, 1, 0, 0}, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b.path(), nil
+}
+
+// Adds the TrueType outline of the glyph to the path, transformed by m
+func (f *font) trueTypeGlyph(b *pathBuilder, glyph int, m affine, depth int) error {
+	if depth > glyfMaxRecursion {
+		return errors.New("Composite glyphs are nested too deeply")
+	}
+	start, end := f.loca[glyph], f.loca[glyph+1]
+	if start >= end {
+		// Glyphs such as space have no outline
+		return nil
+	}
+	if start < 0 || end > len(f.glyf) {
+		return errors.New(fmt.Sprintf("Glyph %d is out of range", glyph))
+	}
+	r := &byteReader{data: f.glyf[start:end], order: binary.BigEndian}
+	contours := r.i16()
+	r.skip(8) // Bounding box
+
+	if contours < 0 {
+		for flags := glyfMore; flags&glyfMore != 0 && r.err == nil; {
+			flags = int(r.u16())
+			component := int(r.u16())
+			var dx, dy float64
+			if flags&glyfArgWords != 0 {
+				dx, dy = float64(r.i16()), float64(r.i16())
+			} else {
+				dx, dy = float64(int8(r.u8())), float64(int8(r.u8()))
+			}
+			if flags&glyfArgsXY == 0 {
+				// Positioning by matching points is not supported
+				dx, dy = 0, 0
+			}
+			f2dot14 := func() float64 {
+				return float64(r.i16()) / (1 << 14)
+			}
+			t := affine{1, 0, 0, 1, dx, dy}
+			switch {
+			case flags&glyfScale != 0:
+				s := f2dot14()
+				t[0], t[3] = s, s
+			case flags&glyfXYScale != 0:
+				t[0], t[3] = f2dot14(), f2dot14()
+			case flags&glyfTwoByTwo != 0:
+				t[0], t[1], t[2], t[3] = f2dot14(), f2dot14(), f2dot14(), f2dot14()
+			}
+			if r.err != nil {
+				break
+			}
+			if component >= f.numGlyphs {
+				return errors.New(fmt.Sprintf("Glyph %d is out of range", component))
+			}
+			if err := f.trueTypeGlyph(b, component, m.mul(t), depth+1); err != nil {
+				return err
+			}
+		}
+		return r.err
+	}
+
+	ends := make([]int, contours)
+	for i := range ends {
+		ends[i] = int(r.u16())
+	}
+	count := 0
+	if contours > 0 {
+		count = ends[contours-1] + 1
+	}
+	r.skip(int(r.u16())) // Instructions
+	flags := make([]uint8, 0, count)
+	for len(flags) < count && r.err == nil {
+		flag := r.u8()
+		flags = append(flags, flag)
+		if flag&glyfRepeat != 0 {
+			for n := int(r.u8()); n > 0 && len(flags) < count; n-- {
+				flags = append(flags, flag)
+			}
+		}
+	}
+	points := make([]vec, count)
+	coords := func(short, sameOrPos uint8, set func(p *vec, v float64)) {
+		v := 0
+		for i := 0; i < count && r.err == nil; i++ {
+			if flags[i]&short != 0 {
+				d := int(r.u8())
+				if flags[i]&sameOrPos == 0 {
+					d = -d
+				}
+				v += d
+			} else if flags[i]&sameOrPos == 0 {
+				v += r.i16()
+			}
+			set(&points[i], float64(v))
+		}
+	}
+	coords(glyfXShort, glyfXSameOrPos, func(p *vec, v float64) { p.X = v })
+	coords(glyfYShort, glyfYSameOrPos, func(p *vec, v float64) { p.Y = v })
+	if r.err != nil {
+		return r.err
+	}
+
+	first := 0
+	for _, last := range ends {
+		if last < first || last >= count {
+			return errors.New("Invalid contour end point")
+		}
+		trueTypeContour(b, points[first:last+1], flags[first:last+1], m)
+		first = last + 1
+	}
+	return nil
+}
+
+// Adds a contour of on and off curve points to the path, two off curve
+// points in a row have an implied on curve point midway between them
+func trueTypeContour(b *pathBuilder, points []vec, flags []uint8, m affine) {
+	n := len(points)
+	if n == 0 {
+		return
+	}
+	on := func(i int) bool {
+		return flags[i%n]&glyfOnCurve != 0
+	}
+	at := func(i int) vec {
+		return m.apply(points[i%n])
+	}
+	// Start from an on curve point, or between two off curve points
+	start, startPoint := -1, vec{}
+	for i := 0; i < n; i++ {
+		if on(i) {
+			start, startPoint = i, at(i)
+			break
+		}
+	}
+	if start < 0 {
+		start, startPoint = 0, at(0).lerp(at(1), 0.5)
+	}
+	b.moveTo(startPoint)
+	var control *vec
+	for i := start + 1; i <= start+n; i++ {
+		p := at(i)
+		if on(i) {
+			if control != nil {
+				b.quadTo(*control, p)
+			} else {
+				b.lineTo(p)
+			}
+			control = nil
+			continue
+		}
+		if control != nil {
+			b.quadTo(*control, control.lerp(p, 0.5))
+		}
+		c := p
+		control = &c
+	}
+	if control != nil {
+		b.quadTo(*control, startPoint)
+	}
+	b.close()
+}
+
+// Reads the horizontal pairs of format 0 kern subtables
+func parseKern(kern *byteReader) map[uint32]int {
+	pairs := make(map[uint32]int)
+	kern.skip(2)
+	tables := int(kern.u16())
+	for i := 0; i < tables && kern.err == nil; i++ {
+		kern.skip(2) // Version
+		length := int(kern.u16())
+		coverage := kern.u16()
+		sub := kern.sub(length - 6)
+		// Only horizontal kerning values, not minimums or cross stream
+		if coverage>>8 != 0 || coverage&0x7 != 1 {
+			continue
+		}
+		count := int(sub.u16())
+		sub.skip(6)
+		for j := 0; j < count && sub.err == nil; j++ {
+			pair := sub.u32()
+			pairs[pair] += sub.i16()
+		}
+	}
+	return pairs
+}
+
+// Returns the pair adjustment subtables of the lookups used by the kern
+// feature of the GPOS table
+func gposKernSubtables(data []byte) []*byteReader {
+	at := func(offset int) *byteReader {
+		if offset < 0 || offset > len(data) {
+			return &byteReader{order: binary.BigEndian, err: errors.New("Offset out of range")}
+		}
+		return &byteReader{data: data[offset:], order: binary.BigEndian}
+	}
+	r := at(0)
+	r.skip(6)
+	featureList, lookupList := int(r.u16()), int(r.u16())
+
+	lookups := make(map[int]bool)
+	features := at(featureList)
+	for i, count := 0, int(features.u16()); i < count && features.err == nil; i++ {
+		tag, offset := string(features.bytes(4)), int(features.u16())
+		if tag != "kern" {
+			continue
+		}
+		feature := at(featureList + offset)
+		feature.skip(2)
+		for j, n := 0, int(feature.u16()); j < n && feature.err == nil; j++ {
+			lookups[int(feature.u16())] = true
+		}
+	}
+
+	subtables := make([]*byteReader, 0)
+	list := at(lookupList)
+	for i, count := 0, int(list.u16()); i < count && list.err == nil; i++ {
+		offset := lookupList + int(list.u16())
+		if !lookups[i] {
+			continue
+		}
+		lookup := at(offset)
+		kind := int(lookup.u16())
+		lookup.skip(2)
+		for j, n := 0, int(lookup.u16()); j < n && lookup.err == nil; j++ {
+			sub := offset + int(lookup.u16())
+			if kind == gposExtension {
+				ext := at(sub)
+				ext.skip(2)
+				if int(ext.u16()) != gposPairAdjustment {
+					continue
+				}
+				sub += int(ext.u32())
+			} else if kind != gposPairAdjustment {
+				continue
+			}
+			subtables = append(subtables, at(sub))
+		}
+	}
+	return subtables
+}
+
+// Returns the kerning between two glyphs in font units, from the GPOS
+// table if it has any pair adjustments and the kern table otherwise
+func (f *font) kerning(left, right int) int {
+	if len(f.gposPairs) == 0 {
+		return f.kern[uint32(left)<<16|uint32(right)]
+	}
+	for _, sub := range f.gposPairs {
+		if v, ok := gposPairKerning(sub.data, left, right); ok {
+			return v
+		}
+	}
+	return 0
+}
+
+// Looks up the pair in a pair adjustment subtable, returning the change
+// to the advance of the left glyph
+func gposPairKerning(data []byte, left, right int) (int, bool) {
+	u16 := func(pos int) int {
+		return u16At(data, pos)
+	}
+	format := u16(0)
+	index := coverageIndex(data, u16(2), left)
+	if index < 0 {
+		return 0, false
+	}
+	format1, format2 := u16(4), u16(6)
+	size1, size2 := valueRecordSize(format1), valueRecordSize(format2)
+	advance := func(pos int) int {
+		// The horizontal advance follows the x and y placements
+		if format1&0x4 == 0 {
+			return 0
+		}
+		for bit := 0; bit < 2; bit++ {
+			if format1&(1<<uint(bit)) != 0 {
+				pos += 2
+			}
+		}
+		return int(int16(u16(pos)))
+	}
+
+	switch format {
+	case 1:
+		if index >= u16(8) {
+			return 0, false
+		}
+		set := u16(10 + index*2)
+		size := 2 + size1 + size2
+		lo, hi := 0, u16(set)-1
+		for lo <= hi {
+			mid := (lo + hi) / 2
+			pos := set + 2 + mid*size
+			switch second := u16(pos); {
+			case second == right:
+				return advance(pos + 2), true
+			case second < right:
+				lo = mid + 1
+			default:
+				hi = mid - 1
+			}
+		}
+	case 2:
+		class1 := classValue(data, u16(8), left)
+		class2 := classValue(data, u16(10), right)
+		count1, count2 := u16(12), u16(14)
+		if class1 >= count1 || class2 >= count2 {
+			return 0, false
+		}
+		return advance(16 + (class1*count2+class2)*(size1+size2)), true
+	}
+	return 0, false
+}
+
+// Reads the big endian value at pos, 0 if it is out of range
+func u16At(data []byte, pos int) int {
+	if pos < 0 || pos+2 > len(data) {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(data[pos:]))
+}
+
+// Returns the size in bytes of a value record with the given format
+func valueRecordSize(format int) int {
+	size := 0
+	for ; format != 0; format >>= 1 {
+		size += (format & 1) * 2
+	}
+	return size
+}
+
+// Returns the index of the glyph in a coverage table, -1 if not covered
+func coverageIndex(data []byte, offset, glyph int) int {
+	u16 := func(pos int) int {
+		return u16At(data, pos)
+	}
+	count := u16(offset + 2)
+	switch u16(offset) {
+	case 1:
+		lo, hi := 0, count-1
+		for lo <= hi {
+			mid := (lo + hi) / 2
+			switch g := u16(offset + 4 + mid*2); {
+			case g == glyph:
+				return mid
+			case g < glyph:
+				lo = mid + 1
+			default:
+				hi = mid - 1
+			}
+		}
+	case 2:
+		for i := 0; i < count; i++ {
+			pos := offset + 4 + i*6
+			if start, end := u16(pos), u16(pos+2); glyph >= start && glyph <= end {
+				return u16(pos+4) + glyph - start
+			}
+		}
+	}
+	return -1
+}
+
+// Returns the class of the glyph in a class definition table, glyphs
+// that are not listed are in class 0
+func classValue(data []byte, offset, glyph int) int {
+	u16 := func(pos int) int {
+		return u16At(data, pos)
+	}
+	switch u16(offset) {
+	case 1:
+		start, count := u16(offset+2), u16(offset+4)
+		if glyph >= start && glyph < start+count {
+			return u16(offset + 6 + (glyph-start)*2)
+		}
+	case 2:
+		for i, count := 0, u16(offset+2); i < count; i++ {
+			pos := offset + 4 + i*6
+			if start, end := u16(pos), u16(pos+2); glyph >= start && glyph <= end {
+				return u16(pos + 4)
+			}
+		}
+	}
+	return 0
+}
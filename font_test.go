@@ -0,0 +1,277 @@
+package atlas
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Builds a font with 1000 units to the em and four glyphs: an empty
+// .notdef, a 500x500 square for 'A', a circle in the same box for 'V' and
+// an empty space. 'A' is kerned by -100 before 'V'. The outlines are
+// TrueType unless cff is set
+func testFont(cff bool) []byte {
+	be := func(b *bytes.Buffer, values ...interface{}) {
+		for _, v := range values {
+			binary.Write(b, binary.BigEndian, v)
+		}
+	}
+	tables := make(map[string][]byte)
+
+	head := &bytes.Buffer{}
+	be(head, make([]byte, 18), uint16(1000), make([]byte, 30), int16(1), int16(0))
+	tables["head"] = head.Bytes()
+
+	hhea := &bytes.Buffer{}
+	be(hhea, uint32(0x10000), int16(800), int16(-200), int16(0), make([]byte, 24), uint16(4))
+	tables["hhea"] = hhea.Bytes()
+
+	maxp := &bytes.Buffer{}
+	be(maxp, uint32(0x5000), uint16(4))
+	tables["maxp"] = maxp.Bytes()
+
+	hmtx := &bytes.Buffer{}
+	be(hmtx, uint16(500), int16(0), uint16(700), int16(100), uint16(700), int16(100), uint16(250), int16(0))
+	tables["hmtx"] = hmtx.Bytes()
+
+	// Maps space, A and V to glyphs 3, 1 and 2
+	cmap := &bytes.Buffer{}
+	be(cmap, uint16(0), uint16(1), uint16(3), uint16(1), uint32(12))
+	be(cmap, uint16(4), uint16(16+4*8), uint16(0), uint16(8), make([]byte, 6))
+	be(cmap, []uint16{32, 65, 86, 0xffff}, uint16(0), []uint16{32, 65, 86, 0xffff})
+	be(cmap, []int16{3 - 32, 1 - 65, 2 - 86, 1}, []uint16{0, 0, 0, 0})
+	tables["cmap"] = cmap.Bytes()
+
+	kern := &bytes.Buffer{}
+	be(kern, uint16(0), uint16(1), uint16(0), uint16(6+8+6), uint16(1))
+	be(kern, uint16(1), make([]byte, 6), uint16(1), uint16(2), int16(-100))
+	tables["kern"] = kern.Bytes()
+
+	if cff {
+		// Numbers are all written as 16 bit values, offsets in dicts as
+		// 32 bit values so the layout is known in advance
+		num := func(values ...int) []byte {
+			b := &bytes.Buffer{}
+			for _, v := range values {
+				be(b, uint8(28), int16(v))
+			}
+			return b.Bytes()
+		}
+		op := func(code []byte, ops ...byte) []byte {
+			return append(code, ops...)
+		}
+		index := func(items ...[]byte) []byte {
+			b := &bytes.Buffer{}
+			be(b, uint16(len(items)), uint8(4))
+			offset := uint32(1)
+			be(b, offset)
+			for _, item := range items {
+				offset += uint32(len(item))
+				be(b, offset)
+			}
+			for _, item := range items {
+				b.Write(item)
+			}
+			return b.Bytes()
+		}
+		dictOffset := func(v int) []byte {
+			b := &bytes.Buffer{}
+			be(b, uint8(29), int32(v))
+			return b.Bytes()
+		}
+
+		// The square includes an advance width before its first move
+		square := op(num(600, 100, 0), csRMoveTo)
+		square = op(append(square, num(500, 500, -500)...), csHLineTo, csEndChar)
+		circle := op(num(350, 0), csRMoveTo)
+		circle = op(append(circle, num(-107)...), csCallSubr, csEndChar)
+		quarters := op(num(138, 0, 112, 112, 0, 138, 0, 138, -112, 112, -138, 0,
+			-138, 0, -112, -112, 0, -138, 0, -138, 112, -112, 138, 0), csRRCurveTo, csReturn)
+		charStrings := index([]byte{csEndChar}, square, circle, []byte{csEndChar})
+		private := op(dictOffset(6), cffSubrs)
+
+		name := index([]byte("Test"))
+		topSize := 17
+		start := 4 + len(name) + len(index(make([]byte, topSize))) + 2 + 2
+		top := op(dictOffset(start), cffCharStrings)
+		top = op(append(top, dictOffset(len(private))...), dictOffset(start+len(charStrings))...)
+		top = append(top, cffPrivate)
+
+		table := &bytes.Buffer{}
+		be(table, []uint8{1, 0, 4, 4}, name, index(top), uint16(0), uint16(0))
+		be(table, charStrings, private, index(quarters))
+		tables["CFF "] = table.Bytes()
+	} else {
+		glyph := func(points [][2]int16, on bool) []byte {
+			b := &bytes.Buffer{}
+			be(b, int16(1), int16(100), int16(0), int16(600), int16(500), uint16(len(points)-1), uint16(0))
+			for range points {
+				if on {
+					be(b, uint8(glyfOnCurve))
+				} else {
+					be(b, uint8(0))
+				}
+			}
+			for axis := 0; axis < 2; axis++ {
+				last := int16(0)
+				for _, p := range points {
+					be(b, p[axis]-last)
+					last = p[axis]
+				}
+			}
+			return b.Bytes()
+		}
+		corners := [][2]int16{{100, 0}, {600, 0}, {600, 500}, {100, 500}}
+		// The circle is made of off curve points alone, with the curve
+		// passing through the implied points between them
+		glyphs := [][]byte{nil, glyph(corners, true), glyph(corners, false), nil}
+		glyf, loca := &bytes.Buffer{}, &bytes.Buffer{}
+		for _, g := range glyphs {
+			be(loca, uint32(glyf.Len()))
+			glyf.Write(g)
+		}
+		be(loca, uint32(glyf.Len()))
+		tables["glyf"], tables["loca"] = glyf.Bytes(), loca.Bytes()
+	}
+
+	tags := make([]string, 0, len(tables))
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	b := &bytes.Buffer{}
+	if cff {
+		b.WriteString("OTTO")
+	} else {
+		be(b, uint32(0x10000))
+	}
+	be(b, uint16(len(tags)), make([]byte, 6))
+	offset := 12 + 16*len(tags)
+	for _, tag := range tags {
+		be(b, []byte(tag), uint32(0), uint32(offset), uint32(len(tables[tag])))
+		offset += len(tables[tag])
+	}
+	for _, tag := range tags {
+		b.Write(tables[tag])
+	}
+	return b.Bytes()
+}
+
+func TestParseFont(t *testing.T) {
+	for _, cff := range []bool{false, true} {
+		f, err := parseFont(testFont(cff))
+		if err != nil {
+			t.Fatalf("Unable to parse font (cff %v): %s", cff, err.Error())
+		}
+		a, v := f.glyphIndex('A'), f.glyphIndex('V')
+		if a != 1 || v != 2 || f.glyphIndex(' ') != 3 || f.glyphIndex('B') != 0 {
+			t.Errorf("Unexpected glyphs: A %d, V %d, space %d, B %d", a, v, f.glyphIndex(' '), f.glyphIndex('B'))
+		}
+		if k := f.kerning(a, v); k != -100 {
+			t.Errorf("Unexpected kerning: want -100, got %d", k)
+		}
+
+		// Draw each glyph at 50 units to the pixel with y pointing down
+		draw := func(glyph int) []float32 {
+			contours, err := f.glyphContours(glyph, 1)
+			if err != nil {
+				t.Fatalf("Unable to read glyph %d (cff %v): %s", glyph, cff, err.Error())
+			}
+			contours = transformContours(contours, affine{0.02, 0, 0, -0.02, 0, 10})
+			return rasterize(contours, 12, 10, fillNonZero)
+		}
+		square, circle := draw(a), draw(v)
+		cases := []struct {
+			coverage []float32
+			x, y     int
+			want     float32
+		}{
+			{square, 5, 5, 1},
+			{square, 2, 0, 1},
+			{square, 11, 9, 1},
+			{square, 1, 5, 0},
+			{circle, 7, 5, 1},
+			{circle, 3, 5, 1},
+			{circle, 2, 0, 0},
+			{circle, 11, 9, 0},
+		}
+		for i, c := range cases {
+			if got := c.coverage[c.y*12+c.x]; got != c.want {
+				t.Errorf("Unexpected coverage %d at %d,%d (cff %v): want %v, got %v", i, c.x, c.y, cff, c.want, got)
+			}
+		}
+	}
+}
+
+func TestGenerateFont(t *testing.T) {
+	dir := t.TempDir()
+	fontFile := path.Join(dir, "test.ttf")
+	if err := ioutil.WriteFile(fontFile, testFont(false), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := GenerateFont(fontFile, dir, &FontParams{
+		Name:       "test-font",
+		Size:       20,
+		Characters: "VAB A ",
+		Padding:    1,
+	})
+	if err != nil {
+		t.Fatalf("GenerateFont threw an error: %s", err.Error())
+	}
+	if len(res.Glyphs) != 3 {
+		t.Fatalf("Unexpected number of glyphs: want 3, got %d", len(res.Glyphs))
+	}
+	if _, err := os.Stat(path.Join(dir, res.Atlases[0].ImageName())); err != nil {
+		t.Errorf("Page %s was not written", res.Atlases[0].ImageName())
+	}
+	data, err := ioutil.ReadFile(path.Join(dir, "test-font.fnt"))
+	if err != nil {
+		t.Fatalf("Descriptor was not written: %s", err.Error())
+	}
+	// The square is 10 pixels wide starting 2 pixels right of the pen,
+	// and its top is 6 pixels below the top of the line
+	frame := res.Glyphs[1].Frame()
+	for _, want := range []string{
+		`info face="test" size=20`,
+		"common lineHeight=20 base=16",
+		"chars count=3",
+		"char id=32 x=0 y=0 width=0 height=0 xoffset=0 yoffset=16 xadvance=5 page=0",
+		"char id=65 x=" + strconv.Itoa(frame.Min.X) + " y=" + strconv.Itoa(frame.Min.Y) + " width=10 height=10 xoffset=2 yoffset=6 xadvance=14 page=0",
+		"kernings count=1\nkerning first=65 second=86 amount=-2\n",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected the descriptor to contain %q, got:\n%s", want, data)
+		}
+	}
+
+	_, err = GenerateFont(fontFile, dir, &FontParams{Name: "test-font-xml", Format: FONT_XML, Characters: "AV"})
+	if err != nil {
+		t.Fatalf("GenerateFont threw an error: %s", err.Error())
+	}
+	data, err = ioutil.ReadFile(path.Join(dir, "test-font-xml.fnt"))
+	if err != nil {
+		t.Fatalf("Descriptor was not written: %s", err.Error())
+	}
+	var desc struct {
+		Chars []struct {
+			ID int `xml:"id,attr"`
+		} `xml:"chars>char"`
+		Kernings []struct {
+			Amount int `xml:"amount,attr"`
+		} `xml:"kernings>kerning"`
+	}
+	if err := xml.Unmarshal(data, &desc); err != nil {
+		t.Fatalf("Unable to parse XML descriptor: %s", err.Error())
+	}
+	if len(desc.Chars) != 2 || len(desc.Kernings) != 1 || desc.Kernings[0].Amount != -3 {
+		t.Errorf("Unexpected XML descriptor:\n%s", data)
+	}
+}
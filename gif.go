@@ -0,0 +1,83 @@
+package atlas
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"path"
+	"strings"
+)
+
+// Expands an animated GIF into one file per frame. Frames are named
+// after the GIF with the frame index appended, eg. "anim.gif" becomes
+// "anim_0.gif", "anim_1.gif"...
+func gifFiles(filename string, g *gif.GIF) []*File {
+	frames, delays := gifFrames(g)
+	ext := path.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+	files := make([]*File, len(frames))
+	for i, frame := range frames {
+		size := frame.Bounds().Size()
+		files[i] = &File{
+			FileName: filename,
+			Name:     fmt.Sprintf("%s_%d%s", base, i, ext),
+			Image:    frame,
+			Width:    size.X,
+			Height:   size.Y,
+			Duration: delays[i],
+		}
+	}
+	return files
+}
+
+// Composites each frame of a GIF onto the canvas left behind by the
+// frames before it, applying each frame's disposal method once it has
+// been displayed. Returns the full canvas for every frame along with
+// the frame delays in milliseconds
+func gifFrames(g *gif.GIF) (frames []image.Image, delays []int) {
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if bounds.Empty() {
+		// Some encoders leave the logical screen size empty, in which
+		// case we fall back to the area covered by all the frames
+		for _, frame := range g.Image {
+			bounds = bounds.Union(frame.Bounds())
+		}
+	}
+
+	canvas := image.NewRGBA(bounds)
+	frames = make([]image.Image, len(g.Image))
+	delays = make([]int, len(g.Image))
+	for i, frame := range g.Image {
+		disposal := byte(0)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+
+		// Keep a copy of the canvas to restore once this frame is done
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(bounds)
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		out := image.NewRGBA(bounds)
+		copy(out.Pix, canvas.Pix)
+		frames[i] = out
+		if i < len(g.Delay) {
+			// GIF delays are stored in hundredths of a second
+			delays[i] = g.Delay[i] * 10
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			// Browsers restore to transparent rather than the background
+			// colour and this is what artists expect
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			copy(canvas.Pix, previous.Pix)
+		}
+	}
+	return frames, delays
+}
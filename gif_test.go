@@ -0,0 +1,84 @@
+package atlas
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+func TestGifFrames(t *testing.T) {
+	palette := color.Palette{color.Transparent, color.White, color.Black}
+
+	// Each frame draws a single pixel so we can see what is left behind
+	// by the disposal of the frame before it
+	pixel := func(x, y int, index uint8) *image.Paletted {
+		im := image.NewPaletted(image.Rect(x, y, x+1, y+1), palette)
+		im.SetColorIndex(x, y, index)
+		return im
+	}
+
+	g := &gif.GIF{
+		Image: []*image.Paletted{
+			pixel(0, 0, 1),
+			pixel(1, 0, 1),
+			pixel(2, 0, 2),
+			pixel(0, 1, 2),
+		},
+		Delay: []int{10, 20, 30, 40},
+		Disposal: []byte{
+			gif.DisposalNone,
+			gif.DisposalBackground,
+			gif.DisposalPrevious,
+			gif.DisposalNone,
+		},
+		Config: image.Config{Width: 3, Height: 2},
+	}
+
+	cases := []struct {
+		frame  int
+		opaque []image.Point
+	}{
+		{0, []image.Point{{0, 0}}},
+		{1, []image.Point{{0, 0}, {1, 0}}},
+		{2, []image.Point{{0, 0}, {2, 0}}},
+		{3, []image.Point{{0, 0}, {0, 1}}},
+	}
+
+	frames, delays := gifFrames(g)
+	if len(frames) != len(g.Image) {
+		t.Fatalf("Unexpected number of frames: want %d, got %d", len(g.Image), len(frames))
+	}
+	for _, c := range cases {
+		if delays[c.frame] != g.Delay[c.frame]*10 {
+			t.Errorf("Unexpected delay for frame %d: want %dms, got %dms", c.frame, g.Delay[c.frame]*10, delays[c.frame])
+		}
+		frame := frames[c.frame]
+		if frame.Bounds() != image.Rect(0, 0, 3, 2) {
+			t.Errorf("Unexpected bounds for frame %d: want %v, got %v", c.frame, image.Rect(0, 0, 3, 2), frame.Bounds())
+		}
+		numOpaque := 0
+		for y := 0; y < 2; y++ {
+			for x := 0; x < 3; x++ {
+				if _, _, _, a := frame.At(x, y).RGBA(); a != 0 {
+					numOpaque += 1
+				}
+			}
+		}
+		if numOpaque != len(c.opaque) {
+			t.Errorf("Unexpected number of opaque pixels in frame %d: want %d, got %d", c.frame, len(c.opaque), numOpaque)
+		}
+		for _, p := range c.opaque {
+			if _, _, _, a := frame.At(p.X, p.Y).RGBA(); a == 0 {
+				t.Errorf("Expected pixel %v of frame %d to be opaque", p, c.frame)
+			}
+		}
+	}
+}
+
+func TestGenerateGIF(t *testing.T) {
+	res, _, _ := generateJSON(t, []string{"./fixtures/yes_no_maybe_no.gif"}, &GenerateParams{Name: "test-gif"})
+	if len(res.Files) != 22 || len(res.Atlases) != 1 {
+		t.Errorf("Unexpected result: want 22 files in 1 atlas, got %d files in %d atlases", len(res.Files), len(res.Atlases))
+	}
+}
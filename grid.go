@@ -0,0 +1,110 @@
+package atlas
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"path"
+	"strings"
+)
+
+// Describes how to cut a sprite sheet into individual frames. The cell
+// size can either be given directly or worked out from the number of
+// rows and columns in the sheet
+type Grid struct {
+	CellWidth, CellHeight int
+	Rows, Cols            int
+	// The space around the outside of the sheet
+	Margin int
+	// The space between each cell
+	Spacing int
+	// Discard cells that are fully transparent
+	SkipEmpty bool
+}
+
+// Works out the cell size and number of cells along one axis of the sheet
+// given either the cell size or the number of cells
+func (g *Grid) axis(size, cell, count int) (int, int, error) {
+	inner := size - g.Margin*2
+	if cell == 0 && count > 0 {
+		cell = (inner - (count-1)*g.Spacing) / count
+	} else if count == 0 && cell > 0 {
+		count = (inner + g.Spacing) / (cell + g.Spacing)
+	}
+	if cell <= 0 || count <= 0 {
+		return 0, 0, errors.New("Grid must have a cell size or number of rows and columns")
+	}
+	return cell, count, nil
+}
+
+// Cuts the given sheet into a file per cell of the grid. Cells are read
+// left to right, top to bottom and are named after the sheet with the
+// index of the cell appended, eg. "sheet.png" becomes "sheet_0.png",
+// "sheet_1.png"... Skipped cells keep their index so names are stable
+func sliceGrid(filename string, sheet image.Image, grid *Grid) ([]*File, error) {
+	bounds := sheet.Bounds()
+	cellWidth, cols, err := grid.axis(bounds.Dx(), grid.CellWidth, grid.Cols)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to slice %s: %s", filename, err.Error()))
+	}
+	cellHeight, rows, err := grid.axis(bounds.Dy(), grid.CellHeight, grid.Rows)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to slice %s: %s", filename, err.Error()))
+	}
+
+	ext := path.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+	files := make([]*File, 0, rows*cols)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			min := bounds.Min.Add(image.Pt(
+				grid.Margin+col*(cellWidth+grid.Spacing),
+				grid.Margin+row*(cellHeight+grid.Spacing),
+			))
+			rect := image.Rectangle{min, min.Add(image.Pt(cellWidth, cellHeight))}
+			if !rect.In(bounds) {
+				return nil, errors.New(fmt.Sprintf("Grid cell %v is outside of %s (%dx%d)",
+					rect, filename, bounds.Dx(), bounds.Dy()))
+			}
+			cell := subImage(sheet, rect)
+			if grid.SkipEmpty && isTransparent(cell) {
+				continue
+			}
+			files = append(files, &File{
+				FileName: filename,
+				Name:     fmt.Sprintf("%s_%d%s", base, row*cols+col, ext),
+				Image:    cell,
+				Width:    cellWidth,
+				Height:   cellHeight,
+			})
+		}
+	}
+	return files, nil
+}
+
+// Returns the given area of an image, sharing pixels with the original
+// image where the image type supports it
+func subImage(im image.Image, rect image.Rectangle) image.Image {
+	if s, ok := im.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(rect)
+	}
+	sub := image.NewRGBA(rect)
+	draw.Draw(sub, rect, im, rect.Min, draw.Src)
+	return sub
+}
+
+// Returns whether every pixel of the image is fully transparent
+func isTransparent(im image.Image) bool {
+	bounds := im.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := im.At(x, y).RGBA(); a != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
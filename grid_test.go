@@ -0,0 +1,72 @@
+package atlas
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestSliceGrid(t *testing.T) {
+	// A 2x2 sheet of 10x8 cells with a margin of 1 and spacing of 2,
+	// only the top left and bottom right cells have any content
+	sheet := image.NewNRGBA(image.Rect(0, 0, 1+10+2+10+1, 1+8+2+8+1))
+	sheet.Set(1, 1, color.White)
+	sheet.Set(1+10+2+9, 1+8+2+7, color.White)
+
+	type Want struct {
+		names []string
+		err   bool
+	}
+
+	cases := []struct {
+		grid *Grid
+		want Want
+	}{
+		{
+			&Grid{CellWidth: 10, CellHeight: 8, Margin: 1, Spacing: 2},
+			Want{names: []string{"sheet_0.png", "sheet_1.png", "sheet_2.png", "sheet_3.png"}},
+		},
+		{
+			&Grid{Rows: 2, Cols: 2, Margin: 1, Spacing: 2, SkipEmpty: true},
+			Want{names: []string{"sheet_0.png", "sheet_3.png"}},
+		},
+		{
+			&Grid{CellWidth: 10, CellHeight: 8, Rows: 1, Margin: 1, Spacing: 2},
+			Want{names: []string{"sheet_0.png", "sheet_1.png"}},
+		},
+		{
+			&Grid{Margin: 1},
+			Want{err: true},
+		},
+		{
+			&Grid{CellWidth: 10, CellHeight: 8, Rows: 3, Cols: 2, Margin: 1, Spacing: 2},
+			Want{err: true},
+		},
+	}
+
+	for _, c := range cases {
+		got, err := sliceGrid("sheet.png", sheet, c.grid)
+		if c.want.err {
+			if err == nil {
+				t.Errorf("Expected an error slicing %+v", c.grid)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("sliceGrid threw an error: %s", err.Error())
+			continue
+		}
+		if len(got) != len(c.want.names) {
+			t.Errorf("Unexpected number of cells: want %d, got %d", len(c.want.names), len(got))
+			continue
+		}
+		for i, file := range got {
+			if file.Name != c.want.names[i] {
+				t.Errorf("Unexpected cell name: want %s, got %s", c.want.names[i], file.Name)
+			}
+			if file.Width != 10 || file.Height != 8 {
+				t.Errorf("Unexpected cell size: want 10x8, got %dx%d", file.Width, file.Height)
+			}
+		}
+	}
+}
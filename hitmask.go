@@ -0,0 +1,123 @@
+package atlas
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"path"
+)
+
+// The extension of the file holding the hit masks of an atlas
+const HIT_MASK_EXT = ".hitmask"
+
+// The alpha at or above which pixels are set in hit masks when no
+// threshold is given
+const DEFAULT_HIT_THRESHOLD = 128
+
+const hitMaskMagic = "HMSK"
+const hitMaskVersion = 1
+
+// Represents the pixels of a sprite that can be hit, one bit per pixel
+type HitMask struct {
+	// The name of the sprite as used in descriptors
+	Name          string
+	Width, Height int
+	// Rows of (Width+7)/8 bytes from the top of the sprite, with the
+	// leftmost pixel of each byte in its most significant bit
+	Bits []byte
+}
+
+// Returns whether the pixel at the given position of the sprite is set,
+// positions outside the sprite are never set
+func (m *HitMask) Hit(x, y int) bool {
+	if x < 0 || y < 0 || x >= m.Width || y >= m.Height {
+		return false
+	}
+	return m.Bits[y*((m.Width+7)/8)+x/8]&(0x80>>uint(x%8)) != 0
+}
+
+// Builds the hit mask of an image, setting the pixels with an alpha of at
+// least the threshold
+func hitMaskImage(im image.Image, threshold uint8) *HitMask {
+	b := im.Bounds()
+	stride := (b.Dx() + 7) / 8
+	m := &HitMask{Width: b.Dx(), Height: b.Dy(), Bits: make([]byte, stride*b.Dy())}
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			if _, _, _, a := im.At(b.Min.X+x, b.Min.Y+y).RGBA(); a>>8 >= uint32(threshold) {
+				m.Bits[y*stride+x/8] |= 0x80 >> uint(x%8)
+			}
+		}
+	}
+	return m
+}
+
+// Returns the file name of the hit masks written for this atlas, an empty
+// string if hit masks are not written
+func (a *Atlas) HitMaskName() string {
+	if !a.HitMask {
+		return ""
+	}
+	return a.Name + HIT_MASK_EXT
+}
+
+// Writes the hit mask of each file in the atlas to the given output
+// directory, in the same order as the files in the descriptor. The file
+// starts with "HMSK", a 16 bit version and a 32 bit count of sprites, each
+// sprite then has a 16 bit name length, the name, a 32 bit width and
+// height and the bits of its HitMask. Values are little endian. Does
+// nothing if hit masks are not enabled for the atlas
+func (a *Atlas) WriteHitMasks(outputDir string) error {
+	if !a.HitMask {
+		return nil
+	}
+	threshold := a.HitThreshold
+	if threshold == 0 {
+		threshold = DEFAULT_HIT_THRESHOLD
+	}
+	b := &bytes.Buffer{}
+	le := func(values ...interface{}) {
+		for _, v := range values {
+			binary.Write(b, binary.LittleEndian, v)
+		}
+	}
+	b.WriteString(hitMaskMagic)
+	le(uint16(hitMaskVersion), uint32(len(a.Files)))
+	for _, file := range a.Files {
+		im, err := file.decode()
+		if err != nil {
+			return err
+		}
+		m := hitMaskImage(im, threshold)
+		le(uint16(len(file.Name)))
+		b.WriteString(file.Name)
+		le(uint32(m.Width), uint32(m.Height), m.Bits)
+	}
+	return ioutil.WriteFile(path.Join(outputDir, a.HitMaskName()), b.Bytes(), 0644)
+}
+
+// Reads the hit masks written by WriteHitMasks
+func ReadHitMasks(data []byte) ([]*HitMask, error) {
+	r := &byteReader{data: data, order: binary.LittleEndian}
+	if string(r.bytes(4)) != hitMaskMagic {
+		return nil, errors.New("Not a hit mask file")
+	}
+	if version := r.u16(); version != hitMaskVersion {
+		return nil, errors.New(fmt.Sprintf("Unsupported hit mask version %d", version))
+	}
+	count := int(r.u32())
+	masks := make([]*HitMask, 0)
+	for i := 0; i < count && r.err == nil; i++ {
+		m := &HitMask{Name: string(r.bytes(int(r.u16())))}
+		m.Width, m.Height = int(r.u32()), int(r.u32())
+		m.Bits = r.bytes((m.Width + 7) / 8 * m.Height)
+		masks = append(masks, m)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return masks, nil
+}
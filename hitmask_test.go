@@ -0,0 +1,100 @@
+package atlas
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+// Builds a 10x3 image with alpha rising by 25 each column from the left
+func testHitImage() *image.NRGBA {
+	im := image.NewNRGBA(image.Rect(0, 0, 10, 3))
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 10; x++ {
+			im.SetNRGBA(x, y, color.NRGBA{255, 0, 0, uint8(x * 25)})
+		}
+	}
+	return im
+}
+
+func TestHitMaskImage(t *testing.T) {
+	m := hitMaskImage(testHitImage(), 100)
+	if m.Width != 10 || m.Height != 3 || len(m.Bits) != 6 {
+		t.Fatalf("Unexpected mask size: %dx%d with %d bytes", m.Width, m.Height, len(m.Bits))
+	}
+	// Columns 4 to 9 have an alpha of at least 100
+	for y, want := 0, []byte{0x0f, 0xc0}; y < 3; y++ {
+		if got := m.Bits[y*2 : y*2+2]; got[0] != want[0] || got[1] != want[1] {
+			t.Errorf("Unexpected bits in row %d: want %x, got %x", y, want, got)
+		}
+	}
+	if m.Hit(3, 1) || !m.Hit(4, 1) || !m.Hit(9, 2) || m.Hit(10, 2) || m.Hit(-1, 0) {
+		t.Errorf("Unexpected hits for %x", m.Bits)
+	}
+}
+
+func TestGenerateHitMasks(t *testing.T) {
+	dir := t.TempDir()
+	wall := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	wall.SetNRGBA(1, 2, color.NRGBA{0, 0, 0, 255})
+	files := []string{writeTestPNG(t, dir, "turret.png", testHitImage()), writeTestPNG(t, dir, "wall.png", wall)}
+
+	res, outputDir, data := generateJSON(t, files, &GenerateParams{
+		Name:         "test-hit",
+		Descriptor:   DESC_JSON_HASH,
+		HitMask:      true,
+		HitThreshold: 200,
+		Padding:      2,
+	})
+	atlas := res.Atlases[0]
+	var desc struct {
+		Meta struct{ HitMasks string }
+	}
+	parseDescriptor(t, data, &desc)
+	if desc.Meta.HitMasks != "test-hit-1.hitmask" {
+		t.Errorf("Unexpected hit mask file in descriptor: %q", desc.Meta.HitMasks)
+	}
+
+	data, err := ioutil.ReadFile(path.Join(outputDir, desc.Meta.HitMasks))
+	if err != nil {
+		t.Fatalf("Hit masks were not written: %s", err.Error())
+	}
+	masks, err := ReadHitMasks(data)
+	if err != nil {
+		t.Fatalf("Unable to read hit masks: %s", err.Error())
+	}
+	if len(masks) != len(atlas.Files) {
+		t.Fatalf("Unexpected number of hit masks: want %d, got %d", len(atlas.Files), len(masks))
+	}
+	for i, m := range masks {
+		if m.Name != atlas.Files[i].Name {
+			t.Errorf("Unexpected name of mask %d: want %s, got %s", i, atlas.Files[i].Name, m.Name)
+		}
+		frame := atlas.Files[i].Frame()
+		if m.Width != frame.Dx() || m.Height != frame.Dy() {
+			t.Errorf("Unexpected size of %s: want %v, got %dx%d", m.Name, frame.Size(), m.Width, m.Height)
+		}
+		want := map[string]func(x, y int) bool{
+			files[0]: func(x, y int) bool { return x >= 8 },
+			files[1]: func(x, y int) bool { return x == 1 && y == 2 },
+		}[m.Name]
+		for y := 0; y < m.Height; y++ {
+			for x := 0; x < m.Width; x++ {
+				if m.Hit(x, y) != want(x, y) {
+					t.Errorf("Unexpected hit of %s at %d,%d: %v", m.Name, x, y, m.Hit(x, y))
+				}
+			}
+		}
+	}
+
+	if _, err := ReadHitMasks(data[:len(data)-1]); err == nil {
+		t.Errorf("Expected an error reading truncated hit masks")
+	}
+
+	// Distance fields replace the pixels the masks are built from
+	if _, err := Generate(files, outputDir, &GenerateParams{HitMask: true, DistanceField: DF_SDF}); err == nil {
+		t.Errorf("Expected an error for hit masks of distance fields")
+	}
+}
@@ -0,0 +1,100 @@
+package atlas
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// The identifier at the start of every KTX 1 file, see
+// https://registry.khronos.org/KTX/specs/1.0/ktxspec.v1.html
+var ktxIdentifier = []byte{0xAB, 0x4B, 0x54, 0x58, 0x20, 0x31, 0x31, 0xBB, 0x0D, 0x0A, 0x1A, 0x0A}
+
+// OpenGL enums used to describe the pixel formats
+const (
+	glUnsignedByte        = 0x1401
+	glUnsignedShort4444   = 0x8033
+	glUnsignedShort5551   = 0x8034
+	glUnsignedShort565    = 0x8363
+	glAlpha               = 0x1906
+	glRGB                 = 0x1907
+	glRGBA                = 0x1908
+	glLuminance           = 0x1909
+	glAlpha8              = 0x803C
+	glLuminance8          = 0x8040
+	glRGB8                = 0x8051
+	glRGBA4               = 0x8056
+	glRGB5A1              = 0x8057
+	glRGBA8               = 0x8058
+	glRGB565              = 0x8D62
+	glCompressedRGBADXT1  = 0x83F1
+	glCompressedRGBADXT5  = 0x83F3
+	glETC1RGB8            = 0x8D64
+	glCompressedRGB8ETC2  = 0x9274
+	glCompressedRGBA8ETC2 = 0x9278
+)
+
+// Describes how a pixel format is stored in a KTX file
+type ktxFormat struct {
+	glType, typeSize, format, internalFormat, baseFormat uint32
+}
+
+var ktxFormats = map[PixelFormat]ktxFormat{
+	PIXEL_RGBA8888:  {glUnsignedByte, 1, glRGBA, glRGBA8, glRGBA},
+	PIXEL_RGB888:    {glUnsignedByte, 1, glRGB, glRGB8, glRGB},
+	PIXEL_RGBA4444:  {glUnsignedShort4444, 2, glRGBA, glRGBA4, glRGBA},
+	PIXEL_RGBA5551:  {glUnsignedShort5551, 2, glRGBA, glRGB5A1, glRGBA},
+	PIXEL_RGB565:    {glUnsignedShort565, 2, glRGB, glRGB565, glRGB},
+	PIXEL_L8:        {glUnsignedByte, 1, glLuminance, glLuminance8, glLuminance},
+	PIXEL_A8:        {glUnsignedByte, 1, glAlpha, glAlpha8, glAlpha},
+	PIXEL_BC1:       {0, 1, 0, glCompressedRGBADXT1, glRGBA},
+	PIXEL_BC3:       {0, 1, 0, glCompressedRGBADXT5, glRGBA},
+	PIXEL_ETC1:      {0, 1, 0, glETC1RGB8, glRGB},
+	PIXEL_ETC2_RGB:  {0, 1, 0, glCompressedRGB8ETC2, glRGB},
+	PIXEL_ETC2_RGBA: {0, 1, 0, glCompressedRGBA8ETC2, glRGBA},
+}
+
+// Encodes a KTX file holding the given mipmap levels of a texture, the
+// first level is the full size texture. Rows of uncompressed levels are
+// padded to 4 bytes as the format requires
+func encodeKTX(format PixelFormat, width, height int, levels [][]byte) ([]byte, error) {
+	kf, ok := ktxFormats[format]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Pixel format %s can not be written to a KTX container", format))
+	}
+
+	b := &bytes.Buffer{}
+	le := func(values ...uint32) {
+		for _, v := range values {
+			binary.Write(b, binary.LittleEndian, v)
+		}
+	}
+	b.Write(ktxIdentifier)
+	le(0x04030201, kf.glType, kf.typeSize, kf.format, kf.internalFormat, kf.baseFormat)
+	le(uint32(width), uint32(height), 0, 0, 1, uint32(len(levels)), 0)
+	for i, level := range levels {
+		if !format.Compressed() {
+			level = padRows(level, rowSize(format, maxInt(width>>uint(i), 1)), 4)
+		}
+		le(uint32(len(level)))
+		b.Write(level)
+		// Each level is padded to 4 bytes
+		b.Write(make([]byte, (4-len(level)%4)%4))
+	}
+	return b.Bytes(), nil
+}
+
+// Pads each row of the data to a multiple of align bytes
+func padRows(data []byte, row, align int) []byte {
+	if row%align == 0 {
+		return data
+	}
+	padded := (row + align - 1) / align * align
+	out := make([]byte, 0, len(data)/row*padded)
+	for i := 0; i+row <= len(data); i += row {
+		out = append(out, data[i:i+row]...)
+		out = append(out, make([]byte, padded-row)...)
+	}
+	return out
+}
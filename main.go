@@ -4,8 +4,13 @@ import (
 	"errors"
 	"fmt"
 	"image"
+	"image/gif"
+	"io"
+	"io/ioutil"
 	"math"
 	"os"
+	"path"
+	"strings"
 )
 
 // Includes parameters that can be passed to the Generate function
@@ -17,12 +22,110 @@ type GenerateParams struct {
 	MaxWidth, MaxHeight int
 	MaxAtlases          int
 	Padding, Gutter     int
+	// Pattern used to detect numbered sequences of files for animations
+	// see SEQUENCE_DEFAULT
+	SequencePattern string
+	// Pattern used to read nine-slice borders from file names, see
+	// NINE_SLICE_DEFAULT. Borders can also come from Android nine-patch
+	// (.9.png) images and from sidecar files, see SIDECAR_SUFFIX
+	NineSlicePattern string
+	// The pivot of files that do not set their own, relative to the
+	// canvas they were taken from so 0.5, 1 is the bottom centre. Files
+	// can set their own pivot in a sidecar file, see SIDECAR_SUFFIX
+	Pivot *Pivot
+	// Sprite sheets that should be cut into individual frames before
+	// packing, keyed by the path of the sheet as given to Generate
+	Grids map[string]*Grid
+	// The scale SVG images are rasterised at relative to their own size,
+	// defaults to 1
+	SVGScale float64
+	// The size to rasterise SVG images at, overrides SVGScale. If only one
+	// is set the other is worked out to keep the aspect ratio
+	SVGWidth, SVGHeight int
+	// Layers of PSD files with names matching this pattern are not packed,
+	// names include the groups the layer is in eg. "buttons/ok"
+	PSDIgnore string
+	// Scaled copies of the atlases to generate, each is packed separately
+	// with the suffix added to its atlas names. Defaults to a single
+	// variant at scale 1 with no suffix. With more than one variant each
+	// needs a different suffix that does not start with a digit
+	Variants []Variant
+	// Fill the colour of transparent pixels from the nearest sprite so
+	// that filtering does not pull black into the edges of sprites. This
+	// is independent of Gutter, which copies the edges of sprites outwards
+	AlphaBleed bool
+	// Write atlas images with their colour multiplied by alpha, for
+	// renderers that use premultiplied blending. Descriptors record this
+	// so loaders do not apply it twice
+	Premultiply bool
+	// Write atlases as indexed colour PNGs with a palette of up to this
+	// many colours (at most MAX_PALETTE_SIZE), 0 writes full colour
+	PaletteSize int
+	// The dithering to use when reducing the colours or pixel format of
+	// atlases
+	Dither Dither
+	// The pixel format to reduce atlas textures to, defaults to
+	// PIXEL_RGBA8888. Can not be used with PaletteSize
+	PixelFormat PixelFormat
+	// How atlas textures are written, defaults to CONTAINER_PNG. Block
+	// compressed pixel formats must be written to CONTAINER_DDS or
+	// CONTAINER_KTX
+	Container Container
+	// Snap sprites to the 4x4 blocks of compressed pixel formats so that
+	// compression does not bleed between neighbouring sprites
+	BlockAlign bool
+	// The number of mipmap levels to generate below the full size atlas,
+	// or MIP_FULL for every level down to 1x1. Levels are written inside
+	// DDS and KTX containers or as separate images otherwise
+	MipLevels int
+	// The filter used to shrink each mipmap level, defaults to MIP_BOX
+	MipFilter MipFilter
+	// The mipmap level down to which sprites are kept apart. Files are
+	// aligned and padded so that each sprite still has its own pixels
+	// with a gap around it at this level
+	MipSeparation int
+	// Write the alpha of each atlas to a second image with the same
+	// layout, leaving the colour image opaque. Defaults to ALPHA_SPLIT_NONE
+	AlphaSplit AlphaSplit
+	// The image format atlases are written in when Container is
+	// CONTAINER_PNG, defaults to a PNGEncoder. Descriptors refer to images
+	// by the extension of the encoder, eg. a WebPEncoder writes .webp
+	Encoder ImageEncoder
+	// Extra images such as normal maps to pack at the same positions as
+	// each source image, written to an atlas per companion. Companion
+	// images passed to Generate are not packed on their own
+	Companions []Companion
+	// Pack files as grayscale masks into the red, green, blue and alpha
+	// channels of the atlases, so up to four masks share the same pixels.
+	// Descriptors give the channel of each file
+	ChannelPack bool
+	// Convert files to distance fields, which keep sharp edges when
+	// scaled up by a shader. Defaults to DF_NONE
+	DistanceField DistanceField
+	// The distance in pixels either side of an edge covered by a
+	// distance field, defaults to DEFAULT_SPREAD. Files grow by this
+	// on every side
+	DistanceSpread int
+	// Build a mesh of triangles covering the opaque pixels of each file,
+	// so transparent areas are not drawn. Defaults to MESH_NONE
+	Mesh MeshMode
+	// The distance in pixels the outline of a mesh may stray from the
+	// opaque pixels to save vertices, defaults to DEFAULT_MESH_TOLERANCE
+	MeshTolerance float64
+	// Write a file of 1-bit masks of the pixels of each file that can be
+	// hit next to each atlas, see Atlas.WriteHitMasks. Can not be used
+	// with a DistanceField
+	HitMask bool
+	// The alpha at or above which pixels are set in hit masks, defaults
+	// to DEFAULT_HIT_THRESHOLD
+	HitThreshold uint8
 }
 
 // Includes details of the result of a texture atlas Generate request
 type GenerateResult struct {
-	Files   []*File
-	Atlases []*Atlas
+	Files      []*File
+	Atlases    []*Atlas
+	Animations []*Animation
 }
 
 // Generates a series of texture atlases using the given files as input
@@ -52,74 +155,360 @@ func Generate(files []string, outputDir string, params *GenerateParams) (res *Ge
 	if params.MaxHeight == 0 {
 		params.MaxHeight = math.MaxInt32
 	}
+	if params.SequencePattern == "" {
+		params.SequencePattern = SEQUENCE_DEFAULT
+	}
+	if params.NineSlicePattern == "" {
+		params.NineSlicePattern = NINE_SLICE_DEFAULT
+	}
+	if len(params.Variants) == 0 {
+		params.Variants = []Variant{{Scale: 1}}
+	}
+	if params.PixelFormat == "" {
+		params.PixelFormat = PIXEL_RGBA8888
+	}
+	if params.Container == "" {
+		params.Container = CONTAINER_PNG
+	}
+	if params.Encoder == nil {
+		params.Encoder = &PNGEncoder{}
+	}
+	if err := checkPixelFormat(params.PixelFormat); err != nil {
+		return nil, err
+	}
+	if err := checkContainer(params.Container, params.PixelFormat); err != nil {
+		return nil, err
+	}
+	if params.MipFilter == "" {
+		params.MipFilter = MIP_BOX
+	}
+	if params.MipFilter != MIP_BOX && params.MipFilter != MIP_LANCZOS {
+		return nil, errors.New(fmt.Sprintf("Unsupported mipmap filter %s", params.MipFilter))
+	}
+	if params.AlphaSplit != ALPHA_SPLIT_NONE && params.AlphaSplit != ALPHA_SPLIT_GRAY && params.AlphaSplit != ALPHA_SPLIT_RGB {
+		return nil, errors.New(fmt.Sprintf("Unsupported alpha split %s", params.AlphaSplit))
+	}
+	if params.DistanceField != DF_NONE && params.DistanceField != DF_SDF && params.DistanceField != DF_MSDF {
+		return nil, errors.New(fmt.Sprintf("Unsupported distance field %s", params.DistanceField))
+	}
+	if params.DistanceSpread == 0 {
+		params.DistanceSpread = DEFAULT_SPREAD
+	}
+	if params.DistanceSpread < 0 {
+		return nil, errors.New("DistanceSpread can not be negative")
+	}
+	if params.DistanceField != DF_NONE && (params.AlphaBleed || params.Premultiply || len(params.Companions) > 0) {
+		return nil, errors.New("DistanceField can not be used with AlphaBleed, Premultiply or Companions")
+	}
+	if params.DistanceField != DF_NONE && params.HitMask {
+		// Masks are built from the atlas pixels, which would be the
+		// distance field rather than the alpha of the sprite
+		return nil, errors.New("HitMask can not be used with DistanceField")
+	}
+	if params.DistanceField == DF_MSDF && params.ChannelPack {
+		return nil, errors.New("ChannelPack can only be used with single channel distance fields")
+	}
+	if params.Mesh != MESH_NONE && params.Mesh != MESH_CONVEX && params.Mesh != MESH_CONCAVE {
+		return nil, errors.New(fmt.Sprintf("Unsupported mesh %s", params.Mesh))
+	}
+	if params.HitThreshold == 0 {
+		params.HitThreshold = DEFAULT_HIT_THRESHOLD
+	}
+	if params.MeshTolerance == 0 {
+		params.MeshTolerance = DEFAULT_MESH_TOLERANCE
+	}
+	if params.MeshTolerance < 0 {
+		return nil, errors.New("MeshTolerance can not be negative")
+	}
+	if params.MipSeparation < 0 {
+		return nil, errors.New("MipSeparation can not be negative")
+	}
+	if params.MipSeparation > 0 {
+		// A gap of a pixel between sprites at the separation level
+		// needs half that many pixels of padding on each side
+		params.Padding = maxInt(params.Padding, 1<<uint(params.MipSeparation)/2)
+	}
+	suffixes := make(map[string]bool)
+	for _, c := range params.Companions {
+		if c.Suffix == "" || suffixes[c.Suffix] || (params.AlphaSplit != ALPHA_SPLIT_NONE && c.Suffix == ALPHA_SUFFIX) {
+			return nil, errors.New(fmt.Sprintf("Invalid companion suffix \"%s\"", c.Suffix))
+		}
+		suffixes[c.Suffix] = true
+	}
+	// Variant suffixes follow the atlas number in image names, with any
+	// companion or alpha suffix after them, so each combination must differ
+	names := make(map[string]bool)
+	for _, v := range params.Variants {
+		added := []string{v.Suffix}
+		for _, c := range params.Companions {
+			added = append(added, v.Suffix+c.Suffix)
+		}
+		if params.AlphaSplit != ALPHA_SPLIT_NONE {
+			added = append(added, v.Suffix+ALPHA_SUFFIX)
+		}
+		invalid := (v.Suffix == "" && len(params.Variants) > 1) || (v.Suffix != "" && v.Suffix[0] >= '0' && v.Suffix[0] <= '9')
+		for _, name := range added {
+			invalid = invalid || names[name]
+			names[name] = true
+		}
+		if invalid {
+			return nil, errors.New(fmt.Sprintf("Invalid variant suffix \"%s\"", v.Suffix))
+		}
+	}
+	if params.ChannelPack && (params.AlphaBleed || params.Premultiply || params.AlphaSplit != ALPHA_SPLIT_NONE ||
+		params.PaletteSize > 0 || len(params.Companions) > 0) {
+		return nil, errors.New("ChannelPack can not be used with AlphaBleed, Premultiply, AlphaSplit, PaletteSize or Companions")
+	}
+	if _, ok := params.Encoder.(*PNGEncoder); !ok && params.Container != CONTAINER_PNG {
+		return nil, errors.New(fmt.Sprintf("Encoder can not be used with the %s container", params.Container))
+	}
+	if _, ok := params.Encoder.(*PNGEncoder); params.PaletteSize > 0 && (params.PixelFormat != PIXEL_RGBA8888 || params.Container != CONTAINER_PNG || !ok) {
+		return nil, errors.New("PaletteSize can only be used with RGBA8888 PNG atlases")
+	}
 
 	res = &GenerateResult{}
-	res.Files = make([]*File, len(files))
+	res.Files = make([]*File, 0, len(files))
+	res.Animations = make([]*Animation, 0)
+	res.Atlases = make([]*Atlas, 0)
+
+	sources := make([]*File, 0, len(files))
+	sourceAnimations := make([]*Animation, 0)
+	// Files read along with their own animations, such as Aseprite
+	// frames, are left out of sequence detection
+	animated := make(map[*File]bool)
+	for _, filename := range primaryFiles(files, params.Companions) {
+		loaded, animations, err := readFiles(filename, params)
+		if err == image.ErrFormat {
+			fmt.Printf("Incorrect format for file: %s\n", filename)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if err := nineSliceNames(loaded, params.NineSlicePattern); err != nil {
+			return nil, err
+		}
+		if err := applySidecar(filename, loaded); err != nil {
+			return nil, err
+		}
+		if params.Pivot != nil {
+			for _, file := range loaded {
+				if file.Pivot == nil {
+					file.Pivot = file.canvasPivot(*params.Pivot)
+				}
+			}
+		}
+		if animations != nil {
+			for _, file := range loaded {
+				animated[file] = true
+			}
+		}
+		sources = append(sources, loaded...)
+		sourceAnimations = append(sourceAnimations, animations...)
+	}
+
+	if len(sources) == 0 {
+		fmt.Printf("No files to pack\n")
+		return res, nil
+	}
+	if err := checkNineSlices(sources); err != nil {
+		return nil, err
+	}
+	if err := loadCompanions(sources, params.Companions); err != nil {
+		return nil, err
+	}
 
 	// The amount that will be added to the files width/height
 	// by padding and gutter (we *2 to include both sides ie. top & bottom)
 	border := params.Padding*2 + params.Gutter*2
-	for i, filename := range files {
-		// Open the given file
-		r, err := os.Open(filename)
+	// The size files are rounded up to a multiple of so that they sit on
+	// compression blocks and halve exactly down to the separation level
+	align := 1 << uint(params.MipSeparation)
+	if params.BlockAlign {
+		align *= BLOCK_SIZE
+	}
+	for _, variant := range params.Variants {
+		scaled, err := variantFiles(sources, variant)
 		if err != nil {
 			return nil, err
 		}
-
-		decoded, _, err := image.Decode(r)
-		if err != nil && err != image.ErrFormat {
-			return nil, err
+		if params.DistanceField != DF_NONE {
+			if err := distanceFieldFiles(scaled, params.DistanceField, params.DistanceSpread); err != nil {
+				return nil, err
+			}
 		}
-
-		if err != image.ErrFormat {
-			size := decoded.Bounds().Size()
+		if params.Mesh != MESH_NONE {
+			if err := meshFiles(scaled, params.Mesh, params.MeshTolerance); err != nil {
+				return nil, err
+			}
+		}
+		for _, file := range scaled {
 			// Here we use padding*2 as if there is only one image it will still need
 			// padding on both sides left & right in the atlas
-			if size.X+border > params.MaxWidth ||
-				size.Y+border > params.MaxHeight {
+			if file.Width+border > params.MaxWidth ||
+				file.Height+border > params.MaxHeight {
 				return nil, errors.New(fmt.Sprintf("File %s exceeds maximum size of atlas (%dx%d)",
-					filename, size.X, size.Y))
+					file.Name, file.Width, file.Height))
 			}
 			// Here we only add padding to the width and height once because otherwise
 			// we will end up with double gaps between images
-			res.Files[i] = &File{
-				FileName: filename,
-				Width:    size.X + border,
-				Height:   size.Y + border,
+			file.Width += border
+			file.Height += border
+			// Packers place files next to each other so sizes that are a
+			// multiple of the alignment keep every file aligned
+			file.alignWidth = (align - file.Width%align) % align
+			file.alignHeight = (align - file.Height%align) % align
+			file.Width += file.alignWidth
+			file.Height += file.alignHeight
+		}
+		res.Files = append(res.Files, scaled...)
+
+		animations := variantAnimations(sourceAnimations, sources, scaled)
+		numbered := make([]*File, 0, len(scaled))
+		for i, file := range scaled {
+			if !animated[sources[i]] {
+				numbered = append(numbered, file)
+			}
+		}
+		detected, err := detectAnimations(numbered, params.SequencePattern)
+		if err != nil {
+			return nil, err
+		}
+		animations = append(animations, detected...)
+		res.Animations = append(res.Animations, animations...)
+
+		pending := params.Sorter(scaled)
+		for i := 0; len(pending) > 0; i++ {
+			atlas := &Atlas{
+				Name:          fmt.Sprintf("%s-%d%s", params.Name, (i + 1), variant.Suffix),
+				MaxWidth:      params.MaxWidth,
+				MaxHeight:     params.MaxHeight,
+				Descriptor:    params.Descriptor,
+				Padding:       params.Padding,
+				Gutter:        params.Gutter,
+				Scale:         variant.Scale,
+				AlphaBleed:    params.AlphaBleed,
+				Premultiplied: params.Premultiply,
+				PaletteSize:   params.PaletteSize,
+				Dither:        params.Dither,
+				PixelFormat:   params.PixelFormat,
+				Container:     params.Container,
+				MipLevels:     params.MipLevels,
+				MipFilter:     params.MipFilter,
+				MipSeparation: params.MipSeparation,
+				AlphaSplit:    params.AlphaSplit,
+				Encoder:       params.Encoder,
+				Companions:    params.Companions,
+				ChannelPack:   params.ChannelPack,
+				DistanceField: params.DistanceField,
+				Spread:        params.DistanceSpread,
+				HitMask:       params.HitMask,
+				HitThreshold:  params.HitThreshold,
+			}
+			res.Atlases = append(res.Atlases, atlas)
+			if params.ChannelPack {
+				packChannels(atlas, pending, params.Packer)
+			} else {
+				params.Packer(atlas, pending)
+			}
+			pending = getRemainingFiles(pending)
+			atlas.Animations = atlasAnimations(animations, atlas)
+			fmt.Printf("Writing atlas named %s to %s\n", atlas.Name, outputDir)
+			err = atlas.Write(outputDir)
+			if err != nil {
+				return nil, err
 			}
-		} else {
-			fmt.Printf("Incorrect format for file: %s\n", filename)
 		}
 	}
 
-	if len(res.Files) == 0 {
-		fmt.Printf("No files to pack\n")
-		return res, nil
+	return res, nil
+}
+
+// Reads the given file and returns the files to be packed from it. Most
+// images produce a single file however animated GIFs, sprite sheets and
+// Aseprite files produce one file per frame, and may also describe the
+// animations made from those frames. PSD files produce one file per
+// visible layer, SVG images are rasterised at the size set in the params
+// and nine-patch images have their guides stripped. Returns
+// image.ErrFormat if the file is not a recognised image
+func readFiles(filename string, params *GenerateParams) ([]*File, []*Animation, error) {
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".ase", ".aseprite":
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		return asepriteFiles(filename, data)
+	case ".psd", ".psb":
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		files, err := psdFiles(filename, data, params.PSDIgnore)
+		return files, nil, err
+	case ".svg":
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		im, err := rasterizeSVG(data, params.SVGScale, params.SVGWidth, params.SVGHeight)
+		if err != nil {
+			return nil, nil, errors.New(fmt.Sprintf("Unable to rasterise %s: %s", filename, err.Error()))
+		}
+		return []*File{&File{
+			FileName: filename,
+			Name:     filename,
+			Image:    im,
+			Width:    im.Bounds().Dx(),
+			Height:   im.Bounds().Dy(),
+		}}, nil, nil
 	}
 
-	res.Atlases = make([]*Atlas, 0)
+	r, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
 
-	pending := params.Sorter(res.Files)
-	for i := 0; len(pending) > 0; i++ {
-		atlas := &Atlas{
-			Name:       fmt.Sprintf("%s-%d", params.Name, (i + 1)),
-			MaxWidth:   params.MaxWidth,
-			MaxHeight:  params.MaxHeight,
-			Descriptor: DESC_KIWI,
-			Padding:    params.Padding,
-			Gutter:     params.Gutter,
-		}
-		res.Atlases = append(res.Atlases, atlas)
-		params.Packer(atlas, pending)
-		pending = getRemainingFiles(pending)
-		fmt.Printf("Writing atlas named %s to %s\n", atlas.Name, outputDir)
-		err = atlas.Write(outputDir)
+	decoded, format, err := image.Decode(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if grid, ok := params.Grids[filename]; ok {
+		files, err := sliceGrid(filename, decoded, grid)
+		return files, nil, err
+	}
+
+	if strings.HasSuffix(strings.ToLower(filename), NINE_PATCH_EXT) {
+		file, err := ninePatchFile(filename, decoded)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
+		return []*File{file}, nil, nil
 	}
 
-	return res, nil
+	if format == "gif" {
+		// image.Decode only gives us the first frame so go back and read
+		// the whole animation
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return nil, nil, err
+		}
+		g, err := gif.DecodeAll(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(g.Image) > 1 {
+			return gifFiles(filename, g), nil, nil
+		}
+	}
+
+	size := decoded.Bounds().Size()
+	return []*File{&File{
+		FileName: filename,
+		Name:     filename,
+		Width:    size.X,
+		Height:   size.Y,
+	}}, nil, nil
 }
 
 func getRemainingFiles(files []*File) (remaining []*File) {
@@ -1,6 +1,14 @@
 package atlas
 
-import "testing"
+import (
+	"encoding/json"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
 
 type TestParams struct {
 	Files  []string
@@ -11,6 +19,47 @@ type TestWant struct {
 	NumFiles, NumAtlases int
 }
 
+// Writes the image as a PNG named name in the directory, failing the test
+// if it can not be written. Returns the path of the file
+func writeTestPNG(t *testing.T, dir, name string, im image.Image) string {
+	t.Helper()
+	filename := path.Join(dir, name)
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, im); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// Generates atlases from the files into a new temporary directory, failing
+// the test if that fails or the first atlas has no descriptor. Returns the
+// result, the output directory and the descriptor of the first atlas
+func generateJSON(t *testing.T, files []string, params *GenerateParams) (*GenerateResult, string, []byte) {
+	t.Helper()
+	outputDir := t.TempDir()
+	res, err := Generate(files, outputDir, params)
+	if err != nil {
+		t.Fatalf("Generate threw an error: %s", err.Error())
+	}
+	data, err := ioutil.ReadFile(path.Join(outputDir, res.Atlases[0].Name+".json"))
+	if err != nil {
+		t.Fatalf("Descriptor was not written: %s", err.Error())
+	}
+	return res, outputDir, data
+}
+
+// Parses a descriptor into desc, failing the test if it is not valid JSON
+func parseDescriptor(t *testing.T, data []byte, desc interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(data, desc); err != nil {
+		t.Fatalf("Unable to parse descriptor: %s\n%s", err.Error(), data)
+	}
+}
+
 func TestGenerate(t *testing.T) {
 	OUTPUT_DIR := "./output"
 
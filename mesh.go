@@ -0,0 +1,410 @@
+package atlas
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// Represents the kind of polygon drawn around the opaque pixels of files
+type MeshMode string
+
+const (
+	MESH_NONE MeshMode = ""
+	// A single convex polygon around every opaque pixel
+	MESH_CONVEX MeshMode = "convex"
+	// Follows the outline of each separate area of opaque pixels, holes
+	// are filled in
+	MESH_CONCAVE MeshMode = "concave"
+)
+
+// The distance in pixels the outlines of meshes may stray from the opaque
+// pixels when none is given
+const DEFAULT_MESH_TOLERANCE = 2
+
+// Represents the triangles covering the opaque pixels of an image
+type Mesh struct {
+	// Positions of the vertices in pixels from the top left of the image
+	Vertices []image.Point
+	// Indices into Vertices, three for each triangle
+	Triangles []int
+}
+
+// Builds the mesh of each file. Meshes cover every pixel that is not
+// fully transparent, and their outlines are simplified so that they stray
+// no further than the tolerance from those pixels
+func meshFiles(files []*File, mode MeshMode, tolerance float64) error {
+	for _, file := range files {
+		im, err := file.decode()
+		if err != nil {
+			return err
+		}
+		file.Mesh = meshImage(im, mode, tolerance)
+	}
+	return nil
+}
+
+// Returns the mesh covering the opaque pixels of the image, nil if the
+// image is fully transparent
+func meshImage(im image.Image, mode MeshMode, tolerance float64) *Mesh {
+	b := im.Bounds()
+	// The mask is grown by the tolerance so simplified outlines can cut
+	// into the growth without reaching the pixels, with room around the
+	// image for the growth
+	grow := int(math.Ceil(tolerance))
+	w, h := b.Dx()+grow*2, b.Dy()+grow*2
+	mask := make([]bool, w*h)
+	empty := true
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			if _, _, _, a := im.At(b.Min.X+x, b.Min.Y+y).RGBA(); a > 0 {
+				mask[(y+grow)*w+x+grow] = true
+				empty = false
+			}
+		}
+	}
+	if empty {
+		return nil
+	}
+	mask = dilateMask(mask, w, h, grow)
+
+	loops := maskOutlines(mask, w, h)
+	if mode == MESH_CONVEX {
+		points := make([]vec, 0)
+		for _, loop := range loops {
+			points = append(points, loop...)
+		}
+		loops = [][]vec{convexHull(points)}
+	}
+
+	mesh := &Mesh{}
+	bounds := [4]float64{0, 0, float64(b.Dx()), float64(b.Dy())}
+	for _, loop := range loops {
+		loop = simplifyLoop(loop, tolerance)
+		for i := range loop {
+			loop[i] = loop[i].sub(vec{float64(grow), float64(grow)})
+		}
+		loop = removeCollinear(clipLoop(loop, bounds))
+		if len(loop) < 3 {
+			continue
+		}
+		base := len(mesh.Vertices)
+		for _, p := range loop {
+			mesh.Vertices = append(mesh.Vertices, image.Pt(int(math.Round(p.X)), int(math.Round(p.Y))))
+		}
+		for _, i := range triangulate(loop) {
+			mesh.Triangles = append(mesh.Triangles, base+i)
+		}
+	}
+	return mesh
+}
+
+// Grows the set pixels of the mask by the given number of pixels in every
+// direction, including diagonally
+func dilateMask(mask []bool, w, h, n int) []bool {
+	if n <= 0 {
+		return mask
+	}
+	pass := func(src []bool, step, length, lines, lineStep int) []bool {
+		dst := make([]bool, len(src))
+		for line := 0; line < lines; line++ {
+			start := line * lineStep
+			// The distance to the last set pixel, so each pixel is visited
+			// once in each direction
+			for _, dir := range []int{1, -1} {
+				last := n + 1
+				for i := 0; i < length; i++ {
+					j := i
+					if dir < 0 {
+						j = length - 1 - i
+					}
+					if src[start+j*step] {
+						last = 0
+					} else {
+						last++
+					}
+					if last <= n {
+						dst[start+j*step] = true
+					}
+				}
+			}
+		}
+		return dst
+	}
+	rows := pass(mask, 1, w, h, w)
+	return pass(rows, w, h, w, 1)
+}
+
+// Traces the outer outlines of the set areas of the mask along the edges
+// of the pixels. Outlines go clockwise on screen, holes are left out
+func maskOutlines(mask []bool, w, h int) [][]vec {
+	set := func(x, y int) bool {
+		return x >= 0 && y >= 0 && x < w && y < h && mask[y*w+x]
+	}
+	// Edges of set pixels that border unset ones, keyed by start point,
+	// with the set pixel on the right on screen
+	type edge struct {
+		from, to image.Point
+		used     bool
+	}
+	edges := make(map[image.Point][]*edge)
+	add := func(x0, y0, x1, y1 int) {
+		from := image.Pt(x0, y0)
+		edges[from] = append(edges[from], &edge{from: from, to: image.Pt(x1, y1)})
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if !set(x, y) {
+				continue
+			}
+			if !set(x, y-1) {
+				add(x, y, x+1, y)
+			}
+			if !set(x+1, y) {
+				add(x+1, y, x+1, y+1)
+			}
+			if !set(x, y+1) {
+				add(x+1, y+1, x, y+1)
+			}
+			if !set(x-1, y) {
+				add(x, y+1, x, y)
+			}
+		}
+	}
+
+	// Visit start points in order so the outlines are the same each run
+	starts := make([]image.Point, 0, len(edges))
+	for p := range edges {
+		starts = append(starts, p)
+	}
+	sort.Slice(starts, func(i, j int) bool {
+		return starts[i].Y < starts[j].Y || (starts[i].Y == starts[j].Y && starts[i].X < starts[j].X)
+	})
+
+	loops := make([][]vec, 0)
+	for _, start := range starts {
+		for _, first := range edges[start] {
+			if first.used {
+				continue
+			}
+			loop := make([]vec, 0)
+			for e := first; e != nil && !e.used; {
+				e.used = true
+				loop = append(loop, vec{float64(e.from.X), float64(e.from.Y)})
+				// Where two areas touch at a corner keep turning towards
+				// the set pixels, so each outline keeps to its own area
+				dir := e.to.Sub(e.from)
+				var next *edge
+				best := -2
+				for _, candidate := range edges[e.to] {
+					if candidate.used {
+						continue
+					}
+					out := candidate.to.Sub(candidate.from)
+					if turn := dir.X*out.Y - dir.Y*out.X; turn > best {
+						next, best = candidate, turn
+					}
+				}
+				e = next
+			}
+			if loopArea(loop) > 0 {
+				loops = append(loops, removeCollinear(loop))
+			}
+		}
+	}
+	return loops
+}
+
+// Returns twice the signed area of the loop, positive when it goes
+// clockwise on screen
+func loopArea(loop []vec) float64 {
+	area := 0.0
+	for i, p := range loop {
+		area += p.cross(loop[(i+1)%len(loop)])
+	}
+	return area
+}
+
+// Removes points that lie on the line between their neighbours
+func removeCollinear(loop []vec) []vec {
+	// Remove one point at a time so runs of collinear points keep their
+	// ends
+	for i := 0; i < len(loop) && len(loop) >= 3; {
+		p, prev, next := loop[i], loop[(i+len(loop)-1)%len(loop)], loop[(i+1)%len(loop)]
+		if p == next || math.Abs(p.sub(prev).cross(next.sub(p))) < 1e-9 {
+			// Only the neighbours of the removed point need checking again
+			loop = append(loop[:i:i], loop[i+1:]...)
+			i = maxInt(i-1, 0)
+			continue
+		}
+		i++
+	}
+	return loop
+}
+
+// Returns the convex hull of the points, clockwise on screen
+func convexHull(points []vec) []vec {
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].X < points[j].X || (points[i].X == points[j].X && points[i].Y < points[j].Y)
+	})
+	hull := make([]vec, 0, len(points))
+	// Builds each half of the hull keeping only clockwise turns
+	half := func(points []vec) {
+		start := len(hull)
+		for _, p := range points {
+			for len(hull) >= start+2 && hull[len(hull)-1].sub(hull[len(hull)-2]).cross(p.sub(hull[len(hull)-1])) <= 0 {
+				hull = hull[:len(hull)-1]
+			}
+			hull = append(hull, p)
+		}
+		hull = hull[:len(hull)-1]
+	}
+	half(points)
+	reversed := make([]vec, len(points))
+	for i, p := range points {
+		reversed[len(points)-1-i] = p
+	}
+	half(reversed)
+	return hull
+}
+
+// Simplifies the closed loop with the Douglas-Peucker algorithm, keeping
+// a subset of its points so that no point is dropped that is further than
+// the tolerance from the simplified outline
+func simplifyLoop(loop []vec, tolerance float64) []vec {
+	if len(loop) <= 3 || tolerance <= 0 {
+		return loop
+	}
+	// Split the loop at the point furthest from the first
+	far := 0
+	for i, p := range loop {
+		if p.sub(loop[0]).length() > loop[far].sub(loop[0]).length() {
+			far = i
+		}
+	}
+	keep := make([]bool, len(loop))
+	keep[0], keep[far] = true, true
+	var simplify func(from, to int)
+	simplify = func(from, to int) {
+		a, b := loop[from], loop[to%len(loop)]
+		dir := b.sub(a)
+		worst, index := 0.0, -1
+		for i := from + 1; i < to; i++ {
+			p := loop[i%len(loop)]
+			var d float64
+			if l := dir.length(); l > 0 {
+				d = math.Abs(dir.cross(p.sub(a))) / l
+			} else {
+				d = p.sub(a).length()
+			}
+			if d > worst {
+				worst, index = d, i
+			}
+		}
+		if index >= 0 && worst > tolerance {
+			keep[index%len(loop)] = true
+			simplify(from, index)
+			simplify(index, to)
+		}
+	}
+	simplify(0, far)
+	simplify(far, len(loop))
+
+	res := make([]vec, 0)
+	for i, p := range loop {
+		if keep[i] {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// Clips the loop to the rectangle given as min x, min y, max x, max y
+func clipLoop(loop []vec, bounds [4]float64) []vec {
+	type plane struct {
+		inside func(p vec) bool
+		cross  func(a, b vec) vec
+	}
+	at := func(a, b vec, t float64) vec {
+		return a.lerp(b, t)
+	}
+	planes := []plane{
+		{func(p vec) bool { return p.X >= bounds[0] }, func(a, b vec) vec { return at(a, b, (bounds[0]-a.X)/(b.X-a.X)) }},
+		{func(p vec) bool { return p.Y >= bounds[1] }, func(a, b vec) vec { return at(a, b, (bounds[1]-a.Y)/(b.Y-a.Y)) }},
+		{func(p vec) bool { return p.X <= bounds[2] }, func(a, b vec) vec { return at(a, b, (bounds[2]-a.X)/(b.X-a.X)) }},
+		{func(p vec) bool { return p.Y <= bounds[3] }, func(a, b vec) vec { return at(a, b, (bounds[3]-a.Y)/(b.Y-a.Y)) }},
+	}
+	for _, pl := range planes {
+		if len(loop) == 0 {
+			break
+		}
+		res := make([]vec, 0, len(loop)+4)
+		for i, p := range loop {
+			prev := loop[(i+len(loop)-1)%len(loop)]
+			switch in, prevIn := pl.inside(p), pl.inside(prev); {
+			case in && prevIn:
+				res = append(res, p)
+			case in:
+				res = append(res, pl.cross(prev, p), p)
+			case prevIn:
+				res = append(res, pl.cross(prev, p))
+			}
+		}
+		loop = res
+	}
+	return loop
+}
+
+// Splits a loop that goes clockwise on screen into triangles by clipping
+// ears, returning the indices of the points of each triangle
+func triangulate(loop []vec) []int {
+	index := make([]int, len(loop))
+	for i := range index {
+		index[i] = i
+	}
+	triangles := make([]int, 0, (len(loop)-2)*3)
+	for len(index) > 3 {
+		n := len(index)
+		ear := -1
+		for i := 0; i < n && ear < 0; i++ {
+			a, b, c := loop[index[(i+n-1)%n]], loop[index[i]], loop[index[(i+1)%n]]
+			if b.sub(a).cross(c.sub(b)) <= 0 {
+				continue
+			}
+			ear = i
+			for j := 0; j < n; j++ {
+				p := loop[index[j]]
+				if p == a || p == b || p == c {
+					continue
+				}
+				if inTriangle(p, a, b, c) {
+					ear = -1
+					break
+				}
+			}
+		}
+		if ear < 0 {
+			// Outlines that touch themselves may have no clean ear left,
+			// clip the flattest corner so the loop still finishes
+			ear = 0
+			for i := 1; i < n; i++ {
+				if math.Abs(loop[index[i]].sub(loop[index[(i+n-1)%n]]).cross(loop[index[(i+1)%n]].sub(loop[index[i]]))) <
+					math.Abs(loop[index[ear]].sub(loop[index[(ear+n-1)%n]]).cross(loop[index[(ear+1)%n]].sub(loop[index[ear]]))) {
+					ear = i
+				}
+			}
+		}
+		triangles = append(triangles, index[(ear+n-1)%n], index[ear], index[(ear+1)%n])
+		index = append(index[:ear], index[ear+1:]...)
+	}
+	if len(index) == 3 {
+		triangles = append(triangles, index...)
+	}
+	return triangles
+}
+
+// Returns whether the point is inside or on the edge of the clockwise
+// triangle
+func inTriangle(p, a, b, c vec) bool {
+	return b.sub(a).cross(p.sub(a)) >= 0 && c.sub(b).cross(p.sub(b)) >= 0 && a.sub(c).cross(p.sub(c)) >= 0
+}
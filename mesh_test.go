@@ -0,0 +1,116 @@
+package atlas
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+)
+
+// Builds a mostly transparent 64x64 image with a circle of radius 20 and a
+// separate 4x4 square in the bottom right corner
+func testMeshImage() *image.NRGBA {
+	im := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			dx, dy := float64(x)+0.5-24, float64(y)+0.5-24
+			if math.Sqrt(dx*dx+dy*dy) < 20 || (x >= 60 && y >= 60) {
+				im.SetNRGBA(x, y, color.NRGBA{255, 255, 255, 128})
+			}
+		}
+	}
+	return im
+}
+
+func TestMeshImage(t *testing.T) {
+	im := testMeshImage()
+	for _, mode := range []MeshMode{MESH_CONVEX, MESH_CONCAVE} {
+		mesh := meshImage(im, mode, 2)
+		if mesh == nil || len(mesh.Triangles) == 0 || len(mesh.Triangles)%3 != 0 {
+			t.Fatalf("Unexpected %s mesh: %v", mode, mesh)
+		}
+		area := 0.0
+		triangle := func(i int) (vec, vec, vec) {
+			at := func(j int) vec {
+				v := mesh.Vertices[mesh.Triangles[i+j]]
+				return vec{float64(v.X), float64(v.Y)}
+			}
+			return at(0), at(1), at(2)
+		}
+		for i := 0; i < len(mesh.Triangles); i += 3 {
+			a, b, c := triangle(i)
+			area += b.sub(a).cross(c.sub(a)) / 2
+		}
+		for _, v := range mesh.Vertices {
+			if !v.In(image.Rect(0, 0, 65, 65)) {
+				t.Errorf("Vertex %v of the %s mesh is outside the image", v, mode)
+			}
+		}
+		// Every opaque pixel is covered by a triangle
+		for y := 0; y < 64; y++ {
+			for x := 0; x < 64; x++ {
+				if im.NRGBAAt(x, y).A == 0 {
+					continue
+				}
+				p, covered := vec{float64(x) + 0.5, float64(y) + 0.5}, false
+				for i := 0; i < len(mesh.Triangles) && !covered; i += 3 {
+					a, b, c := triangle(i)
+					covered = inTriangle(p, a, b, c)
+				}
+				if !covered {
+					t.Errorf("Pixel %d,%d is not covered by the %s mesh", x, y, mode)
+				}
+			}
+		}
+		// The circle covers about 1257 pixels, the convex mesh also takes
+		// in the gap to the square
+		if max := map[MeshMode]float64{MESH_CONVEX: 2400, MESH_CONCAVE: 1700}[mode]; area <= 1257 || area > max {
+			t.Errorf("Unexpected area of the %s mesh: %v", mode, area)
+		}
+	}
+
+	if mesh := meshImage(image.NewNRGBA(image.Rect(0, 0, 8, 8)), MESH_CONCAVE, 2); mesh != nil {
+		t.Errorf("Expected no mesh for a transparent image, got %v", mesh)
+	}
+	// A fully opaque image is covered by two triangles
+	opaque := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	draw.Draw(opaque, opaque.Bounds(), image.White, image.ZP, draw.Src)
+	if mesh := meshImage(opaque, MESH_CONCAVE, 2); mesh == nil || len(mesh.Vertices) != 4 || len(mesh.Triangles) != 6 {
+		t.Errorf("Expected two triangles for an opaque image, got %v", mesh)
+	}
+}
+
+func TestGenerateMesh(t *testing.T) {
+	png := writeTestPNG(t, t.TempDir(), "ship.png", testMeshImage())
+	res, _, data := generateJSON(t, []string{png}, &GenerateParams{
+		Name:    "test-mesh",
+		Mesh:    MESH_CONCAVE,
+		Padding: 2,
+	})
+	var desc struct {
+		Cells []struct {
+			Mesh struct {
+				Vertices  [][2]int
+				UVs       [][2]int
+				Triangles []int
+			}
+		}
+	}
+	parseDescriptor(t, data, &desc)
+	mesh := desc.Cells[0].Mesh
+	if len(mesh.Vertices) == 0 || len(mesh.UVs) != len(mesh.Vertices) || len(mesh.Triangles)%3 != 0 {
+		t.Fatalf("Unexpected mesh in descriptor:\n%s", data)
+	}
+	frame := res.Files[0].Frame()
+	for i, v := range mesh.Vertices {
+		if uv := mesh.UVs[i]; uv[0] != v[0]+frame.Min.X || uv[1] != v[1]+frame.Min.Y {
+			t.Errorf("Unexpected UV %d: want %v offset by %v, got %v", i, v, frame.Min, uv)
+		}
+	}
+	for _, i := range mesh.Triangles {
+		if i < 0 || i >= len(mesh.Vertices) {
+			t.Errorf("Triangle index %d out of range", i)
+		}
+	}
+}
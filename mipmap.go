@@ -0,0 +1,210 @@
+package atlas
+
+import (
+	"image"
+	"math"
+)
+
+// Represents the filter used to shrink each mipmap level from the last
+type MipFilter string
+
+const (
+	// Averages each 2x2 square of pixels
+	MIP_BOX MipFilter = "box"
+	// Sharper than box filtering but can ring around hard edges
+	MIP_LANCZOS MipFilter = "lanczos"
+)
+
+// Generates every mipmap level down to 1x1 when used as MipLevels
+const MIP_FULL = -1
+
+// Converts sRGB values to linear light
+var srgbToLinear [256]float64
+
+func init() {
+	for i := range srgbToLinear {
+		v := float64(i) / 255
+		if v <= 0.04045 {
+			srgbToLinear[i] = v / 12.92
+		} else {
+			srgbToLinear[i] = math.Pow((v+0.055)/1.055, 2.4)
+		}
+	}
+}
+
+// Converts a linear light value back to sRGB
+func linearToSRGB(v float64) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v <= 0.0031308 {
+		return clampByte(v * 12.92 * 255)
+	}
+	return clampByte((1.055*math.Pow(v, 1/2.4) - 0.055) * 255)
+}
+
+// An image held as premultiplied colours in linear light, so that
+// filtering averages the light given off by the pixels rather than their
+// gamma encoded values
+type linearImage struct {
+	width, height int
+	pix           []float64
+}
+
+// How the values of an image are treated when shrinking mipmaps
+type mipSpace int
+
+const (
+	// sRGB colour weighted by alpha
+	mipSRGB mipSpace = iota
+	// Data weighted by alpha, such as normal maps
+	mipLinear
+	// Four separate channels of data, such as channel packed masks
+	mipChannels
+)
+
+// Converts the image to linear light, images that hold data rather than
+// sRGB colours are only scaled to 0-1
+func toLinearImage(im *image.NRGBA, space mipSpace) *linearImage {
+	toLinear := func(v uint8) float64 {
+		if space == mipSRGB {
+			return srgbToLinear[v]
+		}
+		return float64(v) / 255
+	}
+	b := im.Bounds()
+	l := &linearImage{b.Dx(), b.Dy(), make([]float64, b.Dx()*b.Dy()*4)}
+	for y := 0; y < l.height; y++ {
+		row := im.Pix[im.PixOffset(b.Min.X, b.Min.Y+y):]
+		for x := 0; x < l.width; x++ {
+			p := row[x*4 : x*4+4]
+			out := l.pix[(y*l.width+x)*4:]
+			a := float64(p[3]) / 255
+			if space == mipChannels {
+				out[0], out[1], out[2], out[3] = toLinear(p[0]), toLinear(p[1]), toLinear(p[2]), a
+				continue
+			}
+			out[0] = toLinear(p[0]) * a
+			out[1] = toLinear(p[1]) * a
+			out[2] = toLinear(p[2]) * a
+			out[3] = a
+		}
+	}
+	return l
+}
+
+func (l *linearImage) toNRGBA(space mipSpace) *image.NRGBA {
+	fromLinear := linearToSRGB
+	if space != mipSRGB {
+		fromLinear = func(v float64) uint8 {
+			return clampByte(v * 255)
+		}
+	}
+	im := image.NewNRGBA(image.Rect(0, 0, l.width, l.height))
+	for i := 0; i < len(im.Pix); i += 4 {
+		p := l.pix[i : i+4]
+		if space == mipChannels {
+			for c := range p {
+				im.Pix[i+c] = fromLinear(p[c])
+			}
+			continue
+		}
+		a := math.Min(p[3], 1)
+		alpha := clampByte(a * 255)
+		if alpha == 0 {
+			continue
+		}
+		im.Pix[i] = fromLinear(math.Min(p[0], a) / a)
+		im.Pix[i+1] = fromLinear(math.Min(p[1], a) / a)
+		im.Pix[i+2] = fromLinear(math.Min(p[2], a) / a)
+		im.Pix[i+3] = alpha
+	}
+	return im
+}
+
+// Returns a copy of the given area of the image
+func (l *linearImage) sub(r image.Rectangle) *linearImage {
+	s := &linearImage{r.Dx(), r.Dy(), make([]float64, r.Dx()*r.Dy()*4)}
+	for y := 0; y < s.height; y++ {
+		start := ((r.Min.Y+y)*l.width + r.Min.X) * 4
+		copy(s.pix[y*s.width*4:(y+1)*s.width*4], l.pix[start:])
+	}
+	return s
+}
+
+// Copies the image into this one with its top left corner at the point
+func (l *linearImage) paste(src *linearImage, at image.Point) {
+	for y := 0; y < src.height; y++ {
+		start := ((at.Y+y)*l.width + at.X) * 4
+		copy(l.pix[start:start+src.width*4], src.pix[y*src.width*4:])
+	}
+}
+
+// Returns the image at half the size, rounding down but never below 1x1
+func downsample(src *linearImage, filter MipFilter) *linearImage {
+	w, h := maxInt(src.width/2, 1), maxInt(src.height/2, 1)
+	if filter == MIP_LANCZOS {
+		return &linearImage{w, h, resampleFloat(src.pix, src.width, src.height, w, h)}
+	}
+	dst := &linearImage{w, h, make([]float64, w*h*4)}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			out := dst.pix[(y*w+x)*4:]
+			// Odd sizes repeat the last row or column
+			for _, sy := range []int{minInt(y*2, src.height-1), minInt(y*2+1, src.height-1)} {
+				for _, sx := range []int{minInt(x*2, src.width-1), minInt(x*2+1, src.width-1)} {
+					p := src.pix[(sy*src.width+sx)*4:]
+					for k := 0; k < 4; k++ {
+						out[k] += p[k] / 4
+					}
+				}
+			}
+		}
+	}
+	return dst
+}
+
+// Returns the number of mipmap levels below the full size image, levels
+// is either a count or MIP_FULL
+func mipCount(levels, width, height int) int {
+	full := 0
+	for size := maxInt(width, height); size > 1; size /= 2 {
+		full++
+	}
+	if levels == MIP_FULL || levels > full {
+		return full
+	}
+	return levels
+}
+
+// Generates the mipmap levels of the atlas image, not including the image
+// itself. Down to the separation level each file is shrunk on its own so
+// that no sprite bleeds into its neighbours, after that the whole image is
+// shrunk at once
+func (a *Atlas) mipmaps(im *image.NRGBA, space mipSpace) []*image.NRGBA {
+	count := mipCount(a.MipLevels, a.Width, a.Height)
+	levels := make([]*image.NRGBA, 0, count)
+	current := toLinearImage(im, space)
+	for level := 1; level <= count; level++ {
+		var next *linearImage
+		if level <= a.MipSeparation {
+			next = &linearImage{
+				maxInt(current.width/2, 1), maxInt(current.height/2, 1),
+				make([]float64, maxInt(current.width/2, 1)*maxInt(current.height/2, 1)*4),
+			}
+			// Files are aligned to the separation level so their areas
+			// halve exactly at every level above it
+			scale := 1 << uint(level-1)
+			for _, file := range a.Files {
+				r := image.Rect(file.X, file.Y, file.X+file.Width, file.Y+file.Height)
+				r = image.Rect(r.Min.X/scale, r.Min.Y/scale, r.Max.X/scale, r.Max.Y/scale)
+				next.paste(downsample(current.sub(r), a.MipFilter), r.Min.Div(2))
+			}
+		} else {
+			next = downsample(current, a.MipFilter)
+		}
+		levels = append(levels, next.toNRGBA(space))
+		current = next
+	}
+	return levels
+}
@@ -0,0 +1,130 @@
+package atlas
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestDownsampleGamma(t *testing.T) {
+	// Averaging black and white in linear light gives a brighter grey than
+	// averaging their sRGB values
+	im := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	im.SetNRGBA(0, 0, color.NRGBA{255, 255, 255, 255})
+	im.SetNRGBA(1, 1, color.NRGBA{255, 255, 255, 255})
+	im.SetNRGBA(1, 0, color.NRGBA{0, 0, 0, 255})
+	im.SetNRGBA(0, 1, color.NRGBA{0, 0, 0, 255})
+
+	for _, filter := range []MipFilter{MIP_BOX, MIP_LANCZOS} {
+		got := downsample(toLinearImage(im, mipSRGB), filter).toNRGBA(mipSRGB).NRGBAAt(0, 0)
+		if want := (color.NRGBA{188, 188, 188, 255}); got != want {
+			t.Errorf("Unexpected %s downsample: want %v, got %v", filter, want, got)
+		}
+	}
+
+	if n := mipCount(MIP_FULL, 64, 16); n != 6 {
+		t.Errorf("Unexpected full mip count: want 6, got %d", n)
+	}
+	if n := mipCount(2, 64, 16); n != 2 {
+		t.Errorf("Unexpected mip count: want 2, got %d", n)
+	}
+}
+
+func TestMipSeparation(t *testing.T) {
+	// Two solid files side by side should not bleed into each other down
+	// to the separation level
+	solid := func(c color.NRGBA) image.Image {
+		im := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+		for i := 0; i < len(im.Pix); i += 4 {
+			copy(im.Pix[i:], []uint8{c.R, c.G, c.B, c.A})
+		}
+		return im
+	}
+	red, blue := color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 255}
+	atlas := &Atlas{Width: 16, Height: 8, MipLevels: MIP_FULL, MipFilter: MIP_LANCZOS, MipSeparation: 3}
+	atlas.AddFile(&File{Image: solid(red), Width: 8, Height: 8}, 0, 0)
+	atlas.AddFile(&File{Image: solid(blue), Width: 8, Height: 8}, 8, 0)
+	im := image.NewNRGBA(image.Rect(0, 0, 16, 8))
+	compositeImage(atlas.Files, (*File).decode, func(file *File, cim image.Image) {
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 8; x++ {
+				im.Set(file.X+x, file.Y+y, cim.At(x, y))
+			}
+		}
+	})
+
+	levels := atlas.mipmaps(im, mipSRGB)
+	if len(levels) != 4 {
+		t.Fatalf("Unexpected number of levels: want 4, got %d", len(levels))
+	}
+	for i, level := range levels[:3] {
+		w := level.Bounds().Dx()
+		if got := level.NRGBAAt(w/2-1, 0); got != red {
+			t.Errorf("Unexpected colour at the edge of level %d: want %v, got %v", i+1, red, got)
+		}
+		if got := level.NRGBAAt(w/2, 0); got != blue {
+			t.Errorf("Unexpected colour at the edge of level %d: want %v, got %v", i+1, blue, got)
+		}
+	}
+}
+
+func TestGenerateMipmaps(t *testing.T) {
+	outputDir := t.TempDir()
+	res, err := Generate([]string{"./fixtures/button.png", "./fixtures/button_hover.png"}, outputDir, &GenerateParams{
+		Name:          "test-mipmaps",
+		MipLevels:     3,
+		MipSeparation: 2,
+	})
+	if err != nil {
+		t.Fatalf("Generate threw an error: %s", err.Error())
+	}
+	atlas := res.Atlases[0]
+	if atlas.Padding != 2 {
+		t.Errorf("Unexpected padding for mip separation: want 2, got %d", atlas.Padding)
+	}
+	for _, file := range atlas.Files {
+		if file.X%4 != 0 || file.Y%4 != 0 || file.Width%4 != 0 || file.Height%4 != 0 {
+			t.Errorf("File %s is not aligned: %d,%d %dx%d", file.Name, file.X, file.Y, file.Width, file.Height)
+		}
+	}
+	names := atlas.MipNames()
+	if len(names) != 3 {
+		t.Fatalf("Unexpected number of mipmap images: want 3, got %d", len(names))
+	}
+	for _, name := range names {
+		if _, err := os.Stat(path.Join(outputDir, name)); err != nil {
+			t.Errorf("Mipmap image %s was not written", name)
+		}
+	}
+}
+
+func TestGenerateMipmapContainer(t *testing.T) {
+	outputDir := t.TempDir()
+	res, err := Generate([]string{"./fixtures/button.png"}, outputDir, &GenerateParams{
+		Name:        "test-mipmaps-ktx",
+		PixelFormat: PIXEL_BC3,
+		Container:   CONTAINER_KTX,
+		BlockAlign:  true,
+		MipLevels:   MIP_FULL,
+	})
+	if err != nil {
+		t.Fatalf("Generate threw an error: %s", err.Error())
+	}
+	atlas := res.Atlases[0]
+	if names := atlas.MipNames(); names != nil {
+		t.Errorf("Expected mipmaps to be written inside the container, got %v", names)
+	}
+	data, err := ioutil.ReadFile(path.Join(outputDir, atlas.ImageName()))
+	if err != nil {
+		t.Fatalf("Texture was not written: %s", err.Error())
+	}
+	// The level count follows the identifier and 11 other header fields
+	want := uint32(mipCount(MIP_FULL, atlas.Width, atlas.Height) + 1)
+	if got := binary.LittleEndian.Uint32(data[len(ktxIdentifier)+44:]); got != want {
+		t.Errorf("Unexpected number of levels: want %d, got %d", want, got)
+	}
+}
@@ -0,0 +1,124 @@
+package atlas
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The default pattern used to read nine-slice borders from file names,
+// matching names such as "panel_9s_8_4_8_4" which has 8 pixel borders on
+// the left and right and 4 pixel borders on the top and bottom
+const NINE_SLICE_DEFAULT = `_9s_(\d+)_(\d+)_(\d+)_(\d+)$`
+
+// The extension of Android nine-patch images, which have a one pixel
+// border of guides marking the stretched area
+const NINE_PATCH_EXT = ".9.png"
+
+// Reads nine-slice borders from the names of the files, removing the
+// borders from the name so "panel_9s_8_8_8_8.png" becomes "panel.png".
+// The pattern is matched against the file name without its extension and
+// must capture the left, top, right and bottom borders in that order
+func nineSliceNames(files []*File, pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	if re.NumSubexp() < 4 {
+		return errors.New(fmt.Sprintf("Nine-slice pattern %s must capture four borders", pattern))
+	}
+	for _, file := range files {
+		ext := path.Ext(file.Name)
+		base := strings.TrimSuffix(file.Name, ext)
+		match := re.FindStringSubmatchIndex(base)
+		if match == nil {
+			continue
+		}
+		borders := make([]int, 4)
+		for i := range borders {
+			if match[i*2+2] < 0 {
+				return errors.New(fmt.Sprintf("Nine-slice pattern %s did not capture every border of %s", pattern, file.Name))
+			}
+			if borders[i], err = strconv.Atoi(base[match[i*2+2]:match[i*2+3]]); err != nil {
+				return errors.New(fmt.Sprintf("Invalid nine-slice border in %s", file.Name))
+			}
+		}
+		file.NineSlice = &NineSlice{borders[0], borders[1], borders[2], borders[3]}
+		file.Name = base[:match[0]] + base[match[1]:] + ext
+	}
+	return nil
+}
+
+// Checks that the nine-slice borders of each file fit within its image
+func checkNineSlices(files []*File) error {
+	for _, file := range files {
+		n := file.NineSlice
+		if n == nil {
+			continue
+		}
+		if n.Left < 0 || n.Top < 0 || n.Right < 0 || n.Bottom < 0 ||
+			n.Left+n.Right > file.Width || n.Top+n.Bottom > file.Height {
+			return errors.New(fmt.Sprintf("Nine-slice borders of %s do not fit its size (%dx%d)",
+				file.Name, file.Width, file.Height))
+		}
+	}
+	return nil
+}
+
+// Reads an Android nine-patch image. The black pixels of the top and left
+// guides mark the area that stretches, the guides on the right and bottom
+// mark the content area and are ignored. The guides are stripped from the
+// image and ".9" from its name
+func ninePatchFile(filename string, im image.Image) (*File, error) {
+	b := im.Bounds()
+	if b.Dx() < 3 || b.Dy() < 3 {
+		return nil, errors.New(fmt.Sprintf("Nine-patch %s is too small", filename))
+	}
+	black := func(x, y int) bool {
+		r, g, bl, a := im.At(x, y).RGBA()
+		return r == 0 && g == 0 && bl == 0 && a == 0xffff
+	}
+	// Returns the first and last guide pixels along a row or column
+	guide := func(n int, at func(i int) bool) (int, int, bool) {
+		first, last := -1, -1
+		for i := 1; i < n-1; i++ {
+			if at(i) {
+				if first < 0 {
+					first = i
+				}
+				last = i
+			}
+		}
+		return first, last, first >= 0
+	}
+	left, right, okX := guide(b.Dx(), func(i int) bool { return black(b.Min.X+i, b.Min.Y) })
+	top, bottom, okY := guide(b.Dy(), func(i int) bool { return black(b.Min.X, b.Min.Y+i) })
+	if !okX || !okY {
+		return nil, errors.New(fmt.Sprintf("Nine-patch %s is missing its stretch guides", filename))
+	}
+
+	// Keep the case of the extension when removing the ".9"
+	ext := path.Ext(filename)
+	name := filename[:len(filename)-len(NINE_PATCH_EXT)] + ext
+
+	inner := image.NewNRGBA(image.Rect(0, 0, b.Dx()-2, b.Dy()-2))
+	draw.Draw(inner, inner.Bounds(), im, b.Min.Add(image.Pt(1, 1)), draw.Src)
+	return &File{
+		FileName: filename,
+		Name:     name,
+		Image:    inner,
+		Width:    inner.Bounds().Dx(),
+		Height:   inner.Bounds().Dy(),
+		NineSlice: &NineSlice{
+			Left:   left - 1,
+			Top:    top - 1,
+			Right:  b.Dx() - 2 - right,
+			Bottom: b.Dy() - 2 - bottom,
+		},
+	}, nil
+}
@@ -0,0 +1,100 @@
+package atlas
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+// Builds a nine-patch image of a red 10x8 image with stretch guides
+// covering columns 3-6 and rows 2-4 of the image, and a content guide on
+// the right which should be ignored
+func testNinePatch() *image.NRGBA {
+	im := image.NewNRGBA(image.Rect(0, 0, 12, 10))
+	for y := 1; y < 9; y++ {
+		for x := 1; x < 11; x++ {
+			im.SetNRGBA(x, y, color.NRGBA{255, 0, 0, 255})
+		}
+	}
+	black := color.NRGBA{0, 0, 0, 255}
+	for x := 4; x <= 7; x++ {
+		im.SetNRGBA(x, 0, black)
+	}
+	for y := 3; y <= 5; y++ {
+		im.SetNRGBA(0, y, black)
+		im.SetNRGBA(11, y-1, black)
+	}
+	return im
+}
+
+func TestNinePatchFile(t *testing.T) {
+	file, err := ninePatchFile("ui/bar.9.PNG", testNinePatch())
+	if err != nil {
+		t.Fatalf("Unable to read nine-patch: %s", err.Error())
+	}
+	if file.Name != "ui/bar.PNG" {
+		t.Errorf("Unexpected name: want ui/bar.PNG, got %s", file.Name)
+	}
+	if file.Width != 10 || file.Height != 8 {
+		t.Errorf("Expected the guides to be stripped, got %dx%d", file.Width, file.Height)
+	}
+	if got := file.Image.At(0, 0); got != (color.NRGBA{255, 0, 0, 255}) {
+		t.Errorf("Unexpected pixel after stripping the guides: %v", got)
+	}
+	want := NineSlice{Left: 3, Top: 2, Right: 3, Bottom: 3}
+	if *file.NineSlice != want {
+		t.Errorf("Unexpected borders: want %v, got %v", want, *file.NineSlice)
+	}
+
+	if _, err := ninePatchFile("bar.9.png", image.NewNRGBA(image.Rect(0, 0, 8, 8))); err == nil {
+		t.Errorf("Expected an error reading a nine-patch without guides")
+	}
+}
+
+func TestGenerateNineSlice(t *testing.T) {
+	dir := t.TempDir()
+	solid := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	files := []string{
+		writeTestPNG(t, dir, "panel_9s_2_3_4_1.png", solid),
+		writeTestPNG(t, dir, "button.png", solid),
+		writeTestPNG(t, dir, "bar.9.png", testNinePatch()),
+	}
+	// The sidecar overrides the borders in the name
+	sidecar := `{"nineSlice": {"left": 5, "top": 6, "right": 7, "bottom": 8}}`
+	if err := ioutil.WriteFile(files[1]+SIDECAR_SUFFIX, []byte(sidecar), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, outputDir, data := generateJSON(t, files, &GenerateParams{Name: "test-nineslice"})
+	var desc struct {
+		Cells []struct {
+			Name    string
+			Borders *NineSlice
+		}
+	}
+	parseDescriptor(t, data, &desc)
+	want := map[string]NineSlice{
+		path.Join(dir, "panel.png"):  {2, 3, 4, 1},
+		path.Join(dir, "button.png"): {5, 6, 7, 8},
+		path.Join(dir, "bar.png"):    {3, 2, 3, 3},
+	}
+	for _, cell := range desc.Cells {
+		if cell.Borders == nil || *cell.Borders != want[cell.Name] {
+			t.Errorf("Unexpected borders for %s: want %v, got %v", cell.Name, want[cell.Name], cell.Borders)
+		}
+		delete(want, cell.Name)
+	}
+	if len(want) != 0 {
+		t.Errorf("Missing cells %v", want)
+	}
+
+	// Borders wider than the image are rejected
+	if err := ioutil.WriteFile(files[1]+SIDECAR_SUFFIX, []byte(`{"nineSlice": {"left": 10, "right": 10}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Generate(files[1:2], outputDir, nil); err == nil {
+		t.Errorf("Expected an error for borders that do not fit")
+	}
+}
@@ -0,0 +1,86 @@
+package atlas
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+func TestCanvasPivot(t *testing.T) {
+	// A 20x20 image taken from 10,20 of a 100x100 canvas
+	file := &File{Width: 20, Height: 20, OffsetX: 10, OffsetY: 20, SourceWidth: 100, SourceHeight: 100}
+	file.Pivot = file.canvasPivot(Pivot{0.5, 0.5})
+	if want := (Pivot{2, 1.5}); *file.Pivot != want {
+		t.Errorf("Unexpected pivot: want %v, got %v", want, *file.Pivot)
+	}
+	if got := file.SourcePivot(); *got != (Pivot{0.5, 0.5}) {
+		t.Errorf("Unexpected source pivot: want %v, got %v", Pivot{0.5, 0.5}, *got)
+	}
+
+	// Images that are not part of a larger canvas are unchanged
+	file = &File{Width: 20, Height: 20}
+	if got := file.canvasPivot(Pivot{0.25, 1}); *got != (Pivot{0.25, 1}) {
+		t.Errorf("Unexpected pivot: want %v, got %v", Pivot{0.25, 1}, *got)
+	}
+}
+
+func TestGeneratePivots(t *testing.T) {
+	dir := t.TempDir()
+	psd := path.Join(dir, "ship.psd")
+	data := testPSD(100, 100, []testPSDLayer{
+		{name: "gun", bounds: image.Rect(10, 20, 30, 40), fill: color.NRGBA{255, 0, 0, 255}},
+	})
+	if err := ioutil.WriteFile(psd, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(psd+SIDECAR_SUFFIX, []byte(`{"pivot": {"x": 0.5, "y": 0.5}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	png := writeTestPNG(t, dir, "engine.png", image.NewNRGBA(image.Rect(0, 0, 8, 8)))
+
+	want := map[string]struct{ pivot, anchor Pivot }{
+		path.Join(dir, "ship/gun"): {Pivot{2, 1.5}, Pivot{0.5, 0.5}},
+		png:                        {Pivot{0.5, 1}, Pivot{0.5, 1}},
+	}
+	for _, format := range []DescriptorFormat{DESC_KIWI, DESC_JSON_HASH} {
+		_, _, data := generateJSON(t, []string{psd, png}, &GenerateParams{
+			Name:       "test-pivots",
+			Descriptor: format,
+			Pivot:      &Pivot{0.5, 1},
+			Padding:    1,
+		})
+
+		got := make(map[string]Pivot)
+		if format == DESC_KIWI {
+			var desc struct {
+				Cells []struct {
+					Name  string
+					Pivot Pivot
+				}
+			}
+			parseDescriptor(t, data, &desc)
+			for _, cell := range desc.Cells {
+				got[cell.Name] = cell.Pivot
+			}
+		} else {
+			var desc struct {
+				Frames map[string]struct{ Anchor Pivot }
+			}
+			parseDescriptor(t, data, &desc)
+			for name, frame := range desc.Frames {
+				got[name] = frame.Anchor
+			}
+		}
+		for name, w := range want {
+			expected := w.pivot
+			if format == DESC_JSON_HASH {
+				expected = w.anchor
+			}
+			if got[name] != expected {
+				t.Errorf("Unexpected %s pivot for %s: want %v, got %v", format, name, expected, got[name])
+			}
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package atlas
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+)
+
+// Represents the layout of the pixels of an atlas texture
+type PixelFormat string
+
+const (
+	PIXEL_RGBA8888 PixelFormat = "RGBA8888"
+	PIXEL_RGB888   PixelFormat = "RGB888"
+	PIXEL_RGBA4444 PixelFormat = "RGBA4444"
+	PIXEL_RGBA5551 PixelFormat = "RGBA5551"
+	PIXEL_RGB565   PixelFormat = "RGB565"
+	// Grayscale, the luminance of the colour without alpha
+	PIXEL_L8 PixelFormat = "L8"
+	// Alpha only
+	PIXEL_A8 PixelFormat = "A8"
+)
+
+// Represents how an atlas texture is written to disk
+type Container string
+
+const (
+	// A PNG image, reduced pixel formats are written as an 8 bit preview
+	CONTAINER_PNG Container = "png"
+	// The pixel data with no header, rows are written top to bottom and
+	// 16 bit pixels are little endian
+	CONTAINER_RAW Container = "raw"
+)
+
+// The number of bits kept for each of the red, green, blue and alpha
+// channels of a pixel format, 0 drops the channel
+var pixelBits = map[PixelFormat][4]uint{
+	PIXEL_RGBA8888: {8, 8, 8, 8},
+	PIXEL_RGB888:   {8, 8, 8, 0},
+	PIXEL_RGBA4444: {4, 4, 4, 4},
+	PIXEL_RGBA5551: {5, 5, 5, 1},
+	PIXEL_RGB565:   {5, 6, 5, 0},
+	PIXEL_L8:       {8, 0, 0, 0},
+	PIXEL_A8:       {0, 0, 0, 8},
+}
+
+// The 4x4 Bayer threshold matrix used for ordered dithering
+var bayer4 = [4][4]int{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// Returns an error if the pixel format is not recognised
+func checkPixelFormat(format PixelFormat) error {
+	if _, ok := pixelBits[format]; !ok && !format.Compressed() {
+		return errors.New(fmt.Sprintf("Unsupported pixel format %s", format))
+	}
+	return nil
+}
+
+// Reduces the colour depth of the image in place to that of the pixel
+// format. Values are expanded back to 8 bits so the image can be used as a
+// preview, dropped colour channels are set to white and a dropped alpha
+// channel is made opaque. L8 images are converted to grayscale first.
+// Transparent pixels are left out of dithering so their hidden colour
+// does not show up as noise or spread to visible pixels, and alpha that is
+// already fully transparent or opaque is not dithered
+func reduceDepth(im *image.NRGBA, format PixelFormat, dither Dither) {
+	bits := pixelBits[format]
+	b := im.Bounds()
+	if format == PIXEL_L8 {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			row := im.Pix[im.PixOffset(b.Min.X, y):]
+			for x := 0; x < b.Dx(); x++ {
+				p := row[x*4 : x*4+4]
+				// Rec. 601 luma weights
+				l := uint8((299*int(p[0]) + 587*int(p[1]) + 114*int(p[2]) + 500) / 1000)
+				p[0], p[1], p[2] = l, l, l
+			}
+		}
+	}
+
+	w := b.Dx()
+	current, next := make([][4]int, w+2), make([][4]int, w+2)
+	for y := 0; y < b.Dy(); y++ {
+		row := im.Pix[im.PixOffset(b.Min.X, b.Min.Y+y):]
+		for x := 0; x < w; x++ {
+			p := row[x*4 : x*4+4]
+			transparent, solid := p[3] == 0, p[3] == 255
+			for k := 0; k < 4; k++ {
+				dithered := dither != DITHER_NONE && !transparent && !(k == 3 && solid)
+				channelBits := bits[k]
+				if format == PIXEL_L8 && k < 3 {
+					// Grayscale keeps 8 bits of luminance in every channel
+					channelBits = 8
+				}
+				if channelBits == 0 {
+					p[k] = 255
+					continue
+				}
+				if channelBits == 8 {
+					continue
+				}
+				levels := 1<<channelBits - 1
+				v := int(p[k]) * 16
+				switch {
+				case !dithered:
+				case dither == DITHER_ORDERED:
+					// Offset by up to half a step either way
+					step := 255 * 16 / levels
+					v += (bayer4[y%4][x%4]*2 - 15) * step / 32
+				case dither == DITHER_FLOYD_STEINBERG:
+					v += current[x+1][k]
+				}
+				v = clampInt(v, 0, 255*16)
+				level := (v*levels + 255*8) / (255 * 16)
+				reduced := (level*255 + levels/2) / levels
+				p[k] = uint8(reduced)
+				if dithered && dither == DITHER_FLOYD_STEINBERG {
+					e := v - reduced*16
+					current[x+2][k] += e * 7 / 16
+					next[x][k] += e * 3 / 16
+					next[x+1][k] += e * 5 / 16
+					next[x+2][k] += e / 16
+				}
+			}
+		}
+		current, next = next, current
+		for i := range next {
+			next[i] = [4]int{}
+		}
+	}
+}
+
+// Packs the pixels of an image that has already been reduced to the pixel
+// format into raw texture data
+func packPixels(im *image.NRGBA, format PixelFormat) []byte {
+	b := im.Bounds()
+	bits := pixelBits[format]
+	out := make([]byte, 0, b.Dx()*b.Dy()*formatSize(format))
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := im.Pix[im.PixOffset(b.Min.X, y):]
+		for x := 0; x < b.Dx(); x++ {
+			p := row[x*4 : x*4+4]
+			switch format {
+			case PIXEL_L8:
+				out = append(out, p[0])
+			case PIXEL_A8:
+				out = append(out, p[3])
+			case PIXEL_RGB888:
+				out = append(out, p[0], p[1], p[2])
+			case PIXEL_RGBA8888:
+				out = append(out, p[0], p[1], p[2], p[3])
+			default:
+				// 16 bit formats are packed with red in the highest bits
+				v, shift := uint16(0), uint(16)
+				for k, n := range bits {
+					if n == 0 {
+						continue
+					}
+					shift -= n
+					v |= uint16((int(p[k])*(1<<n-1)+127)/255) << shift
+				}
+				out = binary.LittleEndian.AppendUint16(out, v)
+			}
+		}
+	}
+	return out
+}
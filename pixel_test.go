@@ -0,0 +1,131 @@
+package atlas
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestReduceDepth(t *testing.T) {
+	c := color.NRGBA{0x37, 0x9a, 0xf2, 0x80}
+	cases := []struct {
+		format PixelFormat
+		want   color.NRGBA
+		raw    []byte
+	}{
+		{PIXEL_RGBA8888, c, []byte{0x37, 0x9a, 0xf2, 0x80}},
+		{PIXEL_RGB888, color.NRGBA{0x37, 0x9a, 0xf2, 0xff}, []byte{0x37, 0x9a, 0xf2}},
+		{PIXEL_RGBA4444, color.NRGBA{0x33, 0x99, 0xee, 0x88}, []byte{0xe8, 0x39}},
+		{PIXEL_RGB565, color.NRGBA{0x3a, 0x9a, 0xef, 0xff}, []byte{0xdd, 0x3c}},
+		{PIXEL_RGBA5551, color.NRGBA{0x3a, 0x9c, 0xef, 0xff}, []byte{0xfb, 0x3c}},
+		{PIXEL_L8, color.NRGBA{0x86, 0x86, 0x86, 0xff}, []byte{0x86}},
+		{PIXEL_A8, color.NRGBA{0xff, 0xff, 0xff, 0x80}, []byte{0x80}},
+	}
+	for _, tc := range cases {
+		im := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+		im.SetNRGBA(0, 0, c)
+		reduceDepth(im, tc.format, DITHER_NONE)
+		if got := im.NRGBAAt(0, 0); got != tc.want {
+			t.Errorf("Unexpected %s colour: want %v, got %v", tc.format, tc.want, got)
+		}
+		raw := packPixels(im, tc.format)
+		if string(raw) != string(tc.raw) {
+			t.Errorf("Unexpected %s data: want %x, got %x", tc.format, tc.raw, raw)
+		}
+	}
+}
+
+func TestReduceDepthDither(t *testing.T) {
+	// A smooth gradient should keep its average brightness when dithered
+	// rather than snapping to the nearest 4 bit level
+	for _, dither := range []Dither{DITHER_ORDERED, DITHER_FLOYD_STEINBERG} {
+		im := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+		for i := 0; i < len(im.Pix); i += 4 {
+			copy(im.Pix[i:], []uint8{0x08, 0x08, 0x08, 0xff})
+		}
+		reduceDepth(im, PIXEL_RGBA4444, dither)
+		sum, levels := 0, make(map[uint8]bool)
+		for i := 0; i < len(im.Pix); i += 4 {
+			sum += int(im.Pix[i])
+			levels[im.Pix[i]] = true
+		}
+		if avg := sum / 256; avg < 6 || avg > 10 {
+			t.Errorf("Unexpected average brightness with %s dithering: want about 8, got %d", dither, avg)
+		}
+		if len(levels) < 2 {
+			t.Errorf("Expected %s dithering to mix levels", dither)
+		}
+	}
+}
+
+func TestReduceDepthTransparent(t *testing.T) {
+	// Half transparent pixels beside opaque and transparent pixels, the
+	// transparent ones either black or hiding a colour as left by a bleed
+	build := func(hidden color.NRGBA) *image.NRGBA {
+		im := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+		for y := 0; y < 16; y++ {
+			for x := 0; x < 16; x++ {
+				switch {
+				case x < 8:
+					im.SetNRGBA(x, y, color.NRGBA{uint8(x*16 + y), 0x50, 0x90, uint8(0x70 + y)})
+				case y < 8:
+					im.SetNRGBA(x, y, color.NRGBA{0x08, 0x08, 0x08, 0xff})
+				default:
+					im.SetNRGBA(x, y, hidden)
+				}
+			}
+		}
+		return im
+	}
+	for _, dither := range []Dither{DITHER_ORDERED, DITHER_FLOYD_STEINBERG} {
+		black, bled := build(color.NRGBA{}), build(color.NRGBA{0x37, 0x9a, 0xf2, 0})
+		reduceDepth(black, PIXEL_RGBA4444, dither)
+		reduceDepth(bled, PIXEL_RGBA4444, dither)
+		for y := 0; y < 16; y++ {
+			for x := 0; x < 16; x++ {
+				c := black.NRGBAAt(x, y)
+				switch {
+				case x >= 8 && y >= 8:
+					if c != (color.NRGBA{}) {
+						t.Errorf("Expected a transparent black pixel at %d,%d with %s dithering, got %v", x, y, dither, c)
+					}
+				case x >= 8 && c.A != 0xff:
+					t.Errorf("Expected an opaque pixel at %d,%d with %s dithering, got alpha %d", x, y, dither, c.A)
+				case c != bled.NRGBAAt(x, y):
+					t.Errorf("Expected the hidden colour not to change %d,%d with %s dithering: want %v, got %v",
+						x, y, dither, c, bled.NRGBAAt(x, y))
+				}
+			}
+		}
+	}
+}
+
+func TestGenerateRaw(t *testing.T) {
+	outputDir := t.TempDir()
+	res, err := Generate([]string{"./fixtures/button.png"}, outputDir, &GenerateParams{
+		Name:        "test-raw",
+		Descriptor:  DESC_JSON_HASH,
+		PixelFormat: PIXEL_RGB565,
+		Container:   CONTAINER_RAW,
+	})
+	if err != nil {
+		t.Fatalf("Generate threw an error: %s", err.Error())
+	}
+	atlas := res.Atlases[0]
+	info, err := os.Stat(path.Join(outputDir, atlas.ImageName()))
+	if err != nil {
+		t.Fatalf("Raw texture was not written: %s", err.Error())
+	}
+	if want := int64(atlas.Width * atlas.Height * 2); info.Size() != want {
+		t.Errorf("Unexpected raw texture size: want %d, got %d", want, info.Size())
+	}
+
+	if _, err := Generate([]string{"./fixtures/button.png"}, outputDir, &GenerateParams{
+		PixelFormat: PIXEL_RGB565,
+		PaletteSize: 16,
+	}); err == nil {
+		t.Errorf("Expected an error combining a palette with a pixel format")
+	}
+}
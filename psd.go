@@ -0,0 +1,467 @@
+package atlas
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+)
+
+// PSD colour modes and section divider types, see
+// https://www.adobe.com/devnet-apps/photoshop/fileformatashtml/
+const (
+	psdModeGrayscale = 1
+	psdModeRGB       = 3
+
+	psdFlagHidden = 2
+
+	psdSectionOpen    = 1
+	psdSectionClosed  = 2
+	psdSectionDivider = 3
+
+	// The largest documents Photoshop allows in PSD and PSB files
+	psdMaxSize = 30000
+	psbMaxSize = 300000
+
+	psdRaw           = 0
+	psdRLE           = 1
+	psdZip           = 2
+	psdZipPrediction = 3
+)
+
+// Additional layer info keys that have 8 byte lengths in PSB files
+var psbLongKeys = map[string]bool{
+	"LMsk": true, "Lr16": true, "Lr32": true, "Layr": true, "Mt16": true, "Mt32": true,
+	"Mtrn": true, "Alph": true, "FMsk": true, "lnk2": true, "FEid": true, "FXid": true, "PxSD": true,
+}
+
+// Represents a single raster layer of a PSD file
+type psdLayer struct {
+	// The name of the layer including the groups it is in, eg. "group/layer"
+	Name string
+	// The position of the layer within the document
+	Bounds image.Rectangle
+	Image  image.Image
+}
+
+// Represents the parts of a PSD file needed to extract its layers
+type psd struct {
+	Width, Height int
+	Layers        []*psdLayer
+}
+
+// A layer record before its pixel data has been read
+type psdRecord struct {
+	name     string
+	bounds   image.Rectangle
+	channels []psdChannel
+	opacity  uint8
+	hidden   bool
+	section  int
+}
+
+type psdChannel struct {
+	id     int
+	length int
+}
+
+// Decodes the visible raster layers from a PSD or PSB file. Layers inside
+// hidden groups are treated as hidden and layers are cropped to the canvas.
+// Only 8 and 16 bit RGB and grayscale documents are supported, blend modes,
+// layer masks, clipping and effects are ignored
+func decodePSD(data []byte) (*psd, error) {
+	r := &byteReader{data: data, order: binary.BigEndian}
+	if string(r.bytes(4)) != "8BPS" {
+		return nil, errors.New("Not a PSD file")
+	}
+	version := r.u16()
+	if version != 1 && version != 2 {
+		return nil, errors.New(fmt.Sprintf("Unsupported PSD version %d", version))
+	}
+	large := version == 2
+	length := func(r *byteReader) int {
+		if large {
+			return int(r.u64())
+		}
+		return int(r.u32())
+	}
+
+	r.skip(6)
+	r.skip(2) // Number of channels in the merged image
+	height, width := int(r.u32()), int(r.u32())
+	depth := int(r.u16())
+	mode := int(r.u16())
+	if r.err != nil {
+		return nil, r.err
+	}
+	if depth != 8 && depth != 16 {
+		return nil, errors.New(fmt.Sprintf("Unsupported PSD bit depth %d", depth))
+	}
+	if mode != psdModeRGB && mode != psdModeGrayscale {
+		return nil, errors.New(fmt.Sprintf("Unsupported PSD colour mode %d", mode))
+	}
+	maxSize := psdMaxSize
+	if large {
+		maxSize = psbMaxSize
+	}
+	if width < 1 || height < 1 || width > maxSize || height > maxSize {
+		return nil, errors.New(fmt.Sprintf("Invalid PSD size %dx%d", width, height))
+	}
+	canvas := image.Rect(0, 0, width, height)
+
+	r.skip(int(r.u32())) // Colour mode data
+	r.skip(int(r.u32())) // Image resources
+	info := r.sub(length(r))
+	if r.err != nil {
+		return nil, r.err
+	}
+	doc := &psd{Width: width, Height: height}
+	if len(info.data) == 0 {
+		return doc, nil
+	}
+	layers := info.sub(length(info))
+	if len(layers.data) == 0 {
+		// 16 and 32 bit documents leave the layer info empty and keep
+		// their layers in a tagged block after the global layer mask
+		info.skip(int(info.u32()))
+		for info.err == nil && info.pos+12 <= len(info.data) {
+			info.skip(4) // Signature
+			key := string(info.bytes(4))
+			var block *byteReader
+			if large && psbLongKeys[key] {
+				block = info.sub(int(info.u64()))
+			} else {
+				block = info.sub(int(info.u32()))
+			}
+			if key == "Lr16" || key == "Lr32" {
+				layers = block
+				break
+			}
+		}
+		if info.err != nil {
+			return nil, info.err
+		}
+	}
+	if len(layers.data) == 0 {
+		return doc, nil
+	}
+	count := layers.i16()
+	if count < 0 {
+		count = -count
+	}
+	// Every record takes at least 34 bytes
+	if count*34 > layers.remaining() {
+		return nil, errors.New(fmt.Sprintf("Invalid layer count %d", count))
+	}
+
+	records := make([]*psdRecord, count)
+	for i := range records {
+		rec := &psdRecord{}
+		top, left, bottom, right := layers.i32(), layers.i32(), layers.i32(), layers.i32()
+		rec.bounds = image.Rect(left, top, right, bottom)
+		rec.channels = make([]psdChannel, layers.u16())
+		for j := range rec.channels {
+			rec.channels[j] = psdChannel{id: layers.i16(), length: length(layers)}
+		}
+		layers.skip(4 + 4) // Blend mode signature and key
+		rec.opacity = layers.u8()
+		layers.skip(1) // Clipping
+		rec.hidden = layers.u8()&psdFlagHidden != 0
+		layers.skip(1)
+
+		extra := layers.sub(int(layers.u32()))
+		extra.skip(int(extra.u32())) // Layer mask data
+		extra.skip(int(extra.u32())) // Blending ranges
+		// The name is a pascal string padded to a multiple of 4 bytes
+		n := int(extra.u8())
+		rec.name = string(extra.bytes(n))
+		extra.skip((4 - (n+1)%4) % 4)
+		for extra.err == nil && extra.pos+12 <= len(extra.data) {
+			extra.skip(4) // Signature
+			key := string(extra.bytes(4))
+			var block *byteReader
+			if large && psbLongKeys[key] {
+				block = extra.sub(int(extra.u64()))
+			} else {
+				block = extra.sub(int(extra.u32()))
+			}
+			switch key {
+			case "luni":
+				// The unicode name replaces the legacy pascal name
+				n := int(block.u32())
+				if n > block.remaining()/2 {
+					return nil, errors.New("Invalid layer name")
+				}
+				units := make([]uint16, n)
+				for k := range units {
+					units[k] = block.u16()
+				}
+				if block.err == nil {
+					rec.name = string(utf16.Decode(units))
+				}
+			case "lsct", "lsdk":
+				rec.section = int(block.u32())
+			}
+		}
+		records[i] = rec
+	}
+	if layers.err != nil {
+		return nil, layers.err
+	}
+	// Layers may hang off the canvas but not by more than its size
+	limit := image.Rect(-width, -height, width*2, height*2)
+	for _, rec := range records {
+		if !rec.bounds.In(limit) {
+			return nil, errors.New(fmt.Sprintf("Layer %s bounds %v are too large for a %dx%d document", rec.name, rec.bounds, width, height))
+		}
+	}
+
+	// Read the pixel data for every layer, this follows the layer records
+	// in the same order
+	images := make([]image.Image, len(records))
+	for i, rec := range records {
+		channels := make(map[int][]byte)
+		for _, ch := range rec.channels {
+			block := layers.sub(ch.length)
+			if ch.id < -1 || rec.bounds.Empty() {
+				// Layer masks have their own bounds and are not needed
+				continue
+			}
+			pixels, err := psdChannelData(block, rec.bounds.Dx(), rec.bounds.Dy(), depth, large)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Unable to read layer %s: %s", rec.name, err.Error()))
+			}
+			channels[ch.id] = pixels
+		}
+		if layers.err != nil {
+			return nil, layers.err
+		}
+		visible := rec.bounds.Intersect(canvas)
+		if !visible.Empty() && len(channels) > 0 && rec.section == 0 {
+			images[i] = psdImage(channels, rec.bounds, visible, mode, rec.opacity)
+		}
+	}
+
+	// Records are stored bottom to top, a group is made up of a divider
+	// record followed by its children and then the group record itself
+	type group struct {
+		name   string
+		hidden bool
+	}
+	groups := make([]group, 0)
+	for i := len(records) - 1; i >= 0; i-- {
+		rec := records[i]
+		hidden := rec.hidden
+		names := make([]string, 0, len(groups)+1)
+		for _, g := range groups {
+			hidden = hidden || g.hidden
+			names = append(names, g.name)
+		}
+		names = append(names, rec.name)
+
+		switch rec.section {
+		case psdSectionOpen, psdSectionClosed:
+			groups = append(groups, group{rec.name, hidden})
+		case psdSectionDivider:
+			if len(groups) > 0 {
+				groups = groups[:len(groups)-1]
+			}
+		default:
+			if images[i] == nil || hidden {
+				continue
+			}
+			doc.Layers = append(doc.Layers, &psdLayer{
+				Name:   strings.Join(names, "/"),
+				Bounds: rec.bounds.Intersect(canvas),
+				Image:  images[i],
+			})
+		}
+	}
+	return doc, nil
+}
+
+// Decodes the pixels of a single channel into 8 bit values
+func psdChannelData(r *byteReader, width, height, depth int, large bool) ([]byte, error) {
+	compression := r.u16()
+	bytesPerPixel := depth / 8
+	rowSize := width * bytesPerPixel
+	size := rowSize * height
+	var raw []byte
+	switch compression {
+	case psdRaw:
+		raw = r.bytes(size)
+	case psdRLE:
+		countSize := 2
+		if large {
+			countSize = 4
+		}
+		// PackBits expands every 2 bytes to at most 128
+		if height*countSize > r.remaining() || size > 64*r.remaining() {
+			return nil, errors.New("RLE channel is too short")
+		}
+		counts := make([]int, height)
+		for i := range counts {
+			if large {
+				counts[i] = int(r.u32())
+			} else {
+				counts[i] = int(r.u16())
+			}
+		}
+		raw = make([]byte, 0, size)
+		for _, count := range counts {
+			row := unpackBits(r.bytes(count))
+			if len(row) < rowSize {
+				return nil, errors.New("RLE row is too short")
+			}
+			raw = append(raw, row[:rowSize]...)
+		}
+	case psdZip, psdZipPrediction:
+		// Deflate expands data by at most 1032 times
+		if size > 1032*r.remaining() {
+			return nil, errors.New("Compressed channel is too short")
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(r.data[r.pos:]))
+		if err != nil {
+			return nil, err
+		}
+		if raw, err = ioutil.ReadAll(io.LimitReader(zr, int64(size))); err != nil {
+			return nil, err
+		}
+		if len(raw) < size {
+			return nil, errors.New("Compressed channel is too short")
+		}
+		if compression == psdZipPrediction {
+			// Each value is stored as the difference from the one before
+			for y := 0; y < height; y++ {
+				row := raw[y*rowSize : (y+1)*rowSize]
+				if bytesPerPixel == 1 {
+					for x := 1; x < width; x++ {
+						row[x] += row[x-1]
+					}
+				} else {
+					for x := 1; x < width; x++ {
+						v := binary.BigEndian.Uint16(row[x*2:]) + binary.BigEndian.Uint16(row[x*2-2:])
+						binary.BigEndian.PutUint16(row[x*2:], v)
+					}
+				}
+			}
+		}
+	default:
+		return nil, errors.New(fmt.Sprintf("Unsupported compression %d", compression))
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if bytesPerPixel == 1 {
+		return raw[:size], nil
+	}
+	// Keep the most significant byte of 16 bit values
+	pixels := make([]byte, width*height)
+	for i := range pixels {
+		pixels[i] = raw[i*2]
+	}
+	return pixels, nil
+}
+
+// Decodes PackBits run length encoded data
+func unpackBits(data []byte) []byte {
+	res := make([]byte, 0, len(data)*2)
+	for i := 0; i < len(data); {
+		n := int(int8(data[i]))
+		i++
+		if n >= 0 {
+			end := i + n + 1
+			if end > len(data) {
+				end = len(data)
+			}
+			res = append(res, data[i:end]...)
+			i = end
+		} else if n != -128 && i < len(data) {
+			for j := 0; j < 1-n; j++ {
+				res = append(res, data[i])
+			}
+			i++
+		}
+	}
+	return res
+}
+
+// Combines the channels of a layer into an image of the visible part of its
+// bounds, missing colour channels are black and a missing alpha channel is
+// opaque
+func psdImage(channels map[int][]byte, bounds, visible image.Rectangle, mode int, opacity uint8) image.Image {
+	im := image.NewNRGBA(image.Rect(0, 0, visible.Dx(), visible.Dy()))
+	at := func(id, i int, def byte) byte {
+		if c, ok := channels[id]; ok && i < len(c) {
+			return c[i]
+		}
+		return def
+	}
+	for y := visible.Min.Y; y < visible.Max.Y; y++ {
+		for x := visible.Min.X; x < visible.Max.X; x++ {
+			i := (y-bounds.Min.Y)*bounds.Dx() + x - bounds.Min.X
+			p := im.Pix[im.PixOffset(x-visible.Min.X, y-visible.Min.Y):]
+			if mode == psdModeGrayscale {
+				v := at(0, i, 0)
+				p[0], p[1], p[2] = v, v, v
+			} else {
+				p[0], p[1], p[2] = at(0, i, 0), at(1, i, 0), at(2, i, 0)
+			}
+			p[3] = uint8(int(at(-1, i, 255)) * int(opacity) / 255)
+		}
+	}
+	return im
+}
+
+// Reads a PSD file into a file per visible layer. Layers are named after
+// the file with the groups and name of the layer appended, eg. "ui.psd"
+// becomes "ui/buttons/ok". Layers whose name (including their groups)
+// matches the ignore pattern are skipped. Each file records where the
+// layer sits within the document
+func psdFiles(filename string, data []byte, ignore string) ([]*File, error) {
+	var ignored *regexp.Regexp
+	if ignore != "" {
+		var err error
+		if ignored, err = regexp.Compile(ignore); err != nil {
+			return nil, err
+		}
+	}
+	doc, err := decodePSD(data)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to read %s: %s", filename, err.Error()))
+	}
+
+	base := strings.TrimSuffix(filename, path.Ext(filename))
+	files := make([]*File, 0, len(doc.Layers))
+	used := make(map[string]int)
+	for _, layer := range doc.Layers {
+		if ignored != nil && ignored.MatchString(layer.Name) {
+			continue
+		}
+		name := base + "/" + layer.Name
+		// Photoshop allows layers to share a name so number any repeats
+		if used[name]++; used[name] > 1 {
+			name = fmt.Sprintf("%s_%d", name, used[name])
+		}
+		files = append(files, &File{
+			FileName:     filename,
+			Name:         name,
+			Image:        layer.Image,
+			Width:        layer.Bounds.Dx(),
+			Height:       layer.Bounds.Dy(),
+			OffsetX:      layer.Bounds.Min.X,
+			OffsetY:      layer.Bounds.Min.Y,
+			SourceWidth:  doc.Width,
+			SourceHeight: doc.Height,
+		})
+	}
+	return files, nil
+}
@@ -0,0 +1,320 @@
+package atlas
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// A layer to write into a test PSD file, channels are stored with the
+// given compression
+type testPSDLayer struct {
+	name        string
+	bounds      image.Rectangle
+	hidden      bool
+	section     uint32
+	fill        color.NRGBA
+	compression uint16
+}
+
+// Builds a PSD file with the given layers, listed bottom to top
+func testPSD(width, height int, layers []testPSDLayer) []byte {
+	return testPSDDepth(width, height, 8, layers)
+}
+
+// Builds a PSD file with the given bit depth, 16 bit files hold their
+// layers in an "Lr16" block as Photoshop writes them
+func testPSDDepth(width, height, depth int, layers []testPSDLayer) []byte {
+	be := func(b *bytes.Buffer, values ...interface{}) {
+		for _, v := range values {
+			binary.Write(b, binary.BigEndian, v)
+		}
+	}
+
+	channelData := func(l testPSDLayer, value byte) []byte {
+		// The width of a row in bytes, 16 bit samples repeat the value in
+		// both bytes
+		w, h := l.bounds.Dx()*depth/8, l.bounds.Dy()
+		b := &bytes.Buffer{}
+		be(b, l.compression)
+		switch l.compression {
+		case psdRaw:
+			b.Write(bytes.Repeat([]byte{value}, w*h))
+		case psdRLE:
+			// Each row is a single run of the value
+			for y := 0; y < h; y++ {
+				be(b, uint16(2))
+			}
+			for y := 0; y < h; y++ {
+				b.Write([]byte{byte(1 - w), value})
+			}
+		case psdZipPrediction:
+			row := make([]byte, w)
+			row[0] = value
+			z := zlib.NewWriter(b)
+			for y := 0; y < h; y++ {
+				z.Write(row)
+			}
+			z.Close()
+		}
+		return b.Bytes()
+	}
+
+	records, pixels := &bytes.Buffer{}, &bytes.Buffer{}
+	be(records, int16(len(layers)))
+	for _, l := range layers {
+		channels := [][]byte{}
+		if !l.bounds.Empty() {
+			channels = [][]byte{
+				channelData(l, l.fill.R),
+				channelData(l, l.fill.G),
+				channelData(l, l.fill.B),
+				channelData(l, l.fill.A),
+			}
+		}
+		be(records, int32(l.bounds.Min.Y), int32(l.bounds.Min.X), int32(l.bounds.Max.Y), int32(l.bounds.Max.X))
+		be(records, uint16(len(channels)))
+		for i, c := range channels {
+			id := int16(i)
+			if i == 3 {
+				id = -1
+			}
+			be(records, id, uint32(len(c)))
+			pixels.Write(c)
+		}
+		flags := uint8(0)
+		if l.hidden {
+			flags = psdFlagHidden
+		}
+		records.WriteString("8BIMnorm")
+		be(records, uint8(255), uint8(0), flags, uint8(0))
+
+		extra := &bytes.Buffer{}
+		be(extra, uint32(0), uint32(0))
+		// Write a legacy name that is replaced by the unicode name
+		extra.Write([]byte{3, 'x', 'x', 'x'})
+		extra.WriteString("8BIMluni")
+		be(extra, uint32(4+len(l.name)*2), uint32(len(l.name)))
+		for _, r := range l.name {
+			be(extra, uint16(r))
+		}
+		if l.section != 0 {
+			extra.WriteString("8BIMlsct")
+			be(extra, uint32(4), l.section)
+		}
+		be(records, uint32(extra.Len()))
+		records.Write(extra.Bytes())
+	}
+	records.Write(pixels.Bytes())
+
+	b := &bytes.Buffer{}
+	b.WriteString("8BPS")
+	be(b, uint16(1), [6]byte{}, uint16(4), uint32(height), uint32(width), uint16(depth), uint16(psdModeRGB))
+	be(b, uint32(0), uint32(0))
+	if depth == 8 {
+		be(b, uint32(records.Len()+4), uint32(records.Len()))
+		b.Write(records.Bytes())
+	} else {
+		// An empty layer info and global layer mask, then the layers
+		for records.Len()%4 != 0 {
+			records.WriteByte(0)
+		}
+		be(b, uint32(4+4+12+records.Len()), uint32(0), uint32(0))
+		b.WriteString("8BIMLr16")
+		be(b, uint32(records.Len()))
+		b.Write(records.Bytes())
+	}
+	return b.Bytes()
+}
+
+func TestPSDFiles(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	green := color.NRGBA{0, 255, 0, 128}
+	blue := color.NRGBA{0, 0, 255, 255}
+
+	data := testPSD(32, 16, []testPSDLayer{
+		{name: "background", bounds: image.Rect(0, 0, 32, 16), fill: blue, compression: psdZipPrediction},
+		{name: "</Layer group>", section: psdSectionDivider},
+		{name: "ok", bounds: image.Rect(2, 3, 6, 5), fill: red, compression: psdRLE},
+		{name: "off", bounds: image.Rect(0, 0, 4, 4), fill: red, compression: psdRaw, hidden: true},
+		{name: "guide", bounds: image.Rect(0, 0, 4, 4), fill: green, compression: psdRaw},
+		{name: "buttons", section: psdSectionOpen},
+		{name: "</Layer group>", section: psdSectionDivider},
+		{name: "secret", bounds: image.Rect(0, 0, 1, 1), fill: red, compression: psdRaw},
+		{name: "hidden", section: psdSectionClosed, hidden: true},
+	})
+
+	type Want struct {
+		name   string
+		bounds image.Rectangle
+		color  color.NRGBA
+	}
+
+	cases := []struct {
+		ignore string
+		want   []Want
+	}{
+		{"", []Want{
+			{"ui/buttons/guide", image.Rect(0, 0, 4, 4), green},
+			{"ui/buttons/ok", image.Rect(2, 3, 6, 5), red},
+			{"ui/background", image.Rect(0, 0, 32, 16), blue},
+		}},
+		{"guide$", []Want{
+			{"ui/buttons/ok", image.Rect(2, 3, 6, 5), red},
+			{"ui/background", image.Rect(0, 0, 32, 16), blue},
+		}},
+	}
+
+	for _, c := range cases {
+		files, err := psdFiles("ui.psd", data, c.ignore)
+		if err != nil {
+			t.Fatalf("psdFiles threw an error: %s", err.Error())
+		}
+		if len(files) != len(c.want) {
+			t.Errorf("Unexpected number of layers: want %d, got %d", len(c.want), len(files))
+			continue
+		}
+		for i, want := range c.want {
+			file := files[i]
+			if file.Name != want.name {
+				t.Errorf("Unexpected layer name: want %s, got %s", want.name, file.Name)
+			}
+			got := image.Rect(file.OffsetX, file.OffsetY, file.OffsetX+file.Width, file.OffsetY+file.Height)
+			if got != want.bounds {
+				t.Errorf("Unexpected bounds for %s: want %v, got %v", want.name, want.bounds, got)
+			}
+			if file.SourceWidth != 32 || file.SourceHeight != 16 {
+				t.Errorf("Unexpected source size for %s: want 32x16, got %dx%d", want.name, file.SourceWidth, file.SourceHeight)
+			}
+			for y := 0; y < file.Height; y++ {
+				for x := 0; x < file.Width; x++ {
+					if px := color.NRGBAModel.Convert(file.Image.At(x, y)); px != want.color {
+						t.Fatalf("Unexpected pixel in %s at %d,%d: want %v, got %v", want.name, x, y, want.color, px)
+					}
+				}
+			}
+		}
+	}
+
+	if _, err := psdFiles("ui.psd", data[:100], ""); err == nil {
+		t.Errorf("Expected an error reading a truncated file")
+	}
+}
+
+func TestPSD16(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	green := color.NRGBA{0, 255, 0, 128}
+	layers := []testPSDLayer{
+		{name: "raw", bounds: image.Rect(0, 0, 4, 4), fill: red, compression: psdRaw},
+		{name: "rle", bounds: image.Rect(2, 3, 6, 5), fill: green, compression: psdRLE},
+		{name: "zip", bounds: image.Rect(8, 0, 12, 2), fill: red, compression: psdZipPrediction},
+	}
+	want, err := decodePSD(testPSD(16, 8, layers))
+	if err != nil {
+		t.Fatalf("Unable to decode 8 bit PSD: %s", err.Error())
+	}
+	got, err := decodePSD(testPSDDepth(16, 8, 16, layers))
+	if err != nil {
+		t.Fatalf("Unable to decode 16 bit PSD: %s", err.Error())
+	}
+	if len(got.Layers) != len(want.Layers) {
+		t.Fatalf("Unexpected number of layers: want %d, got %d", len(want.Layers), len(got.Layers))
+	}
+	for i, layer := range got.Layers {
+		w := want.Layers[i]
+		if layer.Name != w.Name || layer.Bounds != w.Bounds {
+			t.Errorf("Unexpected layer: want %s %v, got %s %v", w.Name, w.Bounds, layer.Name, layer.Bounds)
+			continue
+		}
+		if !sameImage(w.Image, layer.Image) {
+			t.Errorf("Pixels of 16 bit layer %s do not match the 8 bit layer", layer.Name)
+		}
+	}
+}
+
+func TestUnpackBits(t *testing.T) {
+	// The example from Apple's technical note TN1023
+	packed := []byte{0xFE, 0xAA, 0x02, 0x80, 0x00, 0x2A, 0xFD, 0xAA, 0x03, 0x80, 0x00, 0x2A, 0x22, 0xF7, 0xAA}
+	want := []byte{0xAA, 0xAA, 0xAA, 0x80, 0x00, 0x2A, 0xAA, 0xAA, 0xAA, 0xAA, 0x80, 0x00, 0x2A, 0x22,
+		0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA}
+	if got := unpackBits(packed); !bytes.Equal(got, want) {
+		t.Errorf("Unexpected unpacked data: want %x, got %x", want, got)
+	}
+}
+
+func TestPSDClip(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	files, err := psdFiles("ui.psd", testPSD(8, 8, []testPSDLayer{
+		{name: "edge", bounds: image.Rect(-4, -2, 6, 6), fill: red, compression: psdRLE},
+		{name: "outside", bounds: image.Rect(10, 2, 14, 6), fill: red, compression: psdRaw},
+	}), "")
+	if err != nil {
+		t.Fatalf("psdFiles threw an error: %s", err.Error())
+	}
+	if len(files) != 1 {
+		t.Fatalf("Unexpected number of layers: want 1, got %d", len(files))
+	}
+	file := files[0]
+	got := image.Rect(file.OffsetX, file.OffsetY, file.OffsetX+file.Width, file.OffsetY+file.Height)
+	if want := image.Rect(0, 0, 6, 6); got != want || file.Image.Bounds().Size() != want.Size() {
+		t.Errorf("Unexpected bounds for %s: want %v, got %v with a %v image", file.Name, want, got, file.Image.Bounds())
+	}
+
+	// Each pixel holds its index in the layer to check which part is kept
+	bounds, visible := image.Rect(-2, -1, 4, 3), image.Rect(0, 0, 4, 3)
+	values := make([]byte, bounds.Dx()*bounds.Dy())
+	for i := range values {
+		values[i] = byte(i)
+	}
+	im := psdImage(map[int][]byte{0: values}, bounds, visible, psdModeGrayscale, 255)
+	for y := 0; y < visible.Dy(); y++ {
+		for x := 0; x < visible.Dx(); x++ {
+			want := byte((y+1)*bounds.Dx() + x + 2)
+			if px := color.NRGBAModel.Convert(im.At(x, y)).(color.NRGBA); px.R != want || px.A != 255 {
+				t.Errorf("Unexpected pixel at %d,%d: want %d, got %v", x, y, want, px)
+			}
+		}
+	}
+}
+
+func TestPSDDamaged(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	layers := []testPSDLayer{
+		{name: "raw", bounds: image.Rect(0, 0, 4, 4), fill: red, compression: psdRaw},
+		{name: "rle", bounds: image.Rect(2, 3, 6, 5), fill: red, compression: psdRLE},
+		{name: "zip", bounds: image.Rect(8, 0, 12, 2), fill: red, compression: psdZipPrediction},
+	}
+	data := testPSD(16, 8, layers)
+	// Any truncated file is read without panicking
+	for i := range data {
+		decodePSD(data[:i])
+	}
+
+	patch := func(old, new []byte) []byte {
+		i := bytes.Index(data, old)
+		if i < 0 {
+			t.Fatalf("Unable to find %x in the test file", old)
+		}
+		b := append([]byte{}, data...)
+		copy(b[i:], new)
+		return b
+	}
+	be := func(values ...uint32) []byte {
+		b := &bytes.Buffer{}
+		binary.Write(b, binary.BigEndian, values)
+		return b.Bytes()
+	}
+	cases := map[string][]byte{
+		"huge document": patch(be(8, 16), be(1<<30, 16)),
+		"huge bounds":   patch(be(0, 0, 4, 4), be(0, 0, 1<<30, 1<<30)),
+		"huge name":     patch(append([]byte("8BIMluni"), be(10, 3)...), append([]byte("8BIMluni"), be(10, 1<<30)...)),
+	}
+	for name, b := range cases {
+		if _, err := decodePSD(b); err == nil {
+			t.Errorf("Expected an error reading a file with a %s", name)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package atlas
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// Represents the dithering used when reducing the colours of an atlas
+type Dither string
+
+const (
+	DITHER_NONE            Dither = ""
+	DITHER_FLOYD_STEINBERG Dither = "floyd-steinberg"
+	// Ordered dithering with a 4x4 Bayer matrix, this avoids the crawling
+	// patterns error diffusion can show when sprites move
+	DITHER_ORDERED Dither = "ordered"
+)
+
+// The most colours an indexed image can have
+const MAX_PALETTE_SIZE = 256
+
+// A colour in the image along with how many pixels use it
+type paletteEntry struct {
+	colour [4]uint8
+	count  int
+}
+
+// Reduces the image to an indexed image of at most size colours, alpha is
+// treated as a fourth channel so that partly transparent edges keep their
+// own palette entries. Differences in colour count for less the more
+// transparent a pixel is, and fully transparent pixels all share the
+// first palette entry so they do not take up any others
+func quantize(im *image.NRGBA, size int, dither Dither) *image.Paletted {
+	if size > MAX_PALETTE_SIZE {
+		size = MAX_PALETTE_SIZE
+	}
+	if size < 2 {
+		size = 2
+	}
+	return remap(im, medianCut(im, size), dither)
+}
+
+// Picks a palette for the image by repeatedly splitting the box of colours
+// with the widest range at its median until there are enough boxes, each
+// box then contributes its average colour to the palette. The range of the
+// colour channels of a box is scaled by the highest alpha in it, so boxes
+// of faint colours are split last. Fully transparent pixels are given a
+// single entry at the start of the palette
+func medianCut(im *image.NRGBA, size int) color.Palette {
+	counts := make(map[[4]uint8]int)
+	transparent := false
+	b := im.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := im.Pix[im.PixOffset(b.Min.X, y):]
+		for x := 0; x < b.Dx(); x++ {
+			if row[x*4+3] == 0 {
+				transparent = true
+				continue
+			}
+			var c [4]uint8
+			copy(c[:], row[x*4:x*4+4])
+			counts[c]++
+		}
+	}
+	palette := make(color.Palette, 0, size)
+	if transparent {
+		palette = append(palette, color.NRGBA{})
+		size--
+	}
+	if len(counts) == 0 {
+		return palette
+	}
+	entries := make([]paletteEntry, 0, len(counts))
+	for c, n := range counts {
+		entries = append(entries, paletteEntry{c, n})
+	}
+	// Sort so the palette does not depend on map order
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i].colour, entries[j].colour
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return false
+	})
+
+	boxes := [][]paletteEntry{entries}
+	for len(boxes) < size {
+		// Find the box with the widest channel to split next
+		best, channel, widest := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			alpha := 0
+			for _, e := range box {
+				alpha = maxInt(alpha, int(e.colour[3]))
+			}
+			for k := 0; k < 4; k++ {
+				lo, hi := box[0].colour[k], box[0].colour[k]
+				for _, e := range box {
+					if e.colour[k] < lo {
+						lo = e.colour[k]
+					}
+					if e.colour[k] > hi {
+						hi = e.colour[k]
+					}
+				}
+				width := int(hi - lo)
+				if k < 3 {
+					width = width * alpha / 255
+				}
+				if width > widest || best == -1 {
+					best, channel, widest = i, k, width
+				}
+			}
+		}
+		if best == -1 {
+			break
+		}
+		box := boxes[best]
+		sort.SliceStable(box, func(i, j int) bool {
+			return box[i].colour[channel] < box[j].colour[channel]
+		})
+		total := 0
+		for _, e := range box {
+			total += e.count
+		}
+		// Split where half of the pixels are on each side, leaving at
+		// least one colour in each half
+		split, seen := 1, 0
+		for i, e := range box[:len(box)-1] {
+			seen += e.count
+			split = i + 1
+			if seen*2 >= total {
+				break
+			}
+		}
+		boxes[best] = box[:split]
+		boxes = append(boxes, box[split:])
+	}
+
+	for _, box := range boxes {
+		// Colours are averaged weighted by alpha so that faint pixels do
+		// not pull the colour of the entry towards their own
+		var sum [4]int
+		total, weight := 0, 0
+		for _, e := range box {
+			w := int(e.colour[3]) * e.count
+			for k := 0; k < 3; k++ {
+				sum[k] += int(e.colour[k]) * w
+			}
+			sum[3] += int(e.colour[3]) * e.count
+			total += e.count
+			weight += w
+		}
+		var c [4]uint8
+		for k := 0; k < 3; k++ {
+			c[k] = uint8((sum[k] + weight/2) / weight)
+		}
+		c[3] = uint8((sum[3] + total/2) / total)
+		palette = append(palette, color.NRGBA{c[0], c[1], c[2], c[3]})
+	}
+	return palette
+}
+
+// Returns the distance between two colours, with the difference in colour
+// scaled by the alpha of the first so that it matters less the more
+// transparent the colour is
+func colourDistance(c, p [4]int) int {
+	rgb := 0
+	for k := 0; k < 3; k++ {
+		d := c[k] - p[k]
+		rgb += d * d
+	}
+	d := c[3] - p[3]
+	return rgb*c[3]/255 + d*d
+}
+
+// Returns the typical distance on each channel between the opaque colours
+// of the palette and their nearest neighbours, the size of a step when
+// dithering
+func paletteSpacing(colours [][4]int) int {
+	total, n := 0.0, 0
+	for i, c := range colours {
+		if c[3] == 0 {
+			continue
+		}
+		nearest := -1
+		for j, p := range colours {
+			if i == j || p[3] == 0 {
+				continue
+			}
+			d := 0
+			for k := 0; k < 3; k++ {
+				d += (c[k] - p[k]) * (c[k] - p[k])
+			}
+			if nearest == -1 || d < nearest {
+				nearest = d
+			}
+		}
+		if nearest > 0 {
+			total += math.Sqrt(float64(nearest) / 3)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return clampInt(int(math.Round(total/float64(n))), 0, 255)
+}
+
+// Maps every pixel of the image to its nearest palette colour, optionally
+// spreading the error of each pixel onto its neighbours. Only the colour
+// of pixels is dithered, alpha is matched as it is so that edges stay
+// clean and transparent pixels stay transparent
+func remap(im *image.NRGBA, palette color.Palette, dither Dither) *image.Paletted {
+	b := im.Bounds()
+	out := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), palette)
+	colours := make([][4]int, len(palette))
+	for i, c := range palette {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		colours[i] = [4]int{int(n.R), int(n.G), int(n.B), int(n.A)}
+	}
+	cache := make(map[[4]int]uint8)
+	nearest := func(c [4]int) uint8 {
+		if i, ok := cache[c]; ok {
+			return i
+		}
+		best, bestDist := 0, -1
+		for i, p := range colours {
+			if dist := colourDistance(c, p); bestDist == -1 || dist < bestDist {
+				best, bestDist = i, dist
+			}
+		}
+		cache[c] = uint8(best)
+		return uint8(best)
+	}
+	// Ordered dithering offsets pixels by up to half a step either way
+	step := 0
+	if dither == DITHER_ORDERED {
+		step = paletteSpacing(colours)
+	}
+
+	// The error carried to the current and next rows when dithering
+	w := b.Dx()
+	current, next := make([][3]int, w+2), make([][3]int, w+2)
+	for y := 0; y < b.Dy(); y++ {
+		row := im.Pix[im.PixOffset(b.Min.X, b.Min.Y+y):]
+		for x := 0; x < w; x++ {
+			c := [4]int{int(row[x*4]), int(row[x*4+1]), int(row[x*4+2]), int(row[x*4+3])}
+			if c[3] == 0 {
+				// Transparent pixels neither take nor pass on any error
+				out.Pix[y*out.Stride+x] = nearest([4]int{})
+				continue
+			}
+			for k := 0; k < 3; k++ {
+				switch dither {
+				case DITHER_FLOYD_STEINBERG:
+					c[k] = clampInt(c[k]+current[x+1][k]/16, 0, 255)
+				case DITHER_ORDERED:
+					c[k] = clampInt(c[k]+(bayer4[y%4][x%4]*2-15)*step/32, 0, 255)
+				}
+			}
+			i := nearest(c)
+			out.Pix[y*out.Stride+x] = i
+			if dither != DITHER_FLOYD_STEINBERG {
+				continue
+			}
+			for k := 0; k < 3; k++ {
+				e := c[k] - colours[i][k]
+				current[x+2][k] += e * 7
+				next[x][k] += e * 3
+				next[x+1][k] += e * 5
+				next[x+2][k] += e
+			}
+		}
+		current, next = next, current
+		for i := range next {
+			next[i] = [3]int{}
+		}
+	}
+	return out
+}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
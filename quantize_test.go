@@ -0,0 +1,118 @@
+package atlas
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestQuantize(t *testing.T) {
+	// An image with fewer colours than the palette keeps them exactly
+	im := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	colours := []color.NRGBA{{255, 0, 0, 255}, {0, 255, 0, 128}, {0, 0, 255, 255}, {0, 0, 0, 0}}
+	for i := 0; i < 16; i++ {
+		im.SetNRGBA(i%4, i/4, colours[i%4])
+	}
+	out := quantize(im, 256, DITHER_NONE)
+	if len(out.Palette) != len(colours) {
+		t.Errorf("Unexpected palette size: want %d, got %d", len(colours), len(out.Palette))
+	}
+	for i := 0; i < 16; i++ {
+		if got := out.At(i%4, i/4); got != colours[i%4] {
+			t.Errorf("Unexpected colour at %d,%d: want %v, got %v", i%4, i/4, colours[i%4], got)
+		}
+	}
+
+	// A gradient is reduced to the requested number of colours
+	gradient := image.NewNRGBA(image.Rect(0, 0, 256, 1))
+	for x := 0; x < 256; x++ {
+		gradient.SetNRGBA(x, 0, color.NRGBA{uint8(x), uint8(x), uint8(x), 255})
+	}
+	out = quantize(gradient, 16, DITHER_NONE)
+	if len(out.Palette) != 16 {
+		t.Errorf("Unexpected palette size: want 16, got %d", len(out.Palette))
+	}
+	for x := 0; x < 256; x++ {
+		if r, _, _, _ := out.At(x, 0).RGBA(); absInt(int(r>>8)-x) > 16 {
+			t.Errorf("Colour at %d is too far from the original: got %d", x, r>>8)
+		}
+	}
+}
+
+func TestQuantizeDither(t *testing.T) {
+	// Mid grey between a black and white palette should dither to roughly
+	// half of each
+	im := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for i := 0; i < len(im.Pix); i += 4 {
+		copy(im.Pix[i:], []uint8{128, 128, 128, 255})
+	}
+	palette := color.Palette{color.NRGBA{0, 0, 0, 255}, color.NRGBA{255, 255, 255, 255}}
+
+	plain := remap(im, palette, DITHER_NONE)
+	dithered := remap(im, palette, DITHER_FLOYD_STEINBERG)
+	white := func(p *image.Paletted) int {
+		n := 0
+		for _, i := range p.Pix {
+			n += int(i)
+		}
+		return n
+	}
+	if n := white(plain); n != 256 {
+		t.Errorf("Expected every pixel to be white without dithering, got %d", n)
+	}
+	if n := white(dithered); n < 112 || n > 144 {
+		t.Errorf("Expected about half of the pixels to be white with dithering, got %d", n)
+	}
+}
+
+func TestQuantizeTransparent(t *testing.T) {
+	// A sprite of many colours and alphas surrounded by transparent
+	// padding, some of which still carries colour
+	im := image.NewNRGBA(image.Rect(0, 0, 48, 48))
+	for y := 8; y < 40; y++ {
+		for x := 8; x < 40; x++ {
+			im.SetNRGBA(x, y, color.NRGBA{uint8(x * 6), uint8(y * 6), uint8(x * y), uint8(128 + (x-8)*4)})
+		}
+	}
+	for x := 0; x < 48; x++ {
+		im.SetNRGBA(x, 0, color.NRGBA{uint8(x * 5), 255, 0, 0})
+	}
+
+	for _, dither := range []Dither{DITHER_NONE, DITHER_FLOYD_STEINBERG, DITHER_ORDERED} {
+		out := quantize(im, 64, dither)
+		if _, _, _, a := out.Palette[0].RGBA(); a != 0 {
+			t.Errorf("Expected the first palette entry to be transparent (%s), got %v", dither, out.Palette[0])
+		}
+		for i, c := range out.Palette[1:] {
+			if _, _, _, a := c.RGBA(); a == 0 {
+				t.Errorf("Unexpected transparent palette entry %d (%s)", i+1, dither)
+			}
+		}
+		for y := 0; y < 48; y++ {
+			for x := 0; x < 48; x++ {
+				transparent := im.NRGBAAt(x, y).A == 0
+				if index := out.ColorIndexAt(x, y); transparent != (index == 0) {
+					t.Errorf("Unexpected index %d at %d,%d (%s), transparent %v", index, x, y, dither, transparent)
+				}
+			}
+		}
+	}
+}
+
+func TestQuantizeOrderedDither(t *testing.T) {
+	// Ordered dithering offsets by half the spacing of the palette so mid
+	// grey between black and white is split evenly, alpha is untouched
+	im := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for i := 0; i < len(im.Pix); i += 4 {
+		copy(im.Pix[i:], []uint8{128, 128, 128, 255})
+	}
+	palette := color.Palette{color.NRGBA{0, 0, 0, 255}, color.NRGBA{255, 255, 255, 255}, color.NRGBA{255, 255, 255, 200}}
+	out := remap(im, palette, DITHER_ORDERED)
+	counts := make([]int, len(palette))
+	for _, i := range out.Pix {
+		counts[i]++
+	}
+	if counts[0] < 112 || counts[0] > 144 || counts[2] != 0 {
+		t.Errorf("Expected about half of the pixels to be black and none faded, got %v", counts)
+	}
+}
@@ -0,0 +1,515 @@
+package atlas
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// A point or direction in 2D space
+type vec struct {
+	X, Y float64
+}
+
+func (v vec) add(o vec) vec       { return vec{v.X + o.X, v.Y + o.Y} }
+func (v vec) sub(o vec) vec       { return vec{v.X - o.X, v.Y - o.Y} }
+func (v vec) mul(s float64) vec   { return vec{v.X * s, v.Y * s} }
+func (v vec) dot(o vec) float64   { return v.X*o.X + v.Y*o.Y }
+func (v vec) cross(o vec) float64 { return v.X*o.Y - v.Y*o.X }
+func (v vec) length() float64     { return math.Hypot(v.X, v.Y) }
+func (v vec) lerp(o vec, t float64) vec {
+	return vec{v.X + (o.X-v.X)*t, v.Y + (o.Y-v.Y)*t}
+}
+
+// Returns the vector turned 90 degrees and scaled to unit length
+func (v vec) normal() vec {
+	l := v.length()
+	if l == 0 {
+		return vec{}
+	}
+	return vec{-v.Y / l, v.X / l}
+}
+
+// An affine transform stored as the matrix values a, b, c, d, e, f
+// which map x, y to a*x + c*y + e, b*x + d*y + f
+type affine [6]float64
+
+var identity = affine{1, 0, 0, 1, 0, 0}
+
+func (m affine) apply(p vec) vec {
+	return vec{m[0]*p.X + m[2]*p.Y + m[4], m[1]*p.X + m[3]*p.Y + m[5]}
+}
+
+// Returns the transform that applies n and then m
+func (m affine) mul(n affine) affine {
+	return affine{
+		m[0]*n[0] + m[2]*n[1],
+		m[1]*n[0] + m[3]*n[1],
+		m[0]*n[2] + m[2]*n[3],
+		m[1]*n[2] + m[3]*n[3],
+		m[0]*n[4] + m[2]*n[5] + m[4],
+		m[1]*n[4] + m[3]*n[5] + m[5],
+	}
+}
+
+func (m affine) invert() affine {
+	det := m[0]*m[3] - m[1]*m[2]
+	if det == 0 {
+		return identity
+	}
+	return affine{
+		m[3] / det,
+		-m[1] / det,
+		-m[2] / det,
+		m[0] / det,
+		(m[2]*m[5] - m[3]*m[4]) / det,
+		(m[1]*m[4] - m[0]*m[5]) / det,
+	}
+}
+
+// Returns the average amount the transform scales lengths by
+func (m affine) scale() float64 {
+	return math.Sqrt(math.Abs(m[0]*m[3] - m[1]*m[2]))
+}
+
+// A series of connected points, the last point joins back to the
+// first if the contour is closed
+type contour struct {
+	points []vec
+	closed bool
+}
+
+func transformContours(contours []contour, m affine) []contour {
+	res := make([]contour, len(contours))
+	for i, c := range contours {
+		points := make([]vec, len(c.points))
+		for j, p := range c.points {
+			points[j] = m.apply(p)
+		}
+		res[i] = contour{points, c.closed}
+	}
+	return res
+}
+
+// Returns the bounding box of all the points in the contours
+func contourBounds(contours []contour) (min, max vec) {
+	min = vec{math.Inf(1), math.Inf(1)}
+	max = vec{math.Inf(-1), math.Inf(-1)}
+	for _, c := range contours {
+		for _, p := range c.points {
+			min = vec{math.Min(min.X, p.X), math.Min(min.Y, p.Y)}
+			max = vec{math.Max(max.X, p.X), math.Max(max.Y, p.Y)}
+		}
+	}
+	return min, max
+}
+
+// Builds a path out of lines and curves, flattening the curves into
+// line segments no further than the tolerance from the true curve
+type pathBuilder struct {
+	contours  []contour
+	current   []vec
+	start     vec
+	pos       vec
+	tolerance float64
+}
+
+func newPathBuilder(tolerance float64) *pathBuilder {
+	return &pathBuilder{tolerance: tolerance}
+}
+
+func (b *pathBuilder) finish(closed bool) {
+	if len(b.current) > 1 {
+		b.contours = append(b.contours, contour{b.current, closed})
+	}
+	b.current = nil
+}
+
+func (b *pathBuilder) moveTo(p vec) {
+	b.finish(false)
+	b.start, b.pos = p, p
+}
+
+func (b *pathBuilder) lineTo(p vec) {
+	if len(b.current) == 0 {
+		b.current = append(b.current, b.pos)
+	}
+	b.current = append(b.current, p)
+	b.pos = p
+}
+
+// Returns the number of segments needed to flatten a curve given the
+// largest second difference of its control points
+func (b *pathBuilder) segments(dd float64) int {
+	n := int(math.Ceil(math.Sqrt(dd / (4 * b.tolerance))))
+	if n < 1 {
+		return 1
+	}
+	if n > 200 {
+		return 200
+	}
+	return n
+}
+
+func (b *pathBuilder) quadTo(c, p vec) {
+	p0 := b.pos
+	n := b.segments(p0.sub(c.mul(2)).add(p).length())
+	for i := 1; i <= n; i++ {
+		t := float64(i) / float64(n)
+		b.lineTo(p0.lerp(c, t).lerp(c.lerp(p, t), t))
+	}
+}
+
+func (b *pathBuilder) cubicTo(c1, c2, p vec) {
+	p0 := b.pos
+	dd := math.Max(p0.sub(c1.mul(2)).add(c2).length(), c1.sub(c2.mul(2)).add(p).length())
+	n := b.segments(dd * 1.5)
+	for i := 1; i <= n; i++ {
+		t := float64(i) / float64(n)
+		a, m, c := p0.lerp(c1, t), c1.lerp(c2, t), c2.lerp(p, t)
+		b.lineTo(a.lerp(m, t).lerp(m.lerp(c, t), t))
+	}
+}
+
+// Adds an elliptical arc using the endpoint parameters from SVG paths, see
+// https://www.w3.org/TR/SVG11/implnote.html#ArcImplementationNotes
+func (b *pathBuilder) arcTo(rx, ry, rotation float64, large, sweep bool, p vec) {
+	p0 := b.pos
+	rx, ry = math.Abs(rx), math.Abs(ry)
+	if rx == 0 || ry == 0 || p0 == p {
+		b.lineTo(p)
+		return
+	}
+	sin, cos := math.Sincos(rotation * math.Pi / 180)
+	// Move the points so the ellipse is axis aligned and centred on 0,0
+	d := p0.sub(p).mul(0.5)
+	x1 := cos*d.X + sin*d.Y
+	y1 := -sin*d.X + cos*d.Y
+	// Scale up the radii if they are too small to reach the end point
+	if l := x1*x1/(rx*rx) + y1*y1/(ry*ry); l > 1 {
+		rx, ry = rx*math.Sqrt(l), ry*math.Sqrt(l)
+	}
+	num := rx*rx*ry*ry - rx*rx*y1*y1 - ry*ry*x1*x1
+	den := rx*rx*y1*y1 + ry*ry*x1*x1
+	k := math.Sqrt(math.Max(0, num/den))
+	if large == sweep {
+		k = -k
+	}
+	cx1, cy1 := k*rx*y1/ry, -k*ry*x1/rx
+	mid := p0.add(p).mul(0.5)
+	center := vec{cos*cx1 - sin*cy1 + mid.X, sin*cx1 + cos*cy1 + mid.Y}
+
+	angle := func(u, v vec) float64 {
+		return math.Atan2(u.cross(v), u.dot(v))
+	}
+	start := angle(vec{1, 0}, vec{(x1 - cx1) / rx, (y1 - cy1) / ry})
+	delta := angle(vec{(x1 - cx1) / rx, (y1 - cy1) / ry}, vec{(-x1 - cx1) / rx, (-y1 - cy1) / ry})
+	if !sweep && delta > 0 {
+		delta -= 2 * math.Pi
+	} else if sweep && delta < 0 {
+		delta += 2 * math.Pi
+	}
+
+	// Use enough segments that the chord error stays within tolerance
+	r := math.Max(rx, ry)
+	step := 2 * math.Acos(math.Max(-1, math.Min(1, 1-b.tolerance/r)))
+	n := int(math.Ceil(math.Abs(delta) / math.Max(step, 0.01)))
+	if n < 1 {
+		n = 1
+	}
+	for i := 1; i < n; i++ {
+		a := start + delta*float64(i)/float64(n)
+		s, c := math.Sincos(a)
+		x, y := rx*c, ry*s
+		b.lineTo(vec{cos*x - sin*y + center.X, sin*x + cos*y + center.Y})
+	}
+	b.lineTo(p)
+}
+
+func (b *pathBuilder) close() {
+	if len(b.current) > 0 {
+		b.finish(true)
+	}
+	b.pos = b.start
+}
+
+// Returns the flattened contours of the path
+func (b *pathBuilder) path() []contour {
+	b.finish(false)
+	return b.contours
+}
+
+// Determines which areas of overlapping contours are inside the shape
+type fillRule int
+
+const (
+	fillNonZero fillRule = iota
+	fillEvenOdd
+)
+
+// The number of samples taken vertically in each pixel
+const rasterSubsamples = 16
+
+type edge struct {
+	p0, p1 vec
+	dir    int
+}
+
+// Calculates the coverage of each pixel of a width x height image by the
+// filled area of the contours, open contours are closed when filling.
+// Coverage is stored row by row with values between 0 and 1
+func rasterize(contours []contour, width, height int, rule fillRule) []float32 {
+	coverage := make([]float32, width*height)
+	edges := make([]edge, 0)
+	for _, c := range contours {
+		for i := range c.points {
+			p0, p1 := c.points[i], c.points[(i+1)%len(c.points)]
+			if p0.Y == p1.Y {
+				continue
+			}
+			if p0.Y < p1.Y {
+				edges = append(edges, edge{p0, p1, 1})
+			} else {
+				edges = append(edges, edge{p1, p0, -1})
+			}
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		return edges[i].p0.Y < edges[j].p0.Y
+	})
+
+	type crossing struct {
+		x   float64
+		dir int
+	}
+	active := make([]edge, 0)
+	crossings := make([]crossing, 0)
+	// Coverage of whole pixels is added as a running sum across the row
+	acc := make([]float32, width+1)
+	weight := float32(1) / rasterSubsamples
+	next := 0
+	for y := 0; y < height; y++ {
+		// Update the edges that cross this row
+		remaining := active[:0]
+		for _, e := range active {
+			if e.p1.Y > float64(y) {
+				remaining = append(remaining, e)
+			}
+		}
+		active = remaining
+		for next < len(edges) && edges[next].p0.Y < float64(y+1) {
+			if edges[next].p1.Y > float64(y) {
+				active = append(active, edges[next])
+			}
+			next++
+		}
+		if len(active) == 0 {
+			continue
+		}
+
+		row := coverage[y*width : (y+1)*width]
+		for i := range acc {
+			acc[i] = 0
+		}
+		for s := 0; s < rasterSubsamples; s++ {
+			sy := float64(y) + (float64(s)+0.5)/rasterSubsamples
+			crossings = crossings[:0]
+			for _, e := range active {
+				if sy < e.p0.Y || sy >= e.p1.Y {
+					continue
+				}
+				x := e.p0.X + (sy-e.p0.Y)*(e.p1.X-e.p0.X)/(e.p1.Y-e.p0.Y)
+				crossings = append(crossings, crossing{x, e.dir})
+			}
+			sort.Slice(crossings, func(i, j int) bool {
+				return crossings[i].x < crossings[j].x
+			})
+			winding := 0
+			for i, c := range crossings {
+				winding += c.dir
+				inside := winding != 0
+				if rule == fillEvenOdd {
+					inside = winding%2 != 0
+				}
+				if !inside || i+1 >= len(crossings) {
+					continue
+				}
+				x0 := math.Max(0, c.x)
+				x1 := math.Min(float64(width), crossings[i+1].x)
+				if x1 <= x0 {
+					continue
+				}
+				i0, i1 := int(x0), int(x1)
+				if i0 == i1 {
+					row[i0] += float32(x1-x0) * weight
+					continue
+				}
+				row[i0] += float32(float64(i0+1)-x0) * weight
+				acc[i0+1] += weight
+				acc[i1] -= weight
+				if i1 < width {
+					row[i1] += float32(x1-float64(i1)) * weight
+				}
+			}
+		}
+		sum := float32(0)
+		for x := 0; x < width; x++ {
+			sum += acc[x]
+			row[x] += sum
+			if row[x] > 1 {
+				row[x] = 1
+			}
+		}
+	}
+	return coverage
+}
+
+// Line caps and joins used when stroking
+type lineCap int
+
+const (
+	capButt lineCap = iota
+	capRound
+	capSquare
+)
+
+type lineJoin int
+
+const (
+	joinMiter lineJoin = iota
+	joinRound
+	joinBevel
+)
+
+// Describes how the outline of a path is drawn
+type strokeStyle struct {
+	width      float64
+	cap        lineCap
+	join       lineJoin
+	miterLimit float64
+}
+
+// Converts the contours into a set of polygons covering the area of their
+// stroke. The polygons overlap and all wind the same way so they should be
+// filled with the non-zero rule
+func strokeContours(contours []contour, style strokeStyle, tolerance float64) []contour {
+	hw := style.width / 2
+	res := make([]contour, 0)
+	add := func(points ...vec) {
+		// Make every polygon wind the same way so they never cancel out
+		area := 0.0
+		for i := range points {
+			area += points[i].cross(points[(i+1)%len(points)])
+		}
+		if area < 0 {
+			for i, j := 0, len(points)-1; i < j; i, j = i+1, j-1 {
+				points[i], points[j] = points[j], points[i]
+			}
+		}
+		res = append(res, contour{points, true})
+	}
+	circle := func(center vec) {
+		step := 2 * math.Acos(math.Max(-1, math.Min(1, 1-tolerance/hw)))
+		n := int(math.Max(8, math.Ceil(2*math.Pi/math.Max(step, 0.01))))
+		points := make([]vec, n)
+		for i := range points {
+			s, c := math.Sincos(2 * math.Pi * float64(i) / float64(n))
+			points[i] = center.add(vec{c * hw, s * hw})
+		}
+		add(points...)
+	}
+
+	for _, c := range contours {
+		// Drop repeated points which have no direction
+		points := make([]vec, 0, len(c.points))
+		for _, p := range c.points {
+			if len(points) == 0 || p != points[len(points)-1] {
+				points = append(points, p)
+			}
+		}
+		if c.closed && len(points) > 1 && points[0] == points[len(points)-1] {
+			points = points[:len(points)-1]
+		}
+		if len(points) == 1 {
+			// A zero length path is only drawn with round or square caps
+			if style.cap == capRound {
+				circle(points[0])
+			} else if style.cap == capSquare {
+				p := points[0]
+				add(p.add(vec{-hw, -hw}), p.add(vec{hw, -hw}), p.add(vec{hw, hw}), p.add(vec{-hw, hw}))
+			}
+			continue
+		}
+
+		n := len(points)
+		segments := n - 1
+		if c.closed {
+			segments = n
+		}
+		for i := 0; i < segments; i++ {
+			p0, p1 := points[i], points[(i+1)%n]
+			normal := p1.sub(p0).normal().mul(hw)
+			if !c.closed && style.cap == capSquare {
+				dir := p1.sub(p0).mul(hw / p1.sub(p0).length())
+				if i == 0 {
+					p0 = p0.sub(dir)
+				}
+				if i == segments-1 {
+					p1 = p1.add(dir)
+				}
+			}
+			add(p0.add(normal), p1.add(normal), p1.sub(normal), p0.sub(normal))
+		}
+
+		// Join each pair of segments that meet at a point
+		for i := 0; i < n; i++ {
+			if !c.closed && (i == 0 || i == n-1) {
+				continue
+			}
+			prev, p, next := points[(i+n-1)%n], points[i], points[(i+1)%n]
+			d0, d1 := p.sub(prev), next.sub(p)
+			turn := d0.cross(d1)
+			if turn == 0 {
+				continue
+			}
+			if style.join == joinRound {
+				circle(p)
+				continue
+			}
+			// The outside of the join is on the opposite side to the turn
+			side := 1.0
+			if turn > 0 {
+				side = -1
+			}
+			n0, n1 := d0.normal().mul(hw*side), d1.normal().mul(hw*side)
+			a, b := p.add(n0), p.add(n1)
+			if style.join == joinMiter {
+				// The miter length relative to the stroke width
+				cos := d0.dot(d1) / (d0.length() * d1.length())
+				ratio := 1 / math.Sqrt(math.Max(1e-12, (1+cos)/2))
+				if ratio <= style.miterLimit {
+					bisector := n0.add(n1).mul(0.5)
+					tip := p.add(bisector.mul(hw * hw / bisector.dot(bisector)))
+					add(p, a, tip, b)
+					continue
+				}
+			}
+			add(p, a, b)
+		}
+
+		if !c.closed && style.cap == capRound {
+			circle(points[0])
+			circle(points[n-1])
+		}
+	}
+	return res
+}
+
+// Fills the coverage into an alpha image
+func coverageImage(coverage []float32, width, height int) *image.Alpha {
+	im := image.NewAlpha(image.Rect(0, 0, width, height))
+	for i, c := range coverage {
+		im.Pix[i] = uint8(c*255 + 0.5)
+	}
+	return im
+}
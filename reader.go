@@ -0,0 +1,71 @@
+package atlas
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Reads values from binary file formats, recording the first out of range
+// read so that callers only need to check for an error once. Reads of single
+// values past the end of the data return zeros and longer reads return nothing,
+// so a length read from a damaged file never causes a large allocation
+type byteReader struct {
+	data  []byte
+	pos   int
+	err   error
+	order binary.ByteOrder
+}
+
+func (r *byteReader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || r.pos+n > len(r.data) {
+		if r.err == nil {
+			r.err = errors.New("Unexpected end of file")
+		}
+		r.pos = len(r.data)
+		if n < 0 || n > 8 {
+			n = 0
+		}
+		return make([]byte, n)
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *byteReader) u8() uint8 {
+	return r.bytes(1)[0]
+}
+
+func (r *byteReader) u16() uint16 {
+	return r.order.Uint16(r.bytes(2))
+}
+
+func (r *byteReader) u32() uint32 {
+	return r.order.Uint32(r.bytes(4))
+}
+
+func (r *byteReader) u64() uint64 {
+	return r.order.Uint64(r.bytes(8))
+}
+
+func (r *byteReader) i16() int {
+	return int(int16(r.u16()))
+}
+
+func (r *byteReader) i32() int {
+	return int(int32(r.u32()))
+}
+
+// Returns the number of bytes left to read
+func (r *byteReader) remaining() int {
+	return len(r.data) - r.pos
+}
+
+func (r *byteReader) skip(n int) {
+	r.bytes(n)
+}
+
+// Returns a reader for the next n bytes, moving this reader past them
+func (r *byteReader) sub(n int) *byteReader {
+	return &byteReader{data: r.bytes(n), order: r.order}
+}
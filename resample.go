@@ -0,0 +1,142 @@
+package atlas
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// The number of lobes of the Lanczos filter used when resampling
+const lanczosLobes = 3
+
+// Lanczos windowed sinc filter
+func lanczos(x float64) float64 {
+	x = math.Abs(x)
+	if x == 0 {
+		return 1
+	}
+	if x >= lanczosLobes {
+		return 0
+	}
+	px := math.Pi * x
+	return lanczosLobes * math.Sin(px) * math.Sin(px/lanczosLobes) / (px * px)
+}
+
+// The weights of the source pixels that make up a single destination pixel
+type contribution struct {
+	start   int
+	weights []float64
+}
+
+// Works out the filter weights for resampling a row of size src to size
+// dst. When shrinking the filter is widened so every source pixel counts
+func contributions(src, dst int) []contribution {
+	scale := float64(dst) / float64(src)
+	support := float64(lanczosLobes)
+	filterScale := 1.0
+	if scale < 1 {
+		support /= scale
+		filterScale = scale
+	}
+	res := make([]contribution, dst)
+	for i := range res {
+		center := (float64(i)+0.5)/scale - 0.5
+		start := int(math.Ceil(center - support))
+		end := int(math.Floor(center + support))
+		if start < 0 {
+			start = 0
+		}
+		if end > src-1 {
+			end = src - 1
+		}
+		weights := make([]float64, end-start+1)
+		total := 0.0
+		for j := range weights {
+			w := lanczos((float64(start+j) - center) * filterScale)
+			weights[j] = w
+			total += w
+		}
+		if total != 0 {
+			for j := range weights {
+				weights[j] /= total
+			}
+		}
+		res[i] = contribution{start, weights}
+	}
+	return res
+}
+
+// Resamples the image to the given size with a Lanczos filter. Filtering
+// is done on premultiplied colours so that fully transparent pixels do not
+// bleed their colour into the edges of the image
+func resample(im image.Image, width, height int) *image.NRGBA {
+	b := im.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), im, b.Min, draw.Src)
+	pix := make([]float64, len(src.Pix))
+	for i, v := range src.Pix {
+		pix[i] = float64(v)
+	}
+	pix = resampleFloat(pix, b.Dx(), b.Dy(), width, height)
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < len(dst.Pix); i += 4 {
+		px := pix[i : i+4]
+		alpha := clampByte(px[3])
+		if alpha == 0 {
+			continue
+		}
+		// The filter can overshoot so colours are clamped to the alpha
+		a := clampFloat(px[3])
+		dst.Pix[i] = uint8(math.Min(clampFloat(px[0]), a)*255/a + 0.5)
+		dst.Pix[i+1] = uint8(math.Min(clampFloat(px[1]), a)*255/a + 0.5)
+		dst.Pix[i+2] = uint8(math.Min(clampFloat(px[2]), a)*255/a + 0.5)
+		dst.Pix[i+3] = alpha
+	}
+	return dst
+}
+
+// Resamples 4 channel pixel data of size sw x sh to dw x dh with a Lanczos
+// filter, resampling the rows first then the columns
+func resampleFloat(src []float64, sw, sh, dw, dh int) []float64 {
+	horizontal := contributions(sw, dw)
+	temp := make([]float64, dw*sh*4)
+	for y := 0; y < sh; y++ {
+		row := src[y*sw*4:]
+		for x, c := range horizontal {
+			out := temp[(y*dw+x)*4:]
+			for j, w := range c.weights {
+				p := row[(c.start+j)*4:]
+				out[0] += p[0] * w
+				out[1] += p[1] * w
+				out[2] += p[2] * w
+				out[3] += p[3] * w
+			}
+		}
+	}
+
+	vertical := contributions(sh, dh)
+	dst := make([]float64, dw*dh*4)
+	for y, c := range vertical {
+		for x := 0; x < dw; x++ {
+			out := dst[(y*dw+x)*4:]
+			for j, w := range c.weights {
+				p := temp[((c.start+j)*dw+x)*4:]
+				out[0] += p[0] * w
+				out[1] += p[1] * w
+				out[2] += p[2] * w
+				out[3] += p[3] * w
+			}
+		}
+	}
+	return dst
+}
+
+// Rounds and clamps a value to the range of a byte
+func clampByte(v float64) uint8 {
+	return uint8(clampFloat(v) + 0.5)
+}
+
+func clampFloat(v float64) float64 {
+	return math.Max(0, math.Min(255, v))
+}
@@ -0,0 +1,48 @@
+package atlas
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Added to the path of an image to find its sidecar file, eg. the
+// settings for "button.png" are read from "button.png.json"
+const SIDECAR_SUFFIX = ".json"
+
+// Settings for an image read from its sidecar file, which override any
+// set by the image itself or its name. For example
+// {"nineSlice": {"left": 8, "top": 8, "right": 8, "bottom": 8}, "pivot": {"x": 0.5, "y": 1}}
+type sidecar struct {
+	NineSlice *NineSlice
+	// Relative to the canvas the files were taken from, so every layer of
+	// a PSD file shares the same point
+	Pivot *Pivot
+}
+
+// Applies the sidecar file of the image to each file read from it, if the
+// image has one
+func applySidecar(filename string, files []*File) error {
+	data, err := ioutil.ReadFile(filename + SIDECAR_SUFFIX)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var s sidecar
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New(fmt.Sprintf("Unable to read %s: %s", filename+SIDECAR_SUFFIX, err.Error()))
+	}
+	for _, file := range files {
+		if s.NineSlice != nil {
+			borders := *s.NineSlice
+			file.NineSlice = &borders
+		}
+		if s.Pivot != nil {
+			file.Pivot = file.canvasPivot(*s.Pivot)
+		}
+	}
+	return nil
+}
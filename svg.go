@@ -0,0 +1,1094 @@
+package atlas
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The distance in pixels that flattened curves may stray from the true curve
+const svgTolerance = 0.1
+
+// A generic element of an SVG document
+type svgNode struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Children []*svgNode `xml:",any"`
+}
+
+// Returns the value of the attribute with the given local name,
+// ignoring any namespace so that xlink:href and href are the same
+func (n *svgNode) attr(name string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == name {
+			return strings.TrimSpace(a.Value)
+		}
+	}
+	return ""
+}
+
+// Returns the value of a presentation property, style declarations take
+// precedence over attributes
+func (n *svgNode) property(name string) string {
+	for _, decl := range strings.Split(n.attr("style"), ";") {
+		if i := strings.Index(decl, ":"); i >= 0 && strings.TrimSpace(decl[:i]) == name {
+			return strings.TrimSpace(decl[i+1:])
+		}
+	}
+	return n.attr(name)
+}
+
+// A colour or gradient used to fill or stroke a shape
+type svgPaint struct {
+	none     bool
+	color    [4]float64 // Non-premultiplied RGBA between 0 and 1
+	gradient *svgNode
+}
+
+// The inherited presentation properties of an element
+type svgStyle struct {
+	fill, stroke  svgPaint
+	color         [4]float64
+	fillRule      fillRule
+	fillOpacity   float64
+	strokeOpacity float64
+	opacity       float64
+	line          strokeStyle
+}
+
+// Rasterises an SVG document
+type svgRenderer struct {
+	width, height int
+	// The premultiplied RGBA value of each pixel
+	canvas []float64
+	ids    map[string]*svgNode
+	// The size of the root viewport, used to resolve percentages
+	viewport vec
+	// Whether shapes are recorded rather than painted
+	record bool
+	shapes []svgShape
+	// The elements referenced by the use elements currently being
+	// rendered, so a use that refers back to one of them is caught
+	using map[*svgNode]bool
+}
+
+// The outline of a filled area in image coordinates
+type svgShape struct {
+	contours []contour
+	rule     fillRule
+}
+
+// Rasterises an SVG document into an image. The document's own size is
+// multiplied by scale unless a width or height is given, if only one of
+// them is given the other keeps the document's aspect ratio.
+// Supports paths, basic shapes, groups, use elements, solid fills and
+// strokes and linear and radial gradients. Clipping, masks, filters,
+// text and CSS stylesheets are not supported
+func rasterizeSVG(data []byte, scale float64, width, height int) (*image.RGBA, error) {
+	r, err := renderSVG(data, scale, width, height, false)
+	if err != nil {
+		return nil, err
+	}
+	im := image.NewRGBA(image.Rect(0, 0, r.width, r.height))
+	for i, v := range r.canvas {
+		im.Pix[i] = uint8(math.Max(0, math.Min(1, v))*255 + 0.5)
+	}
+	return im, nil
+}
+
+// Returns the filled shapes of an SVG document in the coordinates of an
+// image of the given size, with strokes turned into shapes of their own.
+// Paint and opacity are ignored
+func svgShapes(data []byte, width, height int) ([]svgShape, error) {
+	r, err := renderSVG(data, 0, width, height, true)
+	if err != nil {
+		return nil, err
+	}
+	return r.shapes, nil
+}
+
+// Renders an SVG document at the size worked out by rasterizeSVG, either
+// painting it onto the canvas or recording its shapes
+func renderSVG(data []byte, scale float64, width, height int, shapes bool) (*svgRenderer, error) {
+	root := &svgNode{}
+	if err := xml.Unmarshal(data, root); err != nil {
+		return nil, err
+	}
+	if root.XMLName.Local != "svg" {
+		return nil, errors.New("Root element is not an svg element")
+	}
+
+	// Work out the coordinate system of the document
+	var viewBox []float64
+	if vb := root.attr("viewBox"); vb != "" {
+		viewBox = svgNumbers(vb)
+		if len(viewBox) != 4 || viewBox[2] <= 0 || viewBox[3] <= 0 {
+			return nil, errors.New(fmt.Sprintf("Invalid viewBox %s", vb))
+		}
+	}
+	size := vec{-1, -1}
+	for i, name := range []string{"width", "height"} {
+		if v := root.attr(name); v != "" && !strings.HasSuffix(v, "%") {
+			l, err := svgLength(v, 0)
+			if err != nil {
+				return nil, err
+			}
+			if i == 0 {
+				size.X = l
+			} else {
+				size.Y = l
+			}
+		}
+	}
+	if viewBox != nil {
+		// Fill in a missing size from the aspect ratio of the view box
+		if size.X < 0 && size.Y < 0 {
+			size = vec{viewBox[2], viewBox[3]}
+		} else if size.X < 0 {
+			size.X = size.Y * viewBox[2] / viewBox[3]
+		} else if size.Y < 0 {
+			size.Y = size.X * viewBox[3] / viewBox[2]
+		}
+	}
+	if size.X <= 0 || size.Y <= 0 {
+		return nil, errors.New("SVG has no width, height or viewBox")
+	}
+
+	// Work out the size of the image to produce
+	if scale <= 0 {
+		scale = 1
+	}
+	out := size.mul(scale)
+	if width > 0 && height > 0 {
+		out = vec{float64(width), float64(height)}
+	} else if width > 0 {
+		out = vec{float64(width), float64(width) * size.Y / size.X}
+	} else if height > 0 {
+		out = vec{float64(height) * size.X / size.Y, float64(height)}
+	}
+	w, h := int(math.Max(1, math.Round(out.X))), int(math.Max(1, math.Round(out.Y)))
+
+	r := &svgRenderer{
+		width:    w,
+		height:   h,
+		canvas:   make([]float64, w*h*4),
+		ids:      make(map[string]*svgNode),
+		using:    make(map[*svgNode]bool),
+		viewport: size,
+		record:   shapes,
+	}
+	r.index(root)
+
+	// The view box is fitted to the image we are producing so a different
+	// aspect ratio keeps the document centred rather than stretching it
+	m := affine{float64(w) / size.X, 0, 0, float64(h) / size.Y, 0, 0}
+	if viewBox != nil {
+		r.viewport = vec{viewBox[2], viewBox[3]}
+		m = svgViewBox(viewBox, vec{float64(w), float64(h)}, root.attr("preserveAspectRatio"))
+	}
+
+	style := svgStyle{
+		fill:          svgPaint{color: [4]float64{0, 0, 0, 1}},
+		stroke:        svgPaint{none: true},
+		color:         [4]float64{0, 0, 0, 1},
+		fillOpacity:   1,
+		strokeOpacity: 1,
+		opacity:       1,
+		line:          strokeStyle{width: 1, miterLimit: 4},
+	}
+	style, err := r.style(root, style)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.renderChildren(root, m, style); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Returns the transform that maps a view box into a viewport of the
+// given size following the preserveAspectRatio rules
+func svgViewBox(viewBox []float64, size vec, aspect string) affine {
+	sx, sy := size.X/viewBox[2], size.Y/viewBox[3]
+	fields := strings.Fields(aspect)
+	align, slice := "xMidYMid", false
+	if len(fields) > 0 {
+		align = fields[0]
+	}
+	if len(fields) > 1 {
+		slice = fields[1] == "slice"
+	}
+	tx, ty := -viewBox[0]*sx, -viewBox[1]*sy
+	if align != "none" {
+		s := math.Min(sx, sy)
+		if slice {
+			s = math.Max(sx, sy)
+		}
+		sx, sy = s, s
+		tx, ty = -viewBox[0]*s, -viewBox[1]*s
+		extra := vec{size.X - viewBox[2]*s, size.Y - viewBox[3]*s}
+		if strings.Contains(align, "xMid") {
+			tx += extra.X / 2
+		} else if strings.Contains(align, "xMax") {
+			tx += extra.X
+		}
+		if strings.Contains(align, "YMid") {
+			ty += extra.Y / 2
+		} else if strings.Contains(align, "YMax") {
+			ty += extra.Y
+		}
+	}
+	return affine{sx, 0, 0, sy, tx, ty}
+}
+
+// Records every element with an id so they can be referenced
+func (r *svgRenderer) index(n *svgNode) {
+	if id := n.attr("id"); id != "" {
+		r.ids[id] = n
+	}
+	for _, c := range n.Children {
+		r.index(c)
+	}
+}
+
+// Returns the element referenced by an href or url(#id) value
+func (r *svgRenderer) reference(ref string) *svgNode {
+	ref = strings.TrimSpace(ref)
+	if strings.HasPrefix(ref, "url(") {
+		ref = strings.TrimSuffix(strings.TrimPrefix(ref, "url("), ")")
+		ref = strings.Trim(ref, `'" `)
+	}
+	if !strings.HasPrefix(ref, "#") {
+		return nil
+	}
+	return r.ids[ref[1:]]
+}
+
+func (r *svgRenderer) renderChildren(n *svgNode, m affine, style svgStyle) error {
+	for _, c := range n.Children {
+		if err := r.render(c, m, style); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Renders an element and its children
+func (r *svgRenderer) render(n *svgNode, m affine, style svgStyle) error {
+	if n.property("display") == "none" {
+		return nil
+	}
+	if t := n.attr("transform"); t != "" {
+		transform, err := svgTransform(t)
+		if err != nil {
+			return err
+		}
+		m = m.mul(transform)
+	}
+	style, err := r.style(n, style)
+	if err != nil {
+		return err
+	}
+
+	switch n.XMLName.Local {
+	case "g", "a", "switch":
+		return r.renderChildren(n, m, style)
+	case "svg":
+		x, y := r.length(n.attr("x"), r.viewport.X), r.length(n.attr("y"), r.viewport.Y)
+		m = m.mul(affine{1, 0, 0, 1, x, y})
+		if vb := svgNumbers(n.attr("viewBox")); len(vb) == 4 && vb[2] > 0 && vb[3] > 0 {
+			size := vec{r.length(n.attr("width"), r.viewport.X), r.length(n.attr("height"), r.viewport.Y)}
+			if size.X <= 0 || size.Y <= 0 {
+				size = vec{vb[2], vb[3]}
+			}
+			m = m.mul(svgViewBox(vb, size, n.attr("preserveAspectRatio")))
+		}
+		return r.renderChildren(n, m, style)
+	case "use":
+		ref := r.reference(n.attr("href"))
+		if ref == nil {
+			return nil
+		}
+		if r.using[ref] || ref == n {
+			return errors.New(fmt.Sprintf("Element %s is used within itself", n.attr("href")))
+		}
+		x, y := r.length(n.attr("x"), r.viewport.X), r.length(n.attr("y"), r.viewport.Y)
+		m = m.mul(affine{1, 0, 0, 1, x, y})
+		r.using[ref] = true
+		defer delete(r.using, ref)
+		if ref.XMLName.Local == "symbol" {
+			return r.renderChildren(ref, m, style)
+		}
+		return r.render(ref, m, style)
+	case "path", "rect", "circle", "ellipse", "line", "polyline", "polygon":
+		// Flatten the shape in user space so strokes are transformed properly
+		tolerance := svgTolerance / math.Max(m.scale(), 1e-6)
+		contours, err := r.shape(n, tolerance)
+		if err != nil {
+			return err
+		}
+		if len(contours) == 0 {
+			return nil
+		}
+		min, max := contourBounds(contours)
+		bounds := [4]float64{min.X, min.Y, max.X - min.X, max.Y - min.Y}
+		if !style.fill.none {
+			filled := transformContours(contours, m)
+			if r.record {
+				r.shapes = append(r.shapes, svgShape{filled, style.fillRule})
+			} else {
+				coverage := rasterize(filled, r.width, r.height, style.fillRule)
+				r.paint(coverage, style.fill, style.fillOpacity*style.opacity, m, bounds)
+			}
+		}
+		if !style.stroke.none && style.line.width > 0 {
+			outline := transformContours(strokeContours(contours, style.line, tolerance), m)
+			if r.record {
+				r.shapes = append(r.shapes, svgShape{outline, fillNonZero})
+			} else {
+				coverage := rasterize(outline, r.width, r.height, fillNonZero)
+				r.paint(coverage, style.stroke, style.strokeOpacity*style.opacity, m, bounds)
+			}
+		}
+	}
+	// Everything else (defs, gradients, symbols, text etc.) is not drawn
+	return nil
+}
+
+// Applies the presentation properties of an element to its inherited style
+func (r *svgRenderer) style(n *svgNode, style svgStyle) (svgStyle, error) {
+	number := func(name string, value *float64) error {
+		if v := n.property(name); v != "" && v != "inherit" {
+			f, err := svgLength(v, 1)
+			if err != nil {
+				return err
+			}
+			*value = f
+		}
+		return nil
+	}
+	if v := n.property("color"); v != "" && v != "inherit" {
+		c, err := svgColor(v, style.color)
+		if err != nil {
+			return style, err
+		}
+		style.color = c
+	}
+	// Opacity is not inherited, instead it is multiplied into the children
+	opacity := 1.0
+	if err := number("opacity", &opacity); err != nil {
+		return style, err
+	}
+	style.opacity *= opacity
+	if err := number("fill-opacity", &style.fillOpacity); err != nil {
+		return style, err
+	}
+	if err := number("stroke-opacity", &style.strokeOpacity); err != nil {
+		return style, err
+	}
+	if err := number("stroke-miterlimit", &style.line.miterLimit); err != nil {
+		return style, err
+	}
+	if v := n.property("stroke-width"); v != "" && v != "inherit" {
+		style.line.width = r.length(v, math.Sqrt((r.viewport.X*r.viewport.X+r.viewport.Y*r.viewport.Y)/2))
+	}
+	switch n.property("fill-rule") {
+	case "nonzero":
+		style.fillRule = fillNonZero
+	case "evenodd":
+		style.fillRule = fillEvenOdd
+	}
+	switch n.property("stroke-linecap") {
+	case "butt":
+		style.line.cap = capButt
+	case "round":
+		style.line.cap = capRound
+	case "square":
+		style.line.cap = capSquare
+	}
+	switch n.property("stroke-linejoin") {
+	case "miter", "miter-clip", "arcs":
+		style.line.join = joinMiter
+	case "round":
+		style.line.join = joinRound
+	case "bevel":
+		style.line.join = joinBevel
+	}
+	var err error
+	if v := n.property("fill"); v != "" && v != "inherit" {
+		if style.fill, err = r.paintValue(v, style.color); err != nil {
+			return style, err
+		}
+	}
+	if v := n.property("stroke"); v != "" && v != "inherit" {
+		if style.stroke, err = r.paintValue(v, style.color); err != nil {
+			return style, err
+		}
+	}
+	return style, nil
+}
+
+// Parses a fill or stroke value
+func (r *svgRenderer) paintValue(v string, current [4]float64) (svgPaint, error) {
+	if strings.HasPrefix(v, "url(") {
+		end := strings.Index(v, ")")
+		if end < 0 {
+			return svgPaint{}, errors.New(fmt.Sprintf("Invalid paint %s", v))
+		}
+		if ref := r.reference(v[:end+1]); ref != nil {
+			switch ref.XMLName.Local {
+			case "linearGradient", "radialGradient":
+				return svgPaint{gradient: ref}, nil
+			}
+		}
+		// Use the fallback colour if the reference can not be used
+		fallback := strings.TrimSpace(v[end+1:])
+		if fallback == "" {
+			return svgPaint{none: true}, nil
+		}
+		v = fallback
+	}
+	if v == "none" {
+		return svgPaint{none: true}, nil
+	}
+	c, err := svgColor(v, current)
+	return svgPaint{color: c}, err
+}
+
+// Resolves a length, percentages are relative to the given reference size
+func (r *svgRenderer) length(v string, ref float64) float64 {
+	l, err := svgLength(v, ref)
+	if err != nil {
+		return 0
+	}
+	return l
+}
+
+// Returns the contours of a shape element in its own coordinate space
+func (r *svgRenderer) shape(n *svgNode, tolerance float64) ([]contour, error) {
+	b := newPathBuilder(tolerance)
+	vw, vh := r.viewport.X, r.viewport.Y
+	diag := math.Sqrt((vw*vw + vh*vh) / 2)
+	switch n.XMLName.Local {
+	case "path":
+		if err := svgPath(b, n.attr("d")); err != nil {
+			return nil, err
+		}
+	case "rect":
+		x, y := r.length(n.attr("x"), vw), r.length(n.attr("y"), vh)
+		w, h := r.length(n.attr("width"), vw), r.length(n.attr("height"), vh)
+		if w <= 0 || h <= 0 {
+			return nil, nil
+		}
+		rx, ry := r.length(n.attr("rx"), vw), r.length(n.attr("ry"), vh)
+		if n.attr("rx") == "" {
+			rx = ry
+		} else if n.attr("ry") == "" {
+			ry = rx
+		}
+		rx, ry = math.Min(rx, w/2), math.Min(ry, h/2)
+		if rx <= 0 || ry <= 0 {
+			b.moveTo(vec{x, y})
+			b.lineTo(vec{x + w, y})
+			b.lineTo(vec{x + w, y + h})
+			b.lineTo(vec{x, y + h})
+			b.close()
+			break
+		}
+		b.moveTo(vec{x + rx, y})
+		b.lineTo(vec{x + w - rx, y})
+		b.arcTo(rx, ry, 0, false, true, vec{x + w, y + ry})
+		b.lineTo(vec{x + w, y + h - ry})
+		b.arcTo(rx, ry, 0, false, true, vec{x + w - rx, y + h})
+		b.lineTo(vec{x + rx, y + h})
+		b.arcTo(rx, ry, 0, false, true, vec{x, y + h - ry})
+		b.lineTo(vec{x, y + ry})
+		b.arcTo(rx, ry, 0, false, true, vec{x + rx, y})
+		b.close()
+	case "circle", "ellipse":
+		cx, cy := r.length(n.attr("cx"), vw), r.length(n.attr("cy"), vh)
+		rx, ry := r.length(n.attr("rx"), vw), r.length(n.attr("ry"), vh)
+		if n.XMLName.Local == "circle" {
+			rx = r.length(n.attr("r"), diag)
+			ry = rx
+		}
+		if rx <= 0 || ry <= 0 {
+			return nil, nil
+		}
+		b.moveTo(vec{cx + rx, cy})
+		b.arcTo(rx, ry, 0, false, true, vec{cx - rx, cy})
+		b.arcTo(rx, ry, 0, false, true, vec{cx + rx, cy})
+		b.close()
+	case "line":
+		b.moveTo(vec{r.length(n.attr("x1"), vw), r.length(n.attr("y1"), vh)})
+		b.lineTo(vec{r.length(n.attr("x2"), vw), r.length(n.attr("y2"), vh)})
+	case "polyline", "polygon":
+		points := svgNumbers(n.attr("points"))
+		for i := 0; i+1 < len(points); i += 2 {
+			if i == 0 {
+				b.moveTo(vec{points[i], points[i+1]})
+			} else {
+				b.lineTo(vec{points[i], points[i+1]})
+			}
+		}
+		if n.XMLName.Local == "polygon" {
+			b.close()
+		}
+	}
+	return b.path(), nil
+}
+
+// Composites a paint onto the canvas using the coverage as a mask. The
+// transform maps user space to pixels and bounds is the bounding box of
+// the shape in user space as x, y, width, height
+func (r *svgRenderer) paint(coverage []float32, paint svgPaint, opacity float64, m affine, bounds [4]float64) {
+	var gradient *svgGradient
+	if paint.gradient != nil {
+		gradient = r.gradient(paint.gradient, m, bounds)
+		if gradient == nil {
+			return
+		}
+	}
+	color := paint.color
+	for i, c := range coverage {
+		if c == 0 {
+			continue
+		}
+		if gradient != nil {
+			color = gradient.at(vec{float64(i%r.width) + 0.5, float64(i/r.width) + 0.5})
+		}
+		a := color[3] * opacity * float64(c)
+		dst := r.canvas[i*4 : i*4+4]
+		for j := 0; j < 3; j++ {
+			dst[j] = color[j]*a + dst[j]*(1-a)
+		}
+		dst[3] = a + dst[3]*(1-a)
+	}
+}
+
+// A gradient ready to be sampled in pixel space
+type svgGradient struct {
+	radial bool
+	// Maps pixels into the gradient's coordinate space
+	inverse affine
+	// Start and end points of a linear gradient, or the centre
+	// and focal point of a radial gradient
+	p0, p1  vec
+	radius  float64
+	spread  string
+	offsets []float64
+	colors  [][4]float64
+}
+
+// Resolves a gradient element, following any hrefs to inherit attributes
+// and stops. Returns nil if the gradient has no stops and should not be drawn
+func (r *svgRenderer) gradient(n *svgNode, m affine, bounds [4]float64) *svgGradient {
+	// Collect the gradient and all the gradients it references
+	chain := []*svgNode{n}
+	for ref := r.reference(n.attr("href")); ref != nil && len(chain) < 16; ref = r.reference(ref.attr("href")) {
+		chain = append(chain, ref)
+	}
+	attr := func(name, def string) string {
+		for _, node := range chain {
+			if v := node.attr(name); v != "" {
+				return v
+			}
+		}
+		return def
+	}
+
+	g := &svgGradient{
+		radial: n.XMLName.Local == "radialGradient",
+		spread: attr("spreadMethod", "pad"),
+	}
+	for _, node := range chain {
+		for _, stop := range node.Children {
+			if stop.XMLName.Local != "stop" {
+				continue
+			}
+			offset, _ := svgLength(stop.attr("offset"), 1)
+			if len(g.offsets) > 0 {
+				// Offsets must never go backwards
+				offset = math.Max(offset, g.offsets[len(g.offsets)-1])
+			}
+			color, err := svgColor(stop.property("stop-color"), [4]float64{0, 0, 0, 1})
+			if err != nil || stop.property("stop-color") == "" {
+				color = [4]float64{0, 0, 0, 1}
+			}
+			if v := stop.property("stop-opacity"); v != "" {
+				o, _ := svgLength(v, 1)
+				color[3] *= o
+			}
+			g.offsets = append(g.offsets, math.Max(0, math.Min(1, offset)))
+			g.colors = append(g.colors, color)
+		}
+		if len(g.offsets) > 0 {
+			break
+		}
+	}
+	if len(g.offsets) == 0 {
+		return nil
+	}
+
+	// Gradient coordinates are either fractions of the bounding box
+	// or lengths in user space
+	space := m
+	ref := r.viewport
+	diag := math.Sqrt((ref.X*ref.X + ref.Y*ref.Y) / 2)
+	if attr("gradientUnits", "objectBoundingBox") == "objectBoundingBox" {
+		if bounds[2] == 0 || bounds[3] == 0 {
+			return nil
+		}
+		space = m.mul(affine{bounds[2], 0, 0, bounds[3], bounds[0], bounds[1]})
+		ref, diag = vec{1, 1}, 1
+	}
+	if t := attr("gradientTransform", ""); t != "" {
+		if transform, err := svgTransform(t); err == nil {
+			space = space.mul(transform)
+		}
+	}
+	g.inverse = space.invert()
+
+	length := func(name, def string, ref float64) float64 {
+		return r.length(attr(name, def), ref)
+	}
+	if g.radial {
+		g.p0 = vec{length("cx", "50%", ref.X), length("cy", "50%", ref.Y)}
+		g.radius = length("r", "50%", diag)
+		g.p1 = g.p0
+		if attr("fx", "") != "" || attr("fy", "") != "" {
+			g.p1 = vec{length("fx", attr("cx", "50%"), ref.X), length("fy", attr("cy", "50%"), ref.Y)}
+		}
+	} else {
+		g.p0 = vec{length("x1", "0%", ref.X), length("y1", "0%", ref.Y)}
+		g.p1 = vec{length("x2", "100%", ref.X), length("y2", "0%", ref.Y)}
+	}
+	return g
+}
+
+// Returns the colour of the gradient at the given pixel
+func (g *svgGradient) at(pixel vec) [4]float64 {
+	p := g.inverse.apply(pixel)
+	t := 0.0
+	if g.radial {
+		// Find the circle between the focal point and the outer circle
+		// that passes through the point
+		d, e := p.sub(g.p1), g.p0.sub(g.p1)
+		a := e.dot(e) - g.radius*g.radius
+		b := d.dot(e)
+		c := d.dot(d)
+		if g.radius > 0 {
+			if math.Abs(a) < 1e-12 {
+				if b != 0 {
+					t = c / (2 * b)
+				}
+			} else if disc := b*b - a*c; disc >= 0 {
+				// Use the largest circle, a negative radius is not valid
+				t = math.Max(0, math.Max((b-math.Sqrt(disc))/a, (b+math.Sqrt(disc))/a))
+			}
+		}
+	} else {
+		d := g.p1.sub(g.p0)
+		if l := d.dot(d); l > 0 {
+			t = p.sub(g.p0).dot(d) / l
+		}
+	}
+
+	switch g.spread {
+	case "repeat":
+		t -= math.Floor(t)
+	case "reflect":
+		t = math.Mod(math.Abs(t), 2)
+		if t > 1 {
+			t = 2 - t
+		}
+	default:
+		t = math.Max(0, math.Min(1, t))
+	}
+
+	if t <= g.offsets[0] {
+		return g.colors[0]
+	}
+	for i := 1; i < len(g.offsets); i++ {
+		if t <= g.offsets[i] {
+			span := g.offsets[i] - g.offsets[i-1]
+			if span <= 0 {
+				return g.colors[i]
+			}
+			f := (t - g.offsets[i-1]) / span
+			var c [4]float64
+			for j := range c {
+				c[j] = g.colors[i-1][j] + (g.colors[i][j]-g.colors[i-1][j])*f
+			}
+			return c
+		}
+	}
+	return g.colors[len(g.colors)-1]
+}
+
+// Reads the numbers from a list separated by whitespace and/or commas
+func svgNumbers(s string) []float64 {
+	res := make([]float64, 0)
+	p := &svgScanner{s: s}
+	for {
+		n, ok := p.number()
+		if !ok {
+			return res
+		}
+		res = append(res, n)
+	}
+}
+
+// Parses a length with optional units. Percentages are relative to ref
+func svgLength(s string, ref float64) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	units := map[string]float64{
+		"px": 1, "pt": 96.0 / 72, "pc": 16, "mm": 96 / 25.4, "cm": 96 / 2.54, "in": 96, "em": 16, "ex": 8,
+	}
+	scale := 1.0
+	if strings.HasSuffix(s, "%") {
+		scale = ref / 100
+		s = s[:len(s)-1]
+	} else if len(s) > 2 {
+		if u, ok := units[s[len(s)-2:]]; ok {
+			scale = u
+			s = s[:len(s)-2]
+		}
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("Invalid length %s", s))
+	}
+	return v * scale, nil
+}
+
+// Parses a transform list such as "translate(10 20) rotate(45)"
+func svgTransform(s string) (affine, error) {
+	m := identity
+	for {
+		s = strings.TrimLeft(s, " \t\r\n,")
+		if s == "" {
+			return m, nil
+		}
+		open := strings.Index(s, "(")
+		end := strings.Index(s, ")")
+		if open < 0 || end < open {
+			return m, errors.New(fmt.Sprintf("Invalid transform %s", s))
+		}
+		name := strings.TrimSpace(s[:open])
+		args := svgNumbers(s[open+1 : end])
+		s = s[end+1:]
+		arg := func(i int, def float64) float64 {
+			if i < len(args) {
+				return args[i]
+			}
+			return def
+		}
+		var t affine
+		switch name {
+		case "matrix":
+			if len(args) != 6 {
+				return m, errors.New("Transform matrix must have 6 values")
+			}
+			copy(t[:], args)
+		case "translate":
+			t = affine{1, 0, 0, 1, arg(0, 0), arg(1, 0)}
+		case "scale":
+			t = affine{arg(0, 1), 0, 0, arg(1, arg(0, 1)), 0, 0}
+		case "rotate":
+			sin, cos := math.Sincos(arg(0, 0) * math.Pi / 180)
+			cx, cy := arg(1, 0), arg(2, 0)
+			t = affine{1, 0, 0, 1, cx, cy}.mul(affine{cos, sin, -sin, cos, 0, 0}).mul(affine{1, 0, 0, 1, -cx, -cy})
+		case "skewX":
+			t = affine{1, 0, math.Tan(arg(0, 0) * math.Pi / 180), 1, 0, 0}
+		case "skewY":
+			t = affine{1, math.Tan(arg(0, 0) * math.Pi / 180), 0, 1, 0, 0}
+		default:
+			return m, errors.New(fmt.Sprintf("Unknown transform %s", name))
+		}
+		m = m.mul(t)
+	}
+}
+
+// Reads numbers and flags from path data and other attribute values
+type svgScanner struct {
+	s   string
+	pos int
+}
+
+func (p *svgScanner) skipSeparators() {
+	for p.pos < len(p.s) && (unicode.IsSpace(rune(p.s[p.pos])) || p.s[p.pos] == ',') {
+		p.pos++
+	}
+}
+
+func (p *svgScanner) number() (float64, bool) {
+	p.skipSeparators()
+	start := p.pos
+	if p.pos < len(p.s) && (p.s[p.pos] == '+' || p.s[p.pos] == '-') {
+		p.pos++
+	}
+	digits, dot := false, false
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		if c >= '0' && c <= '9' {
+			digits = true
+		} else if c == '.' && !dot {
+			dot = true
+		} else {
+			break
+		}
+		p.pos++
+	}
+	if digits && p.pos < len(p.s) && (p.s[p.pos] == 'e' || p.s[p.pos] == 'E') {
+		// Only treat this as an exponent if digits follow
+		e := p.pos + 1
+		if e < len(p.s) && (p.s[e] == '+' || p.s[e] == '-') {
+			e++
+		}
+		if e < len(p.s) && p.s[e] >= '0' && p.s[e] <= '9' {
+			p.pos = e
+			for p.pos < len(p.s) && p.s[p.pos] >= '0' && p.s[p.pos] <= '9' {
+				p.pos++
+			}
+		}
+	}
+	if !digits {
+		p.pos = start
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(p.s[start:p.pos], 64)
+	return v, err == nil
+}
+
+// Reads an arc flag which may not be separated from the next value
+func (p *svgScanner) flag() (bool, bool) {
+	p.skipSeparators()
+	if p.pos < len(p.s) && (p.s[p.pos] == '0' || p.s[p.pos] == '1') {
+		p.pos++
+		return p.s[p.pos-1] == '1', true
+	}
+	return false, false
+}
+
+// Parses SVG path data into the path builder
+func svgPath(b *pathBuilder, d string) error {
+	p := &svgScanner{s: d}
+	var cmd byte
+	var pos, ctrl vec
+	prevCmd := byte(0)
+	for {
+		p.skipSeparators()
+		if p.pos >= len(p.s) {
+			return nil
+		}
+		if c := p.s[p.pos]; unicode.IsLetter(rune(c)) {
+			cmd = c
+			p.pos++
+		} else if cmd == 0 {
+			return errors.New(fmt.Sprintf("Path data must start with a command: %s", d))
+		}
+
+		// Reads count numbers, failing if they are not all there
+		args := make([]float64, 0, 7)
+		read := func(count int) bool {
+			for len(args) < count {
+				n, ok := p.number()
+				if !ok {
+					return false
+				}
+				args = append(args, n)
+			}
+			return true
+		}
+		relative := cmd >= 'a'
+		origin := vec{}
+		if relative {
+			origin = pos
+		}
+		pt := func(i int) vec {
+			return vec{args[i], args[i+1]}.add(origin)
+		}
+		// The reflection of the last control point for smooth curves
+		reflect := func(kinds string) vec {
+			if strings.IndexByte(kinds, prevCmd|0x20) >= 0 {
+				return pos.mul(2).sub(ctrl)
+			}
+			return pos
+		}
+
+		ok := true
+		switch cmd | 0x20 {
+		case 'z':
+			b.close()
+			pos = b.pos
+		case 'm':
+			if ok = read(2); ok {
+				pos = pt(0)
+				b.moveTo(pos)
+				// Further coordinates are implicit line commands
+				cmd = 'L' | (cmd & 0x20)
+			}
+		case 'l':
+			if ok = read(2); ok {
+				pos = pt(0)
+				b.lineTo(pos)
+			}
+		case 'h':
+			if ok = read(1); ok {
+				pos = vec{args[0] + origin.X, pos.Y}
+				b.lineTo(pos)
+			}
+		case 'v':
+			if ok = read(1); ok {
+				pos = vec{pos.X, args[0] + origin.Y}
+				b.lineTo(pos)
+			}
+		case 'c':
+			if ok = read(6); ok {
+				ctrl = pt(2)
+				b.cubicTo(pt(0), ctrl, pt(4))
+				pos = pt(4)
+			}
+		case 's':
+			if ok = read(4); ok {
+				c1 := reflect("cs")
+				ctrl = pt(0)
+				b.cubicTo(c1, ctrl, pt(2))
+				pos = pt(2)
+			}
+		case 'q':
+			if ok = read(4); ok {
+				ctrl = pt(0)
+				b.quadTo(ctrl, pt(2))
+				pos = pt(2)
+			}
+		case 't':
+			if ok = read(2); ok {
+				ctrl = reflect("qt")
+				b.quadTo(ctrl, pt(0))
+				pos = pt(0)
+			}
+		case 'a':
+			if ok = read(3); ok {
+				var large, sweep bool
+				if large, ok = p.flag(); ok {
+					if sweep, ok = p.flag(); ok {
+						if ok = read(5); ok {
+							end := pt(3)
+							b.arcTo(args[0], args[1], args[2], large, sweep, end)
+							pos = end
+						}
+					}
+				}
+			}
+		default:
+			return errors.New(fmt.Sprintf("Unknown path command %c", cmd))
+		}
+		if !ok {
+			return errors.New(fmt.Sprintf("Invalid path data: %s", d))
+		}
+		prevCmd = cmd
+		if cmd|0x20 == 'z' {
+			cmd = 0
+		}
+	}
+}
+
+// Colours by name, this is the basic set plus common extended colours
+var svgColors = map[string][3]uint8{
+	"black": {0, 0, 0}, "silver": {192, 192, 192}, "gray": {128, 128, 128}, "grey": {128, 128, 128},
+	"white": {255, 255, 255}, "maroon": {128, 0, 0}, "red": {255, 0, 0}, "purple": {128, 0, 128},
+	"fuchsia": {255, 0, 255}, "magenta": {255, 0, 255}, "green": {0, 128, 0}, "lime": {0, 255, 0},
+	"olive": {128, 128, 0}, "yellow": {255, 255, 0}, "navy": {0, 0, 128}, "blue": {0, 0, 255},
+	"teal": {0, 128, 128}, "aqua": {0, 255, 255}, "cyan": {0, 255, 255}, "orange": {255, 165, 0},
+	"pink": {255, 192, 203}, "brown": {165, 42, 42}, "gold": {255, 215, 0}, "darkgray": {169, 169, 169},
+	"darkgrey": {169, 169, 169}, "lightgray": {211, 211, 211}, "lightgrey": {211, 211, 211},
+	"dimgray": {105, 105, 105}, "dimgrey": {105, 105, 105}, "darkred": {139, 0, 0},
+	"darkgreen": {0, 100, 0}, "darkblue": {0, 0, 139}, "lightblue": {173, 216, 230},
+	"skyblue": {135, 206, 235}, "steelblue": {70, 130, 180}, "royalblue": {65, 105, 225},
+	"indigo": {75, 0, 130}, "violet": {238, 130, 238}, "crimson": {220, 20, 60},
+	"tomato": {255, 99, 71}, "coral": {255, 127, 80}, "salmon": {250, 128, 114},
+	"khaki": {240, 230, 140}, "beige": {245, 245, 220}, "ivory": {255, 255, 240},
+	"tan": {210, 180, 140}, "chocolate": {210, 105, 30}, "sienna": {160, 82, 45},
+	"orchid": {218, 112, 214}, "plum": {221, 160, 221}, "turquoise": {64, 224, 208},
+	"limegreen": {50, 205, 50}, "forestgreen": {34, 139, 34}, "seagreen": {46, 139, 87},
+	"darkorange": {255, 140, 0}, "whitesmoke": {245, 245, 245}, "slategray": {112, 128, 144},
+	"slategrey": {112, 128, 144}, "midnightblue": {25, 25, 112}, "hotpink": {255, 105, 180},
+}
+
+// Parses a colour value, currentColor resolves to the given current colour
+func svgColor(s string, current [4]float64) ([4]float64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	invalid := errors.New(fmt.Sprintf("Invalid colour %s", s))
+	switch {
+	case s == "currentcolor":
+		return current, nil
+	case s == "transparent":
+		return [4]float64{}, nil
+	case strings.HasPrefix(s, "#"):
+		hex := s[1:]
+		if len(hex) == 3 || len(hex) == 4 {
+			expanded := make([]byte, 0, 8)
+			for i := range hex {
+				expanded = append(expanded, hex[i], hex[i])
+			}
+			hex = string(expanded)
+		}
+		if len(hex) == 6 {
+			hex += "ff"
+		}
+		if len(hex) != 8 {
+			return current, invalid
+		}
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return current, invalid
+		}
+		return [4]float64{
+			float64(v>>24&0xff) / 255,
+			float64(v>>16&0xff) / 255,
+			float64(v>>8&0xff) / 255,
+			float64(v&0xff) / 255,
+		}, nil
+	case strings.HasPrefix(s, "rgb"):
+		open, end := strings.Index(s, "("), strings.Index(s, ")")
+		if open < 0 || end < open {
+			return current, invalid
+		}
+		parts := strings.FieldsFunc(s[open+1:end], func(r rune) bool {
+			return r == ',' || r == '/' || unicode.IsSpace(r)
+		})
+		if len(parts) < 3 {
+			return current, invalid
+		}
+		c := [4]float64{0, 0, 0, 1}
+		for i, part := range parts {
+			if i > 3 {
+				break
+			}
+			ref := 255.0
+			if i == 3 {
+				ref = 1
+			}
+			v, err := svgLength(part, ref)
+			if err != nil {
+				return current, invalid
+			}
+			c[i] = math.Max(0, math.Min(1, v/ref))
+		}
+		return c, nil
+	}
+	if c, ok := svgColors[s]; ok {
+		return [4]float64{float64(c[0]) / 255, float64(c[1]) / 255, float64(c[2]) / 255, 1}, nil
+	}
+	return current, invalid
+}
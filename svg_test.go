@@ -0,0 +1,141 @@
+package atlas
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestRasterizeSVG(t *testing.T) {
+	const SVG = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 20 10" width="40">
+		<defs>
+			<linearGradient id="fade" x1="0" x2="1">
+				<stop offset="0" stop-color="#ff0000"/>
+				<stop offset="1" stop-color="#0000ff"/>
+			</linearGradient>
+		</defs>
+		<path d="M0 0 H10 V10 H0 Z M2.5 2.5 V7.5 H7.5 V2.5 Z" fill="lime" fill-rule="evenodd"/>
+		<rect x="10" y="0" width="10" height="10" fill="url(#fade)"/>
+		<line x1="0" y1="5" x2="10" y2="5" stroke="rgb(0, 0, 255)" stroke-width="1" style="stroke-opacity: 0"/>
+	</svg>`
+
+	near := func(a, b color.RGBA) bool {
+		d := func(x, y uint8) bool {
+			return int(x)-int(y) <= 1 && int(y)-int(x) <= 1
+		}
+		return d(a.R, b.R) && d(a.G, b.G) && d(a.B, b.B) && d(a.A, b.A)
+	}
+
+	type Want struct {
+		size   image.Point
+		pixels map[image.Point]color.RGBA
+	}
+
+	cases := []struct {
+		scale         float64
+		width, height int
+		want          Want
+	}{
+		{
+			// The width is given so the height follows the aspect ratio
+			0, 0, 0,
+			Want{image.Pt(40, 20), map[image.Point]color.RGBA{
+				{1, 1}:   {0, 255, 0, 255},
+				{10, 10}: {0, 0, 0, 0},
+				{20, 10}: {249, 0, 6, 255},
+				{39, 10}: {6, 0, 249, 255},
+			}},
+		},
+		{
+			2, 0, 0,
+			Want{image.Pt(80, 40), map[image.Point]color.RGBA{
+				{2, 2}:   {0, 255, 0, 255},
+				{20, 20}: {0, 0, 0, 0},
+			}},
+		},
+		{
+			0, 0, 5,
+			Want{image.Pt(10, 5), nil},
+		},
+		{
+			// A different aspect ratio keeps the view box centred
+			0, 20, 20,
+			Want{image.Pt(20, 20), map[image.Point]color.RGBA{
+				{0, 0}:  {0, 0, 0, 0},
+				{0, 6}:  {0, 255, 0, 255},
+				{6, 10}: {0, 0, 0, 0},
+			}},
+		},
+	}
+
+	for _, c := range cases {
+		got, err := rasterizeSVG([]byte(SVG), c.scale, c.width, c.height)
+		if err != nil {
+			t.Errorf("rasterizeSVG threw an error: %s", err.Error())
+			continue
+		}
+		if got.Bounds().Size() != c.want.size {
+			t.Errorf("Unexpected image size: want %v, got %v", c.want.size, got.Bounds().Size())
+		}
+		for p, want := range c.want.pixels {
+			if px := got.RGBAAt(p.X, p.Y); !near(px, want) {
+				t.Errorf("Unexpected pixel at %v: want %v, got %v", p, want, px)
+			}
+		}
+	}
+
+	invalid := []string{
+		`<svg xmlns="http://www.w3.org/2000/svg"></svg>`,
+		`<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"><path d="10 10"/></svg>`,
+		`<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"><rect fill="bogus"/></svg>`,
+		`<html></html>`,
+		// Use elements that refer to themselves or their ancestors
+		`<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"><use id="a" href="#a"/></svg>`,
+		`<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"><g id="a"><use href="#a"/></g></svg>`,
+		`<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10">
+			<g id="a"><use href="#b"/></g><g id="b"><rect width="1" height="1"/><use href="#a"/></g></svg>`,
+	}
+	for _, svg := range invalid {
+		if _, err := rasterizeSVG([]byte(svg), 1, 0, 0); err == nil {
+			t.Errorf("Expected an error rasterising %s", svg)
+		}
+	}
+
+	// Using the same element more than once is not a cycle
+	reused := `<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10">
+		<defs><rect id="dot" width="2" height="2" fill="red"/><g id="dots"><use href="#dot"/><use href="#dot" x="4"/></g></defs>
+		<use href="#dots"/><use href="#dots" y="4"/></svg>`
+	got, err := rasterizeSVG([]byte(reused), 1, 0, 0)
+	if err != nil {
+		t.Fatalf("rasterizeSVG threw an error: %s", err.Error())
+	}
+	if px := got.RGBAAt(5, 5); px != (color.RGBA{255, 0, 0, 255}) {
+		t.Errorf("Unexpected pixel of reused element: want red, got %v", px)
+	}
+}
+
+func TestStrokeContours(t *testing.T) {
+	// A square stroked with a wide line should cover the outer square and
+	// leave a hole in the middle, and the corners should be filled in by
+	// the miter joins
+	square := []contour{{[]vec{{4, 4}, {12, 4}, {12, 12}, {4, 12}}, true}}
+	style := strokeStyle{width: 4, join: joinMiter, miterLimit: 4}
+	coverage := rasterize(strokeContours(square, style, 0.1), 16, 16, fillNonZero)
+
+	cases := []struct {
+		x, y int
+		want float32
+	}{
+		{2, 2, 1},   // Miter corner
+		{8, 3, 1},   // Top edge
+		{13, 8, 1},  // Right edge
+		{8, 8, 0},   // Inside the hole
+		{1, 1, 0},   // Outside the stroke
+		{14, 14, 0}, // Outside the stroke
+	}
+	for _, c := range cases {
+		if got := coverage[c.y*16+c.x]; got != c.want {
+			t.Errorf("Unexpected coverage at %d,%d: want %v, got %v", c.x, c.y, c.want, got)
+		}
+	}
+}
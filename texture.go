@@ -0,0 +1,101 @@
+package atlas
+
+import (
+	"errors"
+	"fmt"
+	"image"
+)
+
+// Block compressed pixel formats, these store each 4x4 block of pixels in
+// a fixed number of bytes and must be written to a DDS or KTX container
+const (
+	// 8 bytes per block, alpha is either opaque or transparent
+	PIXEL_BC1 PixelFormat = "BC1"
+	// 16 bytes per block with smooth alpha
+	PIXEL_BC3 PixelFormat = "BC3"
+	// 8 bytes per block without alpha, for devices limited to OpenGL ES 2
+	PIXEL_ETC1 PixelFormat = "ETC1"
+	// 8 bytes per block without alpha, readable as ETC1
+	PIXEL_ETC2_RGB PixelFormat = "ETC2_RGB"
+	// 16 bytes per block with smooth alpha
+	PIXEL_ETC2_RGBA PixelFormat = "ETC2_RGBA"
+)
+
+const (
+	// A DirectDraw Surface, supports the BC formats
+	CONTAINER_DDS Container = "dds"
+	// A Khronos texture, supports every pixel format
+	CONTAINER_KTX Container = "ktx"
+)
+
+// Whether the pixel format is block compressed
+func (p PixelFormat) Compressed() bool {
+	switch p {
+	case PIXEL_BC1, PIXEL_BC3, PIXEL_ETC1, PIXEL_ETC2_RGB, PIXEL_ETC2_RGBA:
+		return true
+	}
+	return false
+}
+
+// Returns an error if the pixel format can not be written to the container
+func checkContainer(container Container, format PixelFormat) error {
+	switch container {
+	case CONTAINER_PNG:
+		if format.Compressed() {
+			return errors.New(fmt.Sprintf("Pixel format %s must be written to a DDS or KTX container", format))
+		}
+	case CONTAINER_DDS:
+		if format == PIXEL_ETC1 || format == PIXEL_ETC2_RGB || format == PIXEL_ETC2_RGBA {
+			return errors.New(fmt.Sprintf("Pixel format %s can not be written to a DDS container, use KTX", format))
+		}
+	case CONTAINER_RAW, CONTAINER_KTX:
+	default:
+		return errors.New(fmt.Sprintf("Unsupported container %s", container))
+	}
+	return nil
+}
+
+// Returns the pixel data of the image in the given format, the image must
+// already be reduced to the format if it is not compressed
+func textureData(im *image.NRGBA, format PixelFormat) []byte {
+	switch format {
+	case PIXEL_BC1:
+		return encodeBC1(im)
+	case PIXEL_BC3:
+		return encodeBC3(im)
+	case PIXEL_ETC1, PIXEL_ETC2_RGB:
+		return encodeETC1(im)
+	case PIXEL_ETC2_RGBA:
+		return encodeETC2RGBA(im)
+	default:
+		return packPixels(im, format)
+	}
+}
+
+// Returns the number of bytes per pixel of an uncompressed format, or
+// per block of a compressed one
+func formatSize(format PixelFormat) int {
+	switch format {
+	case PIXEL_BC1, PIXEL_ETC1, PIXEL_ETC2_RGB:
+		return 8
+	case PIXEL_BC3, PIXEL_ETC2_RGBA:
+		return 16
+	}
+	size := uint(0)
+	for _, n := range pixelBits[format] {
+		size += n
+	}
+	if format == PIXEL_L8 {
+		size = 8
+	}
+	return int(size / 8)
+}
+
+// Returns the number of bytes taken by a single row of pixels, or of
+// blocks for compressed formats
+func rowSize(format PixelFormat, width int) int {
+	if format.Compressed() {
+		return (width + BLOCK_SIZE - 1) / BLOCK_SIZE * formatSize(format)
+	}
+	return width * formatSize(format)
+}
@@ -0,0 +1,225 @@
+package atlas
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+// Decodes a BC1 colour block into the 16 pixels of the block
+func decodeBC1Block(data []byte, alpha bool) [16][4]int {
+	e0, e1 := binary.LittleEndian.Uint16(data), binary.LittleEndian.Uint16(data[2:])
+	p0, p1 := from565(e0), from565(e1)
+	palette := [4][4]int{{p0[0], p0[1], p0[2], 255}, {p1[0], p1[1], p1[2], 255}}
+	for k := 0; k < 3; k++ {
+		if e0 > e1 || !alpha {
+			palette[2][k] = (2*p0[k] + p1[k]) / 3
+			palette[3][k] = (p0[k] + 2*p1[k]) / 3
+		} else {
+			palette[2][k] = (p0[k] + p1[k]) / 2
+		}
+	}
+	palette[2][3], palette[3][3] = 255, 255
+	if e0 <= e1 && alpha {
+		palette[3] = [4]int{}
+	}
+	var out [16][4]int
+	indices := binary.LittleEndian.Uint32(data[4:])
+	for i := range out {
+		out[i] = palette[indices>>(uint(i)*2)&3]
+	}
+	return out
+}
+
+// Decodes an ETC1 block into the 16 pixels of the block
+func decodeETC1Block(data []byte) [16][4]int {
+	v := binary.BigEndian.Uint64(data)
+	hi, lo := uint32(v>>32), uint32(v)
+	var bases [2][3]int
+	for k := 0; k < 3; k++ {
+		if hi&2 != 0 {
+			c := int(hi >> uint(27-k*8) & 31)
+			// The offset is a 3 bit signed value
+			d := int(int32(hi>>uint(24-k*8)&7<<29) >> 29)
+			bases[0][k] = c<<3 | c>>2
+			c += d
+			bases[1][k] = c<<3 | c>>2
+		} else {
+			bases[0][k] = int(hi>>uint(28-k*8)&15) * 17
+			bases[1][k] = int(hi>>uint(24-k*8)&15) * 17
+		}
+	}
+	tables := [2]int{int(hi >> 5 & 7), int(hi >> 2 & 7)}
+	flip := hi&1 != 0
+	var out [16][4]int
+	for i := range out {
+		x, y := i%4, i/4
+		half := 0
+		if (flip && y >= 2) || (!flip && x >= 2) {
+			half = 1
+		}
+		bit := uint(x*4 + y)
+		index := int(lo>>(16+bit)&1)<<1 | int(lo>>bit&1)
+		mods := etcModifiers[tables[half]]
+		m := [4]int{mods[0], mods[1], -mods[0], -mods[1]}[index]
+		for k := 0; k < 3; k++ {
+			out[i][k] = clampInt(bases[half][k]+m, 0, 255)
+		}
+		out[i][3] = 255
+	}
+	return out
+}
+
+// Decodes the alpha of an EAC block
+func decodeEACBlock(data []byte) [16]int {
+	v := binary.BigEndian.Uint64(data)
+	base, mult, table := int(v>>56), int(v>>52&15), int(v>>48&15)
+	var out [16]int
+	for i := range out {
+		j := uint((i%4)*4 + i/4)
+		out[i] = clampInt(base+eacModifiers[table][v>>(45-3*j)&7]*mult, 0, 255)
+	}
+	return out
+}
+
+// An image with a smooth diagonal gradient and a transparent corner to
+// compress, each block holds colours along a line so it can be encoded
+// closely by every format
+func testTextureImage() *image.NRGBA {
+	im := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			a := uint8(255)
+			if x >= 12 && y >= 12 {
+				a = 0
+			}
+			v := (x + y) * 8
+			im.SetNRGBA(x, y, color.NRGBA{uint8(v), uint8(v/2 + 64), 128, a})
+		}
+	}
+	return im
+}
+
+// Checks that every opaque pixel of the decoded blocks is close to the
+// original image
+func checkBlocks(t *testing.T, name string, im *image.NRGBA, data []byte, blockSize int, decode func([]byte) [16][4]int) {
+	if want := 16 * blockSize; len(data) != want {
+		t.Fatalf("Unexpected %s data size: want %d, got %d", name, want, len(data))
+	}
+	for b := 0; b < 16; b++ {
+		block := decode(data[b*blockSize:])
+		for i, p := range block {
+			x, y := (b%4)*4+i%4, (b/4)*4+i/4
+			want := im.NRGBAAt(x, y)
+			if absInt(p[3]-int(want.A)) > 8 {
+				t.Fatalf("Unexpected %s alpha at %d,%d: want %d, got %d", name, x, y, want.A, p[3])
+			}
+			if want.A == 0 {
+				continue
+			}
+			for k, c := range []uint8{want.R, want.G, want.B} {
+				if absInt(p[k]-int(c)) > 24 {
+					t.Fatalf("Unexpected %s colour at %d,%d: want %v, got %v", name, x, y, want, p)
+				}
+			}
+		}
+	}
+}
+
+func TestBlockCompression(t *testing.T) {
+	im := testTextureImage()
+	checkBlocks(t, "BC1", im, encodeBC1(im), 8, func(d []byte) [16][4]int {
+		return decodeBC1Block(d, true)
+	})
+	checkBlocks(t, "BC3", im, encodeBC3(im), 16, func(d []byte) [16][4]int {
+		px := decodeBC1Block(d[8:], false)
+		a0, a1 := int(d[0]), int(d[1])
+		indices := uint64(0)
+		for i := 0; i < 6; i++ {
+			indices |= uint64(d[2+i]) << (uint(i) * 8)
+		}
+		for i := range px {
+			index := int(indices >> (uint(i) * 3) & 7)
+			switch index {
+			case 0:
+				px[i][3] = a0
+			case 1:
+				px[i][3] = a1
+			default:
+				px[i][3] = ((8-index)*a0 + (index-1)*a1) / 7
+			}
+		}
+		return px
+	})
+	// ETC2 RGB has no alpha so check against an opaque copy
+	opaque := testTextureImage()
+	for i := 3; i < len(opaque.Pix); i += 4 {
+		opaque.Pix[i] = 255
+	}
+	checkBlocks(t, "ETC2_RGB", opaque, encodeETC1(opaque), 8, decodeETC1Block)
+	checkBlocks(t, "ETC2_RGBA", im, encodeETC2RGBA(im), 16, func(d []byte) [16][4]int {
+		px := decodeETC1Block(d[8:])
+		for i, a := range decodeEACBlock(d) {
+			px[i][3] = a
+		}
+		return px
+	})
+}
+
+func TestGenerateCompressed(t *testing.T) {
+	outputDir := t.TempDir()
+	files := []string{
+		"./fixtures/button.png",
+		"./fixtures/button_active.png",
+		"./fixtures/button_hover.png",
+	}
+	cases := []struct {
+		format    PixelFormat
+		container Container
+		magic     []byte
+	}{
+		{PIXEL_BC1, CONTAINER_DDS, []byte("DDS ")},
+		{PIXEL_BC3, CONTAINER_KTX, ktxIdentifier},
+		{PIXEL_ETC2_RGBA, CONTAINER_KTX, ktxIdentifier},
+		{PIXEL_RGB565, CONTAINER_DDS, []byte("DDS ")},
+	}
+	for _, c := range cases {
+		res, err := Generate(files, outputDir, &GenerateParams{
+			Name:        "test-compressed",
+			Padding:     1,
+			PixelFormat: c.format,
+			Container:   c.container,
+			BlockAlign:  true,
+		})
+		if err != nil {
+			t.Fatalf("Generate threw an error for %s: %s", c.format, err.Error())
+		}
+		atlas := res.Atlases[0]
+		data, err := ioutil.ReadFile(path.Join(outputDir, atlas.ImageName()))
+		if err != nil {
+			t.Fatalf("Texture was not written for %s: %s", c.format, err.Error())
+		}
+		if string(data[:len(c.magic)]) != string(c.magic) {
+			t.Errorf("Unexpected %s file for %s", c.container, c.format)
+		}
+		for _, file := range atlas.Files {
+			if file.X%BLOCK_SIZE != 0 || file.Y%BLOCK_SIZE != 0 || file.Width%BLOCK_SIZE != 0 || file.Height%BLOCK_SIZE != 0 {
+				t.Errorf("File %s is not aligned to blocks: %d,%d %dx%d", file.Name, file.X, file.Y, file.Width, file.Height)
+			}
+			im, _ := file.decode()
+			if file.Frame().Size() != im.Bounds().Size() {
+				t.Errorf("Unexpected frame for %s: want %v, got %v", file.Name, im.Bounds().Size(), file.Frame().Size())
+			}
+		}
+	}
+
+	if _, err := Generate(files, outputDir, &GenerateParams{PixelFormat: PIXEL_ETC2_RGB, Container: CONTAINER_DDS}); err == nil {
+		t.Errorf("Expected an error writing ETC2 to a DDS container")
+	}
+	if _, err := Generate(files, outputDir, &GenerateParams{PixelFormat: PIXEL_BC1}); err == nil {
+		t.Errorf("Expected an error writing BC1 to a PNG")
+	}
+}
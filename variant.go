@@ -0,0 +1,118 @@
+package atlas
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path"
+	"strings"
+)
+
+// Represents a scaled copy of the atlases, used to ship the same art at
+// several device densities. Every variant is packed separately but keeps
+// the same sprite names so they can be swapped at runtime
+type Variant struct {
+	// The scale of the variant relative to the source images, eg. 0.5
+	// for half size
+	Scale float64
+	// Appended to the name of each atlas of this variant, eg. "@2x"
+	Suffix string
+}
+
+// Returns copies of the given files resampled to the scale of the variant.
+// Positions and sizes within the source canvas, nine-slice borders and
+// companion images are scaled with the image. SVG images are rasterised
+// again at the new size rather than resampled. Files are copied without
+// being resampled when the scale is 1
+func variantFiles(files []*File, variant Variant) ([]*File, error) {
+	if variant.Scale <= 0 {
+		return nil, errors.New(fmt.Sprintf("Invalid scale %v for variant %s", variant.Scale, variant.Suffix))
+	}
+
+	scale := func(v int) int {
+		return int(math.Round(float64(v) * variant.Scale))
+	}
+	scaled := make([]*File, len(files))
+	for i, file := range files {
+		copied := *file
+		copied.Atlas = nil
+		if variant.Scale == 1 {
+			scaled[i] = &copied
+			continue
+		}
+		// Keep at least a single pixel so tiny images do not vanish
+		width, height := scale(file.Width), scale(file.Height)
+		if width < 1 {
+			width = 1
+		}
+		if height < 1 {
+			height = 1
+		}
+
+		if strings.ToLower(path.Ext(file.FileName)) == ".svg" {
+			// Vector images are drawn again at the new size so they stay
+			// as sharp as at the original scale
+			data, err := ioutil.ReadFile(file.FileName)
+			if err != nil {
+				return nil, err
+			}
+			if copied.Image, err = rasterizeSVG(data, 0, width, height); err != nil {
+				return nil, errors.New(fmt.Sprintf("Unable to rasterise %s: %s", file.FileName, err.Error()))
+			}
+		} else {
+			im, err := file.decode()
+			if err != nil {
+				return nil, err
+			}
+			copied.Image = resample(im, width, height)
+		}
+		copied.Width, copied.Height = width, height
+		if file.companions != nil {
+			copied.companions = make(map[string]*File, len(file.companions))
+			for suffix, companion := range file.companions {
+				cim, err := companion.decode()
+				if err != nil {
+					return nil, err
+				}
+				copied.companions[suffix] = &File{
+					FileName: companion.FileName,
+					Name:     companion.Name,
+					Image:    resample(cim, width, height),
+					Width:    width,
+					Height:   height,
+				}
+			}
+		}
+		copied.OffsetX, copied.OffsetY = scale(file.OffsetX), scale(file.OffsetY)
+		copied.SourceWidth, copied.SourceHeight = scale(file.SourceWidth), scale(file.SourceHeight)
+		if file.NineSlice != nil {
+			copied.NineSlice = &NineSlice{
+				Left:   scale(file.NineSlice.Left),
+				Top:    scale(file.NineSlice.Top),
+				Right:  scale(file.NineSlice.Right),
+				Bottom: scale(file.NineSlice.Bottom),
+			}
+		}
+		scaled[i] = &copied
+	}
+	return scaled, nil
+}
+
+// Returns the animations with their frames swapped for the matching files
+// of a variant, files and variant files must be in the same order
+func variantAnimations(animations []*Animation, files, variant []*File) []*Animation {
+	index := make(map[*File]*File, len(files))
+	for i, file := range files {
+		index[file] = variant[i]
+	}
+	res := make([]*Animation, len(animations))
+	for i, anim := range animations {
+		frames := make([]*File, len(anim.Frames))
+		for j, frame := range anim.Frames {
+			frames[j] = index[frame]
+		}
+		res[i] = &Animation{Name: anim.Name, Frames: frames}
+	}
+	return res
+}
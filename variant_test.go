@@ -0,0 +1,142 @@
+package atlas
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+func TestVariantFiles(t *testing.T) {
+	im := image.NewNRGBA(image.Rect(0, 0, 20, 10))
+	for i := 0; i < len(im.Pix); i += 4 {
+		copy(im.Pix[i:], []uint8{200, 100, 50, 255})
+	}
+	walk1 := &File{
+		Name:         "walk_1",
+		Image:        im,
+		Width:        20,
+		Height:       10,
+		OffsetX:      4,
+		OffsetY:      2,
+		SourceWidth:  32,
+		SourceHeight: 16,
+		NineSlice:    &NineSlice{2, 2, 4, 4},
+		Pivot:        &Pivot{0.5, 1},
+	}
+	walk2 := &File{Name: "walk_2", Image: im, Width: 20, Height: 10}
+	files := []*File{walk1, walk2}
+
+	scaled, err := variantFiles(files, Variant{Scale: 0.5, Suffix: "@0.5x"})
+	if err != nil {
+		t.Fatalf("variantFiles threw an error: %s", err.Error())
+	}
+	got := scaled[0]
+	if got == walk1 || got.Name != walk1.Name {
+		t.Errorf("Expected a copy of %s, got %s", walk1.Name, got.Name)
+	}
+	if got.Width != 10 || got.Height != 5 || got.Image.Bounds().Dx() != 10 || got.Image.Bounds().Dy() != 5 {
+		t.Errorf("Unexpected scaled size: want 10x5, got %dx%d", got.Width, got.Height)
+	}
+	if got.OffsetX != 2 || got.OffsetY != 1 || got.SourceWidth != 16 || got.SourceHeight != 8 {
+		t.Errorf("Unexpected scaled source: got offset %d,%d size %dx%d",
+			got.OffsetX, got.OffsetY, got.SourceWidth, got.SourceHeight)
+	}
+	if *got.NineSlice != (NineSlice{1, 1, 2, 2}) {
+		t.Errorf("Unexpected scaled nine-slice: got %v", *got.NineSlice)
+	}
+	if *got.Pivot != *walk1.Pivot {
+		t.Errorf("Expected the pivot to be unchanged, got %v", *got.Pivot)
+	}
+	// Resampling a solid image should keep its colour
+	want := color.NRGBA{200, 100, 50, 255}
+	if px := got.Image.At(5, 2); px != want {
+		t.Errorf("Unexpected resampled colour: want %v, got %v", want, px)
+	}
+	// The originals should be left alone
+	if walk1.Width != 20 || walk1.Image != im {
+		t.Errorf("variantFiles modified the source file")
+	}
+
+	animations := variantAnimations([]*Animation{{Name: "walk", Frames: files}}, files, scaled)
+	if animations[0].Frames[0] != scaled[0] || animations[0].Frames[1] != scaled[1] {
+		t.Errorf("Expected animation frames to be swapped for the variant files")
+	}
+
+	if _, err := variantFiles(files, Variant{Scale: 0}); err == nil {
+		t.Errorf("Expected an error for a variant with no scale")
+	}
+}
+
+func TestVariantSVG(t *testing.T) {
+	dir := t.TempDir()
+	filename := path.Join(dir, "icon.svg")
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"><rect width="5" height="10" fill="red"/></svg>`
+	if err := ioutil.WriteFile(filename, []byte(svg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files, _, err := readFiles(filename, &GenerateParams{SVGScale: 1})
+	if err != nil {
+		t.Fatalf("readFiles threw an error: %s", err.Error())
+	}
+
+	// The edge of the rectangle stays sharp at twice the size rather than
+	// being blurred by resampling
+	scaled, err := variantFiles(files, Variant{Scale: 2, Suffix: "@2x"})
+	if err != nil {
+		t.Fatalf("variantFiles threw an error: %s", err.Error())
+	}
+	im := scaled[0].Image
+	if im.Bounds().Size() != image.Pt(20, 20) {
+		t.Fatalf("Unexpected size: want 20x20, got %v", im.Bounds().Size())
+	}
+	if got := color.NRGBAModel.Convert(im.At(9, 5)); got != (color.NRGBA{255, 0, 0, 255}) {
+		t.Errorf("Unexpected pixel inside the edge: want opaque red, got %v", got)
+	}
+	if _, _, _, a := im.At(10, 5).RGBA(); a != 0 {
+		t.Errorf("Unexpected pixel outside the edge: want transparent, got alpha %d", a>>8)
+	}
+}
+
+func TestResampleTransparentEdges(t *testing.T) {
+	// Transparent pixels should not darken the edges of the image
+	im := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for y := 2; y < 6; y++ {
+		for x := 2; x < 6; x++ {
+			im.SetNRGBA(x, y, color.NRGBA{255, 255, 255, 255})
+		}
+	}
+	out := resample(im, 16, 16)
+	for i := 0; i < len(out.Pix); i += 4 {
+		if out.Pix[i+3] != 0 && out.Pix[i] != 255 {
+			t.Fatalf("Unexpected edge colour: got %v", out.Pix[i:i+4])
+		}
+	}
+}
+
+func TestGenerateVariants(t *testing.T) {
+	buttons := []string{"./fixtures/button.png", "./fixtures/button_active.png", "./fixtures/button_hover.png"}
+	res, _, _ := generateJSON(t, buttons, &GenerateParams{
+		Name:     "test-variants",
+		Variants: []Variant{{Scale: 1, Suffix: "@1x"}, {Scale: 2, Suffix: "@2x"}},
+	})
+	if len(res.Files) != len(buttons)*2 || len(res.Atlases) != 2 {
+		t.Errorf("Unexpected result: want %d files in 2 atlases, got %d files in %d atlases", len(buttons)*2, len(res.Files), len(res.Atlases))
+	}
+
+	// Suffixes that would give two images the same name are rejected
+	invalid := []*GenerateParams{
+		{Variants: []Variant{{Scale: 1}, {Scale: 2}}},
+		{Variants: []Variant{{Scale: 1}, {Scale: 2, Suffix: "@2x"}}},
+		{Variants: []Variant{{Scale: 1, Suffix: "@2x"}, {Scale: 2, Suffix: "@2x"}}},
+		{Variants: []Variant{{Scale: 1, Suffix: "@1x"}, {Scale: 2, Suffix: "2x"}}},
+		{Variants: []Variant{{Scale: 1, Suffix: "@1x"}, {Scale: 2, Suffix: "@1x_n"}}, Companions: []Companion{{Suffix: "_n"}}},
+		{Variants: []Variant{{Scale: 1, Suffix: "@1x"}, {Scale: 2, Suffix: "@1x_alpha"}}, AlphaSplit: ALPHA_SPLIT_GRAY},
+	}
+	for _, params := range invalid {
+		if _, err := Generate(buttons, t.TempDir(), params); err == nil {
+			t.Errorf("Expected an error for variants %v", params.Variants)
+		}
+	}
+}
@@ -0,0 +1,574 @@
+package atlas
+
+import (
+	"image"
+	"math"
+)
+
+// Macroblock prediction modes and token limits of the VP8 lossy format,
+// see https://datatracker.ietf.org/doc/html/rfc6386
+const (
+	vp8PredDC = iota
+	vp8PredV
+	vp8PredH
+	vp8PredTM
+)
+
+const (
+	vp8TypeYAC    = 0
+	vp8TypeY2     = 1
+	vp8TypeChroma = 2
+
+	vp8MaxLevel = 2047
+	// The quantised size a coefficient needs before it rounds up, as a
+	// fraction of the step. Below a half so that small values become zero
+	vp8RoundBias = 0.42
+)
+
+// The order coefficients are coded in and the band of each position, the
+// extra band is used for the context after the last coefficient
+var (
+	vp8Zigzag = [16]int{0, 1, 4, 8, 5, 2, 3, 6, 9, 12, 13, 10, 7, 11, 14, 15}
+	vp8Bands  = [17]int{0, 1, 2, 3, 6, 4, 5, 6, 6, 6, 6, 6, 6, 6, 6, 7, 0}
+)
+
+// The probabilities of the extra bits of each large token category
+var vp8CategoryProbs = [4][]int{
+	{173, 148, 140},
+	{176, 155, 140, 135},
+	{180, 157, 141, 134, 130},
+	{254, 254, 243, 230, 196, 177, 153, 140, 133, 130, 129},
+}
+
+// Writes boolean values coded with their probability of being false out
+// of 256, as described in section 7 of the specification
+type boolEncoder struct {
+	buf      []byte
+	rng      uint32
+	bottom   uint32
+	bitCount int
+}
+
+func newBoolEncoder() *boolEncoder {
+	return &boolEncoder{rng: 255, bitCount: 24}
+}
+
+func (e *boolEncoder) put(bit bool, prob int) {
+	split := 1 + (e.rng-1)*uint32(prob)>>8
+	if bit {
+		e.bottom += split
+		e.rng -= split
+	} else {
+		e.rng = split
+	}
+	for e.rng < 128 {
+		e.rng <<= 1
+		if e.bottom&(1<<31) != 0 {
+			e.carry()
+		}
+		e.bottom <<= 1
+		if e.bitCount--; e.bitCount == 0 {
+			e.buf = append(e.buf, byte(e.bottom>>24))
+			e.bottom &= 1<<24 - 1
+			e.bitCount = 8
+		}
+	}
+}
+
+// Writes an unsigned value of n bits with even probabilities, most
+// significant bit first
+func (e *boolEncoder) literal(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		e.put(v>>uint(i)&1 != 0, 128)
+	}
+}
+
+// Adds one to the bytes already written
+func (e *boolEncoder) carry() {
+	i := len(e.buf) - 1
+	for ; e.buf[i] == 255; i-- {
+		e.buf[i] = 0
+	}
+	e.buf[i]++
+}
+
+func (e *boolEncoder) bytes() []byte {
+	v := e.bottom
+	if v&(1<<uint(32-e.bitCount)) != 0 {
+		e.carry()
+	}
+	v <<= uint(e.bitCount & 7)
+	for c := e.bitCount >> 3; c > 0; c-- {
+		v <<= 8
+	}
+	for c := 0; c < 4; c++ {
+		e.buf = append(e.buf, byte(v>>24))
+		v <<= 8
+	}
+	return e.buf
+}
+
+// The quantiser steps of the DC and AC coefficients of a block type
+type vp8Quant [2]int
+
+func (q vp8Quant) step(i int) int {
+	if i == 0 {
+		return q[0]
+	}
+	return q[1]
+}
+
+// Holds the planes of an image being encoded and the state carried
+// between macroblocks
+type vp8Encoder struct {
+	mbw, mbh int
+	// Source and reconstructed planes padded to whole macroblocks
+	y, u, v    []uint8
+	ry, ru, rv []uint8
+	y1, y2, uv vp8Quant
+	header     *boolEncoder
+	tokens     *boolEncoder
+	// Whether the blocks above and to the left had non-zero coefficients
+	topNz  [][9]bool
+	leftNz [9]bool
+}
+
+// Encodes the image as a VP8 key frame, the quality from 0 to 100 sets
+// the quantiser. Alpha is ignored
+func encodeVP8(im *image.NRGBA, quality float64) []byte {
+	b := im.Bounds()
+	width, height := b.Dx(), b.Dy()
+	e := &vp8Encoder{
+		mbw:    (width + 15) / 16,
+		mbh:    (height + 15) / 16,
+		header: newBoolEncoder(),
+		tokens: newBoolEncoder(),
+	}
+	e.topNz = make([][9]bool, e.mbw)
+	e.toYUV(im)
+
+	qi := int(math.Floor((100-math.Max(0, math.Min(quality, 100)))*127/100 + 0.5))
+	e.y1 = vp8Quant{vp8DCTable[qi], vp8ACTable[qi]}
+	e.y2 = vp8Quant{vp8DCTable[qi] * 2, maxInt(vp8ACTable[qi]*155/100, 8)}
+	e.uv = vp8Quant{vp8DCTable[minInt(qi, 117)], vp8ACTable[qi]}
+
+	h := e.header
+	h.literal(0, 1) // Colour space
+	h.literal(0, 1) // Clamping required
+	h.literal(0, 1) // No segmentation
+	h.literal(0, 1) // Normal loop filter
+	h.literal(0, 6) // Loop filter level, disabling it
+	h.literal(0, 3) // Sharpness
+	h.literal(0, 1) // No loop filter adjustments
+	h.literal(0, 2) // One token partition
+	h.literal(qi, 7)
+	h.literal(0, 5) // No quantiser deltas
+	h.literal(0, 1) // Probabilities are not kept for later frames
+	for i := range vp8CoeffUpdateProbs {
+		for j := range vp8CoeffUpdateProbs[i] {
+			for k := range vp8CoeffUpdateProbs[i][j] {
+				for _, p := range vp8CoeffUpdateProbs[i][j][k] {
+					h.put(false, int(p))
+				}
+			}
+		}
+	}
+	h.literal(0, 1) // Every macroblock has coefficients
+
+	for mby := 0; mby < e.mbh; mby++ {
+		e.leftNz = [9]bool{}
+		for mbx := 0; mbx < e.mbw; mbx++ {
+			e.macroblock(mbx, mby)
+		}
+	}
+
+	first := h.bytes()
+	tokens := e.tokens.bytes()
+	frame := make([]byte, 10, 10+len(first)+len(tokens))
+	tag := 1<<4 | len(first)<<5 // Key frame, version 0, shown
+	frame[0], frame[1], frame[2] = byte(tag), byte(tag>>8), byte(tag>>16)
+	frame[3], frame[4], frame[5] = 0x9d, 0x01, 0x2a
+	frame[6], frame[7] = byte(width), byte(width>>8)
+	frame[8], frame[9] = byte(height), byte(height>>8)
+	frame = append(frame, first...)
+	return append(frame, tokens...)
+}
+
+// Converts the image to YUV 4:2:0 planes, repeating the edge pixels to
+// fill the last macroblocks
+func (e *vp8Encoder) toYUV(im *image.NRGBA) {
+	b := im.Bounds()
+	w, h := e.mbw*16, e.mbh*16
+	at := func(x, y int) []uint8 {
+		x, y = minInt(x, b.Dx()-1), minInt(y, b.Dy()-1)
+		i := im.PixOffset(b.Min.X+x, b.Min.Y+y)
+		return im.Pix[i : i+3]
+	}
+	e.y, e.ry = make([]uint8, w*h), make([]uint8, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := at(x, y)
+			e.y[y*w+x] = uint8((16839*int(p[0]) + 33059*int(p[1]) + 6420*int(p[2]) + 16<<16 + 1<<15) >> 16)
+		}
+	}
+	e.u, e.ru = make([]uint8, w*h/4), make([]uint8, w*h/4)
+	e.v, e.rv = make([]uint8, w*h/4), make([]uint8, w*h/4)
+	for y := 0; y < h/2; y++ {
+		for x := 0; x < w/2; x++ {
+			// Sum the four pixels covered by each chroma sample
+			r, g, bl := 0, 0, 0
+			for _, p := range [][]uint8{at(x*2, y*2), at(x*2+1, y*2), at(x*2, y*2+1), at(x*2+1, y*2+1)} {
+				r, g, bl = r+int(p[0]), g+int(p[1]), bl+int(p[2])
+			}
+			e.u[y*w/2+x] = uint8(clampInt((-9719*r-19081*g+28800*bl+128<<18+1<<17)>>18, 0, 255))
+			e.v[y*w/2+x] = uint8(clampInt((28800*r-24116*g-4684*bl+128<<18+1<<17)>>18, 0, 255))
+		}
+	}
+}
+
+// Chooses the prediction modes of a macroblock, writes them and its
+// coefficients and reconstructs it as the decoder will
+func (e *vp8Encoder) macroblock(mbx, mby int) {
+	stride, uvStride := e.mbw*16, e.mbw*8
+	yMode, yPred := bestPrediction(e.y, e.ry, stride, mbx, mby, 16)
+	uvMode, uPred := bestPrediction(e.u, e.ru, uvStride, mbx, mby, 8)
+	// Both chroma planes use the same mode so it is chosen from U alone
+	vPred := prediction(e.rv, uvStride, mbx, mby, 8, uvMode)
+
+	// Luma with the kf_ymode_tree, B_PRED is never used
+	h := e.header
+	h.put(true, 145)
+	h.put(yMode == vp8PredH || yMode == vp8PredTM, 156)
+	if yMode == vp8PredH || yMode == vp8PredTM {
+		h.put(yMode == vp8PredTM, 128)
+	} else {
+		h.put(yMode == vp8PredV, 163)
+	}
+	h.put(uvMode != vp8PredDC, 142)
+	if uvMode != vp8PredDC {
+		h.put(uvMode != vp8PredV, 114)
+		if uvMode != vp8PredV {
+			h.put(uvMode == vp8PredTM, 183)
+		}
+	}
+
+	// Transform the luma blocks, their DC coefficients are transformed
+	// again as the Y2 block
+	var coeffs [16][16]int
+	var dc [16]int
+	for i := range coeffs {
+		x, y := mbx*16+i%4*4, mby*16+i/4*4
+		coeffs[i] = forwardDCT(e.y, stride, x, y, yPred[i/4*4*16+i%4*4:], 16)
+		dc[i] = coeffs[i][0]
+	}
+	y2 := quantizeBlock(forwardWHT(dc), e.y2, 0)
+	top, left := &e.topNz[mbx], &e.leftNz
+	top[8] = e.writeCoeffs(vp8TypeY2, y2, 0, top[8], left[8])
+	left[8] = top[8]
+	dc = inverseWHT(dequantizeBlock(y2, e.y2))
+	for i := range coeffs {
+		levels := quantizeBlock(coeffs[i], e.y1, 1)
+		nz := e.writeCoeffs(vp8TypeYAC, levels, 1, top[i%4], left[i/4])
+		top[i%4], left[i/4] = nz, nz
+		deq := dequantizeBlock(levels, e.y1)
+		deq[0] = dc[i]
+		x, y := mbx*16+i%4*4, mby*16+i/4*4
+		inverseDCT(deq, yPred[i/4*4*16+i%4*4:], 16, e.ry, stride, x, y)
+	}
+
+	for plane, pred := range [][]int{uPred, vPred} {
+		src, rec := e.u, e.ru
+		if plane == 1 {
+			src, rec = e.v, e.rv
+		}
+		for i := 0; i < 4; i++ {
+			x, y := mbx*8+i%2*4, mby*8+i/2*4
+			p := pred[i/2*4*8+i%2*4:]
+			levels := quantizeBlock(forwardDCT(src, uvStride, x, y, p, 8), e.uv, 0)
+			ctx := 4 + plane*2
+			nz := e.writeCoeffs(vp8TypeChroma, levels, 0, top[ctx+i%2], left[ctx+i/2])
+			top[ctx+i%2], left[ctx+i/2] = nz, nz
+			inverseDCT(dequantizeBlock(levels, e.uv), p, 8, rec, uvStride, x, y)
+		}
+	}
+}
+
+// Tries each prediction mode against the source block, returning the mode
+// with the smallest squared error and its prediction
+func bestPrediction(src, rec []uint8, stride, mbx, mby, size int) (int, []int) {
+	best, bestPred, bestErr := 0, []int(nil), -1
+	for mode := vp8PredDC; mode <= vp8PredTM; mode++ {
+		pred := prediction(rec, stride, mbx, mby, size, mode)
+		err := 0
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				d := int(src[(mby*size+y)*stride+mbx*size+x]) - pred[y*size+x]
+				err += d * d
+			}
+		}
+		if bestErr == -1 || err < bestErr {
+			best, bestPred, bestErr = mode, pred, err
+		}
+	}
+	return best, bestPred
+}
+
+// Predicts a block from the reconstructed pixels above and to the left of
+// it. Pixels above the image are treated as 127 and pixels to its left as
+// 129, except that DC prediction averages only the edges it has
+func prediction(rec []uint8, stride, mbx, mby, size, mode int) []int {
+	x0, y0 := mbx*size, mby*size
+	top, left := make([]int, size), make([]int, size)
+	topLeft := 127
+	for i := 0; i < size; i++ {
+		top[i], left[i] = 127, 129
+		if mby > 0 {
+			top[i] = int(rec[(y0-1)*stride+x0+i])
+		}
+		if mbx > 0 {
+			left[i] = int(rec[(y0+i)*stride+x0-1])
+		}
+	}
+	if mby > 0 && mbx == 0 {
+		topLeft = 129
+	} else if mby > 0 {
+		topLeft = int(rec[(y0-1)*stride+x0-1])
+	}
+
+	shift := uint(3)
+	if size == 16 {
+		shift = 4
+	}
+	dc := 128
+	sum := func(v []int) int {
+		s := 0
+		for _, n := range v {
+			s += n
+		}
+		return s
+	}
+	switch {
+	case mbx > 0 && mby > 0:
+		dc = (sum(top) + sum(left) + size) >> (shift + 1)
+	case mby > 0:
+		dc = (sum(top) + size/2) >> shift
+	case mbx > 0:
+		dc = (sum(left) + size/2) >> shift
+	}
+
+	pred := make([]int, size*size)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			switch mode {
+			case vp8PredDC:
+				pred[y*size+x] = dc
+			case vp8PredV:
+				pred[y*size+x] = top[x]
+			case vp8PredH:
+				pred[y*size+x] = left[y]
+			case vp8PredTM:
+				pred[y*size+x] = clampInt(left[y]+top[x]-topLeft, 0, 255)
+			}
+		}
+	}
+	return pred
+}
+
+// Transforms the difference between a 4x4 block of the source and its
+// prediction, matching the integer transform of libwebp
+func forwardDCT(src []uint8, stride, x, y int, pred []int, predStride int) [16]int {
+	var tmp, out [16]int
+	for i := 0; i < 4; i++ {
+		row := src[(y+i)*stride+x:]
+		p := pred[i*predStride:]
+		d0, d1 := int(row[0])-p[0], int(row[1])-p[1]
+		d2, d3 := int(row[2])-p[2], int(row[3])-p[3]
+		a0, a1, a2, a3 := d0+d3, d1+d2, d1-d2, d0-d3
+		tmp[i*4] = (a0 + a1) * 8
+		tmp[i*4+1] = (a2*2217 + a3*5352 + 1812) >> 9
+		tmp[i*4+2] = (a0 - a1) * 8
+		tmp[i*4+3] = (a3*2217 - a2*5352 + 937) >> 9
+	}
+	for i := 0; i < 4; i++ {
+		a0, a1 := tmp[i]+tmp[12+i], tmp[4+i]+tmp[8+i]
+		a2, a3 := tmp[4+i]-tmp[8+i], tmp[i]-tmp[12+i]
+		out[i] = (a0 + a1 + 7) >> 4
+		out[4+i] = (a2*2217 + a3*5352 + 12000) >> 16
+		if a3 != 0 {
+			out[4+i]++
+		}
+		out[8+i] = (a0 - a1 + 7) >> 4
+		out[12+i] = (a3*2217 - a2*5352 + 51000) >> 16
+	}
+	return out
+}
+
+// Adds the inverse transform of the coefficients to the prediction,
+// writing the reconstructed block
+func inverseDCT(in [16]int, pred []int, predStride int, dst []uint8, stride, x, y int) {
+	mul1 := func(a int) int { return (a*20091)>>16 + a }
+	mul2 := func(a int) int { return (a * 35468) >> 16 }
+	var tmp [16]int
+	for i := 0; i < 4; i++ {
+		a, b := in[i]+in[8+i], in[i]-in[8+i]
+		c := mul2(in[4+i]) - mul1(in[12+i])
+		d := mul1(in[4+i]) + mul2(in[12+i])
+		tmp[i*4], tmp[i*4+1], tmp[i*4+2], tmp[i*4+3] = a+d, b+c, b-c, a-d
+	}
+	for i := 0; i < 4; i++ {
+		dc := tmp[i] + 4
+		a, b := dc+tmp[8+i], dc-tmp[8+i]
+		c := mul2(tmp[4+i]) - mul1(tmp[12+i])
+		d := mul1(tmp[4+i]) + mul2(tmp[12+i])
+		row := dst[(y+i)*stride+x:]
+		p := pred[i*predStride:]
+		for j, v := range [4]int{a + d, b + c, b - c, a - d} {
+			row[j] = uint8(clampInt(p[j]+v>>3, 0, 255))
+		}
+	}
+}
+
+// Transforms the DC coefficients of the 16 luma blocks
+func forwardWHT(in [16]int) [16]int {
+	var tmp, out [16]int
+	for i := 0; i < 4; i++ {
+		r := in[i*4:]
+		a0, a1, a2, a3 := r[0]+r[2], r[1]+r[3], r[1]-r[3], r[0]-r[2]
+		tmp[i*4], tmp[i*4+1], tmp[i*4+2], tmp[i*4+3] = a0+a1, a3+a2, a3-a2, a0-a1
+	}
+	for i := 0; i < 4; i++ {
+		a0, a1 := tmp[i]+tmp[8+i], tmp[4+i]+tmp[12+i]
+		a2, a3 := tmp[4+i]-tmp[12+i], tmp[i]-tmp[8+i]
+		out[i], out[4+i], out[8+i], out[12+i] = (a0+a1)>>1, (a3+a2)>>1, (a3-a2)>>1, (a0-a1)>>1
+	}
+	return out
+}
+
+func inverseWHT(in [16]int) [16]int {
+	var tmp, out [16]int
+	for i := 0; i < 4; i++ {
+		a0, a1 := in[i]+in[12+i], in[4+i]+in[8+i]
+		a2, a3 := in[4+i]-in[8+i], in[i]-in[12+i]
+		tmp[i], tmp[8+i], tmp[4+i], tmp[12+i] = a0+a1, a0-a1, a3+a2, a3-a2
+	}
+	for i := 0; i < 4; i++ {
+		t := tmp[i*4:]
+		dc := t[0] + 3
+		a0, a1, a2, a3 := dc+t[3], t[1]+t[2], t[1]-t[2], dc-t[3]
+		out[i*4], out[i*4+1], out[i*4+2], out[i*4+3] = (a0+a1)>>3, (a3+a2)>>3, (a0-a1)>>3, (a3-a2)>>3
+	}
+	return out
+}
+
+// Quantises the coefficients from the first one on, leaving the others 0
+func quantizeBlock(coeffs [16]int, q vp8Quant, first int) [16]int {
+	var levels [16]int
+	for i := first; i < 16; i++ {
+		step := q.step(i)
+		level := minInt(int(float64(absInt(coeffs[i]))/float64(step)+vp8RoundBias), vp8MaxLevel)
+		if coeffs[i] < 0 {
+			level = -level
+		}
+		levels[i] = level
+	}
+	return levels
+}
+
+func dequantizeBlock(levels [16]int, q vp8Quant) [16]int {
+	var out [16]int
+	for i, l := range levels {
+		out[i] = l * q.step(i)
+	}
+	return out
+}
+
+// Writes the tokens of a block of quantised coefficients, returning
+// whether any were non-zero for the context of the following blocks
+func (e *vp8Encoder) writeCoeffs(blockType int, levels [16]int, first int, top, left bool) bool {
+	probs := &vp8DefaultCoeffProbs[blockType]
+	ctx := 0
+	if top {
+		ctx++
+	}
+	if left {
+		ctx++
+	}
+	last := -1
+	for n := first; n < 16; n++ {
+		if levels[vp8Zigzag[n]] != 0 {
+			last = n
+		}
+	}
+	t := e.tokens
+	p := probs[vp8Bands[first]][ctx][:]
+	if last < 0 {
+		t.put(false, int(p[0])) // End of block
+		return false
+	}
+	for n := first; n <= last; n++ {
+		t.put(true, int(p[0]))
+		for levels[vp8Zigzag[n]] == 0 {
+			t.put(false, int(p[1]))
+			n++
+			p = probs[vp8Bands[n]][0][:]
+		}
+		t.put(true, int(p[1]))
+
+		level := levels[vp8Zigzag[n]]
+		v := absInt(level)
+		if v == 1 {
+			t.put(false, int(p[2]))
+			p = probs[vp8Bands[n+1]][1][:]
+		} else {
+			t.put(true, int(p[2]))
+			writeLargeValue(t, v, p)
+			p = probs[vp8Bands[n+1]][2][:]
+		}
+		t.put(level < 0, 128)
+	}
+	if last < 15 {
+		t.put(false, int(p[0]))
+	}
+	return true
+}
+
+// Writes the token of a coefficient of 2 or more followed by its extra bits
+func writeLargeValue(t *boolEncoder, v int, p []uint8) {
+	switch {
+	case v <= 4:
+		t.put(false, int(p[3]))
+		t.put(v != 2, int(p[4]))
+		if v != 2 {
+			t.put(v == 4, int(p[5]))
+		}
+	case v <= 10:
+		t.put(true, int(p[3]))
+		t.put(false, int(p[6]))
+		t.put(v > 6, int(p[7]))
+		if v <= 6 {
+			t.put(v == 6, 159)
+		} else {
+			t.put((v-7)>>1 != 0, 165)
+			t.put((v-7)&1 != 0, 145)
+		}
+	default:
+		t.put(true, int(p[3]))
+		t.put(true, int(p[6]))
+		cat := 3
+		switch {
+		case v <= 18:
+			cat = 0
+		case v <= 34:
+			cat = 1
+		case v <= 66:
+			cat = 2
+		}
+		t.put(cat >= 2, int(p[8]))
+		t.put(cat&1 != 0, int(p[9+cat>>1]))
+		extra := v - 3 - 8<<uint(cat)
+		bits := vp8CategoryProbs[cat]
+		for i, prob := range bits {
+			t.put(extra>>uint(len(bits)-1-i)&1 != 0, prob)
+		}
+	}
+}
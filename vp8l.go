@@ -0,0 +1,573 @@
+package atlas
+
+import (
+	"image"
+	"sort"
+)
+
+// Transform types and limits of the VP8L lossless format, see
+// https://developers.google.com/speed/webp/docs/webp_lossless_bitstream_specification
+const (
+	vp8lSignature     = 0x2f
+	vp8lPredictor     = 0
+	vp8lSubtractGreen = 2
+
+	// Predictor modes are chosen for blocks of 2^vp8lBlockBits pixels
+	vp8lBlockBits = 5
+
+	vp8lMaxLength     = 4096
+	vp8lMinLength     = 3
+	vp8lMaxCodeLength = 15
+	// The number of length prefix codes that follow the 256 green literals
+	vp8lLengthCodes   = 24
+	vp8lDistanceCodes = 40
+	// Distances are written after the 120 short codes for nearby pixels
+	vp8lDistanceOffset = 120
+)
+
+// The order code lengths of the code length code are written in
+var vp8lCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// Writes values to a byte slice least significant bit first
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+}
+
+func (w *bitWriter) write(v uint32, n uint) {
+	w.acc |= uint64(v) << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.nbits -= 8
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	if w.nbits > 0 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc, w.nbits = 0, 0
+	}
+	return w.buf
+}
+
+// A symbol to be entropy coded, either a literal pixel or a backward
+// reference copying length pixels from distance pixels back
+type vp8lSymbol struct {
+	argb             uint32
+	length, distance int
+}
+
+// A canonical prefix code, codes are stored bit reversed so they can be
+// written least significant bit first
+type prefixCode struct {
+	lengths []int
+	codes   []uint32
+}
+
+func (c *prefixCode) write(w *bitWriter, symbol int) {
+	w.write(c.codes[symbol], uint(c.lengths[symbol]))
+}
+
+// Encodes the image as a complete VP8L bitstream including its header
+func encodeVP8L(im *image.NRGBA) []byte {
+	b := im.Bounds()
+	w := &bitWriter{}
+	w.write(vp8lSignature, 8)
+	w.write(uint32(b.Dx()-1), 14)
+	w.write(uint32(b.Dy()-1), 14)
+	opaque := im.Opaque()
+	if opaque {
+		w.write(0, 1)
+	} else {
+		w.write(1, 1)
+	}
+	w.write(0, 3) // Version
+	writeVP8LImage(w, argbPixels(im), b.Dx(), b.Dy())
+	return w.bytes()
+}
+
+// Returns the pixels of the image packed as ARGB values
+func argbPixels(im *image.NRGBA) []uint32 {
+	b := im.Bounds()
+	pix := make([]uint32, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := im.Pix[im.PixOffset(b.Min.X, y):]
+		for x := 0; x < b.Dx(); x++ {
+			p := row[x*4 : x*4+4]
+			pix = append(pix, uint32(p[3])<<24|uint32(p[0])<<16|uint32(p[1])<<8|uint32(p[2]))
+		}
+	}
+	return pix
+}
+
+// Writes the transforms and entropy coded pixels of an image. The green
+// channel is subtracted from red and blue and then each pixel is predicted
+// from its neighbours, leaving small residuals that compress well
+func writeVP8LImage(w *bitWriter, pix []uint32, width, height int) {
+	pix = append([]uint32(nil), pix...)
+	for i, p := range pix {
+		g := p >> 8 & 0xff
+		r := (p>>16 - g) & 0xff
+		b := (p - g) & 0xff
+		pix[i] = p&0xff00ff00 | r<<16 | b
+	}
+	w.write(1, 1)
+	w.write(vp8lSubtractGreen, 2)
+
+	modes, residuals := vp8lPredict(pix, width, height)
+	w.write(1, 1)
+	w.write(vp8lPredictor, 2)
+	w.write(vp8lBlockBits-2, 3)
+	blocksWide := (width + 1<<vp8lBlockBits - 1) >> vp8lBlockBits
+	blocksHigh := (height + 1<<vp8lBlockBits - 1) >> vp8lBlockBits
+	writeVP8LEntropyImage(w, modes, blocksWide, blocksHigh, false)
+
+	w.write(0, 1) // No more transforms
+	writeVP8LEntropyImage(w, residuals, width, height, true)
+}
+
+// Writes an entropy coded image, the main image also says that it uses a
+// single group of prefix codes for every pixel
+func writeVP8LEntropyImage(w *bitWriter, pix []uint32, width, height int, main bool) {
+	symbols := vp8lBackwardRefs(pix, width)
+	w.write(0, 1) // No colour cache
+	if main {
+		w.write(0, 1) // No meta prefix codes
+	}
+
+	// Count the symbols of each of the five alphabets
+	counts := [5][]int{
+		make([]int, 256+vp8lLengthCodes),
+		make([]int, 256),
+		make([]int, 256),
+		make([]int, 256),
+		make([]int, vp8lDistanceCodes),
+	}
+	for _, s := range symbols {
+		if s.length == 0 {
+			counts[0][s.argb>>8&0xff]++
+			counts[1][s.argb>>16&0xff]++
+			counts[2][s.argb&0xff]++
+			counts[3][s.argb>>24]++
+		} else {
+			code, _, _ := vp8lPrefix(s.length)
+			counts[0][256+code]++
+			code, _, _ = vp8lPrefix(s.distance + vp8lDistanceOffset)
+			counts[4][code]++
+		}
+	}
+	var codes [5]*prefixCode
+	for i, c := range counts {
+		codes[i] = writePrefixCode(w, c)
+	}
+
+	for _, s := range symbols {
+		if s.length == 0 {
+			codes[0].write(w, int(s.argb>>8&0xff))
+			codes[1].write(w, int(s.argb>>16&0xff))
+			codes[2].write(w, int(s.argb&0xff))
+			codes[3].write(w, int(s.argb>>24))
+			continue
+		}
+		code, bits, extra := vp8lPrefix(s.length)
+		codes[0].write(w, 256+code)
+		w.write(extra, bits)
+		code, bits, extra = vp8lPrefix(s.distance + vp8lDistanceOffset)
+		codes[4].write(w, code)
+		w.write(extra, bits)
+	}
+}
+
+// Splits a length or distance into its prefix code and the extra bits
+// that follow it
+func vp8lPrefix(v int) (code int, bits uint, extra uint32) {
+	v--
+	if v < 4 {
+		return v, 0, 0
+	}
+	high := uint(0)
+	for v>>(high+1) != 0 {
+		high++
+	}
+	second := v >> (high - 1) & 1
+	return int(2*high) + second, high - 1, uint32(v & (1<<(high-1) - 1))
+}
+
+// Finds runs of pixels repeated from earlier in the image, checking the
+// previous pixel, the pixel above and the last place the next few pixels
+// were seen
+func vp8lBackwardRefs(pix []uint32, width int) []vp8lSymbol {
+	symbols := make([]vp8lSymbol, 0, len(pix))
+	hash := func(i int) uint32 {
+		return (pix[i]*0x1e35a7bd ^ pix[i+1]*0x9e3779b1 ^ pix[i+2]) * 0x85ebca6b >> 16
+	}
+	last := make(map[uint32]int)
+	matchLength := func(i, from int) int {
+		n := 0
+		for i+n < len(pix) && n < vp8lMaxLength && pix[from+n] == pix[i+n] {
+			n++
+		}
+		return n
+	}
+	for i := 0; i < len(pix); {
+		bestLength, bestDistance := 0, 0
+		candidates := []int{i - 1, i - width}
+		if i+2 < len(pix) {
+			h := hash(i)
+			if j, ok := last[h]; ok {
+				candidates = append(candidates, j)
+			}
+			last[h] = i
+		}
+		for _, from := range candidates {
+			if from < 0 || from >= i {
+				continue
+			}
+			if n := matchLength(i, from); n > bestLength {
+				bestLength, bestDistance = n, i-from
+			}
+		}
+		if bestLength < vp8lMinLength {
+			symbols = append(symbols, vp8lSymbol{argb: pix[i]})
+			i++
+			continue
+		}
+		symbols = append(symbols, vp8lSymbol{length: bestLength, distance: bestDistance})
+		// Remember where the copied pixels were seen for later matches
+		for j := i + 1; j < i+bestLength && j+2 < len(pix); j++ {
+			last[hash(j)] = j
+		}
+		i += bestLength
+	}
+	return symbols
+}
+
+// Chooses the predictor for each block of the image, returning the modes
+// as an image and the residuals left after prediction
+func vp8lPredict(pix []uint32, width, height int) ([]uint32, []uint32) {
+	blocksWide := (width + 1<<vp8lBlockBits - 1) >> vp8lBlockBits
+	blocksHigh := (height + 1<<vp8lBlockBits - 1) >> vp8lBlockBits
+	modes := make([]uint32, blocksWide*blocksHigh)
+	residuals := make([]uint32, len(pix))
+	// A selection of the 14 predictors that suit most images
+	candidates := []int{1, 2, 7, 11, 12, 13}
+
+	for by := 0; by < blocksHigh; by++ {
+		for bx := 0; bx < blocksWide; bx++ {
+			x0, y0 := bx<<vp8lBlockBits, by<<vp8lBlockBits
+			x1, y1 := minInt(x0+1<<vp8lBlockBits, width), minInt(y0+1<<vp8lBlockBits, height)
+			best, bestCost := 1, -1
+			for _, mode := range candidates {
+				cost := 0
+				for y := y0; y < y1; y++ {
+					for x := x0; x < x1; x++ {
+						r := argbSub(pix[y*width+x], vp8lPrediction(pix, width, x, y, mode))
+						for shift := uint(0); shift < 32; shift += 8 {
+							// Residuals near 0 or 255 are both small
+							v := int(int8(r >> shift))
+							cost += absInt(v)
+						}
+					}
+				}
+				if bestCost == -1 || cost < bestCost {
+					best, bestCost = mode, cost
+				}
+			}
+			modes[by*blocksWide+bx] = 0xff000000 | uint32(best)<<8
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					residuals[y*width+x] = argbSub(pix[y*width+x], vp8lPrediction(pix, width, x, y, best))
+				}
+			}
+		}
+	}
+	return modes, residuals
+}
+
+// Predicts the pixel at x,y from its neighbours with the given mode. The
+// first pixel is predicted as opaque black, the rest of the top row from
+// the left and the left column from above
+func vp8lPrediction(pix []uint32, width, x, y, mode int) uint32 {
+	i := y*width + x
+	switch {
+	case x == 0 && y == 0:
+		return 0xff000000
+	case y == 0:
+		return pix[i-1]
+	case x == 0:
+		return pix[i-width]
+	}
+	l, t, tl, tr := pix[i-1], pix[i-width], pix[i-width-1], pix[i-width+1]
+	switch mode {
+	case 1:
+		return l
+	case 2:
+		return t
+	case 3:
+		return tr
+	case 4:
+		return tl
+	case 5:
+		return argbAverage(argbAverage(l, tr), t)
+	case 6:
+		return argbAverage(l, tl)
+	case 7:
+		return argbAverage(l, t)
+	case 8:
+		return argbAverage(tl, t)
+	case 9:
+		return argbAverage(t, tr)
+	case 10:
+		return argbAverage(argbAverage(l, tl), argbAverage(t, tr))
+	case 11:
+		// Pick whichever of left and top is closer to the gradient
+		pl, pt := 0, 0
+		for shift := uint(0); shift < 32; shift += 8 {
+			cl, ct, ctl := int(l>>shift&0xff), int(t>>shift&0xff), int(tl>>shift&0xff)
+			estimate := cl + ct - ctl
+			pl += absInt(estimate - cl)
+			pt += absInt(estimate - ct)
+		}
+		if pl < pt {
+			return l
+		}
+		return t
+	case 12:
+		return argbMap3(l, t, tl, func(a, b, c int) int { return a + b - c })
+	case 13:
+		return argbMap3(argbAverage(l, t), tl, 0, func(a, b, _ int) int { return a + (a-b)/2 })
+	}
+	return 0xff000000
+}
+
+func argbAverage(a, b uint32) uint32 {
+	return argbMap3(a, b, 0, func(a, b, _ int) int { return (a + b) / 2 })
+}
+
+// Applies fn to each channel of the pixels clamping the result to a byte
+func argbMap3(a, b, c uint32, fn func(a, b, c int) int) uint32 {
+	var out uint32
+	for shift := uint(0); shift < 32; shift += 8 {
+		v := fn(int(a>>shift&0xff), int(b>>shift&0xff), int(c>>shift&0xff))
+		out |= uint32(clampInt(v, 0, 255)) << shift
+	}
+	return out
+}
+
+// Subtracts each channel of b from a modulo 256
+func argbSub(a, b uint32) uint32 {
+	var out uint32
+	for shift := uint(0); shift < 32; shift += 8 {
+		out |= (a>>shift - b>>shift) & 0xff << shift
+	}
+	return out
+}
+
+// Builds a prefix code for the symbol counts and writes it, returning the
+// code to write symbols with
+func writePrefixCode(w *bitWriter, counts []int) *prefixCode {
+	used := make([]int, 0, 2)
+	for symbol, n := range counts {
+		if n > 0 {
+			used = append(used, symbol)
+		}
+	}
+	code := &prefixCode{lengths: make([]int, len(counts)), codes: make([]uint32, len(counts))}
+
+	// One or two symbols below 256 can use the simple form, a single
+	// symbol takes no bits to write
+	if len(used) <= 2 && (len(used) == 0 || used[len(used)-1] < 256) {
+		if len(used) == 0 {
+			used = append(used, 0)
+		}
+		w.write(1, 1)
+		w.write(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			w.write(0, 1)
+			w.write(uint32(used[0]), 1)
+		} else {
+			w.write(1, 1)
+			w.write(uint32(used[0]), 8)
+		}
+		if len(used) == 2 {
+			w.write(uint32(used[1]), 8)
+			code.lengths[used[0]], code.lengths[used[1]] = 1, 1
+			code.codes[used[1]] = 1
+		}
+		return code
+	}
+
+	lengths := huffmanLengths(counts, vp8lMaxCodeLength)
+	if len(used) == 1 {
+		// A single symbol is read without using any bits
+		lengths[used[0]] = 1
+	}
+	writeCodeLengths(w, lengths)
+	if len(used) > 1 {
+		code.lengths = lengths
+		code.codes = canonicalCodes(lengths)
+	}
+	return code
+}
+
+// Writes the code lengths of a prefix code using the code length code,
+// runs of zeros are written with the repeat codes
+func writeCodeLengths(w *bitWriter, lengths []int) {
+	type token struct {
+		symbol int
+		extra  uint32
+		bits   uint
+	}
+	tokens := make([]token, 0, len(lengths))
+	for i := 0; i < len(lengths); {
+		if lengths[i] != 0 {
+			tokens = append(tokens, token{symbol: lengths[i]})
+			i++
+			continue
+		}
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == 0 && run < 138 {
+			run++
+		}
+		switch {
+		case run >= 11:
+			tokens = append(tokens, token{18, uint32(run - 11), 7})
+		case run >= 3:
+			tokens = append(tokens, token{17, uint32(run - 3), 3})
+		default:
+			for j := 0; j < run; j++ {
+				tokens = append(tokens, token{symbol: 0})
+			}
+		}
+		i += run
+	}
+
+	counts := make([]int, 19)
+	for _, t := range tokens {
+		counts[t.symbol]++
+	}
+	codeLengths := huffmanLengths(counts, 7)
+	used := 0
+	for _, n := range counts {
+		if n > 0 {
+			used++
+		}
+	}
+	if used == 1 {
+		// Give the only symbol a second entry so it still takes a bit,
+		// the code length code can not be a zero bit code
+		for s, n := range counts {
+			if n == 0 {
+				codeLengths[s] = 1
+				break
+			}
+		}
+		for s, n := range counts {
+			if n > 0 {
+				codeLengths[s] = 1
+			}
+		}
+	}
+	codes := canonicalCodes(codeLengths)
+
+	written := len(vp8lCodeLengthOrder)
+	for written > 4 && codeLengths[vp8lCodeLengthOrder[written-1]] == 0 {
+		written--
+	}
+	w.write(0, 1) // Normal code
+	w.write(uint32(written-4), 4)
+	for _, s := range vp8lCodeLengthOrder[:written] {
+		w.write(uint32(codeLengths[s]), 3)
+	}
+	w.write(0, 1) // Code lengths are given for the whole alphabet
+	for _, t := range tokens {
+		w.write(codes[t.symbol], uint(codeLengths[t.symbol]))
+		w.write(t.extra, t.bits)
+	}
+}
+
+// Works out Huffman code lengths for the symbol counts no longer than
+// maxLength, flattening the counts until the code fits
+func huffmanLengths(counts []int, maxLength int) []int {
+	lengths := make([]int, len(counts))
+	scaled := append([]int(nil), counts...)
+	for {
+		type node struct {
+			count       int
+			symbol      int
+			left, right *node
+		}
+		nodes := make([]*node, 0, len(scaled))
+		for s, n := range scaled {
+			if n > 0 {
+				nodes = append(nodes, &node{count: n, symbol: s})
+			}
+		}
+		if len(nodes) == 0 {
+			return lengths
+		}
+		if len(nodes) == 1 {
+			lengths[nodes[0].symbol] = 1
+			return lengths
+		}
+		for len(nodes) > 1 {
+			sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].count < nodes[j].count })
+			merged := &node{count: nodes[0].count + nodes[1].count, symbol: -1, left: nodes[0], right: nodes[1]}
+			nodes = append(nodes[2:], merged)
+		}
+		longest := 0
+		var walk func(n *node, depth int)
+		walk = func(n *node, depth int) {
+			if n.symbol >= 0 {
+				lengths[n.symbol] = depth
+				longest = maxInt(longest, depth)
+				return
+			}
+			walk(n.left, depth+1)
+			walk(n.right, depth+1)
+		}
+		walk(nodes[0], 0)
+		if longest <= maxLength {
+			return lengths
+		}
+		for s, n := range scaled {
+			if n > 0 {
+				scaled[s] = n>>1 + 1
+			}
+		}
+	}
+}
+
+// Assigns canonical codes to the code lengths, bit reversed so that they
+// can be written least significant bit first
+func canonicalCodes(lengths []int) []uint32 {
+	codes := make([]uint32, len(lengths))
+	count := make([]int, vp8lMaxCodeLength+1)
+	for _, l := range lengths {
+		if l > 0 {
+			count[l]++
+		}
+	}
+	next := make([]uint32, vp8lMaxCodeLength+2)
+	code := uint32(0)
+	for l := 1; l <= vp8lMaxCodeLength; l++ {
+		code = (code + uint32(count[l-1])) << 1
+		next[l] = code
+	}
+	for s, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		c := next[l]
+		next[l]++
+		reversed := uint32(0)
+		for i := 0; i < l; i++ {
+			reversed = reversed<<1 | c>>uint(i)&1
+		}
+		codes[s] = reversed
+	}
+	return codes
+}
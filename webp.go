@@ -0,0 +1,109 @@
+package atlas
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+)
+
+// WebP container flags, see
+// https://developers.google.com/speed/webp/docs/riff_container
+const (
+	webpFlagAlpha = 0x10
+	// The alpha chunk is compressed with the lossless format
+	webpAlphaLossless = 1
+	// Widths and heights are stored in 14 bits
+	webpMaxSize = 1 << 14
+)
+
+// Encodes images as WebP, either lossless or lossy at the given quality
+type WebPEncoder struct {
+	Lossless bool
+	// The quality of lossy images from 0 to 100, 0 is treated as 75
+	Quality float64
+}
+
+func (e *WebPEncoder) Ext() string {
+	return "webp"
+}
+
+func (e *WebPEncoder) Encode(w io.Writer, im image.Image) error {
+	if size := im.Bounds().Size(); size.X > webpMaxSize || size.Y > webpMaxSize {
+		return errors.New(fmt.Sprintf("Image of %dx%d exceeds the maximum WebP size of %dx%d",
+			size.X, size.Y, webpMaxSize, webpMaxSize))
+	}
+	nrgba, ok := im.(*image.NRGBA)
+	if !ok {
+		nrgba = image.NewNRGBA(im.Bounds())
+		draw.Draw(nrgba, nrgba.Bounds(), im, im.Bounds().Min, draw.Src)
+	}
+
+	var chunks []byte
+	if e.Lossless {
+		chunks = webpChunk("VP8L", encodeVP8L(nrgba))
+	} else {
+		quality := e.Quality
+		if quality == 0 {
+			quality = 75
+		}
+		lossy := webpChunk("VP8 ", encodeVP8(nrgba, quality))
+		if nrgba.Opaque() {
+			chunks = lossy
+		} else {
+			// Lossy images hold alpha in a separate chunk, which needs
+			// the extended header
+			b := nrgba.Bounds()
+			header := make([]byte, 10)
+			header[0] = webpFlagAlpha
+			putUint24(header[4:], uint32(b.Dx()-1))
+			putUint24(header[7:], uint32(b.Dy()-1))
+			chunks = append(webpChunk("VP8X", header), webpChunk("ALPH", webpAlpha(nrgba))...)
+			chunks = append(chunks, lossy...)
+		}
+	}
+
+	header := make([]byte, 12)
+	copy(header, "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(4+len(chunks)))
+	copy(header[8:], "WEBP")
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(chunks)
+	return err
+}
+
+// Returns a RIFF chunk holding the data, padded to an even size
+func webpChunk(fourCC string, data []byte) []byte {
+	chunk := make([]byte, 8, 8+len(data)+1)
+	copy(chunk, fourCC)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(data)))
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
+
+// Returns the contents of an alpha chunk, the alpha values are stored in
+// the green channel of a lossless image without its header
+func webpAlpha(im *image.NRGBA) []byte {
+	b := im.Bounds()
+	pix := make([]uint32, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := im.Pix[im.PixOffset(b.Min.X, y):]
+		for x := 0; x < b.Dx(); x++ {
+			pix = append(pix, 0xff000000|uint32(row[x*4+3])<<8)
+		}
+	}
+	w := &bitWriter{buf: []byte{webpAlphaLossless}}
+	writeVP8LImage(w, pix, b.Dx(), b.Dy())
+	return w.bytes()
+}
@@ -0,0 +1,549 @@
+package atlas
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+// Reads boolean values written by a boolEncoder
+type boolDecoder struct {
+	data     []byte
+	value    uint32
+	rng      uint32
+	bitCount int
+}
+
+func newBoolDecoder(data []byte) *boolDecoder {
+	return &boolDecoder{data: data[2:], value: uint32(data[0])<<8 | uint32(data[1]), rng: 255}
+}
+
+func (d *boolDecoder) get(prob int) bool {
+	split := 1 + (d.rng-1)*uint32(prob)>>8
+	bit := d.value >= split<<8
+	if bit {
+		d.rng -= split
+		d.value -= split << 8
+	} else {
+		d.rng = split
+	}
+	for d.rng < 128 {
+		d.value <<= 1
+		d.rng <<= 1
+		if d.bitCount++; d.bitCount == 8 {
+			d.bitCount = 0
+			if len(d.data) > 0 {
+				d.value |= uint32(d.data[0])
+				d.data = d.data[1:]
+			}
+		}
+	}
+	return bit
+}
+
+func (d *boolDecoder) literal(n int) int {
+	v := 0
+	for i := 0; i < n; i++ {
+		v <<= 1
+		if d.get(128) {
+			v |= 1
+		}
+	}
+	return v
+}
+
+// Reads values written by a bitWriter, least significant bit first
+type testBitReader struct {
+	data []byte
+	pos  uint
+}
+
+func (r *testBitReader) read(n uint) uint32 {
+	v := uint32(0)
+	for i := uint(0); i < n; i++ {
+		if r.pos/8 < uint(len(r.data)) && r.data[r.pos/8]>>(r.pos%8)&1 != 0 {
+			v |= 1 << i
+		}
+		r.pos++
+	}
+	return v
+}
+
+// A canonical prefix code for decoding, keyed by code length and code
+type testPrefixCode struct {
+	symbols map[[2]uint32]int
+	// The symbol of a code that takes no bits, -1 if there is none
+	only int
+}
+
+func newTestPrefixCode(lengths []int) *testPrefixCode {
+	c := &testPrefixCode{symbols: make(map[[2]uint32]int), only: -1}
+	used := 0
+	for s, l := range lengths {
+		if l > 0 {
+			used++
+			c.only = s
+		}
+	}
+	if used != 1 {
+		c.only = -1
+	}
+	// Codes are assigned in order of length and then symbol
+	code := uint32(0)
+	for l := 1; l <= vp8lMaxCodeLength; l++ {
+		for s, sl := range lengths {
+			if sl == l {
+				c.symbols[[2]uint32{uint32(l), code}] = s
+				code++
+			}
+		}
+		code <<= 1
+	}
+	return c
+}
+
+func (c *testPrefixCode) decode(t *testing.T, r *testBitReader) int {
+	if c.only >= 0 {
+		return c.only
+	}
+	code := uint32(0)
+	for l := uint32(1); l <= vp8lMaxCodeLength; l++ {
+		code = code<<1 | r.read(1)
+		if s, ok := c.symbols[[2]uint32{l, code}]; ok {
+			return s
+		}
+	}
+	t.Fatalf("Invalid prefix code at bit %d", r.pos)
+	return 0
+}
+
+// Reads a prefix code for an alphabet of the given size
+func readTestPrefixCode(t *testing.T, r *testBitReader, size int) *testPrefixCode {
+	lengths := make([]int, size)
+	if r.read(1) == 1 {
+		count := r.read(1) + 1
+		first := r.read(uint(r.read(1))*7 + 1)
+		lengths[first] = 1
+		if count == 2 {
+			lengths[r.read(8)] = 1
+		}
+		return newTestPrefixCode(lengths)
+	}
+	codeLengths := make([]int, 19)
+	for _, s := range vp8lCodeLengthOrder[:r.read(4)+4] {
+		codeLengths[s] = int(r.read(3))
+	}
+	lengthCode := newTestPrefixCode(codeLengths)
+	max := size
+	if r.read(1) == 1 {
+		max = 2 + int(r.read(2+2*uint(r.read(3))))
+	}
+	prev := 8
+	for i := 0; i < size && max > 0; max-- {
+		switch s := lengthCode.decode(t, r); {
+		case s < 16:
+			lengths[i] = s
+			i++
+			if s != 0 {
+				prev = s
+			}
+		case s == 16:
+			for n := 3 + int(r.read(2)); n > 0 && i < size; n-- {
+				lengths[i] = prev
+				i++
+			}
+		case s == 17:
+			i += 3 + int(r.read(3))
+		default:
+			i += 11 + int(r.read(7))
+		}
+	}
+	return newTestPrefixCode(lengths)
+}
+
+// Reads a length or distance written as a prefix code and extra bits
+func readTestPrefixValue(r *testBitReader, code int) int {
+	if code < 4 {
+		return code + 1
+	}
+	bits := uint(code-2) >> 1
+	return (2+code&1)<<bits + int(r.read(bits)) + 1
+}
+
+// Decodes an entropy coded image of ARGB pixels, supporting the features
+// written by the encoder
+func readTestEntropyImage(t *testing.T, r *testBitReader, width, height int, main bool) []uint32 {
+	if r.read(1) != 0 {
+		t.Fatalf("Unexpected colour cache")
+	}
+	if main && r.read(1) != 0 {
+		t.Fatalf("Unexpected meta prefix codes")
+	}
+	var codes [5]*testPrefixCode
+	for i, size := range []int{256 + vp8lLengthCodes, 256, 256, 256, vp8lDistanceCodes} {
+		codes[i] = readTestPrefixCode(t, r, size)
+	}
+	pix := make([]uint32, 0, width*height)
+	for len(pix) < width*height {
+		green := codes[0].decode(t, r)
+		if green < 256 {
+			red, blue, alpha := codes[1].decode(t, r), codes[2].decode(t, r), codes[3].decode(t, r)
+			pix = append(pix, uint32(alpha)<<24|uint32(red)<<16|uint32(green)<<8|uint32(blue))
+			continue
+		}
+		length := readTestPrefixValue(r, green-256)
+		distance := readTestPrefixValue(r, codes[4].decode(t, r)) - vp8lDistanceOffset
+		if distance < 1 || distance > len(pix) || len(pix)+length > width*height {
+			t.Fatalf("Invalid backward reference of %d pixels from %d back at %d", length, distance, len(pix))
+		}
+		for i := 0; i < length; i++ {
+			pix = append(pix, pix[len(pix)-distance])
+		}
+	}
+	return pix
+}
+
+// Decodes the transforms and pixels of a VP8L image following its header,
+// undoing the transforms as described by the specification
+func readTestVP8LImage(t *testing.T, r *testBitReader, width, height int) []uint32 {
+	type transform struct {
+		kind  uint32
+		bits  uint
+		modes []uint32
+	}
+	transforms := make([]transform, 0)
+	for r.read(1) == 1 {
+		tr := transform{kind: r.read(2)}
+		switch tr.kind {
+		case vp8lPredictor:
+			tr.bits = uint(r.read(3)) + 2
+			blocks := func(n int) int { return (n + 1<<tr.bits - 1) >> tr.bits }
+			tr.modes = readTestEntropyImage(t, r, blocks(width), blocks(height), false)
+		case vp8lSubtractGreen:
+		default:
+			t.Fatalf("Unexpected transform %d", tr.kind)
+		}
+		transforms = append(transforms, tr)
+	}
+	pix := readTestEntropyImage(t, r, width, height, true)
+
+	channels := func(p uint32) [4]int {
+		return [4]int{int(p >> 24), int(p >> 16 & 0xff), int(p >> 8 & 0xff), int(p & 0xff)}
+	}
+	argb := func(c [4]int) uint32 {
+		return uint32(c[0]&0xff)<<24 | uint32(c[1]&0xff)<<16 | uint32(c[2]&0xff)<<8 | uint32(c[3]&0xff)
+	}
+	each := func(fn func(k int) int) uint32 {
+		var c [4]int
+		for k := range c {
+			c[k] = fn(k)
+		}
+		return argb(c)
+	}
+	average := func(a, b uint32) uint32 {
+		ca, cb := channels(a), channels(b)
+		return each(func(k int) int { return (ca[k] + cb[k]) / 2 })
+	}
+	clamp := func(v int) int { return clampInt(v, 0, 255) }
+	for i := len(transforms) - 1; i >= 0; i-- {
+		tr := transforms[i]
+		if tr.kind == vp8lSubtractGreen {
+			for j, p := range pix {
+				c := channels(p)
+				c[1] += c[2]
+				c[3] += c[2]
+				pix[j] = argb(c)
+			}
+			continue
+		}
+		blocksWide := (width + 1<<tr.bits - 1) >> tr.bits
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				j := y*width + x
+				var predicted uint32
+				switch {
+				case x == 0 && y == 0:
+					predicted = 0xff000000
+				case y == 0:
+					predicted = pix[j-1]
+				case x == 0:
+					predicted = pix[j-width]
+				default:
+					// The pixel to the top right of the last column is the
+					// first pixel of the current row
+					l, tp, tl, tr2 := pix[j-1], pix[j-width], pix[j-width-1], pix[j-width+1]
+					cl, ct, ctl := channels(l), channels(tp), channels(tl)
+					switch mode := tr.modes[(y>>tr.bits)*blocksWide+x>>tr.bits] >> 8 & 0xff; mode {
+					case 0:
+						predicted = 0xff000000
+					case 1:
+						predicted = l
+					case 2:
+						predicted = tp
+					case 3:
+						predicted = tr2
+					case 4:
+						predicted = tl
+					case 5:
+						predicted = average(average(l, tr2), tp)
+					case 6:
+						predicted = average(l, tl)
+					case 7:
+						predicted = average(l, tp)
+					case 8:
+						predicted = average(tl, tp)
+					case 9:
+						predicted = average(tp, tr2)
+					case 10:
+						predicted = average(average(l, tl), average(tp, tr2))
+					case 11:
+						pl, pt := 0, 0
+						for k := range cl {
+							estimate := cl[k] + ct[k] - ctl[k]
+							pl += absInt(estimate - cl[k])
+							pt += absInt(estimate - ct[k])
+						}
+						predicted = tp
+						if pl < pt {
+							predicted = l
+						}
+					case 12:
+						predicted = each(func(k int) int { return clamp(cl[k] + ct[k] - ctl[k]) })
+					case 13:
+						a := channels(average(l, tp))
+						predicted = each(func(k int) int { return clamp(a[k] + (a[k]-ctl[k])/2) })
+					default:
+						t.Fatalf("Invalid predictor mode %d", mode)
+					}
+				}
+				c, p := channels(pix[j]), channels(predicted)
+				pix[j] = each(func(k int) int { return c[k] + p[k] })
+			}
+		}
+	}
+	return pix
+}
+
+// Splits a WebP file into its chunks
+func webpChunks(t *testing.T, data []byte) map[string][]byte {
+	if string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		t.Fatalf("Not a WebP file")
+	}
+	if size := int(binary.LittleEndian.Uint32(data[4:])); size != len(data)-8 {
+		t.Fatalf("Unexpected RIFF size: want %d, got %d", len(data)-8, size)
+	}
+	chunks := make(map[string][]byte)
+	for data = data[12:]; len(data) >= 8; {
+		size := int(binary.LittleEndian.Uint32(data[4:]))
+		chunks[string(data[:4])] = data[8 : 8+size]
+		data = data[8+size+size%2:]
+	}
+	return chunks
+}
+
+func testWebPImage(alpha uint8) *image.NRGBA {
+	im := image.NewNRGBA(image.Rect(0, 0, 37, 21))
+	for y := 0; y < 21; y++ {
+		for x := 0; x < 37; x++ {
+			im.SetNRGBA(x, y, color.NRGBA{uint8(x * 7), uint8(y * 12), 200, alpha})
+		}
+	}
+	return im
+}
+
+// Builds an image of noise, flat areas and repeated stripes with varying
+// alpha, so every part of the lossless encoder is used
+func testWebPDetailImage() *image.NRGBA {
+	im := image.NewNRGBA(image.Rect(0, 0, 70, 45))
+	seed := uint32(1)
+	for y := 0; y < 45; y++ {
+		for x := 0; x < 70; x++ {
+			seed = seed*1103515245 + 12345
+			var c color.NRGBA
+			switch {
+			case x < 20:
+				c = color.NRGBA{uint8(seed >> 24), uint8(seed >> 16), uint8(seed >> 8), uint8(seed)}
+			case x < 40:
+				c = color.NRGBA{30, 60, 90, uint8(y * 5)}
+			default:
+				c = color.NRGBA{uint8((x % 4) * 60), uint8(x * y), 7, 255}
+			}
+			im.SetNRGBA(x, y, c)
+		}
+	}
+	return im
+}
+
+func TestBoolEncoder(t *testing.T) {
+	e := newBoolEncoder()
+	for i := 0; i < 2000; i++ {
+		e.put(i%3 == 0 || i%7 == 0, (i*37)%255+1)
+	}
+	e.literal(1234, 11)
+	d := newBoolDecoder(e.bytes())
+	for i := 0; i < 2000; i++ {
+		if d.get((i*37)%255+1) != (i%3 == 0 || i%7 == 0) {
+			t.Fatalf("Unexpected bool at %d", i)
+		}
+	}
+	if v := d.literal(11); v != 1234 {
+		t.Errorf("Unexpected literal: want 1234, got %d", v)
+	}
+}
+
+func TestPrefixCodes(t *testing.T) {
+	// Canonical codes are assigned shortest first, here 10, 0, 110, 111
+	// written least significant bit first
+	codes := canonicalCodes([]int{2, 1, 3, 3})
+	for i, want := range []uint32{1, 0, 3, 7} {
+		if codes[i] != want {
+			t.Errorf("Unexpected code for %d: want %b, got %b", i, want, codes[i])
+		}
+	}
+
+	// Fibonacci counts give the deepest possible tree
+	counts := make([]int, 30)
+	a, b := 1, 1
+	for i := range counts {
+		counts[i] = a
+		a, b = b, a+b
+	}
+	lengths := huffmanLengths(counts, vp8lMaxCodeLength)
+	kraft := 0.0
+	for i, l := range lengths {
+		if l < 1 || l > vp8lMaxCodeLength {
+			t.Fatalf("Unexpected code length for %d: %d", i, l)
+		}
+		kraft += 1 / float64(int(1)<<uint(l))
+	}
+	if kraft != 1 {
+		t.Errorf("Code is not complete: %f", kraft)
+	}
+}
+
+func TestWebPEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (&WebPEncoder{Lossless: true}).Encode(&buf, testWebPImage(128)); err != nil {
+		t.Fatalf("Encode threw an error: %s", err.Error())
+	}
+	chunks := webpChunks(t, buf.Bytes())
+	data, ok := chunks["VP8L"]
+	if !ok {
+		t.Fatalf("Expected a VP8L chunk")
+	}
+	header := binary.LittleEndian.Uint32(data[1:])
+	if data[0] != vp8lSignature || header&0x3fff != 36 || header>>14&0x3fff != 20 || header>>28&1 != 1 {
+		t.Errorf("Unexpected VP8L header: %x", data[:5])
+	}
+
+	// Lossless images decode to exactly the same pixels
+	for _, im := range []*image.NRGBA{testWebPImage(128), testWebPImage(255), testWebPDetailImage()} {
+		r := &testBitReader{data: encodeVP8L(im)}
+		r.read(8)
+		width, height := int(r.read(14))+1, int(r.read(14))+1
+		r.read(4)
+		if width != im.Bounds().Dx() || height != im.Bounds().Dy() {
+			t.Fatalf("Unexpected VP8L size: want %v, got %dx%d", im.Bounds().Size(), width, height)
+		}
+		pix := readTestVP8LImage(t, r, width, height)
+		for i, want := range argbPixels(im) {
+			if pix[i] != want {
+				t.Fatalf("Unexpected pixel at %d,%d: want %08x, got %08x", i%width, i/width, want, pix[i])
+			}
+		}
+	}
+
+	buf.Reset()
+	if err := (&WebPEncoder{Quality: 80}).Encode(&buf, testWebPImage(128)); err != nil {
+		t.Fatalf("Encode threw an error: %s", err.Error())
+	}
+	chunks = webpChunks(t, buf.Bytes())
+	for _, name := range []string{"VP8X", "ALPH", "VP8 "} {
+		if _, ok := chunks[name]; !ok {
+			t.Fatalf("Expected a %s chunk", name)
+		}
+	}
+	if chunks["VP8X"][0] != webpFlagAlpha {
+		t.Errorf("Expected the alpha flag to be set")
+	}
+	// Alpha is stored losslessly whatever the quality
+	alpha := chunks["ALPH"]
+	if alpha[0] != webpAlphaLossless {
+		t.Errorf("Unexpected alpha chunk header: %x", alpha[0])
+	}
+	for i, p := range readTestVP8LImage(t, &testBitReader{data: alpha[1:]}, 37, 21) {
+		if p>>8&0xff != 128 {
+			t.Fatalf("Unexpected alpha at %d,%d: want 128, got %d", i%37, i/37, p>>8&0xff)
+		}
+	}
+	data = chunks["VP8 "]
+	tag := uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16
+	if tag&1 != 0 || tag>>4&1 != 1 || !bytes.Equal(data[3:6], []byte{0x9d, 0x01, 0x2a}) {
+		t.Fatalf("Expected a VP8 key frame that is shown")
+	}
+	if w, h := binary.LittleEndian.Uint16(data[6:]), binary.LittleEndian.Uint16(data[8:]); w != 37 || h != 21 {
+		t.Errorf("Unexpected VP8 size: want 37x21, got %dx%d", w, h)
+	}
+	// The first partition holds the header and modes, the single token
+	// partition takes up the rest of the chunk
+	if first := int(tag >> 5); first == 0 || 10+first >= len(data) {
+		t.Errorf("Unexpected first partition size %d in a chunk of %d bytes", first, len(data))
+	}
+	// Read the frame header up to the quantiser index
+	d := newBoolDecoder(data[10:])
+	if fields := d.literal(2 + 1 + 1 + 6 + 3 + 1 + 2); fields != 0 {
+		t.Errorf("Unexpected frame header fields: %b", fields)
+	}
+	if qi := d.literal(7); qi != 25 {
+		t.Errorf("Unexpected quantiser index: want 25, got %d", qi)
+	}
+
+	// Opaque lossy images need no extended header
+	buf.Reset()
+	(&WebPEncoder{}).Encode(&buf, testWebPImage(255))
+	chunks = webpChunks(t, buf.Bytes())
+	if _, ok := chunks["VP8X"]; ok || len(chunks) != 1 {
+		t.Errorf("Expected only a VP8 chunk for an opaque image")
+	}
+}
+
+func TestGenerateWebP(t *testing.T) {
+	outputDir := t.TempDir()
+	files := []string{
+		"./fixtures/button.png",
+		"./fixtures/button_active.png",
+		"./fixtures/button_hover.png",
+	}
+	res, err := Generate(files, outputDir, &GenerateParams{
+		Name:       "test-webp",
+		Descriptor: DESC_JSON_HASH,
+		Encoder:    &WebPEncoder{Lossless: true},
+	})
+	if err != nil {
+		t.Fatalf("Generate threw an error: %s", err.Error())
+	}
+	atlas := res.Atlases[0]
+	if atlas.ImageName() != "test-webp-1.webp" {
+		t.Errorf("Unexpected image name: %s", atlas.ImageName())
+	}
+	data, err := ioutil.ReadFile(path.Join(outputDir, atlas.ImageName()))
+	if err != nil {
+		t.Fatalf("Image was not written: %s", err.Error())
+	}
+	webpChunks(t, data)
+	desc, err := ioutil.ReadFile(path.Join(outputDir, "test-webp-1.json"))
+	if err != nil {
+		t.Fatalf("Descriptor was not written: %s", err.Error())
+	}
+	if !bytes.Contains(desc, []byte(`"image": "test-webp-1.webp"`)) {
+		t.Errorf("Expected the descriptor to refer to the WebP image")
+	}
+
+	if _, err := Generate(files, outputDir, &GenerateParams{Encoder: &WebPEncoder{}, Container: CONTAINER_RAW}); err == nil {
+		t.Errorf("Expected an error using an encoder with a raw container")
+	}
+}